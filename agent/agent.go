@@ -0,0 +1,365 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/orkhan-huseyn/vsdlog/auth"
+	"github.com/orkhan-huseyn/vsdlog/discovery"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/soheilhy/cmux"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Agent runs a node of the cluster: the replicated log, the grpc server
+// and the membership that wires the other nodes into replication
+// raft and grpc share the rpc port, connections are multiplexed by cmux
+type Agent struct {
+	Config
+
+	mux        cmux.CMux
+	log        *log.DistributedLog
+	server     *grpc.Server
+	membership *discovery.Membership
+	metrics    *http.Server
+	http       *http.Server
+	kafka      *server.KafkaServer
+
+	// what Reload swaps, tlsConfig is what the listeners are secured with
+	reloadLock sync.Mutex
+	authorizer authorizer
+	aclDigest  [sha256.Size]byte
+	serverTLS  atomic.Pointer[tls.Config]
+	tlsConfig  *tls.Config
+
+	shutdown     bool
+	shutdowns    chan struct{}
+	shutdownLock sync.Mutex
+}
+
+type Config struct {
+	// ServerTLSConfig secures the connections the node accepts
+	// PeerTLSConfig the ones it makes to the other nodes
+	ServerTLSConfig *tls.Config
+	PeerTLSConfig   *tls.Config
+
+	DataDir string
+	// BindAddr is the address serf listens on
+	BindAddr string
+	// RPCPort is the port raft and grpc share, on BindAddr's host
+	RPCPort        int
+	NodeName       string
+	StartJoinAddrs []string
+	// Bootstrap should be set on the first node of a new cluster only
+	Bootstrap bool
+	// ACLPolicyFile enables authorization of produce and consume requests
+	ACLPolicyFile string
+	// TokenValidator authenticates clients by their bearer tokens, on top
+	// of their certificates, see server.Config.TokenValidator
+	TokenValidator server.TokenValidator
+	// Quotas limit how fast the tenants produce and how much their
+	// topics take, see server.Config.Quotas
+	Quotas map[string]server.Quota
+	// ServerSubjects are the subjects of the nodes' certificates, the
+	// requests they forward are limited as the tenant they pass along,
+	// see server.Config.ServerSubjects
+	ServerSubjects []string
+	// MetricsAddr is where prometheus metrics are served on /metrics, along
+	// with the node's health check on /healthz. neither is served if empty
+	MetricsAddr string
+	// HTTPAddr is where the JSON api of server.NewHTTPHandler is served,
+	// with ServerTLSConfig if it's set. it isn't served if empty
+	HTTPAddr string
+	// KafkaAddr is where server.NewKafkaServer listens for kafka clients,
+	// with ServerTLSConfig if it's set. it doesn't listen if empty
+	KafkaAddr string
+	// TracerProvider traces the rpcs and log operations of the node
+	TracerProvider trace.TracerProvider
+	// Log configures the segments of the node's log
+	Log log.Config
+	// Topics are managed through the Admin rpcs, see server.Config.Topics
+	Topics *log.Topics
+	// Auditor records the config reloads and ACL changes, e.g. in the
+	// audit topic of log.Topics. they aren't recorded if it's nil
+	Auditor log.Auditor
+	// Reload loads the config again on SIGHUP, e.g. from the file the node
+	// was started with, and has the agent apply it, see Agent.Reload.
+	// SIGHUP isn't handled if it's nil
+	Reload func() (Config, error)
+}
+
+func (c Config) RPCAddr() (string, error) {
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, c.RPCPort), nil
+}
+
+func New(config Config) (*Agent, error) {
+	a := &Agent{
+		Config:    config,
+		shutdowns: make(chan struct{}),
+	}
+	setup := []func() error{
+		a.setupTLS,
+		a.setupMux,
+		a.setupLog,
+		a.setupServer,
+		a.setupMembership,
+		a.setupMetrics,
+	}
+	for _, fn := range setup {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	go a.serve()
+	if config.Reload != nil {
+		go a.reloadOnSignal()
+	}
+	return a, nil
+}
+
+func (a *Agent) setupMux() error {
+	rpcAddr, err := a.RPCAddr()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", rpcAddr)
+	if err != nil {
+		return err
+	}
+	a.mux = cmux.New(ln)
+	return nil
+}
+
+func (a *Agent) setupLog() error {
+	raftLn := a.mux.Match(func(reader io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := reader.Read(b); err != nil {
+			return false
+		}
+		return bytes.Equal(b, []byte{byte(log.RaftRPC)})
+	})
+	logConfig := a.Config.Log
+	logConfig.Raft.StreamLayer = log.NewStreamLayer(
+		raftLn,
+		a.tlsConfig,
+		a.Config.PeerTLSConfig,
+	)
+	logConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
+	logConfig.Raft.Bootstrap = a.Config.Bootstrap
+	if a.Config.TracerProvider != nil {
+		logConfig.TracerProvider = a.Config.TracerProvider
+	}
+
+	var err error
+	a.log, err = log.NewDistributedLog(a.Config.DataDir, logConfig)
+	if err != nil {
+		return err
+	}
+	if a.Config.Bootstrap {
+		return a.log.WaitForLeader(3 * time.Second)
+	}
+	return nil
+}
+
+func (a *Agent) setupServer() error {
+	serverConfig := &server.Config{
+		CommitLog:      a.log,
+		TracerProvider: a.Config.TracerProvider,
+		MaxRecordBytes: a.Config.Log.Store.MaxRecordBytes,
+		Quotas:         a.Config.Quotas,
+		ServerSubjects: a.Config.ServerSubjects,
+		TokenValidator: a.Config.TokenValidator,
+		Topics:         a.Config.Topics,
+	}
+	if a.Config.ACLPolicyFile != "" {
+		acl, err := auth.New(a.Config.ACLPolicyFile)
+		if err != nil {
+			return err
+		}
+		a.authorizer.acl.Store(acl)
+		if a.aclDigest, err = fileDigest(a.Config.ACLPolicyFile); err != nil {
+			return err
+		}
+	}
+	serverConfig.Authorizer = &a.authorizer
+	if a.Config.PeerTLSConfig != nil {
+		serverConfig.ForwardDialOptions = []grpc.DialOption{
+			grpc.WithTransportCredentials(credentials.NewTLS(a.Config.PeerTLSConfig)),
+		}
+	}
+	var opts []grpc.ServerOption
+	if a.tlsConfig != nil {
+		creds := credentials.NewTLS(a.tlsConfig)
+		opts = append(opts, grpc.Creds(creds))
+	}
+	var err error
+	a.server, err = server.NewGRPCServer(serverConfig, opts...)
+	if err != nil {
+		return err
+	}
+	grpcLn := a.mux.Match(cmux.Any())
+	go func() {
+		if err := a.server.Serve(grpcLn); err != nil {
+			_ = a.Shutdown()
+		}
+	}()
+	if err := a.setupHTTP(serverConfig); err != nil {
+		return err
+	}
+	return a.setupKafka(serverConfig)
+}
+
+func (a *Agent) setupHTTP(serverConfig *server.Config) error {
+	if a.Config.HTTPAddr == "" {
+		return nil
+	}
+	handler, err := server.NewHTTPHandler(serverConfig)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", a.Config.HTTPAddr)
+	if err != nil {
+		return err
+	}
+	if a.tlsConfig != nil {
+		ln = tls.NewListener(ln, a.tlsConfig)
+	}
+	a.http = &http.Server{Handler: handler}
+	go func() {
+		_ = a.http.Serve(ln)
+	}()
+	return nil
+}
+
+func (a *Agent) setupKafka(serverConfig *server.Config) error {
+	if a.Config.KafkaAddr == "" {
+		return nil
+	}
+	var err error
+	a.kafka, err = server.NewKafkaServer(serverConfig)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", a.Config.KafkaAddr)
+	if err != nil {
+		return err
+	}
+	if a.tlsConfig != nil {
+		ln = tls.NewListener(ln, a.tlsConfig)
+	}
+	go func() {
+		_ = a.kafka.Serve(ln)
+	}()
+	return nil
+}
+
+func (a *Agent) setupMembership() error {
+	rpcAddr, err := a.Config.RPCAddr()
+	if err != nil {
+		return err
+	}
+	a.membership, err = discovery.New(a.log, discovery.Config{
+		NodeName: a.Config.NodeName,
+		BindAddr: a.Config.BindAddr,
+		Tags: map[string]string{
+			"rpc_addr": rpcAddr,
+		},
+		StartJoinAddrs: a.Config.StartJoinAddrs,
+	})
+	return err
+}
+
+func (a *Agent) setupMetrics() error {
+	if a.Config.MetricsAddr == "" {
+		return nil
+	}
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(a.log); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", a.Config.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", server.NewHealthHandler(a.log))
+	a.metrics = &http.Server{Handler: mux}
+	go func() {
+		_ = a.metrics.Serve(ln)
+	}()
+	return nil
+}
+
+func (a *Agent) serve() error {
+	if err := a.mux.Serve(); err != nil {
+		_ = a.Shutdown()
+		return err
+	}
+	return nil
+}
+
+func (a *Agent) Shutdown() error {
+	a.shutdownLock.Lock()
+	defer a.shutdownLock.Unlock()
+	if a.shutdown {
+		return nil
+	}
+	a.shutdown = true
+	close(a.shutdowns)
+
+	shutdown := []func() error{
+		a.membership.Leave,
+		func() error {
+			if a.metrics == nil {
+				return nil
+			}
+			return a.metrics.Close()
+		},
+		func() error {
+			if a.http == nil {
+				return nil
+			}
+			return a.http.Close()
+		},
+		func() error {
+			if a.kafka == nil {
+				return nil
+			}
+			return a.kafka.Close()
+		},
+		func() error {
+			a.server.GracefulStop()
+			return nil
+		},
+		a.log.Close,
+		func() error {
+			a.mux.Close()
+			return nil
+		},
+	}
+	for _, fn := range shutdown {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
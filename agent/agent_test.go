@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/config"
+	"github.com/orkhan-huseyn/vsdlog/internal/testcerts"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestAgent(t *testing.T) {
+	certs, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certs.ServerCertFile,
+		KeyFile:       certs.ServerKeyFile,
+		CAFile:        certs.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certs.RootClientCertFile,
+		KeyFile:       certs.RootClientKeyFile,
+		CAFile:        certs.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	err = os.WriteFile(policy, []byte("root, *, produce\nroot, *, consume\n"), 0644)
+	require.NoError(t, err)
+
+	var agents []*Agent
+	for i := 0; i < 3; i++ {
+		ports := freePorts(t, 3)
+		bindAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
+		rpcPort := ports[1]
+		metricsAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[2])
+
+		dataDir, err := os.MkdirTemp("", "agent-test-log")
+		require.NoError(t, err)
+
+		var startJoinAddrs []string
+		if i != 0 {
+			startJoinAddrs = append(
+				startJoinAddrs,
+				agents[0].Config.BindAddr,
+			)
+		}
+
+		agent, err := New(Config{
+			ServerTLSConfig: serverTLSConfig,
+			PeerTLSConfig:   peerTLSConfig,
+			ACLPolicyFile:   policy,
+			MetricsAddr:     metricsAddr,
+			NodeName:        fmt.Sprintf("%d", i),
+			StartJoinAddrs:  startJoinAddrs,
+			BindAddr:        bindAddr,
+			RPCPort:         rpcPort,
+			DataDir:         dataDir,
+			Bootstrap:       i == 0,
+		})
+		require.NoError(t, err)
+
+		agents = append(agents, agent)
+	}
+	defer func() {
+		for _, agent := range agents {
+			err := agent.Shutdown()
+			require.NoError(t, err)
+			require.NoError(t, os.RemoveAll(agent.Config.DataDir))
+		}
+	}()
+	// wait until agents have joined the cluster
+	time.Sleep(3 * time.Second)
+
+	leaderClient := client(t, agents[0], peerTLSConfig)
+	produceResponse, err := leaderClient.Produce(
+		context.Background(),
+		&api.ProduceRequest{
+			Record: &api.Record{Value: []byte("foo")},
+		},
+	)
+	require.NoError(t, err)
+
+	// wait until replication has finished
+	time.Sleep(3 * time.Second)
+
+	followerClient := client(t, agents[1], peerTLSConfig)
+	consumeResponse, err := followerClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{Offset: produceResponse.Offset},
+	)
+	require.NoError(t, err)
+	require.Equal(t, consumeResponse.Record.Value, []byte("foo"))
+
+	res, err := http.Get("http://" + agents[1].Config.MetricsAddr + "/metrics")
+	require.NoError(t, err)
+	metrics, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(metrics), "vsdlog_appends_total 1")
+
+	// produce requests sent to a follower make it to the leader
+	produceResponse, err = followerClient.Produce(
+		context.Background(),
+		&api.ProduceRequest{
+			Record: &api.Record{Value: []byte("bar")},
+		},
+	)
+	require.NoError(t, err)
+	consumeResponse, err = leaderClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{Offset: produceResponse.Offset},
+	)
+	require.NoError(t, err)
+	require.Equal(t, consumeResponse.Record.Value, []byte("bar"))
+
+	// records aren't applied more than once
+	consumeResponse, err = leaderClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{Offset: produceResponse.Offset + 1},
+	)
+	require.Nil(t, consumeResponse)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.LogClient {
+	rpcAddr, err := agent.Config.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(
+		rpcAddr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return api.NewLogClient(conn)
+}
+
+func freePorts(t *testing.T, n int) []int {
+	t.Helper()
+	var ports []int
+	var lns []net.Listener
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		lns = append(lns, ln)
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	}
+	for _, ln := range lns {
+		ln.Close()
+	}
+	return ports
+}
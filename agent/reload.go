@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/orkhan-huseyn/vsdlog/auth"
+	"github.com/orkhan-huseyn/vsdlog/log"
+)
+
+// ErrTLSReload is returned by Reload for a config that turns tls on or
+// off, the listeners are secured or not from the start
+var ErrTLSReload = errors.New("agent: tls can't be turned on or off without a restart")
+
+// ErrACLReload is returned by Reload for a config that turns the ACLs on
+// or off, so a node that enforced them isn't opened up by a reloaded
+// config that lost its ACLPolicyFile
+var ErrACLReload = errors.New("agent: acls can't be turned on or off without a restart")
+
+// Reload applies the settings of the config that can change while the
+// node's running, without dropping its connections and streams:
+//   - Log.Retention.MaxAge and Log.Retention.MaxBytes
+//   - ACLPolicyFile, which is read again even if it's the same file
+//   - ServerTLSConfig, used for the handshakes from then on
+//
+// the rest of the config takes a restart and is ignored, Quotas too, the
+// servers hold on to the ones they were made with. the reload, and
+// the change of the ACLs if they changed, are audited with Config.Auditor
+func (a *Agent) Reload(c Config) error {
+	return a.reload(c, "agent")
+}
+
+// reload reloads the config on behalf of the principal
+func (a *Agent) reload(c Config, principal string) error {
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	if (c.ServerTLSConfig == nil) != (a.Config.ServerTLSConfig == nil) {
+		return ErrTLSReload
+	}
+	if (c.ACLPolicyFile == "") != (a.Config.ACLPolicyFile == "") {
+		return ErrACLReload
+	}
+	var acl *auth.Authorizer
+	var digest [sha256.Size]byte
+	if c.ACLPolicyFile != "" {
+		var err error
+		if acl, err = auth.New(c.ACLPolicyFile); err != nil {
+			return err
+		}
+		if digest, err = fileDigest(c.ACLPolicyFile); err != nil {
+			return err
+		}
+	}
+
+	// nothing's changed before everything's been loaded
+	a.authorizer.acl.Store(acl)
+	if c.ServerTLSConfig != nil {
+		a.serverTLS.Store(c.ServerTLSConfig)
+	}
+	a.log.SetRetention(c.Log.Retention.MaxAge, c.Log.Retention.MaxBytes)
+	a.Config.ACLPolicyFile = c.ACLPolicyFile
+	a.Config.ServerTLSConfig = c.ServerTLSConfig
+	a.Config.Log.Retention.MaxAge = c.Log.Retention.MaxAge
+	a.Config.Log.Retention.MaxBytes = c.Log.Retention.MaxBytes
+	aclChanged := digest != a.aclDigest
+	a.aclDigest = digest
+
+	if a.Config.Auditor == nil {
+		return nil
+	}
+	if aclChanged {
+		if err := a.Config.Auditor.Audit(log.AuditEvent{
+			Principal: principal,
+			Action:    log.AuditACLChange,
+			Target:    a.Config.NodeName,
+			Details:   map[string]string{"policy_file": c.ACLPolicyFile},
+		}); err != nil {
+			return err
+		}
+	}
+	return a.Config.Auditor.Audit(log.AuditEvent{
+		Principal: principal,
+		Action:    log.AuditConfigReload,
+		Target:    a.Config.NodeName,
+		Details: map[string]string{
+			"retention_max_age":   c.Log.Retention.MaxAge.String(),
+			"retention_max_bytes": fmt.Sprint(c.Log.Retention.MaxBytes),
+		},
+	})
+}
+
+// fileDigest hashes the file, to tell whether it changed
+func fileDigest(name string) ([sha256.Size]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// reloadOnSignal reloads the config with Config.Reload on every SIGHUP
+// until the agent shuts down
+func (a *Agent) reloadOnSignal() {
+	logger := slog.Default().With("component", "agent", "node", a.Config.NodeName)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-a.shutdowns:
+			return
+		case <-hup:
+		}
+		c, err := a.Config.Reload()
+		if err == nil {
+			err = a.reload(c, "SIGHUP")
+		}
+		if err != nil {
+			logger.Error("failed to reload the config", "error", err)
+			continue
+		}
+		logger.Info("reloaded the config")
+	}
+}
+
+// authorizer lets Reload swap the ACLs of the running servers, everything's
+// allowed without them, which only a node started without them is
+type authorizer struct {
+	acl atomic.Pointer[auth.Authorizer]
+}
+
+func (a *authorizer) Authorize(subject, object, action string) error {
+	acl := a.acl.Load()
+	if acl == nil {
+		return nil
+	}
+	return acl.Authorize(subject, object, action)
+}
+
+// setupTLS has the listeners hand out the current ServerTLSConfig
+// on every handshake, so Reload gets to swap it
+func (a *Agent) setupTLS() error {
+	if a.Config.ServerTLSConfig == nil {
+		return nil
+	}
+	a.serverTLS.Store(a.Config.ServerTLSConfig)
+	a.tlsConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return a.serverTLS.Load(), nil
+		},
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build linux || darwin
+
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/config"
+	"github.com/orkhan-huseyn/vsdlog/internal/testcerts"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReload(t *testing.T) {
+	tlsConfigs := func(certs testcerts.Files) (server, client *tls.Config) {
+		server, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: certs.ServerCertFile,
+			KeyFile:  certs.ServerKeyFile,
+			CAFile:   certs.CAFile,
+			Server:   true,
+		})
+		require.NoError(t, err)
+		client, err = config.SetupTLSConfig(config.TLSConfig{
+			CertFile:      certs.RootClientCertFile,
+			KeyFile:       certs.RootClientKeyFile,
+			CAFile:        certs.CAFile,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+		return server, client
+	}
+	oldCerts, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+	newCerts, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+	oldServer, oldClient := tlsConfigs(oldCerts)
+	newServer, newClient := tlsConfigs(newCerts)
+
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("root, *, produce\n"), 0644))
+
+	ports := freePorts(t, 2)
+	c := Config{
+		ServerTLSConfig: oldServer,
+		PeerTLSConfig:   oldClient,
+		ACLPolicyFile:   policy,
+		NodeName:        "0",
+		BindAddr:        fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		RPCPort:         ports[1],
+		DataDir:         t.TempDir(),
+		Bootstrap:       true,
+	}
+	auditor := &auditEvents{}
+	c.Auditor = auditor
+	reloaded := c
+	reloaded.ServerTLSConfig = newServer
+	reloads := make(chan struct{}, 1)
+	c.Reload = func() (Config, error) {
+		reloads <- struct{}{}
+		return reloaded, nil
+	}
+	agent, err := New(c)
+	require.NoError(t, err)
+	defer agent.Shutdown()
+
+	ctx := context.Background()
+	oldClientConn := client(t, agent, oldClient)
+	res, err := oldClientConn.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+	_, err = oldClientConn.Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the new acls and certificates are loaded on SIGHUP
+	require.NoError(t, os.WriteFile(policy, []byte("root, *, produce\nroot, *, consume\n"), 0644))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reloaded")
+	}
+	require.Eventually(t, func() bool {
+		_, err := oldClientConn.Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the connection made before is still up, new ones need the new certificates
+	_, err = client(t, agent, oldClient).Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	consumed, err := client(t, agent, newClient).Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), consumed.Record.Value)
+
+	// the acls changed with the reload, which is audited as the signal's
+	events := auditor.get()
+	require.Len(t, events, 2)
+	require.Equal(t, log.AuditACLChange, events[0].Action)
+	require.Equal(t, log.AuditConfigReload, events[1].Action)
+	require.Equal(t, "SIGHUP", events[1].Principal)
+	require.NoError(t, agent.Reload(reloaded))
+	events = auditor.get()
+	require.Len(t, events, 3)
+	require.Equal(t, log.AuditConfigReload, events[2].Action)
+	require.Equal(t, "agent", events[2].Principal)
+
+	// the acls stay on, a config without them is refused and changes nothing
+	noACLs := reloaded
+	noACLs.ACLPolicyFile = ""
+	require.ErrorIs(t, agent.Reload(noACLs), ErrACLReload)
+	_, err = client(t, agent, newClient).Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(policy, []byte("root, *, produce\n"), 0644))
+	require.NoError(t, agent.Reload(reloaded))
+	_, err = client(t, agent, newClient).Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	reloaded.ServerTLSConfig = nil
+	require.ErrorIs(t, agent.Reload(reloaded), ErrTLSReload)
+}
+
+type auditEvents struct {
+	mu     sync.Mutex
+	events []log.AuditEvent
+}
+
+func (a *auditEvents) Audit(e log.AuditEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, e)
+	return nil
+}
+
+func (a *auditEvents) get() []log.AuditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]log.AuditEvent(nil), a.events...)
+}
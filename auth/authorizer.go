@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Wildcard matches any subject or object in a policy
+const Wildcard = "*"
+
+// Authorizer decides whether a subject may perform an action on an object
+// based on a policy file with a "subject, object, action" rule per line
+// blank lines and lines starting with # are skipped
+type Authorizer struct {
+	rules map[rule]struct{}
+}
+
+type rule struct {
+	subject string
+	object  string
+	action  string
+}
+
+func New(policyFile string) (*Authorizer, error) {
+	f, err := os.Open(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &Authorizer{rules: make(map[rule]struct{})}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected subject, object, action", policyFile, n)
+		}
+		a.rules[rule{
+			subject: strings.TrimSpace(fields[0]),
+			object:  strings.TrimSpace(fields[1]),
+			action:  strings.TrimSpace(fields[2]),
+		}] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authorize returns a PermissionDenied status error
+// unless a rule allows the subject to perform the action on the object
+func (a *Authorizer) Authorize(subject, object, action string) error {
+	for _, s := range []string{subject, Wildcard} {
+		for _, o := range []string{object, Wildcard} {
+			if _, ok := a.rules[rule{subject: s, object: o, action: action}]; ok {
+				return nil
+			}
+		}
+	}
+	msg := fmt.Sprintf(
+		"%s not permitted to %s to %s",
+		subject,
+		action,
+		object,
+	)
+	st := status.New(codes.PermissionDenied, msg)
+	return st.Err()
+}
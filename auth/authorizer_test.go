@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizer(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	err := os.WriteFile(policy, []byte(`# subject, object, action
+root, *, produce
+root, *, consume
+
+*, public, consume
+`), 0644)
+	require.NoError(t, err)
+
+	a, err := New(policy)
+	require.NoError(t, err)
+
+	require.NoError(t, a.Authorize("root", "*", "produce"))
+	require.NoError(t, a.Authorize("root", "anything", "consume"))
+	require.NoError(t, a.Authorize("nobody", "public", "consume"))
+
+	err = a.Authorize("nobody", "*", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = a.Authorize("nobody", "private", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthorizerInvalidPolicy(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("root, produce\n"), 0644))
+
+	_, err := New(policy)
+	require.Error(t, err)
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for bearer tokens that don't check out
+var ErrInvalidToken = errors.New("auth: invalid token")
+
+// TokenValidator checks the bearer token of a request and returns
+// the subject it's authorized as, like the common name of a certificate
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (subject string, err error)
+}
+
+// StaticTokens maps the tokens handed out to their subjects
+type StaticTokens map[string]string
+
+func (t StaticTokens) Validate(_ context.Context, token string) (string, error) {
+	for known, subject := range t {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return subject, nil
+		}
+	}
+	return "", ErrInvalidToken
+}
+
+// jwksRefetchInterval is how often the keys are fetched again at most
+// when a token is signed with a key that isn't known
+const jwksRefetchInterval = 10 * time.Second
+
+// JWTValidator validates JSON web tokens, signed with HS256 and Secret or
+// with RS256 or ES256 and one of the keys published at JWKSURL. the
+// subject is the sub claim, expired tokens and those not valid yet fail
+type JWTValidator struct {
+	// Secret checks HS256 tokens, they're refused if it's empty
+	Secret []byte
+	// JWKSURL is where the keys of RS256 and ES256 tokens are fetched from,
+	// again whenever a token has a key id that isn't known yet
+	JWKSURL string
+	// Issuer and Audience are the iss and aud the tokens need if set
+	Issuer   string
+	Audience string
+	// Client fetches the keys, http.DefaultClient if nil
+	Client *http.Client
+	// Clock is what tokens expire by, time.Now if nil
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// fetching is closed once the fetch under way is done, nil if there's none
+	fetching chan struct{}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// audience is a string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (v *JWTValidator) Validate(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: not a jwt", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = v.verify(ctx, header, parts[0]+"."+parts[1], sig); err != nil {
+		return "", err
+	}
+
+	var claims jwtClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := time.Now
+	if v.Clock != nil {
+		now = v.Clock
+	}
+	unix := now().Unix()
+	switch {
+	case claims.ExpiresAt != nil && unix >= *claims.ExpiresAt:
+		return "", fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != nil && unix < *claims.NotBefore:
+		return "", fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case v.Issuer != "" && claims.Issuer != v.Issuer:
+		return "", fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	case v.Audience != "" && !slices.Contains(claims.Audience, v.Audience):
+		return "", fmt.Errorf("%w: not meant for %q", ErrInvalidToken, v.Audience)
+	case claims.Subject == "":
+		return "", fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims.Subject, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// verify checks the signature of what's signed with the algorithm of the
+// header, "none" and anything else that isn't known are refused
+func (v *JWTValidator) verify(ctx context.Context, header jwtHeader, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch header.Alg {
+	case "HS256":
+		if len(v.Secret) == 0 {
+			return fmt.Errorf("%w: no secret for HS256", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case "RS256":
+		key, err := v.key(ctx, header.Kid)
+		if err != nil {
+			return err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], sig) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case "ES256":
+		key, err := v.key(ctx, header.Kid)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: alg %q", ErrInvalidToken, header.Alg)
+	}
+}
+
+// key returns the key of the id, the keys are fetched
+// again if it isn't known and they weren't just now. the fetch is done
+// without the lock, so the keys known are looked up meanwhile, and the
+// validations that need it too wait for it instead of fetching again
+func (v *JWTValidator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if v.JWKSURL == "" {
+		return nil, fmt.Errorf("%w: no keys to check it with", ErrInvalidToken)
+	}
+	for {
+		v.mu.Lock()
+		if key, ok := v.keys[kid]; ok {
+			v.mu.Unlock()
+			return key, nil
+		}
+		if fetching := v.fetching; fetching != nil {
+			v.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if !v.fetched.IsZero() && time.Since(v.fetched) < jwksRefetchInterval {
+			v.mu.Unlock()
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+		}
+		fetching := make(chan struct{})
+		v.fetching = fetching
+		v.mu.Unlock()
+
+		keys, err := v.fetchKeys(ctx)
+		v.mu.Lock()
+		v.fetched = time.Now()
+		if err == nil {
+			v.keys = keys
+		}
+		v.fetching = nil
+		close(fetching)
+		v.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys fetches the RSA and P-256 keys of the JWKS, the others are skipped
+func (v *JWTValidator) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: fetching %s: %s", v.JWKSURL, res.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	b64 := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, e := b64(k.N), b64(k.E)
+			if n == nil || e == nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			// FillBytes panics on coordinates that don't fit
+			x, y := b64(k.X), b64(k.Y)
+			if x == nil || y == nil || x.BitLen() > 256 || y.BitLen() > 256 {
+				continue
+			}
+			point := append([]byte{4}, x.FillBytes(make([]byte, 32))...)
+			point = append(point, y.FillBytes(make([]byte, 32))...)
+			key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+			if err != nil {
+				continue
+			}
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"s3cret": "team-a"}
+	subject, err := tokens.Validate(context.Background(), "s3cret")
+	require.NoError(t, err)
+	require.Equal(t, "team-a", subject)
+	_, err = tokens.Validate(context.Background(), "guess")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+// signToken builds a jwt of the claims, sign signs its first two segments
+func signToken(t *testing.T, header, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	segment := func(v any) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(header) + "." + segment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestJWTValidatorSecret(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := &JWTValidator{
+		Secret:   []byte("secret"),
+		Issuer:   "vsdlog",
+		Audience: "orders",
+		Clock:    func() time.Time { return now },
+	}
+	hs256 := func(secret string) func([]byte) []byte {
+		return func(b []byte) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(b)
+			return mac.Sum(nil)
+		}
+	}
+	header := map[string]any{"alg": "HS256", "typ": "JWT"}
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{"sub": "team-a", "iss": "vsdlog", "aud": []string{"orders", "payments"}, "exp": now.Unix() + 60}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	subject, err := v.Validate(ctx, signToken(t, header, claims(nil), hs256("secret")))
+	require.NoError(t, err)
+	require.Equal(t, "team-a", subject)
+	_, err = v.Validate(ctx, signToken(t, header, claims(map[string]any{"aud": "orders"}), hs256("secret")))
+	require.NoError(t, err)
+
+	for name, token := range map[string]string{
+		"bad signature": signToken(t, header, claims(nil), hs256("guess")),
+		"expired":       signToken(t, header, claims(map[string]any{"exp": now.Unix()}), hs256("secret")),
+		"not yet valid": signToken(t, header, claims(map[string]any{"nbf": now.Unix() + 1}), hs256("secret")),
+		"issuer":        signToken(t, header, claims(map[string]any{"iss": "other"}), hs256("secret")),
+		"audience":      signToken(t, header, claims(map[string]any{"aud": "payments"}), hs256("secret")),
+		"no subject":    signToken(t, header, claims(map[string]any{"sub": ""}), hs256("secret")),
+		"none":          signToken(t, map[string]any{"alg": "none"}, claims(nil), func([]byte) []byte { return nil }),
+		"garbage":       "not.a.jwt",
+	} {
+		_, err = v.Validate(ctx, token)
+		require.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestJWTValidatorJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	}))
+	defer jwks.Close()
+	v := &JWTValidator{JWKSURL: jwks.URL}
+	ctx := context.Background()
+	claims := map[string]any{"sub": "team-b"}
+
+	rs256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return sig
+	}
+	es256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		require.NoError(t, err)
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	subject, err := v.Validate(ctx, signToken(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims, rs256))
+	require.NoError(t, err)
+	require.Equal(t, "team-b", subject)
+	subject, err = v.Validate(ctx, signToken(t, map[string]any{"alg": "ES256", "kid": "ec"}, claims, es256))
+	require.NoError(t, err)
+	require.Equal(t, "team-b", subject)
+	require.Equal(t, 1, fetches)
+
+	// a key of the wrong kind, or one that isn't published
+	_, err = v.Validate(ctx, signToken(t, map[string]any{"alg": "RS256", "kid": "ec"}, claims, rs256))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = v.Validate(ctx, signToken(t, map[string]any{"alg": "RS256", "kid": "gone"}, claims, rs256))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Equal(t, 1, fetches, "the keys were just fetched")
+
+	// tokens signed with the keys aren't taken for HS256 ones
+	_, err = v.Validate(ctx, signToken(t, map[string]any{"alg": "HS256"}, claims, rs256))
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWTValidatorJWKSFetch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	hang := make(chan struct{})
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-hang
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{
+			{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			// a coordinate too long for P-256 is skipped
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(append([]byte{1}, make([]byte, 32)...)), "y": b64(make([]byte, 32))},
+		}})
+	}))
+	defer jwks.Close()
+	defer close(hang)
+	now := time.Now()
+	v := &JWTValidator{JWKSURL: jwks.URL}
+	claims := map[string]any{"sub": "team-b"}
+	rs256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return sig
+	}
+	_, err = v.Validate(context.Background(), signToken(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims, rs256))
+	require.NoError(t, err)
+	_, err = v.Validate(context.Background(), signToken(t, map[string]any{"alg": "ES256", "kid": "ec"}, claims, rs256))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// a fetch that hangs doesn't hold up the keys already known
+	v.mu.Lock()
+	v.fetched = now.Add(-time.Minute)
+	v.mu.Unlock()
+	go v.Validate(context.Background(), signToken(t, map[string]any{"alg": "RS256", "kid": "gone"}, claims, rs256))
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+	_, err = v.Validate(context.Background(), signToken(t, map[string]any{"alg": "RS256", "kid": "rsa"}, claims, rs256))
+	require.NoError(t, err)
+	// and the ones that need it wait for it rather than fetch again
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = v.Validate(ctx, signToken(t, map[string]any{"alg": "RS256", "kid": "other"}, claims, rs256))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(2), fetches.Load())
+}
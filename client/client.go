@@ -0,0 +1,317 @@
+// Package client is a client of the log's grpc api. it keeps a connection
+// to one of the nodes of the cluster and moves on to the next one when that
+// one goes away or can't get to the leader, retrying the requests with a
+// backoff in between. produced records are sent in batches and carry the
+// headers of an idempotent producer, so a retried batch that made it the
+// first time isn't appended twice
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetries    = 5
+	defaultBackoff    = 100 * time.Millisecond
+	defaultBatchBytes = 64 << 10
+	defaultTimeout    = 10 * time.Second
+	// the backoff doubles with every retry up to this
+	maxBackoff = 5 * time.Second
+)
+
+var (
+	// ErrClosed is returned for requests made after Close
+	ErrClosed = errors.New("client: closed")
+	// ErrRequestTimeout wraps the error of a request that got no answer
+	// in RequestTimeout, it's retried on the next node like Unavailable
+	ErrRequestTimeout = errors.New("client: request timed out")
+)
+
+type Config struct {
+	// Addrs are the nodes of the cluster, they're tried in turn
+	Addrs []string
+	// DialOptions are used to connect to the nodes, insecure if empty
+	DialOptions []grpc.DialOption
+	// Acks is when produce requests are answered, see api.Acks
+	Acks api.Acks
+	// Isolation is which records Consume and ConsumeStream get to see
+	Isolation api.Isolation
+	// Retries is how many times a request is retried after it failed
+	// with an error that can go away, defaults to 5
+	Retries int
+	// Backoff is how long to wait before the first retry, it doubles
+	// with every one after it. defaults to 100ms
+	Backoff time.Duration
+	// BatchBytes is how big the batches of ProduceAsync get, a batch is
+	// sent once it's this big or its first record lingered long enough.
+	// defaults to 64KiB
+	BatchBytes int
+	// Linger is how long a record waits for more to fill its batch, the
+	// records only pile up while another batch is on its way if it's zero
+	Linger time.Duration
+	// RequestTimeout is how long a node gets to answer a request, the
+	// produces included, before it's tried on the next one. the streams
+	// aren't timed out. defaults to 10 seconds
+	RequestTimeout time.Duration
+	// PrefetchRecords and PrefetchBytes cap how far a Consumer fetches
+	// ahead of Next, they default to 1000 records and 1MiB
+	PrefetchRecords int
+	PrefetchBytes   int
+}
+
+type Client struct {
+	Config
+
+	mu     sync.Mutex
+	addr   int
+	conn   *grpc.ClientConn
+	client api.LogClient
+	closed bool
+
+	// the records waiting to be sent, see ProduceAsync
+	batchMu      sync.Mutex
+	batchCond    *sync.Cond
+	pending      []*pendingRecord
+	pendingBytes int
+	// how many of the pending records are sent right away
+	urgent   int
+	stopping bool
+	sent     chan struct{}
+
+	// the batches are sent one by one by a single goroutine, which the
+	// producer is only used by, so the sequence numbers get to the log in order
+	producerID string
+	seq        uint64
+}
+
+// New returns a client of the cluster, it connects to the first of the
+// addresses lazily, with the first request
+func New(c Config) (*Client, error) {
+	if len(c.Addrs) == 0 {
+		return nil, errors.New("client: no addresses")
+	}
+	if len(c.DialOptions) == 0 {
+		c.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	if c.Retries == 0 {
+		c.Retries = defaultRetries
+	}
+	if c.Backoff == 0 {
+		c.Backoff = defaultBackoff
+	}
+	if c.BatchBytes == 0 {
+		c.BatchBytes = defaultBatchBytes
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = defaultTimeout
+	}
+	cl := &Client{Config: c, sent: make(chan struct{})}
+	cl.batchCond = sync.NewCond(&cl.batchMu)
+	if err := cl.newProducer(); err != nil {
+		return nil, err
+	}
+	if err := cl.dial(); err != nil {
+		return nil, err
+	}
+	go cl.send()
+	return cl, nil
+}
+
+// newProducer starts a new producer session. it's done when a produce
+// fails for good, whether the record made it is anyone's guess then, and
+// the log would take the next record with the same sequence number for it
+func (c *Client) newProducer() error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	c.producerID, c.seq = hex.EncodeToString(id), 0
+	return nil
+}
+
+// dial connects to the current address, under the lock
+func (c *Client) dial() error {
+	conn, err := grpc.NewClient(c.Addrs[c.addr], c.DialOptions...)
+	if err != nil {
+		return err
+	}
+	c.conn, c.client = conn, api.NewLogClient(conn)
+	return nil
+}
+
+// current returns the client of the node in use
+func (c *Client) current() (api.LogClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	return c.client, nil
+}
+
+// failover moves on to the next node, unless another request
+// already moved on from the one that failed
+func (c *Client) failover(failed api.LogClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.client != failed || len(c.Addrs) == 1 {
+		return nil
+	}
+	c.conn.Close()
+	c.addr = (c.addr + 1) % len(c.Addrs)
+	return c.dial()
+}
+
+// retryable tells the errors that can go away by themselves, a node that's
+// down or doesn't know its leader is unavailable, the leader changing while
+// a request's handled aborts it
+func retryable(err error) bool {
+	if errors.Is(err, ErrRequestTimeout) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// do calls fn with a ctx that times out after RequestTimeout, see retry
+func (c *Client) do(ctx context.Context, fn func(context.Context, api.LogClient) error) error {
+	return c.retry(ctx, func(client api.LogClient) error {
+		rctx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+		err := fn(rctx, client)
+		if err != nil && ctx.Err() == nil && rctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%w after %s: %w", ErrRequestTimeout, c.RequestTimeout, err)
+		}
+		return err
+	})
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't retryable
+// or runs out of retries, moving on to the next node in between
+func (c *Client) retry(ctx context.Context, fn func(api.LogClient) error) error {
+	backoff := c.Backoff
+	for retry := 0; ; retry++ {
+		client, err := c.current()
+		if err != nil {
+			return err
+		}
+		err = fn(client)
+		if err == nil || !retryable(err) || retry == c.Retries {
+			return err
+		}
+		if err = c.failover(client); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Consume returns the record at off
+func (c *Client) Consume(ctx context.Context, off uint64) (*api.Record, error) {
+	var res *api.ConsumeResponse
+	err := c.do(ctx, func(ctx context.Context, client api.LogClient) (err error) {
+		res, err = client.Consume(ctx, &api.ConsumeRequest{Offset: off, Isolation: c.Isolation})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Record, nil
+}
+
+// ConsumeStream calls fn with the records from off on, and the ones
+// appended after that, until ctx is done or fn fails. a broken stream is
+// picked up again after the last record fn got, the retries only run out
+// if it breaks again and again with nothing received in between
+func (c *Client) ConsumeStream(ctx context.Context, off uint64, fn func(*api.Record) error) error {
+	for {
+		var fnErr error
+		received := false
+		err := c.retry(ctx, func(client api.LogClient) error {
+			stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: off, Isolation: c.Isolation})
+			if err != nil {
+				return err
+			}
+			for {
+				res, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					// the node shut down, another one takes over
+					return status.Error(codes.Unavailable, "stream ended")
+				}
+				if err != nil {
+					return err
+				}
+				if fnErr = fn(res.Record); fnErr != nil {
+					return fnErr
+				}
+				off, received = res.Record.Offset+1, true
+			}
+		})
+		switch {
+		case fnErr != nil:
+			return fnErr
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case received && retryable(err):
+			continue
+		}
+		return err
+	}
+}
+
+// Offsets returns the lowest and highest offsets of the log
+func (c *Client) Offsets(ctx context.Context) (lowest, highest uint64, err error) {
+	var res *api.GetOffsetsResponse
+	err = c.do(ctx, func(ctx context.Context, client api.LogClient) (err error) {
+		res, err = client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.LowestOffset, res.HighestOffset, nil
+}
+
+// Close sends the records still waiting and closes the connection,
+// the other requests still going fail
+func (c *Client) Close() error {
+	c.batchMu.Lock()
+	if !c.stopping {
+		c.stopping = true
+		c.batchCond.Broadcast()
+	}
+	c.batchMu.Unlock()
+	<-c.sent
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func serve(t *testing.T, register func(*grpc.Server)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+func newLog(t *testing.T) *log.Log {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { clog.Close() })
+	return clog
+}
+
+// serveLog serves the log like a node of the cluster does
+func serveLog(t *testing.T, clog *log.Log) string {
+	srv, err := server.NewGRPCServer(&server.Config{CommitLog: clog})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+// deadAddr is an address nobody listens on
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func TestClient(t *testing.T) {
+	// the first node is down, the client moves on to the second
+	c, err := New(Config{
+		Addrs:   []string{deadAddr(t), serveLog(t, newLog(t))},
+		Backoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := context.Background()
+
+	for i, value := range []string{"a", "b", "c"} {
+		off, err := c.Produce(ctx, &api.Record{Value: []byte(value)})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+	record, err := c.Consume(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), record.Value)
+	_, err = c.Consume(ctx, 3)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	lowest, highest, err := c.Offsets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+	require.Equal(t, uint64(2), highest)
+
+	done := errors.New("done")
+	var values []string
+	err = c.ConsumeStream(ctx, 1, func(record *api.Record) error {
+		values = append(values, string(record.Value))
+		if len(values) == 2 {
+			return done
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, done)
+	require.Equal(t, []string{"b", "c"}, values)
+
+	require.NoError(t, c.Close())
+	_, err = c.Consume(ctx, 0)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+// batchServer appends the records of the batches like the server does,
+// it can lose the answer to every other batch and cut batches short
+type batchServer struct {
+	api.UnimplementedLogServer
+	log     *log.Log
+	flaky   bool
+	limit   int
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *batchServer) ProduceBatch(_ context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, len(req.Records))
+	res := &api.ProduceBatchResponse{}
+	for i, record := range req.Records {
+		if s.limit > 0 && i == s.limit {
+			break
+		}
+		off, err := s.log.AppendRecord(log.Record{Value: record.Value, Headers: record.Headers})
+		if err != nil {
+			return nil, err
+		}
+		res.Offsets = append(res.Offsets, off)
+	}
+	if s.flaky && len(s.batches)%2 == 1 {
+		return nil, status.Error(codes.Unavailable, "lost")
+	}
+	return res, nil
+}
+
+func TestClientRetriesProduce(t *testing.T) {
+	srv := &batchServer{log: newLog(t), flaky: true}
+	addr := serve(t, func(s *grpc.Server) { api.RegisterLogServer(s, srv) })
+	c, err := New(Config{Addrs: []string{addr}, Backoff: time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+
+	// the retries get the offset the first try appended at
+	for i := 0; i < 3; i++ {
+		off, err := c.Produce(context.Background(), &api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+	require.Equal(t, []int{1, 1, 1, 1, 1, 1}, srv.batches)
+	highest, err := srv.log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+}
+
+func TestClientRunsOutOfRetries(t *testing.T) {
+	c, err := New(Config{Addrs: []string{deadAddr(t)}, Retries: 2, Backoff: time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Produce(context.Background(), &api.Record{Value: []byte("hello")})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// hungServer never answers
+type hungServer struct {
+	api.UnimplementedLogServer
+}
+
+func (hungServer) ProduceBatch(ctx context.Context, _ *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungServer) GetOffsets(ctx context.Context, _ *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	hung := serve(t, func(s *grpc.Server) { api.RegisterLogServer(s, hungServer{}) })
+	// the hung node gets its timeout, then the next node's asked
+	c, err := New(Config{
+		Addrs:          []string{hung, serveLog(t, newLog(t))},
+		Backoff:        time.Millisecond,
+		RequestTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	off, err := c.Produce(context.Background(), &api.Record{Value: []byte("hello")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	c, err = New(Config{
+		Addrs:          []string{hung},
+		Retries:        1,
+		Backoff:        time.Millisecond,
+		RequestTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Produce(context.Background(), &api.Record{Value: []byte("hello")})
+	require.ErrorIs(t, err, ErrRequestTimeout)
+	_, _, err = c.Offsets(context.Background())
+	require.ErrorIs(t, err, ErrRequestTimeout)
+}
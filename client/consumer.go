@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultPrefetchRecords = 1000
+	defaultPrefetchBytes   = 1 << 20
+)
+
+// Consumer reads the log from an offset on, one record after the other.
+// a goroutine keeps a stream open and fetches ahead of Next, up to
+// PrefetchRecords and PrefetchBytes, so it rarely waits on the network
+type Consumer struct {
+	cancel  context.CancelFunc
+	records chan *api.Record
+	stopped chan struct{}
+
+	mu       sync.Mutex
+	room     *sync.Cond
+	bytes    int
+	maxBytes int
+	closed   bool
+	err      error
+}
+
+// NewConsumer starts fetching the records from off on
+func (c *Client) NewConsumer(off uint64) *Consumer {
+	records := c.PrefetchRecords
+	if records <= 0 {
+		records = defaultPrefetchRecords
+	}
+	maxBytes := c.PrefetchBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultPrefetchBytes
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cs := &Consumer{
+		cancel:   cancel,
+		records:  make(chan *api.Record, records),
+		stopped:  make(chan struct{}),
+		maxBytes: maxBytes,
+	}
+	cs.room = sync.NewCond(&cs.mu)
+	go cs.fetch(ctx, c, off)
+	return cs
+}
+
+func (cs *Consumer) fetch(ctx context.Context, c *Client, off uint64) {
+	defer close(cs.stopped)
+	err := c.ConsumeStream(ctx, off, func(record *api.Record) error {
+		size := proto.Size(record)
+		cs.mu.Lock()
+		// a record bigger than the limit still gets through on its own
+		for cs.bytes > 0 && cs.bytes+size > cs.maxBytes && !cs.closed {
+			cs.room.Wait()
+		}
+		if cs.closed {
+			cs.mu.Unlock()
+			return ErrClosed
+		}
+		cs.bytes += size
+		cs.mu.Unlock()
+
+		select {
+		case cs.records <- record:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	cs.mu.Lock()
+	cs.err = err
+	if cs.closed {
+		cs.err = ErrClosed
+	}
+	cs.mu.Unlock()
+	close(cs.records)
+}
+
+// Next returns the next record, waiting for it to be appended if the
+// consumer's at the head of the log. it fails once the stream can't be
+// picked up again, or with ErrClosed after Close
+func (cs *Consumer) Next(ctx context.Context) (*api.Record, error) {
+	cs.mu.Lock()
+	closed := cs.closed
+	cs.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case record, ok := <-cs.records:
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		if !ok {
+			if cs.err == nil {
+				return nil, errors.New("client: stream ended")
+			}
+			return nil, cs.err
+		}
+		cs.bytes -= proto.Size(record)
+		cs.room.Signal()
+		return record, nil
+	}
+}
+
+// Close stops fetching, the records fetched ahead are dropped
+func (cs *Consumer) Close() error {
+	cs.mu.Lock()
+	cs.closed = true
+	cs.room.Broadcast()
+	cs.mu.Unlock()
+	cs.cancel()
+	<-cs.stopped
+	return nil
+}
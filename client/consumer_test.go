@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumer(t *testing.T) {
+	clog := newLog(t)
+	addr := serveLog(t, clog)
+
+	for i := 0; i < 10; i++ {
+		_, err := clog.Append([]byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+	}
+	c, err := New(Config{Addrs: []string{addr}, PrefetchRecords: 3})
+	require.NoError(t, err)
+	defer c.Close()
+	cs := c.NewConsumer(2)
+
+	// it doesn't fetch further ahead than it's allowed to
+	require.Eventually(t, func() bool {
+		return len(cs.records) == 3
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, cs.records, 3)
+
+	ctx := context.Background()
+	for i := 2; i < 10; i++ {
+		record, err := cs.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), record.Offset)
+		require.Equal(t, fmt.Sprint(i), string(record.Value))
+	}
+
+	// at the head it waits for what's appended next
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = cs.Next(short)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = clog.AppendRecord(log.Record{Value: []byte("next")})
+	require.NoError(t, err)
+	record, err := cs.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "next", string(record.Value))
+
+	require.NoError(t, cs.Close())
+	_, err = cs.Next(ctx)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestConsumerPrefetchBytes(t *testing.T) {
+	clog := newLog(t)
+	addr := serveLog(t, clog)
+
+	for i := 0; i < 10; i++ {
+		_, err := clog.Append(make([]byte, 100))
+		require.NoError(t, err)
+	}
+	c, err := New(Config{Addrs: []string{addr}, PrefetchBytes: 250})
+	require.NoError(t, err)
+	defer c.Close()
+	cs := c.NewConsumer(0)
+	defer cs.Close()
+
+	require.Eventually(t, func() bool {
+		return len(cs.records) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, cs.records, 2)
+	for i := 0; i < 10; i++ {
+		record, err := cs.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), record.Offset)
+	}
+}
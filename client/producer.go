@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProduceAsync blocks once this many batches are waiting to be sent
+const maxPendingBatches = 16
+
+// Future is the outcome of a record given to ProduceAsync
+type Future struct {
+	done chan struct{}
+	off  uint64
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(off uint64, err error) {
+	f.off, f.err = off, err
+	close(f.done)
+}
+
+// Done is closed once the record is appended, or failed to be
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the offset of the record once it's appended, which
+// is zero with api.Acks_ACKS_NONE as it isn't known yet
+func (f *Future) Wait(ctx context.Context) (uint64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-f.done:
+		return f.off, f.err
+	}
+}
+
+type pendingRecord struct {
+	record *api.Record
+	size   int
+	at     time.Time
+	future *Future
+}
+
+// Produce appends the record and returns its offset, which is zero with
+// api.Acks_ACKS_NONE as it isn't known yet. the batch it goes out with
+// is sent right away, along with the records waiting before it
+func (c *Client) Produce(ctx context.Context, record *api.Record) (uint64, error) {
+	f := c.ProduceAsync(record)
+	c.hurry()
+	return f.Wait(ctx)
+}
+
+// ProduceAsync queues the record to be sent with the ones around it, in a
+// batch of up to BatchBytes that's sent once it's full or its first record
+// lingered for Linger. the records are appended in the order they're queued.
+// it blocks while a lot of batches are waiting already
+func (c *Client) ProduceAsync(record *api.Record) *Future {
+	f := newFuture()
+	p := &pendingRecord{record: record, size: proto.Size(record), at: time.Now(), future: f}
+
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	for c.pendingBytes >= maxPendingBatches*c.BatchBytes && !c.stopping {
+		c.batchCond.Wait()
+	}
+	if c.stopping {
+		f.resolve(0, ErrClosed)
+		return f
+	}
+	c.pending = append(c.pending, p)
+	c.pendingBytes += p.size
+	c.batchCond.Broadcast()
+	return f
+}
+
+// Flush sends the records queued so far without waiting for them to
+// linger and waits until they're all appended, or failed to be
+func (c *Client) Flush(ctx context.Context) error {
+	c.batchMu.Lock()
+	var last *Future
+	if n := len(c.pending); n > 0 {
+		last = c.pending[n-1].future
+	}
+	c.batchMu.Unlock()
+	if last == nil {
+		return nil
+	}
+	c.hurry()
+	// the batches are answered in order
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-last.Done():
+		return nil
+	}
+}
+
+// hurry has the records queued so far sent without lingering
+func (c *Client) hurry() {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	c.urgent = len(c.pending)
+	c.batchCond.Broadcast()
+}
+
+// send sends the batches until the client's closed
+func (c *Client) send() {
+	defer close(c.sent)
+	for {
+		batch, ok := c.nextBatch()
+		if !ok {
+			return
+		}
+		c.sendBatch(batch)
+	}
+}
+
+// nextBatch waits until a batch is due and takes it off the pending
+// records, ok is false once the client's closed and they're all sent
+func (c *Client) nextBatch() (batch []*pendingRecord, ok bool) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	for {
+		if len(c.pending) == 0 {
+			if c.stopping {
+				return nil, false
+			}
+			c.batchCond.Wait()
+			continue
+		}
+		linger := c.Linger - time.Since(c.pending[0].at)
+		if c.urgent > 0 || c.stopping || linger <= 0 ||
+			c.pendingBytes >= c.BatchBytes || len(c.pending) >= log.ProducerWindow {
+			break
+		}
+		t := time.AfterFunc(linger, func() {
+			c.batchMu.Lock()
+			defer c.batchMu.Unlock()
+			c.batchCond.Broadcast()
+		})
+		c.batchCond.Wait()
+		t.Stop()
+	}
+
+	// a retried batch can't go back further than the log's window
+	n, size := 0, 0
+	for n < len(c.pending) && n < log.ProducerWindow && (n == 0 || size+c.pending[n].size <= c.BatchBytes) {
+		size += c.pending[n].size
+		n++
+	}
+	batch = c.pending[:n:n]
+	c.pending = c.pending[n:]
+	c.pendingBytes -= size
+	c.urgent = max(0, c.urgent-n)
+	c.batchCond.Broadcast()
+	return batch, true
+}
+
+// sendBatch produces the batch and resolves the futures of its records.
+// what's left of a batch that was cut short is sent again on its own
+func (c *Client) sendBatch(batch []*pendingRecord) {
+	records := make([]*api.Record, len(batch))
+	for i, p := range batch {
+		headers := make(map[string]string, len(p.record.Headers)+2)
+		for k, v := range p.record.Headers {
+			headers[k] = v
+		}
+		headers[log.ProducerIDHeader] = c.producerID
+		headers[log.ProducerSeqHeader] = strconv.FormatUint(c.seq+uint64(i), 10)
+		records[i] = &api.Record{Key: p.record.Key, Value: p.record.Value, Headers: headers, Timestamp: p.record.Timestamp}
+	}
+	for len(batch) > 0 {
+		req := &api.ProduceBatchRequest{Records: records, Acks: c.Acks}
+		var res *api.ProduceBatchResponse
+		err := c.do(context.Background(), func(ctx context.Context, client api.LogClient) (err error) {
+			res, err = client.ProduceBatch(ctx, req)
+			return err
+		})
+		if err == nil && len(res.Offsets) == 0 {
+			err = errors.New("client: nothing appended")
+		}
+		if err != nil {
+			if perr := c.newProducer(); perr != nil {
+				err = errors.Join(err, perr)
+			}
+			for _, p := range batch {
+				p.future.resolve(0, err)
+			}
+			return
+		}
+		n := min(len(res.Offsets), len(batch))
+		for i := 0; i < n; i++ {
+			batch[i].future.resolve(res.Offsets[i], nil)
+		}
+		c.seq += uint64(n)
+		batch, records = batch[n:], records[n:]
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProduceAsync(t *testing.T) {
+	record := &api.Record{Value: []byte("hello")}
+	for name, test := range map[string]struct {
+		config  Config
+		flaky   bool
+		limit   int
+		batches []int
+	}{
+		"lingering fills a batch": {
+			config:  Config{Linger: time.Hour},
+			batches: []int{10},
+		},
+		"full batches are sent right away": {
+			config:  Config{Linger: time.Hour, BatchBytes: 3 * proto.Size(record)},
+			batches: []int{3, 3, 3, 1},
+		},
+		"the rest of a batch cut short is sent again": {
+			config:  Config{Linger: time.Hour},
+			limit:   4,
+			batches: []int{10, 6, 2},
+		},
+		"retried batches get their offsets back": {
+			config:  Config{Linger: time.Hour},
+			flaky:   true,
+			batches: []int{10, 10},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := &batchServer{log: newLog(t), flaky: test.flaky, limit: test.limit}
+			addr := serve(t, func(s *grpc.Server) { api.RegisterLogServer(s, srv) })
+			test.config.Addrs = []string{addr}
+			test.config.Backoff = time.Millisecond
+			c, err := New(test.config)
+			require.NoError(t, err)
+			defer c.Close()
+
+			var futures []*Future
+			for i := 0; i < 10; i++ {
+				futures = append(futures, c.ProduceAsync(record))
+			}
+			ctx := context.Background()
+			require.NoError(t, c.Flush(ctx))
+			for i, f := range futures {
+				off, err := f.Wait(ctx)
+				require.NoError(t, err)
+				require.Equal(t, uint64(i), off)
+			}
+			require.Equal(t, test.batches, srv.batches)
+		})
+	}
+}
+
+func TestProduceAsyncLinger(t *testing.T) {
+	srv := &batchServer{log: newLog(t)}
+	addr := serve(t, func(s *grpc.Server) { api.RegisterLogServer(s, srv) })
+	c, err := New(Config{Addrs: []string{addr}, Linger: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	f := c.ProduceAsync(&api.Record{Value: []byte("hello")})
+	select {
+	case <-f.Done():
+		t.Fatal("the record didn't linger")
+	case <-time.After(10 * time.Millisecond):
+	}
+	off, err := f.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// closing sends what's left
+	f = c.ProduceAsync(&api.Record{Value: []byte("hello")})
+	require.NoError(t, c.Close())
+	off, err = f.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = c.ProduceAsync(&api.Record{Value: []byte("hello")}).Wait(context.Background())
+	require.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+)
+
+// csvHeader are the columns of an export to csv, the headers
+// of a record are a json object in a column of their own
+var csvHeader = []string{"offset", "timestamp", "event_time", "key", "headers", "value"}
+
+// export writes the records from from up to to, the end of the log if it's
+// zero, as json lines like dump prints them or as csv
+func export(l *log.Log, out io.Writer, from, to uint64, format string) error {
+	var write func(off uint64, record log.Record) error
+	switch format {
+	case "", "jsonl":
+		enc := json.NewEncoder(out)
+		write = func(off uint64, record log.Record) error {
+			return enc.Encode(newDumped(off, record))
+		}
+	case "csv":
+		w := csv.NewWriter(out)
+		defer w.Flush()
+		if err := w.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(off uint64, record log.Record) error {
+			row, err := csvRow(off, record)
+			if err != nil {
+				return err
+			}
+			return w.Write(row)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	var n int
+	it := l.Iterator(from)
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) || (err == nil && to > 0 && it.Offset() >= to) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("export failed after %d records: %w", n, err)
+		}
+		if err = write(it.Offset(), record); err != nil {
+			return err
+		}
+		n++
+	}
+	return nil
+}
+
+func csvRow(off uint64, record log.Record) ([]string, error) {
+	row := []string{strconv.FormatUint(off, 10), "", "", string(record.Key), "", string(record.Value)}
+	if !record.Timestamp.IsZero() {
+		row[1] = record.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if !record.EventTime.IsZero() {
+		row[2] = record.EventTime.UTC().Format(time.RFC3339Nano)
+	}
+	if len(record.Headers) > 0 {
+		headers, err := json.Marshal(record.Headers)
+		if err != nil {
+			return nil, err
+		}
+		row[4] = string(headers)
+	}
+	return row, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := log.Config{Clock: func() time.Time { return now }}
+	c.Segment.MaxStoreBytes = 64
+	l, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	for _, record := range []log.Record{
+		{Value: []byte("first")},
+		{Key: []byte("user"), Value: []byte("second, with a comma"), Headers: map[string]string{"source": "test"}},
+		{Key: []byte("user"), Value: []byte("third")},
+		{Value: []byte("fourth")},
+	} {
+		_, err = l.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"export", "-dir", dir, "-from", "1", "-to", "3"}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"offset":1`)
+	require.Contains(t, lines[0], `"timestamp":"2024-01-02T03:04:05Z"`)
+	require.Contains(t, lines[0], `"headers":{"source":"test"}`)
+	require.Contains(t, lines[1], `"value":"third"`)
+
+	path := filepath.Join(t.TempDir(), "records.csv")
+	out.Reset()
+	require.NoError(t, run([]string{"export", "-dir", dir, "-format", "csv", "-out", path}, &out))
+	require.Empty(t, out.String())
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		csvHeader,
+		{"0", "2024-01-02T03:04:05Z", "", "", "", "first"},
+		{"1", "2024-01-02T03:04:05Z", "", "user", `{"source":"test"}`, "second, with a comma"},
+		{"2", "2024-01-02T03:04:05Z", "", "user", "", "third"},
+		{"3", "2024-01-02T03:04:05Z", "", "", "", "fourth"},
+	}, rows)
+
+	require.Error(t, run([]string{"export", "-dir", dir, "-format", "xml"}, &bytes.Buffer{}))
+
+	// what's exported is imported back as it was
+	exported := filepath.Join(t.TempDir(), "records.jsonl")
+	require.NoError(t, run([]string{"export", "-dir", dir, "-out", exported}, &bytes.Buffer{}))
+	imported := t.TempDir()
+	out.Reset()
+	require.NoError(t, run([]string{"import", "-dir", imported, "-in", exported}, &out))
+	require.Equal(t, "ok: 4 records imported\n", out.String())
+	out.Reset()
+	require.NoError(t, run([]string{"export", "-dir", imported, "-format", "csv"}, &out))
+	rows, err = csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 5)
+	require.Equal(t, []string{"1", "2024-01-02T03:04:05Z", "", "user", `{"source":"test"}`, "second, with a comma"}, rows[2])
+}
@@ -0,0 +1,302 @@
+// vsdlogctl inspects the segments of a log directory, and manages
+// the topics of a running node
+//
+//	vsdlogctl segments -dir data/log
+//	vsdlogctl offsets -dir data/log
+//	vsdlogctl dump -dir data/log -from 10 -n 5 -format json
+//	vsdlogctl export -dir data/log -from 10 -to 20 -format csv -out records.csv
+//	vsdlogctl import -dir data/log -in records.jsonl
+//	vsdlogctl verify -dir data/log
+//	vsdlogctl migrate -dir data/log -framing varint
+//	vsdlogctl rebuild-index -dir data/log
+//	vsdlogctl topics create -addr localhost:8400 -name orders -partitions 3 -retention-max-age 168h
+//	vsdlogctl topics describe -addr localhost:8400 -name orders
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+)
+
+const usage = `usage: vsdlogctl <command> -dir <log dir> [flags]
+
+commands:
+  segments  print the offset range, record count and sizes of every segment
+  offsets   print the lowest and highest offsets of the log
+  dump      print records in hex or json
+  export    write a range of records as json lines or csv, to stdout or -out
+  import    append the records of json lines or of a dump of the stores, from stdin or -in
+  verify    read every record, checking its checksum, and the indexes
+  migrate   rewrite the segments to the current on-disk format, the log can't be open
+  rebuild-index
+            rebuild the indexes from the stores, the log can't be open
+  topics    create, delete, list and describe the topics of a node, see vsdlogctl topics
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "vsdlogctl:", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("see usage above")
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, usage)
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	if cmd == "topics" {
+		return topics(args, out)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("dir", "", "log directory")
+	keyPrefix := fs.String("key-env-prefix", "", "prefix of the env vars holding the encryption keys")
+	from := fs.Uint64("from", 0, "dump, export: first offset to print")
+	to := fs.Uint64("to", 0, "export: offset to stop before, the end of the log if zero")
+	n := fs.Int("n", -1, "dump: how many records to print, all if negative")
+	format := fs.String("format", "", "dump: hex (default) or json, export: jsonl (default) or csv")
+	output := fs.String("out", "", "export: file to write to, stdout if empty")
+	input := fs.String("in", "", "import: file to read from, stdin if empty")
+	framing := fs.String("framing", "fixed", "migrate: framing of the stores, fixed or varint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		fs.Usage()
+		return errUsage
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+
+	c := log.Config{}
+	if *keyPrefix != "" {
+		c.Store.KeyProvider = &log.EnvKeyProvider{Prefix: *keyPrefix}
+	}
+	// migrate and rebuild-index open the log themselves
+	switch cmd {
+	case "migrate":
+		return migrate(*dir, c, *framing, out)
+	case "rebuild-index":
+		return rebuildIndex(*dir, c, out)
+	}
+	// only import writes, the others leave the directory as it is
+	// for a crashed log or one that's open elsewhere
+	open := log.OpenReadOnly
+	if cmd == "import" {
+		open = log.NewLog
+	}
+	l, err := open(*dir, c)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	switch cmd {
+	case "segments":
+		return segments(l, out)
+	case "offsets":
+		return offsets(l, out)
+	case "dump":
+		return dump(l, out, *from, *n, *format)
+	case "export":
+		if *output == "" {
+			return export(l, out, *from, *to, *format)
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		if err = export(l, f, *from, *to, *format); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "import":
+		return importRecords(l, out, *input)
+	case "verify":
+		return verify(l, out)
+	}
+	fmt.Fprint(out, usage)
+	return errUsage
+}
+
+func segments(l *log.Log, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE\tNEXT\tRECORDS\tSTORE BYTES\tINDEX BYTES")
+	for _, s := range l.Segments() {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\n",
+			s.BaseOffset, s.NextOffset, s.Records, s.StoreBytes, s.IndexBytes)
+	}
+	return w.Flush()
+}
+
+func offsets(l *log.Log, out io.Writer) error {
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return err
+	}
+	highest, err := l.HighestOffset()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "lowest: %d\nhighest: %d\n", lowest, highest)
+	return nil
+}
+
+// newDumped is the record at off as it's printed in json,
+// the way it's read back by an import
+func newDumped(off uint64, record log.Record) log.JSONRecord {
+	d := log.JSONRecord{Offset: off, Headers: record.Headers}
+	if !record.Timestamp.IsZero() {
+		d.Timestamp = &record.Timestamp
+	}
+	if !record.EventTime.IsZero() {
+		d.EventTime = &record.EventTime
+	}
+	if record.Key != nil {
+		key := string(record.Key)
+		d.Key = &key
+	}
+	if record.Value != nil {
+		value := string(record.Value)
+		d.Value = &value
+	}
+	return d
+}
+
+// errDone stops the scan once enough records were printed
+var errDone = errors.New("done")
+
+func dump(l *log.Log, out io.Writer, from uint64, n int, format string) error {
+	if format == "" {
+		format = "hex"
+	}
+	if format != "hex" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	enc := json.NewEncoder(out)
+	err := l.Scan(func(off uint64, record log.Record) error {
+		if off < from {
+			return nil
+		}
+		if n == 0 {
+			return errDone
+		}
+		n--
+
+		if format == "hex" {
+			fmt.Fprintf(out, "offset %d", off)
+			if !record.Timestamp.IsZero() {
+				fmt.Fprintf(out, " at %s", record.Timestamp.UTC().Format(time.RFC3339Nano))
+			}
+			if record.Key != nil {
+				fmt.Fprintf(out, " key %q", record.Key)
+			}
+			if record.IsTombstone() {
+				fmt.Fprint(out, " tombstone")
+			}
+			fmt.Fprintln(out)
+			names := make([]string, 0, len(record.Headers))
+			for name := range record.Headers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(out, "  %s: %s\n", name, record.Headers[name])
+			}
+			fmt.Fprint(out, hex.Dump(record.Value))
+			return nil
+		}
+
+		return enc.Encode(newDumped(off, record))
+	})
+	if errors.Is(err, errDone) {
+		return nil
+	}
+	return err
+}
+
+func verify(l *log.Log, out io.Writer) error {
+	corruptions, err := l.Verify()
+	if err != nil {
+		return fmt.Errorf("verify failed: %w", err)
+	}
+	if len(corruptions) > 0 {
+		errs := make([]error, len(corruptions))
+		for i, c := range corruptions {
+			errs[i] = c
+		}
+		return fmt.Errorf("verify found %d corruptions: %w", len(corruptions), errors.Join(errs...))
+	}
+	var records, bytes uint64
+	err = l.Scan(func(_ uint64, record log.Record) error {
+		records++
+		bytes += uint64(len(record.Key) + len(record.Value))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("verify failed after %d records: %w", records, err)
+	}
+	fmt.Fprintf(out, "ok: %d records, %d bytes of keys and values\n", records, bytes)
+	return nil
+}
+
+func importRecords(l *log.Log, out io.Writer, input string) error {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := l.ImportFrom(r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "ok: %d records imported\n", n)
+	return nil
+}
+
+func migrate(dir string, c log.Config, framing string, out io.Writer) error {
+	switch framing {
+	case "fixed":
+		c.Store.Framing = log.FramingFixed
+	case "varint":
+		c.Store.Framing = log.FramingVarint
+	default:
+		return fmt.Errorf("unknown framing %q", framing)
+	}
+	n, err := log.Migrate(dir, c)
+	if err != nil {
+		return fmt.Errorf("migrate failed after %d segments: %w", n, err)
+	}
+	fmt.Fprintf(out, "ok: %d segments migrated\n", n)
+	return nil
+}
+
+func rebuildIndex(dir string, c log.Config, out io.Writer) error {
+	n, err := log.RebuildIndexes(dir, c)
+	if err != nil {
+		return fmt.Errorf("rebuild-index failed: %w", err)
+	}
+	fmt.Fprintf(out, "ok: %d indexes rebuilt\n", n)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 64
+	l, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	for _, record := range []log.Record{
+		{Value: []byte("first")},
+		{Key: []byte("user"), Value: []byte("second"), Headers: map[string]string{"source": "test"}},
+		{Key: []byte("user")},
+	} {
+		_, err = l.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	runOut := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		require.NoError(t, run(append(args, "-dir", dir), &out))
+		return out.String()
+	}
+
+	require.Contains(t, runOut("offsets"), "lowest: 0\nhighest: 2\n")
+	require.Contains(t, runOut("segments"), "BASE  NEXT  RECORDS")
+	require.Equal(t, "ok: 3 records, 19 bytes of keys and values\n", runOut("verify"))
+
+	out := runOut("dump", "-format", "json", "-from", "1", "-n", "1")
+	require.Contains(t, out, `"offset":1`)
+	require.Contains(t, out, `"key":"user"`)
+	require.Contains(t, out, `"headers":{"source":"test"}`)
+	require.Contains(t, out, `"value":"second"`)
+	require.NotContains(t, out, `"offset":2`)
+
+	out = runOut("dump")
+	require.Contains(t, out, "offset 2")
+	require.Contains(t, out, `key "user" tombstone`)
+	require.Contains(t, out, "  source: test\n")
+	require.Contains(t, out, "|first|")
+
+	require.Equal(t, "ok: 2 segments migrated\n", runOut("migrate", "-framing", "varint"))
+	require.Equal(t, "ok: 0 segments migrated\n", runOut("migrate", "-framing", "varint"))
+	require.Equal(t, "ok: 3 records, 19 bytes of keys and values\n", runOut("verify"))
+	require.Error(t, run([]string{"migrate", "-dir", dir, "-framing", "other"}, &bytes.Buffer{}))
+	require.Equal(t, "ok: 2 segments migrated\n", runOut("migrate"))
+
+	// a garbled index is left for rebuild-index to rebuild
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.index"), []byte("garbage"), 0644))
+	err = run([]string{"offsets", "-dir", dir}, &bytes.Buffer{})
+	require.ErrorIs(t, err, log.ErrCorruptIndex)
+	b, err := os.ReadFile(filepath.Join(dir, "0.index"))
+	require.NoError(t, err)
+	require.Equal(t, "garbage", string(b))
+	require.Equal(t, "ok: 3 indexes rebuilt\n", runOut("rebuild-index"))
+	require.Equal(t, "ok: 3 records, 19 bytes of keys and values\n", runOut("verify"))
+
+	// a corrupt record fails verification
+	store, err := os.ReadFile(filepath.Join(dir, "0.store"))
+	require.NoError(t, err)
+	fi, err := os.Stat(filepath.Join(dir, "2.store"))
+	require.NoError(t, err)
+	size := fi.Size()
+	f, err := os.OpenFile(filepath.Join(dir, "0.store"), os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("X"), 30)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	err = run([]string{"verify", "-dir", dir}, &bytes.Buffer{})
+	require.ErrorIs(t, err, log.ErrCorruptRecord)
+
+	// a torn record is reported, not dropped
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.store"), store, 0644))
+	f, err = os.OpenFile(filepath.Join(dir, "2.store"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Contains(t, runOut("offsets"), "lowest: 0\nhighest: 2\n")
+	err = run([]string{"verify", "-dir", dir}, &bytes.Buffer{})
+	require.ErrorIs(t, err, log.ErrCorruptRecord)
+	fi, err = os.Stat(filepath.Join(dir, "2.store"))
+	require.NoError(t, err)
+	require.Equal(t, size+3, fi.Size())
+
+	require.Error(t, run(nil, &bytes.Buffer{}))
+	require.Error(t, run([]string{"unknown", "-dir", dir}, &bytes.Buffer{}))
+}
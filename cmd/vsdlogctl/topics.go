@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const topicsUsage = `usage: vsdlogctl topics <command> -addr <rpc addr> [flags]
+
+commands:
+  create    create the topic -name, with -partitions and the overrides given
+  delete    delete the topic -name and all of its data
+  list      print the topics and how many partitions they have
+  describe  print the overrides of the topic -name and the offsets of its partitions
+`
+
+// compressions are the codecs by the names -compression takes
+var compressions = map[string]api.Compression{
+	"none":      api.Compression_COMPRESSION_NONE,
+	"snappy":    api.Compression_COMPRESSION_SNAPPY,
+	"lz4":       api.Compression_COMPRESSION_LZ4,
+	"zstd":      api.Compression_COMPRESSION_ZSTD,
+	"zstd-dict": api.Compression_COMPRESSION_ZSTD_DICT,
+}
+
+// topics manages the topics of a running node through its admin rpcs
+func topics(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, topicsUsage)
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("topics "+cmd, flag.ContinueOnError)
+	fs.SetOutput(out)
+	addr := fs.String("addr", "", "rpc address of the node")
+	caFile := fs.String("ca-file", "", "ca to verify the node with, the connection's insecure if empty")
+	certFile := fs.String("cert-file", "", "client certificate")
+	keyFile := fs.String("key-file", "", "key of the client certificate")
+	token := fs.String("token", "", "bearer token to authenticate with")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for the node")
+	name := fs.String("name", "", "create, delete, describe: the topic")
+	partitions := fs.Uint("partitions", 1, "create: how many partitions the topic has")
+	maxStoreBytes := fs.Uint64("max-store-bytes", 0, "create: size of the segments' stores")
+	maxIndexBytes := fs.Uint64("max-index-bytes", 0, "create: size of the segments' indexes")
+	retentionMaxAge := fs.Duration("retention-max-age", 0, "create: how long records are kept, zero turns it off")
+	retentionMaxBytes := fs.Uint64("retention-max-bytes", 0, "create: how many bytes a partition keeps, zero turns it off")
+	compactionInterval := fs.Duration("compaction-interval", 0, "create: how often the topic's compacted, zero turns it off")
+	punchHoles := fs.Bool("punch-holes", false, "create: punch out what compaction drops instead of rewriting the segments")
+	compression := fs.String("compression", "", "create: none, snappy, lz4, zstd or zstd-dict")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *addr == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if *caFile != "" {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: *certFile,
+			KeyFile:  *keyFile,
+			CAFile:   *caFile,
+		})
+		if err != nil {
+			return err
+		}
+		opts[0] = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	if *token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerCredentials{
+			token:  *token,
+			secure: *caFile != "",
+		}))
+	}
+	conn, err := grpc.NewClient(*addr, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := api.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch cmd {
+	case "create":
+		c := &api.TopicConfig{}
+		var err error
+		// only the flags given are overridden
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "max-store-bytes":
+				c.MaxStoreBytes = maxStoreBytes
+			case "max-index-bytes":
+				c.MaxIndexBytes = maxIndexBytes
+			case "retention-max-age":
+				c.RetentionMaxAge = durationpb.New(*retentionMaxAge)
+			case "retention-max-bytes":
+				c.RetentionMaxBytes = retentionMaxBytes
+			case "compaction-interval":
+				c.CompactionInterval = durationpb.New(*compactionInterval)
+			case "punch-holes":
+				c.PunchHoles = punchHoles
+			case "compression":
+				codec, ok := compressions[*compression]
+				if !ok {
+					err = fmt.Errorf("unknown compression %q", *compression)
+				}
+				c.Compression = &codec
+			}
+		})
+		if err != nil {
+			return err
+		}
+		res, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+			Name:       *name,
+			Partitions: uint32(*partitions),
+			Config:     c,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ok: %s created with %d partitions\n", res.Topic.Name, res.Topic.Partitions)
+		return nil
+	case "delete":
+		if _, err := client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: *name}); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ok: %s deleted\n", *name)
+		return nil
+	case "list":
+		res, err := client.ListTopics(ctx, &api.ListTopicsRequest{})
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tPARTITIONS")
+		for _, topic := range res.Topics {
+			fmt.Fprintf(w, "%s\t%d\n", topic.Name, topic.Partitions)
+		}
+		return w.Flush()
+	case "describe":
+		res, err := client.DescribeTopic(ctx, &api.DescribeTopicRequest{Name: *name})
+		if err != nil {
+			return err
+		}
+		return describe(res.Topic, out)
+	}
+	fmt.Fprint(out, topicsUsage)
+	return errUsage
+}
+
+// describe prints the overrides of the topic, the ones it
+// doesn't have are left out, and the offsets of its partitions
+func describe(topic *api.Topic, out io.Writer) error {
+	fmt.Fprintf(out, "name: %s\npartitions: %d\n", topic.Name, topic.Partitions)
+	c := topic.Config
+	if c.MaxStoreBytes != nil {
+		fmt.Fprintf(out, "max-store-bytes: %d\n", *c.MaxStoreBytes)
+	}
+	if c.MaxIndexBytes != nil {
+		fmt.Fprintf(out, "max-index-bytes: %d\n", *c.MaxIndexBytes)
+	}
+	if c.RetentionMaxAge != nil {
+		fmt.Fprintf(out, "retention-max-age: %s\n", c.RetentionMaxAge.AsDuration())
+	}
+	if c.RetentionMaxBytes != nil {
+		fmt.Fprintf(out, "retention-max-bytes: %d\n", *c.RetentionMaxBytes)
+	}
+	if c.CompactionInterval != nil {
+		fmt.Fprintf(out, "compaction-interval: %s\n", c.CompactionInterval.AsDuration())
+	}
+	if c.PunchHoles != nil {
+		fmt.Fprintf(out, "punch-holes: %t\n", *c.PunchHoles)
+	}
+	if c.Compression != nil {
+		for name, codec := range compressions {
+			if codec == *c.Compression {
+				fmt.Fprintf(out, "compression: %s\n", name)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tLOWEST\tHIGHEST")
+	for _, p := range topic.PartitionOffsets {
+		fmt.Fprintf(w, "%d\t%d\t%d\n", p.Partition, p.LowestOffset, p.HighestOffset)
+	}
+	return w.Flush()
+}
+
+// bearerCredentials sends the token in the authorization header of every rpc
+type bearerCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c bearerCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c bearerCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
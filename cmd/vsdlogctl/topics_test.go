@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopics(t *testing.T) {
+	dir := t.TempDir()
+	topics, err := log.NewTopics(filepath.Join(dir, "topics"), log.TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	clog, err := log.NewLog(filepath.Join(dir, "log"), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{CommitLog: clog, Topics: topics})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	runOut := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		require.NoError(t, run(append(append([]string{"topics"}, args...), "-addr", l.Addr().String()), &out))
+		return out.String()
+	}
+
+	require.Equal(t, "ok: orders created with 2 partitions\n", runOut("create",
+		"-name", "orders", "-partitions", "2", "-retention-max-age", "24h", "-compression", "lz4"))
+	require.Error(t, run([]string{"topics", "create", "-addr", l.Addr().String(), "-name", "orders"}, &bytes.Buffer{}))
+	require.Error(t, run([]string{"topics", "create", "-addr", l.Addr().String(),
+		"-name", "payments", "-compression", "gzip"}, &bytes.Buffer{}))
+
+	orders, err := topics.Topic("orders")
+	require.NoError(t, err)
+	require.Nil(t, orders.Overrides().MaxStoreBytes)
+	partition, err := orders.Partition(1)
+	require.NoError(t, err)
+	for range 2 {
+		_, err = partition.AppendRecord(log.Record{Value: []byte("order")})
+		require.NoError(t, err)
+	}
+
+	out := runOut("list")
+	require.Contains(t, out, "NAME")
+	require.Contains(t, out, "orders")
+
+	out = runOut("describe", "-name", "orders")
+	require.Contains(t, out, "partitions: 2\n")
+	require.Contains(t, out, "retention-max-age: 24h0m0s\n")
+	require.Contains(t, out, "compression: lz4\n")
+	require.NotContains(t, out, "max-store-bytes")
+	require.Contains(t, out, "1          0       1\n")
+
+	require.Equal(t, "ok: orders deleted\n", runOut("delete", "-name", "orders"))
+	require.NotContains(t, runOut("list"), "orders")
+	require.ErrorIs(t, run([]string{"topics", "rename", "-addr", l.Addr().String()}, &bytes.Buffer{}), errUsage)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultCertCheckInterval is how often the files are checked for changes
+const defaultCertCheckInterval = 10 * time.Second
+
+// CertReloader hands out the certificate of the files, it's loaded again
+// once they've changed. they're checked on handshakes, every so often, so
+// short lived certificates renewed in place are picked up by the
+// connections made after, the ones already up keep theirs
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// Interval is how often the files are checked at most, defaults to 10s
+	Interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	loaded  [2]os.FileInfo
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate, it fails if it can't be
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load loads the certificate if the files changed since the last time,
+// under the lock
+func (r *CertReloader) load() error {
+	r.checked = time.Now()
+	var infos [2]os.FileInfo
+	for i, name := range []string{r.CertFile, r.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		infos[i] = info
+	}
+	if r.cert != nil && unchanged(r.loaded[0], infos[0]) && unchanged(r.loaded[1], infos[1]) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.loaded = &cert, infos
+	return nil
+}
+
+func unchanged(a, b os.FileInfo) bool {
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// Certificate returns the current certificate. the one loaded before is
+// kept if the files can't be loaded, e.g. since only one of them has been
+// written yet, they're tried again the next time
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultCertCheckInterval
+	}
+	if time.Since(r.checked) >= interval {
+		_ = r.load()
+	}
+	return r.cert
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
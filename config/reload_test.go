@@ -0,0 +1,118 @@
+package config
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/internal/testcerts"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	oldCerts, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+	newCerts, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	modTime := time.Now()
+	install := func(certs testcerts.Files) {
+		modTime = modTime.Add(time.Second)
+		for src, dst := range map[string]string{certs.ServerCertFile: certFile, certs.ServerKeyFile: keyFile} {
+			b, err := os.ReadFile(src)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(dst, b, 0600))
+			require.NoError(t, os.Chtimes(dst, modTime, modTime))
+		}
+	}
+	install(oldCerts)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	r.Interval = time.Nanosecond
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: r.GetCertificate})
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 1)
+				for {
+					if _, err := conn.Read(b); err != nil {
+						return
+					}
+					if _, err := conn.Write(b); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	dial := func(certs testcerts.Files) (*tls.Conn, error) {
+		clientConfig, err := SetupTLSConfig(TLSConfig{CAFile: certs.CAFile, ServerAddress: "127.0.0.1"})
+		require.NoError(t, err)
+		conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+		return conn, err
+	}
+	echo := func(conn *tls.Conn) {
+		_, err := conn.Write([]byte{1})
+		require.NoError(t, err)
+		_, err = conn.Read(make([]byte, 1))
+		require.NoError(t, err)
+	}
+
+	before, err := dial(oldCerts)
+	require.NoError(t, err)
+	echo(before)
+
+	// the renewed certificate is served to the connections made after
+	install(newCerts)
+	_, err = dial(newCerts)
+	require.NoError(t, err)
+	_, err = dial(oldCerts)
+	require.Error(t, err)
+	// the connection made before is still up
+	echo(before)
+}
+
+func TestCertReloaderKeepsCertificate(t *testing.T) {
+	certs, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+	c, err := SetupTLSConfig(TLSConfig{
+		CertFile:   certs.ServerCertFile,
+		KeyFile:    certs.ServerKeyFile,
+		Server:     true,
+		WatchCerts: true,
+	})
+	require.NoError(t, err)
+	require.Empty(t, c.Certificates)
+	require.NotNil(t, c.GetCertificate)
+
+	r, err := NewCertReloader(certs.ServerCertFile, certs.ServerKeyFile)
+	require.NoError(t, err)
+	r.Interval = time.Nanosecond
+	cert := r.Certificate()
+	require.NotNil(t, cert)
+
+	// a pair that's only half written doesn't replace the certificate
+	b, err := os.ReadFile(certs.RootClientKeyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certs.ServerKeyFile, b, 0600))
+	require.NoError(t, os.Chtimes(certs.ServerKeyFile, time.Now().Add(time.Second), time.Now().Add(time.Second)))
+	require.Same(t, cert, r.Certificate())
+
+	_, err = NewCertReloader(certs.ServerCertFile, filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+type TLSConfig struct {
+	CertFile      string
+	KeyFile       string
+	CAFile        string
+	ServerAddress string
+	Server        bool
+	// ClientAuth is the policy servers verify client certificates with
+	// defaults to requiring and verifying them when a CA is given
+	ClientAuth tls.ClientAuthType
+	// WatchCerts loads CertFile and KeyFile again once they change, so the
+	// handshakes after get the renewed certificate, see CertReloader
+	WatchCerts bool
+	// GetCertificate hands out the certificate instead of CertFile and
+	// KeyFile, e.g. one a secrets manager keeps renewing. it's the client's
+	// certificate for clients
+	GetCertificate func() (*tls.Certificate, error)
+}
+
+// SetupTLSConfig builds a tls config for servers (verifying their clients with the CA)
+// or for clients (verifying the server with the CA)
+func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	var err error
+	tlsConfig := &tls.Config{}
+	getCertificate := cfg.GetCertificate
+	if getCertificate == nil && cfg.WatchCerts && cfg.CertFile != "" && cfg.KeyFile != "" {
+		r, err := NewCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		getCertificate = func() (*tls.Certificate, error) { return r.Certificate(), nil }
+	}
+	switch {
+	case getCertificate != nil && cfg.Server:
+		tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return getCertificate()
+		}
+	case getCertificate != nil:
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return getCertificate()
+		}
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		tlsConfig.Certificates = make([]tls.Certificate, 1)
+		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(
+			cfg.CertFile,
+			cfg.KeyFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ca := x509.NewCertPool()
+		if !ca.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf(
+				"failed to parse root certificate: %q",
+				cfg.CAFile,
+			)
+		}
+		if cfg.Server {
+			tlsConfig.ClientCAs = ca
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if cfg.ClientAuth != tls.NoClientCert {
+				tlsConfig.ClientAuth = cfg.ClientAuth
+			}
+		} else {
+			tlsConfig.RootCAs = ca
+		}
+		tlsConfig.ServerName = cfg.ServerAddress
+	}
+	return tlsConfig, nil
+}
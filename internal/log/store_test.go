@@ -1,86 +0,0 @@
-package log
-
-import (
-	"os"
-	"testing"
-
-	"github.com/stretchr/testify/require"
-)
-
-var (
-	write = []byte("hello world")
-	width = uint64(len(write) + headerSizeBytes)
-)
-
-func TestStoreAppendRead(t *testing.T) {
-	f, err := os.CreateTemp("", "store_append_read_test")
-	require.NoError(t, err)
-	defer os.Remove(t.Name())
-
-	s, err := newStore(f)
-	require.NoError(t, err)
-
-	testAppend(t, s)
-	testRead(t, s)
-
-	s, err = newStore(f)
-	require.NoError(t, err)
-	testRead(t, s)
-}
-
-func testAppend(t *testing.T, s *store) {
-	t.Helper()
-	for i := uint64(1); i < 4; i++ {
-		n, pos, err := s.Append(write)
-		require.NoError(t, err)
-		require.Equal(t, pos+n, width*i)
-	}
-}
-
-func testRead(t *testing.T, s *store) {
-	t.Helper()
-	var pos uint64
-	for i := uint64(1); i < 4; i++ {
-		read, err := s.Read(pos)
-		require.NoError(t, err)
-		require.Equal(t, write, read)
-		pos += width
-	}
-}
-
-func TestStoreClose(t *testing.T) {
-	f, err := os.CreateTemp("", "store_close_test")
-	require.NoError(t, err)
-	defer os.Remove(f.Name())
-	s, err := newStore(f)
-
-	require.NoError(t, err)
-	_, _, err = s.Append(write)
-	require.NoError(t, err)
-
-	f, beforeSize, err := openFile(f.Name())
-	require.NoError(t, err)
-
-	err = s.Close()
-	require.NoError(t, err)
-
-	_, afterSize, err := openFile(f.Name())
-	require.NoError(t, err)
-	require.True(t, afterSize > beforeSize)
-}
-
-func openFile(name string) (file *os.File, size int64, err error) {
-	f, err := os.OpenFile(
-		name,
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
-		0644,
-	)
-	if err != nil {
-		return nil, 0, err
-	}
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, 0, err
-	}
-	return f, fi.Size(), nil
-}
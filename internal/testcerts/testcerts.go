@@ -0,0 +1,117 @@
+// Package testcerts generates a throwaway certificate authority
+// along with server and client certificates for tests
+package testcerts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Names of the client certificates' common names
+const (
+	RootClient   = "root"
+	NobodyClient = "nobody"
+)
+
+type Files struct {
+	CAFile string
+
+	ServerCertFile string
+	ServerKeyFile  string
+
+	RootClientCertFile string
+	RootClientKeyFile  string
+
+	NobodyClientCertFile string
+	NobodyClientKeyFile  string
+}
+
+// Generate writes the certificates to dir
+// server certificates are valid for localhost and 127.0.0.1
+func Generate(dir string) (Files, error) {
+	var f Files
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return f, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vsdlog test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return f, err
+	}
+	f.CAFile = filepath.Join(dir, "ca.pem")
+	if err = writePEM(f.CAFile, "CERTIFICATE", caDER); err != nil {
+		return f, err
+	}
+
+	certs := []struct {
+		name     string
+		server   bool
+		certFile *string
+		keyFile  *string
+	}{
+		{"server", true, &f.ServerCertFile, &f.ServerKeyFile},
+		{RootClient, false, &f.RootClientCertFile, &f.RootClientKeyFile},
+		{NobodyClient, false, &f.NobodyClientCertFile, &f.NobodyClientKeyFile},
+	}
+	for i, c := range certs {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return f, err
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: c.name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{
+				x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth,
+			},
+		}
+		if c.server {
+			tmpl.DNSNames = []string{"localhost"}
+			tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			return f, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return f, err
+		}
+		*c.certFile = filepath.Join(dir, c.name+".pem")
+		*c.keyFile = filepath.Join(dir, c.name+"-key.pem")
+		if err = writePEM(*c.certFile, "CERTIFICATE", der); err != nil {
+			return f, err
+		}
+		if err = writePEM(*c.keyFile, "EC PRIVATE KEY", keyDER); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func writePEM(name, typ string, der []byte) error {
+	return os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+}
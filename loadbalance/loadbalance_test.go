@@ -0,0 +1,88 @@
+package loadbalance_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/loadbalance"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/memlog"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// clusterLog is a log of a node that knows the servers of the cluster
+type clusterLog struct {
+	*memlog.Log
+	servers []*api.Server
+}
+
+func (l *clusterLog) GetServers() ([]*api.Server, error) {
+	return l.servers, nil
+}
+
+func TestResolveAndPick(t *testing.T) {
+	// three nodes with logs of their own, the first one's the leader
+	var lns []net.Listener
+	var servers []*api.Server
+	for i := 0; i < 3; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		lns = append(lns, ln)
+		servers = append(servers, &api.Server{Id: string(rune('a' + i)), RpcAddr: ln.Addr().String(), IsLeader: i == 0})
+	}
+	var logs []*clusterLog
+	for i, ln := range lns {
+		l := &clusterLog{Log: memlog.New(log.Config{}), servers: servers}
+		logs = append(logs, l)
+		if i > 0 {
+			_, err := l.AppendRecord(log.Record{Value: []byte(servers[i].Id)})
+			require.NoError(t, err)
+		}
+		srv, err := server.NewGRPCServer(&server.Config{CommitLog: l})
+		require.NoError(t, err)
+		go srv.Serve(ln)
+		t.Cleanup(srv.Stop)
+	}
+
+	// any of the servers does to resolve the cluster
+	conn, err := grpc.NewClient(loadbalance.Name+":///"+lns[2].Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := api.NewLogClient(conn)
+	ctx := context.Background()
+
+	// the produces go to the leader
+	for i := 0; i < 3; i++ {
+		_, err = c.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("produced")}})
+		require.NoError(t, err)
+	}
+	record, err := logs[0].ReadRecord(2)
+	require.NoError(t, err)
+	require.Equal(t, "produced", string(record.Value))
+
+	// and the consumes take turns on the followers, once they're connected
+	require.Eventually(t, func() bool {
+		var followers int
+		for i := 0; i < 2; i++ {
+			res, err := c.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+			if err == nil && string(res.Record.Value) != "produced" {
+				followers++
+			}
+		}
+		return followers == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		res, err := c.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+		require.NoError(t, err)
+		seen[string(res.Record.Value)]++
+	}
+	require.Equal(t, map[string]int{"b": 2, "c": 2}, seen)
+}
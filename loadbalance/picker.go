@@ -0,0 +1,61 @@
+package loadbalance
+
+import (
+	"sync/atomic"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+var _ base.PickerBuilder = (*Picker)(nil)
+var _ balancer.Picker = (*Picker)(nil)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+}
+
+// Picker sends the produces, and whatever it doesn't know, to the leader,
+// and the consumes to the followers in turn. the leader takes the consumes
+// when there are no followers
+type Picker struct {
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	current   atomic.Uint64
+}
+
+func (p *Picker) Build(info base.PickerBuildInfo) balancer.Picker {
+	p = &Picker{}
+	for sc, scInfo := range info.ReadySCs {
+		leader, _ := scInfo.Address.Attributes.Value(isLeader).(bool)
+		if leader {
+			p.leader = sc
+			continue
+		}
+		p.followers = append(p.followers, sc)
+	}
+	return p
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var result balancer.PickResult
+	switch info.FullMethodName {
+	case api.Log_Consume_FullMethodName, api.Log_ConsumeStream_FullMethodName:
+		result.SubConn = p.nextFollower()
+	}
+	if result.SubConn == nil {
+		result.SubConn = p.leader
+	}
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	return result, nil
+}
+
+func (p *Picker) nextFollower() balancer.SubConn {
+	if len(p.followers) == 0 {
+		return nil
+	}
+	cur := p.current.Add(1)
+	return p.followers[cur%uint64(len(p.followers))]
+}
@@ -0,0 +1,98 @@
+// Package loadbalance makes grpc clients cluster aware. the resolver asks
+// the servers of a cluster who's in it and who leads it, the picker sends
+// the produces to the leader and spreads the consumes over the followers.
+// clients dial "vsdlog:///<addr of any server>" once the package is imported
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the scheme of the resolver and the name of the balancer
+const Name = "vsdlog"
+
+// isLeader is the address attribute the picker tells the leader by
+const isLeader = "is_leader"
+
+var _ resolver.Builder = (*Resolver)(nil)
+var _ resolver.Resolver = (*Resolver)(nil)
+
+func init() {
+	resolver.Register(&Resolver{})
+}
+
+// Resolver resolves a cluster from the GetServers rpc of one of its servers
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+	logger        *slog.Logger
+}
+
+func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	r = &Resolver{
+		clientConn: cc,
+		logger:     slog.Default().With("component", "resolver"),
+	}
+	var dialOpts []grpc.DialOption
+	if opts.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	r.serviceConfig = cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name))
+	var err error
+	r.resolverConn, err = grpc.NewClient(target.Endpoint(), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	return r, nil
+}
+
+func (r *Resolver) Scheme() string {
+	return Name
+}
+
+// ResolveNow asks for the servers again, grpc calls it when it lost
+// a connection, e.g. to a leader that stepped down
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, err := api.NewLogClient(r.resolverConn).GetServers(context.Background(), &api.GetServersRequest{})
+	if err != nil {
+		r.logger.Error("failed to resolve the servers", "error", err)
+		r.clientConn.ReportError(err)
+		return
+	}
+	var addrs []resolver.Address
+	for _, server := range res.Servers {
+		addrs = append(addrs, resolver.Address{
+			Addr:       server.RpcAddr,
+			Attributes: attributes.New(isLeader, server.IsLeader),
+		})
+	}
+	if err = r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}); err != nil {
+		r.logger.Error("failed to update the state", "error", err)
+	}
+}
+
+func (r *Resolver) Close() {
+	if err := r.resolverConn.Close(); err != nil {
+		r.logger.Error("failed to close the connection", "error", err)
+	}
+}
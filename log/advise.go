@@ -0,0 +1,61 @@
+package log
+
+import (
+	"context"
+	"os"
+)
+
+// readCursor is how far a reader going through the log front to back got,
+// for the hints of Store.ReadAdvice
+type readCursor struct {
+	segment *segment
+	// dropped is where the pages of the segment's store still cached start
+	dropped uint64
+}
+
+type cursorKey struct{}
+
+// SequentialReads returns a context for the ReadContext calls of a reader
+// that goes through the log front to back, e.g. a consumer's stream. with
+// Store.ReadAdvice the reads made with it hint the kernel to read ahead of
+// them and to drop the pages of the sealed stores behind them. it's meant
+// for one reader at a time
+func SequentialReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cursorKey{}, &readCursor{})
+}
+
+// cursorFrom returns the cursor of a context of SequentialReads, or nil
+func cursorFrom(ctx context.Context) *readCursor {
+	c, _ := ctx.Value(cursorKey{}).(*readCursor)
+	return c
+}
+
+// advise hints the kernel that the reader at the cursor read the record at
+// pos of the segment, under the log's read lock. the active segment is left
+// alone, the records at the head are the ones read the most. they're only
+// hints, so whatever fails is ignored
+func (l *Log) advise(c *readCursor, s *segment, pos uint64) {
+	if c == nil || !l.Config.Store.ReadAdvice || s == l.activeSegment {
+		return
+	}
+	f, ok := s.store.file()
+	if !ok {
+		return
+	}
+	if c.segment != s {
+		// the reader's done with the one before
+		if c.segment != nil {
+			if prev, ok := c.segment.store.file(); ok {
+				dropCache(prev, int64(c.dropped), 0)
+			}
+		}
+		c.segment, c.dropped = s, 0
+		adviseSequential(f)
+	}
+	// whole pages only, the one the record starts in may still be read
+	end := pos &^ uint64(os.Getpagesize()-1)
+	if end > c.dropped {
+		dropCache(f, int64(c.dropped), int64(end-c.dropped))
+		c.dropped = end
+	}
+}
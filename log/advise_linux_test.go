@@ -0,0 +1,85 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// cachedPages counts the pages of the file that are in the page cache
+func cachedPages(t *testing.T, name string) int {
+	t.Helper()
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	b, err := unix.Mmap(int(f.Fd()), 0, int(fi.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	require.NoError(t, err)
+	defer unix.Munmap(b)
+	page := os.Getpagesize()
+	vec := make([]byte, (len(b)+page-1)/page)
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(unsafe.Pointer(&vec[0])))
+	require.Zero(t, errno)
+	var n int
+	for _, v := range vec {
+		n += int(v & 1)
+	}
+	return n
+}
+
+func TestReadAdvice(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Store.ReadAdvice = true
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	value := bytes.Repeat([]byte("x"), 16<<10)
+	for i := 0; i < 2*64; i++ {
+		off, err := log.Append(value)
+		require.NoError(t, err)
+		// two sealed segments of 64 records
+		if i%64 == 63 {
+			require.NoError(t, log.rotate(context.Background(), off+1))
+		}
+	}
+	require.NoError(t, log.Sync())
+	sealed := []*segment{log.segments[0], log.segments[1]}
+	for _, s := range sealed {
+		require.NoError(t, s.Sync())
+		_, err := os.ReadFile(s.store.Name())
+		require.NoError(t, err)
+		if cachedPages(t, s.store.Name()) == 0 {
+			t.Skip("the filesystem doesn't keep the stores in the page cache")
+		}
+	}
+	total := cachedPages(t, sealed[0].store.Name())
+
+	// the iterator drops the pages behind it
+	it := log.Iterator(0)
+	for i := 0; i < 64; i++ {
+		_, err := it.Next()
+		require.NoError(t, err)
+	}
+	require.Less(t, cachedPages(t, sealed[0].store.Name()), total/4)
+
+	// and so do the sequential reads, the plain ones don't
+	for off := uint64(64); off < 128; off++ {
+		_, err := log.ReadRecord(off)
+		require.NoError(t, err)
+	}
+	require.Greater(t, cachedPages(t, sealed[1].store.Name()), total/2)
+	ctx := SequentialReads(context.Background())
+	for off := uint64(64); off < 128; off++ {
+		_, err := log.ReadContext(ctx, off)
+		require.NoError(t, err)
+	}
+	require.Less(t, cachedPages(t, sealed[1].store.Name()), total/4)
+}
@@ -0,0 +1,57 @@
+package log
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrLogClosed is returned for appends queued after the log was closed
+var ErrLogClosed = errors.New("log: closed")
+
+// how many async appends can be queued before AppendAsync blocks
+const asyncQueueSize = 256
+
+type asyncAppend struct {
+	record Record
+	done   func(off uint64, err error)
+}
+
+// AppendAsync queues the record to be appended by the log's writer goroutine
+// and returns right away, unless the queue is full. done is called from the
+// writer with the offset of the record once it's appended, in the order the
+// records were queued, so it shouldn't block for long or queue appends itself
+func (l *Log) AppendAsync(record Record, done func(off uint64, err error)) {
+	l.asyncMu.Lock()
+	defer l.asyncMu.Unlock()
+
+	if l.asyncClosed {
+		done(0, ErrLogClosed)
+		return
+	}
+	if l.appends == nil {
+		l.appends = make(chan asyncAppend, asyncQueueSize)
+		l.wg.Add(1)
+		go l.asyncLoop()
+	}
+	l.appends <- asyncAppend{record: record, done: done}
+}
+
+func (l *Log) asyncLoop() {
+	defer l.wg.Done()
+
+	for req := range l.appends {
+		off, err := l.appendRecord(context.Background(), req.record, nil)
+		req.done(off, err)
+	}
+}
+
+// stopAsync lets the writer append what's queued and stop
+func (l *Log) stopAsync() {
+	l.asyncMu.Lock()
+	defer l.asyncMu.Unlock()
+
+	l.asyncClosed = true
+	if l.appends != nil {
+		close(l.appends)
+	}
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrBatchTooLarge is returned by AppendAll for records
+// that don't fit in a single segment
+var ErrBatchTooLarge = errors.New("log: batch too large for a segment")
+
+// AppendAll writes the values as records without a key, all of them or none
+// of them, and returns the offsets of the first and last of them.
+// the records go into a single segment and all but the last are marked
+// as pending, the last one commits the batch. the pending records at the
+// end of the log are what's left of a batch that was cut short, and they're
+// dropped when the log is opened
+func (l *Log) AppendAll(values [][]byte) (first, last uint64, err error) {
+	if len(values) == 0 {
+		return 0, 0, ErrEmptyBatch
+	}
+	now := l.Config.Clock()
+	records := make([][]byte, len(values))
+	var size uint64
+	for i, value := range values {
+		records[i] = encodeRecord(Record{Value: value, Timestamp: now, Epoch: l.epoch.Load()})
+		if err := l.Config.checkSize(records[i]); err != nil {
+			return 0, 0, err
+		}
+		if i < len(values)-1 {
+			records[i][0] |= attrPending
+		}
+		size += uint64(len(records[i]))
+	}
+	if err := l.reserveSpace(context.Background(), size); err != nil {
+		return 0, 0, err
+	}
+
+	ctx, span := l.tracer.Start(context.Background(), "log.AppendAll")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.Int("vsdlog.records", len(values)))
+
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.activeSegment
+	if s.fitting(records) < len(records) {
+		// a segment of its own is as much room as a batch gets
+		if s.nextOffset == s.baseOffset {
+			return 0, 0, fmt.Errorf("%w: %d records", ErrBatchTooLarge, len(records))
+		}
+		if err = l.rotate(ctx, s.nextOffset); err != nil {
+			return 0, 0, err
+		}
+		if s = l.activeSegment; s.fitting(records) < len(records) {
+			return 0, 0, fmt.Errorf("%w: %d records", ErrBatchTooLarge, len(records))
+		}
+	}
+
+	first = s.nextOffset
+	if _, _, err = s.AppendBatch(records); err != nil {
+		// what made it of the batch isn't committed
+		return 0, 0, errors.Join(err, s.truncateFrom(first))
+	}
+	l.observeAppend(len(values), start)
+	if s.IsMaxed() {
+		err = l.rotate(ctx, s.nextOffset)
+	}
+	return first, first + uint64(len(values)) - 1, err
+}
+
+// dropUncommitted drops the pending records the active segment ends with
+// they're of a batch whose last record never made it to disk
+func (l *Log) dropUncommitted() error {
+	s := l.activeSegment
+	var from uint64
+	pending := false
+	err := s.scan(func(off uint64, b []byte) error {
+		if len(b) > 0 && b[0]&attrPending != 0 {
+			if !pending {
+				from, pending = off, true
+			}
+		} else {
+			pending = false
+		}
+		return nil
+	})
+	if err != nil || !pending {
+		return err
+	}
+	l.Config.logger().Warn("dropped an uncommitted batch", "from", from, "to", s.nextOffset)
+	return s.truncateFrom(from)
+}
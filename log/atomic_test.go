@@ -0,0 +1,64 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendAll(t *testing.T) {
+	dir, err := os.MkdirTemp("", "atomic_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	values := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	first, last, err := log.AppendAll(values)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), first)
+	require.Equal(t, uint64(2), last)
+
+	// the batch doesn't fit after the records already there,
+	// so it goes into a segment of its own
+	first, last, err = log.AppendAll(values)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), first)
+	require.Equal(t, uint64(5), last)
+	require.Equal(t, uint64(3), log.Segments()[1].BaseOffset)
+	for off := first; off <= last; off++ {
+		record, err := log.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, values[off-first], record.Value)
+	}
+
+	_, _, err = log.AppendAll(make([][]byte, 5))
+	require.ErrorIs(t, err, ErrBatchTooLarge)
+	_, _, err = log.AppendAll(nil)
+	require.ErrorIs(t, err, ErrEmptyBatch)
+
+	// a batch cut short before its last record is dropped on open
+	next, err := log.Append(write)
+	require.NoError(t, err)
+	for range 2 {
+		b := encodeRecord(Record{Value: write})
+		b[0] |= attrPending
+		_, err = log.activeSegment.Append(b)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, next, highest)
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, next+1, off)
+}
@@ -0,0 +1,191 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// AuditTopic is the internal topic the audit events are appended to,
+// it can't be deleted or truncated through an Admin
+const AuditTopic = "__audit"
+
+// the actions of the audit events
+const (
+	AuditCreateTopic  = "create_topic"
+	AuditDeleteTopic  = "delete_topic"
+	AuditTruncate     = "truncate"
+	AuditACLChange    = "acl_change"
+	AuditConfigReload = "config_reload"
+)
+
+// ErrAuditTopic is returned for changes an Admin refuses to make
+// to the audit topic
+var ErrAuditTopic = errors.New("log: the audit topic can't be changed")
+
+// AuditEvent is an administrative operation, who made it and when
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Principal is the authenticated subject that made the change
+	Principal string `json:"principal"`
+	Action    string `json:"action"`
+	// Target is what's changed, e.g. the topic
+	Target  string            `json:"target,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Auditor keeps the audit events, Topics keeps them in its AuditTopic
+type Auditor interface {
+	Audit(AuditEvent) error
+}
+
+var _ Auditor = (*Topics)(nil)
+
+// Audit appends the event to the audit topic, which is created with a
+// single partition the first time. the event's stamped with the time if
+// it has none, and appended without a key so compaction keeps all of them
+func (t *Topics) Audit(e AuditEvent) error {
+	topic, err := t.auditTopic()
+	if err != nil {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	log, err := topic.Partition(0)
+	if err != nil {
+		return err
+	}
+	_, err = log.AppendRecord(Record{Value: b, Timestamp: e.Time})
+	return err
+}
+
+// auditTopic returns the audit topic, creating it if need be. whatever the
+// node's config says, it's never compacted nor has its events retained
+// away, the overrides are kept with the topic so that holds over restarts
+func (t *Topics) auditTopic() (*Topic, error) {
+	topic, err := t.Topic(AuditTopic)
+	if errors.Is(err, ErrUnknownTopic) {
+		var off time.Duration
+		var noMaxBytes uint64
+		topic, err = t.CreateTopic(AuditTopic, TopicConfig{
+			Log: t.Config.Log,
+			Overrides: TopicOverrides{
+				RetentionMaxAge:    &off,
+				RetentionMaxBytes:  &noMaxBytes,
+				CompactionInterval: &off,
+			},
+		})
+		if errors.Is(err, ErrTopicExists) {
+			// created by another audit in the meantime
+			return t.Topic(AuditTopic)
+		}
+	}
+	return topic, err
+}
+
+// AuditEvents reads the audit events from the offset on, there are
+// none if nothing's been audited yet
+func (t *Topics) AuditEvents(from uint64) ([]AuditEvent, error) {
+	topic, err := t.Topic(AuditTopic)
+	if errors.Is(err, ErrUnknownTopic) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log, err := topic.Partition(0)
+	if err != nil {
+		return nil, err
+	}
+	var events []AuditEvent
+	it := log.Iterator(from)
+	for {
+		record, err := it.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		var e AuditEvent
+		if err = json.Unmarshal(record.Value, &e); err != nil {
+			return events, fmt.Errorf("%w: audit event at %d: %v", ErrCorruptRecord, it.Offset(), err)
+		}
+		events = append(events, e)
+	}
+}
+
+// Admin makes changes to the topics on behalf of a principal, and
+// records them in the audit topic
+type Admin struct {
+	Topics    *Topics
+	Principal string
+}
+
+// As returns the admin of the topics that makes changes as the principal
+func (t *Topics) As(principal string) *Admin {
+	return &Admin{Topics: t, Principal: principal}
+}
+
+func (a *Admin) audit(action, target string, details map[string]string) error {
+	return a.Topics.Audit(AuditEvent{
+		Principal: a.Principal,
+		Action:    action,
+		Target:    target,
+		Details:   details,
+	})
+}
+
+// CreateTopic creates the topic, see Topics.CreateTopic
+func (a *Admin) CreateTopic(name string, c TopicConfig) (*Topic, error) {
+	if name == AuditTopic {
+		return nil, ErrAuditTopic
+	}
+	topic, err := a.Topics.CreateTopic(name, c)
+	if err != nil {
+		return nil, err
+	}
+	return topic, a.audit(AuditCreateTopic, name, map[string]string{
+		"partitions": fmt.Sprint(topic.Partitions()),
+	})
+}
+
+// DeleteTopic deletes the topic and all of its data, see Topics.DeleteTopic
+func (a *Admin) DeleteTopic(name string) error {
+	if name == AuditTopic {
+		return ErrAuditTopic
+	}
+	if err := a.Topics.DeleteTopic(name); err != nil {
+		return err
+	}
+	return a.audit(AuditDeleteTopic, name, nil)
+}
+
+// Truncate removes the records of the partition before lowest, see Log.Truncate
+func (a *Admin) Truncate(name string, partition int, lowest uint64) error {
+	if name == AuditTopic {
+		return ErrAuditTopic
+	}
+	topic, err := a.Topics.Topic(name)
+	if err != nil {
+		return err
+	}
+	log, err := topic.Partition(partition)
+	if err != nil {
+		return err
+	}
+	if err = log.Truncate(lowest); err != nil {
+		return err
+	}
+	return a.audit(AuditTruncate, name, map[string]string{
+		"partition": fmt.Sprint(partition),
+		"lowest":    fmt.Sprint(lowest),
+	})
+}
@@ -0,0 +1,86 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	topics, err := NewTopics(dir, TopicConfig{})
+	require.NoError(t, err)
+	events, err := topics.AuditEvents(0)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	alice := topics.As("alice")
+	orders, err := alice.CreateTopic("orders", TopicConfig{Partitions: 2})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _, err = orders.Append(Record{Value: write})
+		require.NoError(t, err)
+	}
+	require.NoError(t, alice.Truncate("orders", 1, 1))
+	require.ErrorIs(t, alice.Truncate("orders", 2, 1), ErrUnknownPartition)
+	require.NoError(t, topics.As("bob").DeleteTopic("orders"))
+
+	// the audit topic's kept from the admins
+	_, err = alice.CreateTopic(AuditTopic, TopicConfig{})
+	require.ErrorIs(t, err, ErrAuditTopic)
+	require.ErrorIs(t, alice.DeleteTopic(AuditTopic), ErrAuditTopic)
+	require.ErrorIs(t, alice.Truncate(AuditTopic, 0, 1), ErrAuditTopic)
+	require.NoError(t, topics.Close())
+
+	// the events are kept with the topics
+	topics, err = NewTopics(dir, TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	events, err = topics.AuditEvents(0)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, AuditCreateTopic, events[0].Action)
+	require.Equal(t, "alice", events[0].Principal)
+	require.Equal(t, "orders", events[0].Target)
+	require.Equal(t, map[string]string{"partitions": "2"}, events[0].Details)
+	require.Equal(t, AuditTruncate, events[1].Action)
+	require.Equal(t, map[string]string{"partition": "1", "lowest": "1"}, events[1].Details)
+	require.Equal(t, AuditDeleteTopic, events[2].Action)
+	require.Equal(t, "bob", events[2].Principal)
+	require.False(t, events[2].Time.IsZero())
+
+	events, err = topics.AuditEvents(2)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
+
+func TestAuditKeepsEvents(t *testing.T) {
+	c := TopicConfig{}
+	c.Log.Segment.MaxStoreBytes = 256
+	c.Log.Compaction.Interval = time.Hour
+	c.Log.Retention.MaxBytes = 1
+	topics, err := NewTopics(t.TempDir(), c)
+	require.NoError(t, err)
+	defer topics.Close()
+
+	for range 10 {
+		_, err = topics.As("alice").CreateTopic("orders", TopicConfig{})
+		require.NoError(t, err)
+		require.NoError(t, topics.As("alice").DeleteTopic("orders"))
+	}
+	audit, err := topics.Topic(AuditTopic)
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), *audit.Overrides().CompactionInterval)
+	require.Equal(t, uint64(0), *audit.Overrides().RetentionMaxBytes)
+	log, err := audit.Partition(0)
+	require.NoError(t, err)
+	require.Greater(t, len(log.segments), 1)
+	require.NoError(t, log.Compact())
+
+	// every create and delete is still there
+	events, err := topics.AuditEvents(0)
+	require.NoError(t, err)
+	require.Len(t, events, 20)
+	require.Equal(t, AuditDeleteTopic, events[19].Action)
+}
@@ -0,0 +1,288 @@
+package log
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// ErrCorruptBackup is returned by Restore for a backup that doesn't
+	// match its manifest
+	ErrCorruptBackup = errors.New("log: corrupt backup")
+	// ErrUnknownBackupVersion is returned by Restore for a backup
+	// written by a newer version of this package
+	ErrUnknownBackupVersion = errors.New("log: unknown backup version")
+	// ErrRestoreNotEmpty is returned by Restore for a directory that already
+	// holds segments
+	ErrRestoreNotEmpty = errors.New("log: restore directory isn't empty")
+)
+
+const (
+	// backupManifest is the first file of every backup
+	backupManifest = "backup.json"
+	backupVersion  = 1
+)
+
+// BackupManifest describes the segments of a backup
+type BackupManifest struct {
+	Version  int             `json:"version"`
+	Created  time.Time       `json:"created"`
+	Segments []BackupSegment `json:"segments"`
+}
+
+// BackupSegment is a segment of a backup, its files are
+// named after its base offset like in the log's directory
+type BackupSegment struct {
+	BaseOffset uint64     `json:"base_offset"`
+	NextOffset uint64     `json:"next_offset"`
+	Store      BackupFile `json:"store"`
+	Index      BackupFile `json:"index"`
+}
+
+// BackupFile is a file of a backup along with its crc32c
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	CRC32C uint32 `json:"crc32c"`
+}
+
+// Backup writes a zstd compressed tar archive of the sealed segments to w,
+// a manifest first and then the store and index of every segment.
+// the segments are picked, and their files opened, when it's called,
+// so appends carry on while the archive is written
+func (l *Log) Backup(w io.Writer) (err error) {
+	manifest := BackupManifest{
+		Version: backupVersion,
+		Created: l.Config.Clock(),
+	}
+	// the files are opened under the log's lock, so compaction
+	// and retention can't change them from under the backup
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	l.mu.RLock()
+	for _, s := range l.segments[:len(l.segments)-1] {
+		// the last records of a segment may still be buffered
+		if err = s.store.Flush(); err != nil {
+			break
+		}
+		manifest.Segments = append(manifest.Segments, BackupSegment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Store:      BackupFile{Name: filepath.Base(s.store.Name()), Size: int64(s.store.size)},
+			Index:      BackupFile{Name: filepath.Base(s.index.Name()), Size: int64(s.index.size)},
+		})
+		for _, name := range []string{s.store.Name(), s.index.Name()} {
+			var f *os.File
+			if f, err = os.Open(name); err != nil {
+				break
+			}
+			files = append(files, f)
+		}
+		if err != nil {
+			break
+		}
+	}
+	l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// the manifest goes first, so the checksums are worked out beforehand
+	entries := make([]*BackupFile, 0, len(files))
+	for i := range manifest.Segments {
+		entries = append(entries, &manifest.Segments[i].Store, &manifest.Segments[i].Index)
+	}
+	for i, f := range files {
+		h := crc32.New(crcTable)
+		if _, err = io.Copy(h, io.NewSectionReader(f, 0, entries[i].Size)); err != nil {
+			return err
+		}
+		entries[i].CRC32C = h.Sum32()
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	if err = writeTarFile(tw, backupManifest, int64(len(b)), manifest.Created, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	for i, f := range files {
+		r := io.NewSectionReader(f, 0, entries[i].Size)
+		if err = writeTarFile(tw, entries[i].Name, entries[i].Size, manifest.Created, r); err != nil {
+			return err
+		}
+	}
+	return errors.Join(tw.Close(), zw.Close())
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// restoreExt is what the files are restored to, before they're renamed
+// once the whole backup checks out. NewLog doesn't pick them up
+const restoreExt = ".restore"
+
+// Restore unpacks a backup written by Log.Backup into dir, checking the
+// files against the manifest, and leaves a directory NewLog can open
+func Restore(dir string, r io.Reader) error {
+	return RestoreFrom(dir, r, 0)
+}
+
+// RestoreFrom is Restore, skipping the segments with no records
+// at or after from. segments are restored whole, so the lowest
+// offset of the log can be below from
+func RestoreFrom(dir string, r io.Reader, from uint64) (err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".store" {
+			return fmt.Errorf("%w: %s", ErrRestoreNotEmpty, dir)
+		}
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != backupManifest {
+		return fmt.Errorf("%w: no manifest", ErrCorruptBackup)
+	}
+	var manifest BackupManifest
+	if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+	}
+	if manifest.Version != backupVersion {
+		return fmt.Errorf("%w: %d", ErrUnknownBackupVersion, manifest.Version)
+	}
+
+	// the files to restore, the skipped ones are read past
+	want := make(map[string]BackupFile)
+	skip := make(map[string]bool)
+	for _, s := range manifest.Segments {
+		for _, file := range []BackupFile{s.Store, s.Index} {
+			// the names end up in paths, so they can't point anywhere else
+			if file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+				return fmt.Errorf("%w: bad file name %q", ErrCorruptBackup, file.Name)
+			}
+			if s.NextOffset <= from {
+				skip[file.Name] = true
+			} else {
+				want[file.Name] = file
+			}
+		}
+	}
+
+	var restored []string
+	defer func() {
+		if err != nil {
+			for _, name := range restored {
+				os.Remove(filepath.Join(dir, name+restoreExt))
+			}
+		}
+	}()
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if skip[hdr.Name] {
+			continue
+		}
+		file, ok := want[hdr.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s isn't in the manifest", ErrCorruptBackup, hdr.Name)
+		}
+		delete(want, hdr.Name)
+		restored = append(restored, hdr.Name)
+		if err = restoreFile(filepath.Join(dir, hdr.Name+restoreExt), tr, file); err != nil {
+			return err
+		}
+	}
+	for name := range want {
+		return fmt.Errorf("%w: %s is missing", ErrCorruptBackup, name)
+	}
+
+	// the store goes last, since it's what tells NewLog about a segment
+	for _, s := range manifest.Segments {
+		if s.NextOffset <= from {
+			continue
+		}
+		for _, name := range []string{s.Index.Name, s.Store.Name} {
+			path := filepath.Join(dir, name)
+			if err = os.Rename(path+restoreExt, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreFile writes the file to path and checks it against the manifest
+func restoreFile(path string, r io.Reader, file BackupFile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	h := crc32.New(crcTable)
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != file.Size || h.Sum32() != file.CRC32C {
+		return fmt.Errorf("%w: %s doesn't match its checksum", ErrCorruptBackup, file.Name)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package log
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	dir, err := os.MkdirTemp("", "backup_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 3 * width
+	c.Clock = func() time.Time { return now }
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, log.Backup(&buf))
+	// appends carry on, the backup only has what was sealed
+	_, err = log.Append(write)
+	require.NoError(t, err)
+
+	zr, err := zstd.NewReader(&buf)
+	require.NoError(t, err)
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, backupManifest, hdr.Name)
+	var manifest BackupManifest
+	require.NoError(t, json.NewDecoder(tr).Decode(&manifest))
+	require.Equal(t, backupVersion, manifest.Version)
+	require.True(t, now.Equal(manifest.Created))
+	require.Len(t, manifest.Segments, 3)
+	require.Equal(t, uint64(3), manifest.Segments[1].BaseOffset)
+	require.Equal(t, uint64(6), manifest.Segments[1].NextOffset)
+
+	for _, s := range manifest.Segments {
+		for _, file := range []BackupFile{s.Store, s.Index} {
+			hdr, err := tr.Next()
+			require.NoError(t, err)
+			require.Equal(t, file.Name, hdr.Name)
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, file.Size, int64(len(b)))
+			require.Equal(t, file.CRC32C, crc32.Checksum(b, crcTable))
+		}
+	}
+	_, err = tr.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "restore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 3 * width
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "log"), 0755))
+	log, err := NewLog(filepath.Join(dir, "log"), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 10; i++ {
+		_, err := log.AppendRecord(Record{Key: []byte("key"), Value: write})
+		require.NoError(t, err)
+	}
+	var backup bytes.Buffer
+	require.NoError(t, log.Backup(&backup))
+
+	restored := filepath.Join(dir, "restored")
+	require.NoError(t, Restore(restored, bytes.NewReader(backup.Bytes())))
+	r, err := NewLog(restored, c)
+	require.NoError(t, err)
+	requireLowestOffset(t, r, 0)
+	off, err := r.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+	for off := uint64(0); off <= 8; off++ {
+		record, err := r.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, write, record.Value)
+	}
+	require.NoError(t, r.Close())
+
+	// the directory has to be empty
+	err = Restore(restored, bytes.NewReader(backup.Bytes()))
+	require.ErrorIs(t, err, ErrRestoreNotEmpty)
+
+	// only the segments with offsets from 4 on
+	from := filepath.Join(dir, "from")
+	require.NoError(t, RestoreFrom(from, bytes.NewReader(backup.Bytes()), 4))
+	r, err = NewLog(from, c)
+	require.NoError(t, err)
+	requireLowestOffset(t, r, 3)
+	require.NoError(t, r.Close())
+
+	// a file that doesn't match the manifest fails the restore
+	corrupt := rewriteBackup(t, backup.Bytes(), func(name string, b []byte) []byte {
+		if name == "3.store" {
+			b[len(b)-1]++
+		}
+		return b
+	})
+	bad := filepath.Join(dir, "bad")
+	err = Restore(bad, bytes.NewReader(corrupt))
+	require.ErrorIs(t, err, ErrCorruptBackup)
+	entries, err := os.ReadDir(bad)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// and so does a file left out
+	missing := rewriteBackup(t, backup.Bytes(), func(name string, b []byte) []byte {
+		if name == "6.index" {
+			return nil
+		}
+		return b
+	})
+	err = Restore(bad, bytes.NewReader(missing))
+	require.ErrorIs(t, err, ErrCorruptBackup)
+}
+
+// rewriteBackup rewrites the files of a backup with fn, leaving out the ones it returns nil for
+func rewriteBackup(t *testing.T, backup []byte, fn func(name string, b []byte) []byte) []byte {
+	t.Helper()
+	zr, err := zstd.NewReader(bytes.NewReader(backup))
+	require.NoError(t, err)
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	var out bytes.Buffer
+	zw, err := zstd.NewWriter(&out)
+	require.NoError(t, err)
+	tw := tar.NewWriter(zw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if b = fn(hdr.Name, b); b == nil {
+			continue
+		}
+		require.NoError(t, writeTarFile(tw, hdr.Name, int64(len(b)), hdr.ModTime, bytes.NewReader(b)))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return out.Bytes()
+}
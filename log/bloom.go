@@ -0,0 +1,147 @@
+package log
+
+import (
+	"math"
+)
+
+// bloomFalsePositives is the rate of false positives the filters are sized for
+const bloomFalsePositives = 0.01
+
+// bloomFilter tells whether a key may have been added to it, or definitely
+// wasn't. the filters only live in memory, they're built again once the
+// log is reopened
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for n keys
+func newBloomFilter(n uint64) *bloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(bloomFalsePositives) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		hashes: max(k, 1),
+	}
+}
+
+// positions derives the filter's hashes from two halves of a single one
+func (f *bloomFilter) positions(key []byte, fn func(bit uint64) bool) bool {
+	h := fnv1a(key)
+	h1, h2 := h&math.MaxUint32, h>>32
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+// fnv1a is the 64 bit FNV-1a hash, without hash/fnv's allocation
+func fnv1a(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (f *bloomFilter) add(key []byte) {
+	f.positions(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) mayContain(key []byte) bool {
+	return f.positions(key, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// keyFilter returns the filter of the keys of the sealed segment,
+// it's built with a scan the first time it's needed
+func (s *segment) keyFilter() (*bloomFilter, error) {
+	s.bloomMu.Lock()
+	defer s.bloomMu.Unlock()
+	if s.bloom != nil {
+		return s.bloom, nil
+	}
+	f := newBloomFilter(s.store.records)
+	err := s.scan(func(_ uint64, b []byte) error {
+		if key := recordKey(b); key != nil {
+			f.add(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.bloom = f
+	return f, nil
+}
+
+func (s *segment) setKeyFilter(f *bloomFilter) {
+	s.bloomMu.Lock()
+	defer s.bloomMu.Unlock()
+	s.bloom = f
+}
+
+// HasKey tells whether the latest record of the key in the local segments
+// isn't a tombstone, see latestOffset
+func (l *Log) HasKey(key []byte) (bool, error) {
+	if l.keys != nil {
+		_, ok := l.keys.Offset(key)
+		return ok, nil
+	}
+	_, ok, err := l.latestOffset(key)
+	return ok, err
+}
+
+// latestOffset looks for the latest record of the key in the local segments,
+// ok is false if there's none or it's a tombstone. the sealed segments are
+// only read if their bloom filter says the key may be there
+func (l *Log) latestOffset(key []byte) (off uint64, ok bool, err error) {
+	// the sealed segments stay as they are while compaction can't run,
+	// so they're read without blocking the log
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	off, found, ok, err := l.activeSegment.findKey(key)
+	if found || err != nil {
+		l.mu.RUnlock()
+		return off, ok, err
+	}
+	sealed := l.sealed()
+	l.mu.RUnlock()
+
+	for i := len(sealed) - 1; i >= 0; i-- {
+		f, err := sealed[i].keyFilter()
+		if err != nil {
+			return 0, false, err
+		}
+		if !f.mayContain(key) {
+			continue
+		}
+		if off, found, ok, err = sealed[i].findKey(key); found || err != nil {
+			return off, ok, err
+		}
+	}
+	return 0, false, nil
+}
+
+// findKey looks for the segment's latest record of the key, ok tells
+// whether it was found and isn't a tombstone
+func (s *segment) findKey(key []byte) (off uint64, found, ok bool, err error) {
+	err = s.scan(func(o uint64, b []byte) error {
+		if k := recordKey(b); k != nil && string(k) == string(key) {
+			off, found, ok = o, true, b[0]&attrTombstone == 0
+		}
+		return nil
+	})
+	return off, found, ok, err
+}
@@ -0,0 +1,74 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		require.True(t, f.mayContain([]byte(fmt.Sprintf("key-%d", i))))
+		if f.mayContain([]byte(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 50)
+}
+
+func TestHasKey(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bloom_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for _, record := range []Record{
+		{Key: []byte("a"), Value: write},
+		{Key: []byte("b"), Value: write},
+		{Value: write},
+		{Key: []byte("c"), Value: write},
+		{Key: []byte("b")},
+		{Key: []byte("d"), Value: write},
+	} {
+		_, err := log.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.Len(t, log.Segments(), 3)
+
+	for key, want := range map[string]bool{
+		"a": true,
+		// its latest record is a tombstone
+		"b": false,
+		"c": true,
+		"d": true,
+		"e": false,
+	} {
+		has, err := log.HasKey([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, want, has, key)
+	}
+
+	// the sealed segments got filters of their keys
+	f, err := log.segments[0].keyFilter()
+	require.NoError(t, err)
+	require.True(t, f.mayContain([]byte("a")))
+	require.False(t, f.mayContain([]byte("c")))
+
+	// compaction leaves the filters in sync with what's kept
+	require.NoError(t, log.Compact())
+	has, err := log.HasKey([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, has)
+}
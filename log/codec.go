@@ -0,0 +1,103 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec turns values into the bytes of record values and back
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(b []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(b []byte, v any) error {
+	return json.Unmarshal(b, v)
+}
+
+// ProtoCodec encodes protobuf messages
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("log: %T isn't a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("log: %T isn't a proto.Message", v)
+	}
+	return proto.Unmarshal(b, m)
+}
+
+// MsgpackCodec encodes values with msgpack, which is more compact than json
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(b []byte, v any) error {
+	return msgpack.Unmarshal(b, v)
+}
+
+// RecordLog is what Typed needs from a log, both Log and DistributedLog have it
+type RecordLog interface {
+	AppendRecord(Record) (uint64, error)
+	ReadRecord(uint64) (Record, error)
+}
+
+// Typed appends values of type T to a log and reads them back with a codec
+type Typed[T any] struct {
+	Log   RecordLog
+	Codec Codec
+}
+
+func NewTyped[T any](log RecordLog, codec Codec) *Typed[T] {
+	return &Typed[T]{Log: log, Codec: codec}
+}
+
+// Append writes the value as a record without a key
+func (t *Typed[T]) Append(v T) (uint64, error) {
+	return t.AppendKey(nil, v)
+}
+
+// AppendKey writes the value as a record with the given key
+func (t *Typed[T]) AppendKey(key []byte, v T) (uint64, error) {
+	b, err := t.Codec.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return t.Log.AppendRecord(Record{Key: key, Value: b})
+}
+
+// Read returns the value stored at the given offset
+func (t *Typed[T]) Read(off uint64) (T, error) {
+	var v T
+	record, err := t.Log.ReadRecord(off)
+	if err != nil {
+		return v, err
+	}
+	// protobuf messages are pointers that need something to point to
+	if m, ok := any(v).(proto.Message); ok {
+		v = m.ProtoReflect().New().Interface().(T)
+		err = t.Codec.Unmarshal(record.Value, v)
+	} else {
+		err = t.Codec.Unmarshal(record.Value, &v)
+	}
+	return v, err
+}
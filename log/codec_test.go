@@ -0,0 +1,50 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID    string
+	Items []string
+	Total float64
+}
+
+func TestTyped(t *testing.T) {
+	dir, err := os.MkdirTemp("", "codec_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	want := order{ID: "42", Items: []string{"book", "pen"}, Total: 12.5}
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		orders := NewTyped[order](log, codec)
+		off, err := orders.AppendKey([]byte(want.ID), want)
+		require.NoError(t, err)
+		got, err := orders.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		record, err := log.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte(want.ID), record.Key)
+	}
+
+	messages := NewTyped[*wrapperspb.StringValue](log, ProtoCodec{})
+	off, err := messages.Append(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	got, err := messages.Read(off)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(wrapperspb.String("hello"), got))
+
+	_, err = NewTyped[order](log, ProtoCodec{}).Append(want)
+	require.Error(t, err)
+}
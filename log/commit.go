@@ -0,0 +1,123 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// groupCommit has concurrent appends share their fsyncs when every write
+// is synced. an append waits for an fsync that started after its write,
+// the first one to wait does it for everything written up to then while
+// the appends coming in meanwhile wait for the next one. so the appends
+// are as durable as with an fsync each, with a lot fewer fsyncs
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	synced  uint64
+	syncing bool
+}
+
+// newGroupCommit takes the records before synced to be on disk
+func newGroupCommit(synced uint64) *groupCommit {
+	g := &groupCommit{synced: synced}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// wait returns once the record at off is synced, sync is called with
+// the offset the unsynced records start at and returns where they end.
+// it gives up waiting when ctx is done, the record's synced with the next
+// ones anyway
+func (g *groupCommit) wait(ctx context.Context, off uint64, sync func(from uint64) (uint64, error)) error {
+	stop := context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.cond.Broadcast()
+	})
+	defer stop()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.synced <= off {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.syncing = true
+		from := g.synced
+		g.mu.Unlock()
+		to, err := sync(from)
+		g.mu.Lock()
+		g.syncing = false
+		g.cond.Broadcast()
+		// the ones waiting on it try again themselves
+		if err != nil {
+			return err
+		}
+		g.synced = max(g.synced, to)
+	}
+	return nil
+}
+
+// rewind is called when the records from off on are dropped,
+// so the ones appended in their place get synced too
+func (g *groupCommit) rewind(off uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.synced = min(g.synced, off)
+}
+
+// advance takes the records before off to be synced
+func (g *groupCommit) advance(off uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.synced = max(g.synced, off)
+}
+
+// syncFrom fsyncs the segments holding the records from the given offset
+// on and returns the offset after the last of them. the fsyncs are done
+// without the log's lock, so appends carry on in the meantime
+func (l *Log) syncFrom(from uint64) (uint64, error) {
+	l.mu.RLock()
+	to := l.activeSegment.nextOffset
+	var segments []*segment
+	for i := len(l.segments) - 1; i >= 0 && l.segments[i].nextOffset > from; i-- {
+		l.segments[i].acquire()
+		segments = append(segments, l.segments[i])
+	}
+	l.mu.RUnlock()
+
+	l.metrics.observeGroupCommit()
+	var errs []error
+	for _, s := range segments {
+		errs = append(errs, s.commit(), s.release())
+	}
+	return to, errors.Join(errs...)
+}
+
+// Sync flushes and fsyncs every segment written since it was last synced,
+// for applications with commit points of their own, e.g. a checkpoint.
+// whatever was appended before it's called is on disk once it returns,
+// whatever the sync policy
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	for _, s := range l.segments {
+		if s.unsynced > 0 || s == l.activeSegment {
+			errs = append(errs, s.Sync())
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if l.commits != nil {
+		l.commits.advance(l.activeSegment.nextOffset)
+	}
+	return nil
+}
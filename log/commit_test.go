@@ -0,0 +1,76 @@
+package log
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "commit_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64 * width
+	c.Sync.Policy = SyncEveryWrite
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	const appenders, appends = 16, 20
+	var wg sync.WaitGroup
+	offsets := make(chan uint64, appenders*appends)
+	for range appenders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range appends {
+				off, err := log.Append(write)
+				require.NoError(t, err)
+				offsets <- off
+			}
+		}()
+	}
+	wg.Wait()
+	close(offsets)
+
+	seen := make(map[uint64]bool)
+	for off := range offsets {
+		require.False(t, seen[off])
+		seen[off] = true
+	}
+	require.Len(t, seen, appenders*appends)
+	// every append returned after an fsync, but they didn't each need one
+	commits := testutil.ToFloat64(log.metrics.groupCommits)
+	require.Greater(t, commits, float64(0))
+	require.LessOrEqual(t, commits, float64(appenders*appends))
+	require.Equal(t, uint64(appenders*appends), log.commits.synced)
+}
+
+func TestLogSync(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 2 * width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// the first segment's sealed with a record that was never synced
+	for range 3 {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 2)
+
+	require.NoError(t, log.Sync())
+	for _, s := range log.segments {
+		require.Equal(t, uint64(0), s.unsynced)
+		fi, err := os.Stat(s.store.Name())
+		require.NoError(t, err)
+		require.Equal(t, int64(s.store.size), fi.Size())
+	}
+}
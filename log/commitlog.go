@@ -0,0 +1,46 @@
+package log
+
+import "context"
+
+var _ CommitLog = (*Log)(nil)
+
+// CommitLog is what a log does that isn't about its files, Log and the
+// in-memory memlog.Log have it, so code written against it can be tested
+// without touching the disk
+type CommitLog interface {
+	Append(value []byte) (uint64, error)
+	Delete(key []byte) (uint64, error)
+	AppendRecord(record Record) (uint64, error)
+	AppendIf(record Record, expected uint64) (uint64, error)
+	AppendContext(ctx context.Context, record Record) (uint64, error)
+	AppendAsync(record Record, done func(off uint64, err error))
+	AppendBatch(values [][]byte) (first, last uint64, err error)
+	AppendAll(values [][]byte) (first, last uint64, err error)
+
+	Read(off uint64) ([]byte, error)
+	ReadRecord(off uint64) (Record, error)
+	ReadContext(ctx context.Context, off uint64) (Record, error)
+	ReadWait(ctx context.Context, off uint64) (Record, error)
+	ReadRange(start, end uint64, maxBytes int) ([]RangeRecord, error)
+	Iterator(startOffset uint64) *Iterator
+	Subscribe(ctx context.Context, fromOffset uint64) (<-chan SubscribedRecord, error)
+	Scan(fn func(off uint64, record Record) error) error
+	Get(key []byte) (Record, error)
+	HasKey(key []byte) (bool, error)
+
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	HighWatermark() uint64
+	Truncate(lowest uint64) error
+
+	Commit(group string, offset uint64) error
+	Fetch(group string) (uint64, error)
+
+	Epoch() uint64
+	SetEpoch(epoch uint64) error
+	EndOffsetForEpoch(epoch uint64) (uint64, uint64, error)
+
+	Sync() error
+	Close() error
+	Remove() error
+}
@@ -0,0 +1,274 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// compacted files are written next to the segment's own files
+// and renamed over them once complete
+const compactedExt = ".compacted"
+
+// Compact rewrites the sealed segments keeping only the latest record of
+// every key, records without a key are always kept. so a tombstone drops
+// the values of its key before it, and is dropped itself once it's older
+// than Compaction.TombstoneGrace, if that's set.
+// the active segment is left alone, since it's still being appended to
+func (l *Log) Compact() error {
+	if l.Config.readOnly {
+		return ErrReadOnly
+	}
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	sealed := l.sealed()
+	l.mu.RUnlock()
+
+	// sealed segments don't change, so they can be read without blocking
+	// readers and writers of the log. their key filters are built on the way
+	latest := make(map[string]uint64)
+	for _, s := range sealed {
+		f := newBloomFilter(s.store.records)
+		err := s.scan(func(off uint64, b []byte) error {
+			record, err := decodeRecord(b)
+			if err != nil {
+				return err
+			}
+			if record.Key != nil {
+				latest[string(record.Key)] = off
+				f.add(record.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.setKeyFilter(f)
+	}
+
+	// the tombstones stay around for a while, so the consumers
+	// catching up get to see the deletes
+	var cutoff time.Time
+	if grace := l.Config.Compaction.TombstoneGrace; grace > 0 {
+		cutoff = l.Config.Clock().Add(-grace)
+	}
+	for _, s := range sealed {
+		if err := l.compactSegment(s, latest, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) compactSegment(s *segment, latest map[string]uint64, cutoff time.Time) error {
+	keep := func(off uint64, b []byte) (bool, error) {
+		record, err := decodeRecord(b)
+		if err != nil {
+			return false, err
+		}
+		if record.Key == nil {
+			return true, nil
+		}
+		if latest[string(record.Key)] != off {
+			return false, nil
+		}
+		// tombstones without a timestamp are kept, their age is unknown
+		expired := !cutoff.IsZero() && !record.Timestamp.IsZero() && record.Timestamp.Before(cutoff)
+		return !record.IsTombstone() || !expired, nil
+	}
+	if _, ok := s.store.file(); ok && l.Config.Compaction.PunchHoles && canPunchHoles {
+		if ok, err := l.punchSegment(s, keep); err != nil || ok {
+			return err
+		}
+	}
+
+	// don't rewrite segments that have nothing to drop
+	dirty := false
+	err := s.scan(func(off uint64, b []byte) error {
+		ok, err := keep(off, b)
+		dirty = dirty || !ok
+		return err
+	})
+	if err != nil || !dirty {
+		return err
+	}
+	return l.rewriteSegment(s, keep, false)
+}
+
+// rewriteSegment writes the records of the sealed segment that keep keeps
+// to new files, in the log's current format, and swaps them in. with verify
+// they're read back before, so the segment's only replaced by a sound one
+func (l *Log) rewriteSegment(s *segment, keep func(off uint64, b []byte) (bool, error), verify bool) error {
+	storeName := s.store.Name() + compactedExt
+	indexName := s.index.Name() + compactedExt
+	if err := l.Config.backend().Remove(storeName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(indexName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	out, err := openSegment(storeName, indexName, s.baseOffset, s.config)
+	if err != nil {
+		return err
+	}
+	// the records keep being compressed with the segment's dictionary
+	if d := s.store.dict; d != nil && s.config.Store.Compression == CompressionZstdDict {
+		if err = out.store.writeDict(d.raw); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	f := newBloomFilter(s.store.records)
+	err = s.scan(func(off uint64, b []byte) error {
+		ok, err := keep(off, b)
+		if err != nil || !ok {
+			return err
+		}
+		if key := recordKey(b); key != nil {
+			f.add(key)
+		}
+		return out.write(off, b)
+	})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if verify {
+		var corruptions []Corruption
+		err = out.verify(func(c Corruption) { corruptions = append(corruptions, c) }, nil)
+		if err == nil && len(corruptions) > 0 {
+			err = corruptions[0]
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return l.swapSegment(s, storeName, indexName, f)
+}
+
+// swapSegment replaces the segment with its compacted files and their
+// key filter. renaming the store is the commit point, see recoverCompaction
+func (l *Log) swapSegment(s *segment, storeName, indexName string, f *bloomFilter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := -1
+	for j, segment := range l.segments {
+		if segment == s {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		l.Config.backend().Remove(storeName)
+		return os.Remove(indexName)
+	}
+
+	// the readers that hold on to the segment keep reading its old files
+	if err := s.retire(); err != nil {
+		return err
+	}
+	if err := l.Config.backend().Rename(storeName, s.store.Name()); err != nil {
+		return l.reopenSegment(i, err)
+	}
+	if err := os.Rename(indexName, s.index.Name()); err != nil {
+		return l.reopenSegment(i, err)
+	}
+
+	ns, err := newSegment(l.Dir, s.baseOffset, l.Config)
+	if err != nil {
+		return l.reopenSegment(i, err)
+	}
+	ns.setMetrics(l.metrics)
+	ns.setKeyFilter(f)
+	// nothing survived compaction
+	if ns.nextOffset == ns.baseOffset {
+		l.segments = append(l.segments[:i], l.segments[i+1:]...)
+		return errors.Join(ns.Remove(), l.saveManifest())
+	}
+	l.segments[i] = ns
+	return nil
+}
+
+// recoverCompaction finishes or rolls back a compaction interrupted by a crash
+// if the compacted store is still around, the swap never started
+// otherwise the store was renamed and only the index is left to rename
+func (l *Log) recoverCompaction() error {
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	names, err := l.Config.backend().List(l.Dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	pending := make(map[string]bool)
+	for _, name := range names {
+		if path.Ext(name) == compactedExt {
+			pending[name] = true
+		}
+	}
+
+	for name := range pending {
+		if path.Ext(strings.TrimSuffix(name, compactedExt)) != ".index" {
+			continue
+		}
+		indexName := strings.TrimSuffix(name, compactedExt)
+		storeName := strings.TrimSuffix(indexName, ".index") + ".store" + compactedExt
+		if pending[storeName] {
+			continue
+		}
+		if err := os.Rename(path.Join(l.Dir, name), path.Join(l.Dir, indexName)); err != nil {
+			return err
+		}
+		l.Config.logger().Warn("finished an interrupted compaction", "index", indexName)
+		delete(pending, name)
+	}
+
+	for name := range pending {
+		remove := os.Remove
+		if strings.HasSuffix(name, ".store"+compactedExt) {
+			remove = l.Config.backend().Remove
+		}
+		if err := remove(path.Join(l.Dir, name)); err != nil {
+			return err
+		}
+		l.Config.logger().Warn("dropped the files of an interrupted compaction", "file", name)
+	}
+	return nil
+}
+
+func (l *Log) compactLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Compaction.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			// a failed run is simply retried on the next tick
+			if err := l.Compact(); err != nil {
+				l.Config.logger().Error("compaction failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package log
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := os.MkdirTemp("", "compact_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	records := []Record{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("1")},
+		{Value: []byte("no key")},
+		{Key: []byte("a"), Value: []byte("2")},
+		{Key: []byte("b")},
+		{Key: []byte("a"), Value: []byte("3")},
+		{Key: []byte("c"), Value: []byte("1")},
+	}
+	for _, r := range records {
+		_, err := log.AppendRecord(r)
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 2)
+
+	require.NoError(t, log.Compact())
+
+	// only the latest record per key survives, tombstones included
+	for _, off := range []uint64{0, 1, 3} {
+		_, err := log.ReadRecord(off)
+		require.Error(t, err, "offset %d", off)
+	}
+	for _, off := range []uint64{2, 4, 5, 6} {
+		got, err := log.ReadRecord(off)
+		require.NoError(t, err, "offset %d", off)
+		require.Equal(t, records[off].Key, got.Key)
+		require.Equal(t, records[off].Value, got.Value)
+	}
+	got, err := log.ReadRecord(4)
+	require.NoError(t, err)
+	require.True(t, got.IsTombstone())
+
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(records)), off)
+
+	// compacted segments are picked up on reopen
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Read(0)
+	require.Error(t, err)
+	read, err := log.Read(5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), read)
+}
+
+func TestCompactWhileRead(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for range 6 {
+		_, err := log.AppendRecord(Record{Key: []byte("a"), Value: write})
+		require.NoError(t, err)
+	}
+	before, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+
+	// a reader from before the compaction reads the segments it started with
+	r := log.Reader()
+	require.NoError(t, log.Compact())
+	_, err = log.ReadRecord(0)
+	require.Error(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, before, got)
+	require.NoError(t, r.Close())
+	after, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.Less(t, len(after), len(before))
+}
+
+func TestCompactTombstoneGrace(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Compaction.TombstoneGrace = time.Hour
+	c.Clock = func() time.Time { return now }
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for _, key := range []string{"a", "b", "a", "b"} {
+		_, err = log.AppendRecord(Record{Key: []byte(key), Value: []byte("v")})
+		require.NoError(t, err)
+	}
+	_, err = log.Delete([]byte("a"))
+	require.NoError(t, err)
+	_, err = log.Delete(nil)
+	require.ErrorIs(t, err, ErrInvalidRecord)
+	// seals the tombstone's segment
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	_, err = log.Append(write)
+	require.NoError(t, err)
+
+	// the values go, the tombstone stays through the grace period
+	require.NoError(t, log.Compact())
+	for _, off := range []uint64{0, 1, 2} {
+		_, err = log.ReadRecord(off)
+		require.Error(t, err, "offset %d", off)
+	}
+	tombstone, err := log.ReadRecord(4)
+	require.NoError(t, err)
+	require.True(t, tombstone.IsTombstone())
+	_, err = log.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, log.Compact())
+	_, err = log.ReadRecord(4)
+	require.Error(t, err)
+	_, err = log.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	got, err := log.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v"), got.Value)
+}
+
+func TestRecoverCompaction(t *testing.T) {
+	dir, err := os.MkdirTemp("", "recover_compaction_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	touch := func(name string) {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), nil, 0644))
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(path.Join(dir, name))
+		return err == nil
+	}
+
+	// interrupted before the swap, rolled back
+	touch("0.store.compacted")
+	touch("0.index.compacted")
+	// interrupted after the store was renamed, rolled forward
+	touch("10.index.compacted")
+
+	l := &Log{Dir: dir}
+	require.NoError(t, l.recoverCompaction())
+	require.False(t, exists("0.store.compacted"))
+	require.False(t, exists("0.index.compacted"))
+	require.False(t, exists("10.index.compacted"))
+	require.True(t, exists("10.index"))
+}
+
+func TestRecordEncoding(t *testing.T) {
+	for _, want := range []Record{
+		{Value: []byte("value")},
+		{Key: []byte("key"), Value: []byte("value")},
+		{Key: []byte("key")},
+		{Key: []byte{}, Value: []byte{}},
+		{Key: []byte("key"), Value: []byte("value"), Timestamp: time.Unix(0, 1), EventTime: time.Unix(0, 2)},
+		{Value: []byte("value"), Headers: map[string]string{"traceparent": "00-01", "empty": ""}},
+		{Key: []byte("key"), Headers: map[string]string{"reason": "deleted"}},
+	} {
+		got, err := decodeRecord(encodeRecord(want))
+		require.NoError(t, err)
+		require.Equal(t, want.Key, got.Key)
+		require.Equal(t, string(want.Value), string(got.Value))
+		require.Equal(t, want.IsTombstone(), got.IsTombstone())
+		require.True(t, want.Timestamp.Equal(got.Timestamp))
+		require.True(t, want.EventTime.Equal(got.EventTime))
+		require.Equal(t, want.Headers, got.Headers)
+	}
+
+	_, err := decodeRecord(nil)
+	require.ErrorIs(t, err, ErrInvalidRecord)
+	_, err = decodeRecord([]byte{attrKey, 10, 'a'})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+	_, err = decodeRecord([]byte{attrTimestamp, 1, 2})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+	_, err = decodeRecord([]byte{attrHeaders, 100, 1, 'a'})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+}
@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is the codec used to compress record payloads
+// it's stored in the header of every record, so records written with
+// different codecs can live in the same store
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionLZ4
+	CompressionZstd
+	// CompressionZstdDict is zstd with a dictionary trained on a sample of
+	// the records of the segment before, so small records that zstd on its
+	// own barely shrinks compress well too. the dictionary is kept at the
+	// start of the store, a store without one, like the first of the log,
+	// gets plain zstd records. training makes rotating a bit slower
+	CompressionZstdDict
+)
+
+var (
+	// ErrUnknownCompression is returned for a codec this package doesn't know about
+	ErrUnknownCompression = errors.New("log: unknown compression codec")
+	// ErrNoDictionary is returned for a record compressed with a
+	// dictionary that isn't at hand
+	ErrNoDictionary = errors.New("log: no compression dictionary")
+)
+
+var (
+	// both are safe for concurrent use with EncodeAll and DecodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// dictSamples is how many records a dictionary is trained on at most,
+// dictMaxBytes how big it gets
+const (
+	dictSamples  = 1024
+	dictMaxBytes = 32 << 10
+)
+
+// zstdDict compresses records with a dictionary, like zstdEncoder and
+// zstdDecoder it's safe for concurrent use
+type zstdDict struct {
+	raw []byte
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdDict(raw []byte) (*zstdDict, error) {
+	e, err := zstd.NewWriter(nil, zstd.WithEncoderDict(raw), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderDicts(raw))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdDict{raw: raw, enc: e, dec: d}, nil
+}
+
+// trainDict builds a dictionary out of the samples, it fails if they
+// don't have enough in common to build one from
+func trainDict(samples [][]byte) ([]byte, error) {
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: dictMaxBytes,
+		HashBytes:   6,
+	})
+}
+
+// compress compresses b with the codec, d is the dictionary of
+// CompressionZstdDict
+func compress(c Compression, b []byte, d *zstdDict) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return b, nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, b), nil
+	case CompressionLZ4:
+		var buf bytes.Buffer
+		w := lz4.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(b, nil), nil
+	case CompressionZstdDict:
+		if d == nil {
+			return nil, ErrNoDictionary
+		}
+		return d.enc.EncodeAll(b, nil), nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+func decompress(c Compression, b []byte, d *zstdDict) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return b, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, b)
+	case CompressionLZ4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(b)))
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(b, nil)
+	case CompressionZstdDict:
+		if d == nil {
+			return nil, ErrNoDictionary
+		}
+		return d.dec.DecodeAll(b, nil)
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// seedDict trains the dictionary of the new active segment on the records
+// of the one just sealed, under the log's lock. the segment goes without
+// one if they can't make one, its records are compressed with plain zstd
+func (l *Log) seedDict() error {
+	if len(l.segments) < 2 {
+		return nil
+	}
+	sealed := l.segments[len(l.segments)-2]
+	every := max(1, sealed.store.records/dictSamples)
+	var samples [][]byte
+	var n uint64
+	err := sealed.scan(func(_ uint64, b []byte) error {
+		if n%every == 0 {
+			samples = append(samples, b)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	raw, err := trainDict(samples)
+	if err != nil {
+		l.Config.logger().Warn("no compression dictionary", "base_offset", l.activeSegment.baseOffset, "err", err)
+		return nil
+	}
+	return l.activeSegment.store.writeDict(raw)
+}
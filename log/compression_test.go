@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// event is a small json record, like the ones a dictionary helps with
+func event(i int) []byte {
+	return fmt.Appendf(nil, `{"id":%d,"type":"page_view","user":"user-%d","path":"/products/%d","agent":"Mozilla/5.0"}`, i, i%7, i%13)
+}
+
+func TestZstdDict(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Store.Compression = CompressionZstdDict
+	c.Segment.MaxStoreBytes = 16 << 10
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	n := 0
+	for len(log.segments) < 3 {
+		_, err := log.Append(event(n))
+		require.NoError(t, err)
+		n++
+	}
+	// the first segment had nothing to train on
+	require.Nil(t, log.segments[0].store.dict)
+	require.NotNil(t, log.segments[1].store.dict)
+	require.NotNil(t, log.segments[2].store.dict)
+
+	// the records of the second segment take less room than the first's
+	perRecord := func(s *segment) float64 {
+		return float64(s.store.size-s.store.start) / float64(s.nextOffset-s.baseOffset)
+	}
+	require.Less(t, perRecord(log.segments[1]), perRecord(log.segments[0])*0.8)
+
+	check := func() {
+		t.Helper()
+		for off := 0; off < n; off++ {
+			got, err := log.Read(uint64(off))
+			require.NoError(t, err)
+			require.Equal(t, event(off), got)
+		}
+		var scanned int
+		require.NoError(t, ScanStores(log.Reader(), c, func(record Record) error {
+			require.Equal(t, event(scanned), record.Value)
+			scanned++
+			return nil
+		}))
+		require.Equal(t, n, scanned)
+
+		// copies from the middle of a segment bring its dictionary along
+		from := log.segments[1].baseOffset + 1
+		var b bytes.Buffer
+		next, _, err := log.CopyTo(&b, from)
+		require.NoError(t, err)
+		off := int(from)
+		require.NoError(t, ScanStores(&b, c, func(record Record) error {
+			require.Equal(t, event(off), record.Value)
+			off++
+			return nil
+		}))
+		require.Equal(t, int(next), off)
+
+		corruptions, err := log.Verify()
+		require.NoError(t, err)
+		require.Empty(t, corruptions)
+	}
+	check()
+
+	// the dictionaries are read back with the stores
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.NotNil(t, log.segments[1].store.dict)
+	check()
+
+	// and kept by compaction
+	require.NoError(t, log.Compact())
+	require.NotNil(t, log.segments[1].store.dict)
+	check()
+}
@@ -0,0 +1,195 @@
+package log
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SyncPolicy decides how often appended records are fsynced to disk
+type SyncPolicy int
+
+const (
+	// SyncNever leaves it to the OS to write data to disk
+	SyncNever SyncPolicy = iota
+	// SyncEveryWrite fsyncs after every appended record, the appends
+	// going on at the same time share an fsync
+	SyncEveryWrite
+	// SyncEveryNRecords fsyncs after every Sync.EveryN appended records
+	SyncEveryNRecords
+	// SyncEveryInterval fsyncs on append if at least Sync.Interval
+	// has passed since the last fsync
+	SyncEveryInterval
+)
+
+type Config struct {
+	Store struct {
+		// SkipChecksumVerify disables crc32c verification on reads
+		// useful for hot read paths where the caller trusts the disk
+		SkipChecksumVerify bool
+		// Compression codec for newly appended records
+		Compression Compression
+		// Framing of newly created stores
+		Framing Framing
+		// Preallocate reserves Segment.MaxStoreBytes of disk for newly
+		// created stores, to cut the metadata churn and fragmentation of
+		// growing files. the file's size is still that of its records
+		// only done on linux
+		Preallocate bool
+		// DirectIO writes the stores around the page cache, with O_DIRECT
+		// on linux and F_NOCACHE on darwin, so bulk appends don't push out
+		// what the rest of the process has cached. meant for dedicated disks
+		DirectIO bool
+		// IOUring batches store writes and fsyncs through io_uring
+		// experimental, it's only built on linux with the iouring build tag
+		// stores are written through the usual buffer otherwise
+		IOUring bool
+		// MmapReads maps the stores into memory for reads,
+		// so reading a record is a copy instead of a syscall or two
+		MmapReads bool
+		// ReadAdvice has the iterators, and the reads made with a context of
+		// SequentialReads, tell the kernel they go through the sealed stores
+		// front to back. it reads ahead of them and drops the pages behind
+		// them, so a backfill doesn't push the hot records out of the page
+		// cache. only done on linux
+		ReadAdvice bool
+		// MaxRecordBytes caps the size of a record, its key and headers
+		// included, appending a larger one fails. zero means no cap
+		MaxRecordBytes uint64
+		// KeyProvider enables AES-GCM encryption of newly appended records
+		// and is needed to read back encrypted ones
+		KeyProvider KeyProvider
+		// Backend keeps the stores, in files of the log's directory by
+		// default. Preallocate, DirectIO, IOUring, MmapReads and ReadAdvice
+		// only apply to files, and so do Compaction.PunchHoles, Backup and
+		// tiering, which read the files themselves
+		Backend StorageBackend
+	}
+	Segment struct {
+		MaxStoreBytes uint64
+		MaxIndexBytes uint64
+		InitialOffset uint64
+		// IndexInterval indexes every Nth record instead of every one
+		// and IndexIntervalBytes a record every so many store bytes,
+		// the records in between are found by walking the store from
+		// the nearest entry. a smaller index for slower lookups
+		IndexInterval      uint64
+		IndexIntervalBytes uint64
+	}
+	Compaction struct {
+		// Interval at which sealed segments are compacted in the background
+		// zero disables background compaction, Log.Compact still works
+		Interval time.Duration
+		// PunchHoles compacts the segments in place, the dropped records
+		// are punched out of their stores and only the index is rewritten.
+		// only done on linux, the segments are rewritten elsewhere
+		PunchHoles bool
+		// TombstoneGrace is how long a tombstone is kept once compaction
+		// has dropped the values of its key, judged by its timestamp.
+		// zero keeps the tombstones forever
+		TombstoneGrace time.Duration
+	}
+	Retention struct {
+		// MaxAge after which sealed segments are deleted, judged by
+		// the timestamp of their newest record, zero keeps them forever
+		MaxAge time.Duration
+		// MaxBytes caps the total size of the segments, the oldest ones
+		// are deleted until the log is under it, zero means no cap
+		MaxBytes uint64
+		// Interval at which the retention is checked, defaults to a minute
+		Interval time.Duration
+		// OnDrop is called with the first and last offsets of every segment
+		// the retention deletes, after the log's lock has been released
+		OnDrop func(first, last uint64)
+	}
+	Tiering struct {
+		// Store enables tiering, the oldest sealed segments are uploaded
+		// to it and removed locally, reads fetch them back into a cache
+		Store TierStore
+		// KeepLocal is how many of the newest sealed segments stay local
+		KeepLocal int
+		// CacheSegments is how many fetched segments are kept, defaults to 4
+		CacheSegments int
+		// Interval at which segments are tiered, defaults to a minute
+		Interval time.Duration
+	}
+	Scrub struct {
+		// Interval at which the sealed segments are verified in the
+		// background, like Log.Verify does, zero disables the scrubber
+		Interval time.Duration
+		// BytesPerSecond caps how fast the scrubber reads, zero doesn't
+		BytesPerSecond uint64
+		// OnCorruption is called with every corruption the scrubber finds
+		OnCorruption func(Corruption)
+	}
+	Space struct {
+		// MinFreeBytes is how much of the disk has to stay free, appends fail
+		// with ErrNoSpace rather than fill it up and get cut off mid-record.
+		// only checked on linux and darwin
+		MinFreeBytes uint64
+		// MaxBytes is a quota on the size of the log, appends past it fail
+		// unlike Retention.MaxBytes, which deletes the oldest segments
+		MaxBytes uint64
+		// Wait is how long an append waits for room, e.g. for the retention
+		// to free some up, before it fails. zero fails right away
+		Wait time.Duration
+	}
+	// KeyIndex keeps the offset of the latest record of every key in memory,
+	// for Get and HasKey to find them without searching the segments
+	KeyIndex bool
+	Sync     struct {
+		Policy   SyncPolicy
+		EveryN   uint64
+		Interval time.Duration
+	}
+	Flush struct {
+		// Interval at which the active store's buffer is written out in the
+		// background, so the records reach the OS even if nothing reads
+		// them. zero leaves them buffered until a read or rotation needs them
+		Interval time.Duration
+		// Sync fsyncs the active segment along with every flush
+		Sync bool
+	}
+	// Subscribe is for the channels of Log.Subscribe
+	Subscribe struct {
+		// Buffer is how many records a subscription's channel holds,
+		// defaults to 64
+		Buffer int
+		// Policy is what's done when a subscriber falls behind and
+		// its channel fills up, it's blocked on by default
+		Policy SlowConsumerPolicy
+	}
+	// Clock stamps appended records, defaults to time.Now
+	Clock func() time.Time
+	// Logger gets what the log does on its own: rotations, truncations,
+	// what's recovered on open, failed fsyncs and background runs.
+	// nothing's logged if nil
+	Logger *slog.Logger
+	// TracerProvider enables tracing of appends, reads, flushes and rotations
+	TracerProvider trace.TracerProvider
+	// Replication is for leaders that Replicators follow
+	Replication struct {
+		// Replicas is how many copies of the log a leader has, its own
+		// included, the records on a majority of them are committed.
+		// zero or one has every record committed, see HighWatermark
+		Replicas int
+		// Followers are the IDs of the followers the leader counts towards
+		// the majority, the reports of the others are refused
+		Followers []string
+		// ReportTimeout is how long a follower's report counts towards the
+		// majority, those that don't report again in time are taken for
+		// dead until they do. defaults to 10 seconds
+		ReportTimeout time.Duration
+	}
+	// Raft is only used by DistributedLog
+	Raft struct {
+		raft.Config
+		StreamLayer *StreamLayer
+		Bootstrap   bool
+	}
+
+	// readOnly is set by OpenReadOnly, nothing's written to the directory
+	readOnly bool
+}
@@ -0,0 +1,116 @@
+package log
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// CopyTo writes the records from off on, up to the end of the segment
+// holding off, to w as Log.Reader would, and returns the offset to go on from
+// the bytes go from the store file to w without a detour through memory
+// where the platform allows, e.g. with sendfile when w is a *net.TCPConn
+// the records are read back with ScanStores
+func (l *Log) CopyTo(w io.Writer, off uint64) (next uint64, n int64, err error) {
+	l.mu.RLock()
+	var s *segment
+	for _, segment := range l.segments {
+		if segment.baseOffset <= off && off < segment.nextOffset {
+			s = segment
+			break
+		}
+	}
+	if s == nil {
+		l.mu.RUnlock()
+		return 0, 0, &OffsetError{off, ErrOffsetOutOfRange}
+	}
+	pos, err := s.seek(off)
+	if err != nil {
+		l.mu.RUnlock()
+		return 0, 0, err
+	}
+	end, next := s.store.size, s.nextOffset
+	s.acquire()
+	l.mu.RUnlock()
+	defer func() {
+		if rerr := s.release(); err == nil {
+			err = rerr
+		}
+	}()
+
+	// the records may still be buffered
+	if err = s.store.Flush(); err != nil {
+		return 0, 0, err
+	}
+	var r io.Reader = io.NewSectionReader(s.store, int64(pos), int64(end-pos))
+	// a file of its own, since sendfile goes from the file's offset
+	if _, ok := s.store.file(); ok {
+		f, err := os.Open(s.store.Name())
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		if _, err = f.Seek(int64(pos), io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		r = io.LimitReader(f, int64(end-pos))
+	}
+
+	// the section is framed like a store of its own, with the dictionary
+	// of the segment, if it has one, for its records to be read with, and
+	// where it starts in the store, for the encrypted ones to be opened
+	var version []byte
+	if s.store.framing != FramingFixed {
+		version = []byte{byte(s.store.framing)}
+	}
+	version = append(version, s.store.dictFrame...)
+	if pos != s.store.start {
+		version = append(version, posFrame(s.store.framing, pos)...)
+	}
+	header := append(enc.AppendUint64(nil, uint64(len(version))+end-pos), version...)
+	if _, err = w.Write(header); err != nil {
+		return 0, 0, err
+	}
+	n, err = io.Copy(w, r)
+	return next, n + int64(len(header)), err
+}
+
+// posFrame is the frame that tells readStores the position of the next one
+func posFrame(framing Framing, pos uint64) []byte {
+	contents := enc.AppendUint64(nil, pos)
+	frame := appendLength(nil, framing, uint64(len(contents)))
+	frame = enc.AppendUint32(frame, crc32.Checksum(contents, crcTable))
+	frame = append(frame, posCodec)
+	frame = enc.AppendUint32(frame, 0)
+	return append(frame, contents...)
+}
+
+// seek returns the position of the first record at or after off
+func (s *segment) seek(off uint64) (uint64, error) {
+	var start uint64
+	if off > s.baseOffset {
+		start, _ = s.index.Floor(uint32(off - s.baseOffset))
+	}
+	pos := s.store.size
+	err := s.walk(start, func(o, p uint64) (bool, error) {
+		if o >= off {
+			pos = p
+			return true, nil
+		}
+		return false, nil
+	})
+	return pos, err
+}
+
+// ScanStores calls fn with the records read from what Log.Reader
+// or Log.CopyTo wrote to r, in the order they were written
+func ScanStores(r io.Reader, c Config, fn func(Record) error) error {
+	return readStores(bufio.NewReader(r), c, func(b []byte) error {
+		record, err := decodeRecord(b)
+		if err != nil {
+			return err
+		}
+		return fn(record)
+	})
+}
@@ -0,0 +1,25 @@
+package log
+
+import (
+	"bufio"
+	"io"
+)
+
+// storeWriter buffers the appends of a store until they're flushed
+type storeWriter interface {
+	io.Writer
+	Flush() error
+	Buffered() int
+}
+
+var _ storeWriter = (*bufio.Writer)(nil)
+
+// a store that's truncated picks up its writer from the new size
+type resetter interface {
+	reset(size uint64) error
+}
+
+// syncWriter is a writer that fsyncs the file along with its flush
+type syncWriter interface {
+	FlushSync() error
+}
@@ -0,0 +1,17 @@
+package log
+
+import (
+	"bufio"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// newDirectWriter turns off caching of the store's file, darwin has no
+// alignment rules for it so appends are buffered as usual
+func newDirectWriter(f *os.File, size uint64) (storeWriter, error) {
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1); err != nil {
+		return nil, err
+	}
+	return bufio.NewWriter(f), nil
+}
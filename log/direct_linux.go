@@ -0,0 +1,122 @@
+package log
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// O_DIRECT writes have to start at, and be a multiple of,
+	// the logical block size of the disk, 4K covers all of them
+	directAlign = 4096
+	// directBufferSize is how much is written at once when appends fill it
+	directBufferSize = 64 << 10
+)
+
+// newDirectWriter writes the store around the page cache, through its
+// own O_DIRECT descriptor, starting at size
+func newDirectWriter(f *os.File, size uint64) (storeWriter, error) {
+	direct, err := os.OpenFile(f.Name(), os.O_WRONLY|unix.O_DIRECT, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := &directWriter{
+		direct: direct,
+		src:    f,
+		buf:    alignedBuffer(directBufferSize),
+	}
+	if err = w.reset(size); err != nil {
+		direct.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// directWriter buffers appends in an aligned buffer and writes whole
+// blocks of it. flushing pads the last partial block, then truncates the
+// padding away, the partial block is kept and rewritten by the next flush
+type directWriter struct {
+	direct *os.File
+	// src is the store's file, it's read to pick up a partial block
+	src *os.File
+	buf []byte
+	// off is where buf starts in the file, always block aligned
+	off int64
+	n   int
+	// flushed is how much of buf is already in the file
+	flushed int
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		k := copy(w.buf[w.n:], p)
+		w.n += k
+		p = p[k:]
+		written += k
+		if w.n < len(w.buf) {
+			continue
+		}
+		if _, err := w.direct.WriteAt(w.buf, w.off); err != nil {
+			return written, err
+		}
+		w.off += int64(w.n)
+		w.n = 0
+		w.flushed = 0
+	}
+	return written, nil
+}
+
+func (w *directWriter) Buffered() int {
+	return w.n - w.flushed
+}
+
+func (w *directWriter) Flush() error {
+	if w.Buffered() == 0 {
+		return nil
+	}
+	end := (w.n + directAlign - 1) / directAlign * directAlign
+	clear(w.buf[w.n:end])
+	if _, err := w.direct.WriteAt(w.buf[:end], w.off); err != nil {
+		return err
+	}
+	if err := w.direct.Truncate(w.off + int64(w.n)); err != nil {
+		return err
+	}
+
+	blocks := w.n / directAlign * directAlign
+	copy(w.buf, w.buf[blocks:w.n])
+	w.off += int64(blocks)
+	w.n -= blocks
+	w.flushed = w.n
+	return nil
+}
+
+func (w *directWriter) Close() error {
+	return w.direct.Close()
+}
+
+// reset carries on writing at size, e.g. after the store was truncated
+func (w *directWriter) reset(size uint64) error {
+	w.off = int64(size / directAlign * directAlign)
+	w.n = int(int64(size) - w.off)
+	w.flushed = w.n
+	if w.n == 0 {
+		return nil
+	}
+	_, err := w.src.ReadAt(w.buf[:w.n], w.off)
+	return err
+}
+
+// alignedBuffer returns a buffer whose first byte is block aligned,
+// as O_DIRECT needs the memory to be aligned as well
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	skip := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) % directAlign); r != 0 {
+		skip = directAlign - r
+	}
+	return b[skip : skip+size : skip+size]
+}
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestStoreDirectIO(t *testing.T) {
+	f, err := os.CreateTemp("", "store_direct_io_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.DirectIO = true
+	s, err := newStore(fileStorage{f}, c)
+	if errors.Is(err, unix.EINVAL) {
+		t.Skip("the filesystem doesn't support O_DIRECT")
+	}
+	require.NoError(t, err)
+
+	// enough records to fill the buffer a few times over
+	n := 3*directBufferSize/int(width) + 1
+	for i := 0; i < n; i++ {
+		_, _, err := s.Append(write)
+		require.NoError(t, err)
+		// every other read flushes a partial block
+		if i%2 == 0 {
+			read, err := s.Read(uint64(i) * width)
+			require.NoError(t, err)
+			require.Equal(t, write, read)
+		}
+	}
+	require.NoError(t, s.Flush())
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(n)*int64(width), fi.Size())
+
+	// appends carry on from a truncated store
+	require.NoError(t, s.Truncate(width))
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, width, pos)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	require.NoError(t, s.Close())
+
+	// the padding of a flush that didn't get to truncate it is dropped
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, directAlign))
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.Equal(t, 2*width, s.size)
+	for pos := uint64(0); pos < s.size; pos += width {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package log
+
+import (
+	"bufio"
+	"os"
+)
+
+// newDirectWriter falls back to buffered writes where there's no direct I/O
+func newDirectWriter(f *os.File, size uint64) (storeWriter, error) {
+	return bufio.NewWriter(f), nil
+}
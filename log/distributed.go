@@ -0,0 +1,645 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+)
+
+// DistributedLog replicates appends to a cluster of nodes with raft
+// appends go through the leader, reads are served from the local log
+type DistributedLog struct {
+	config  Config
+	log     *Log
+	raftLog *logStore
+	raft    *raft.Raft
+}
+
+func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
+	l := &DistributedLog{
+		config: config,
+	}
+	if err := l.setupLog(dataDir); err != nil {
+		return nil, err
+	}
+	if err := l.setupRaft(dataDir); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *DistributedLog) setupLog(dataDir string) error {
+	logDir := filepath.Join(dataDir, "log")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	var err error
+	l.log, err = NewLog(logDir, l.config)
+	return err
+}
+
+func (l *DistributedLog) setupRaft(dataDir string) error {
+	fsm := &fsm{log: l.log}
+
+	logDir := filepath.Join(dataDir, "raft", "log")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	logConfig := l.config
+	// raft indexes start at 1
+	logConfig.Segment.InitialOffset = 1
+	// and raft entries aren't keyed, so there's nothing to compact
+	logConfig.Compaction.Interval = 0
+	// raft truncates its own log once it's snapshotted
+	logConfig.Retention.MaxAge = 0
+	logConfig.Retention.MaxBytes = 0
+	logConfig.Tiering.Store = nil
+	var err error
+	l.raftLog, err = newLogStore(logDir, logConfig)
+	if err != nil {
+		return err
+	}
+
+	stableStore, err := newStableStore(filepath.Join(dataDir, "raft", "stable"))
+	if err != nil {
+		return err
+	}
+
+	retain := 1
+	snapshotStore, err := raft.NewFileSnapshotStore(
+		filepath.Join(dataDir, "raft"),
+		retain,
+		os.Stderr,
+	)
+	if err != nil {
+		return err
+	}
+
+	maxPool := 5
+	timeout := 10 * time.Second
+	transport := raft.NewNetworkTransport(
+		l.config.Raft.StreamLayer,
+		maxPool,
+		timeout,
+		os.Stderr,
+	)
+
+	config := raft.DefaultConfig()
+	config.LocalID = l.config.Raft.LocalID
+	if l.config.Raft.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
+	}
+	if l.config.Raft.ElectionTimeout != 0 {
+		config.ElectionTimeout = l.config.Raft.ElectionTimeout
+	}
+	if l.config.Raft.LeaderLeaseTimeout != 0 {
+		config.LeaderLeaseTimeout = l.config.Raft.LeaderLeaseTimeout
+	}
+	if l.config.Raft.CommitTimeout != 0 {
+		config.CommitTimeout = l.config.Raft.CommitTimeout
+	}
+
+	l.raft, err = raft.NewRaft(
+		config,
+		fsm,
+		l.raftLog,
+		stableStore,
+		snapshotStore,
+		transport,
+	)
+	if err != nil {
+		return err
+	}
+
+	hasState, err := raft.HasExistingState(
+		l.raftLog,
+		stableStore,
+		snapshotStore,
+	)
+	if err != nil {
+		return err
+	}
+	if l.config.Raft.Bootstrap && !hasState {
+		config := raft.Configuration{
+			Servers: []raft.Server{{
+				ID:      config.LocalID,
+				Address: transport.LocalAddr(),
+			}},
+		}
+		err = l.raft.BootstrapCluster(config).Error()
+	}
+	return err
+}
+
+// AppendRecord replicates the record through raft
+// and returns its offset once it's committed
+// the leader stamps the record so that every replica stores the same timestamp
+func (l *DistributedLog) AppendRecord(record Record) (uint64, error) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = l.log.Config.Clock()
+	}
+	// checked before it's replicated, every node would refuse it
+	b := encodeRecord(record)
+	if err := l.log.Config.checkSize(b); err != nil {
+		return 0, err
+	}
+	res, err := l.apply(AppendRequestType, b)
+	if err != nil {
+		return 0, err
+	}
+	return res.(uint64), nil
+}
+
+// CopyTo copies the records from the local log, see Log.CopyTo
+func (l *DistributedLog) CopyTo(w io.Writer, off uint64) (uint64, int64, error) {
+	return l.log.CopyTo(w, off)
+}
+
+func (l *DistributedLog) apply(reqType RequestType, req []byte) (interface{}, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(reqType))
+	buf.Write(req)
+
+	timeout := 10 * time.Second
+	future := l.raft.Apply(buf.Bytes(), timeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return nil, ErrNotLeader
+		}
+		return nil, err
+	}
+	res := future.Response()
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadRecord reads from the local log, so the record might not be
+// there yet if this node is a follower that's lagging behind
+func (l *DistributedLog) ReadRecord(off uint64) (Record, error) {
+	return l.log.ReadRecord(off)
+}
+
+// LowestOffset and HighestOffset are those of the local log
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	return l.log.LowestOffset()
+}
+
+func (l *DistributedLog) HighestOffset() (uint64, error) {
+	return l.log.HighestOffset()
+}
+
+// HighWatermark is the next offset of the local log, raft only hands
+// it the records a majority of the cluster has, so they're all committed
+func (l *DistributedLog) HighWatermark() uint64 {
+	return l.log.nextOffset()
+}
+
+// LeaderAddr returns the address of the current leader
+// or an empty string if there's none
+func (l *DistributedLog) LeaderAddr() string {
+	addr, _ := l.raft.LeaderWithID()
+	return string(addr)
+}
+
+// SetRetention changes the retention of the records, see Log.SetRetention.
+// raft's own log isn't affected, it's truncated once snapshotted
+func (l *DistributedLog) SetRetention(maxAge time.Duration, maxBytes uint64) {
+	l.log.SetRetention(maxAge, maxBytes)
+}
+
+// GetServers returns the servers of the cluster, their addresses are
+// where they serve raft and grpc, and which of them is the leader
+func (l *DistributedLog) GetServers() ([]*api.Server, error) {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	leader, _ := l.raft.LeaderWithID()
+	var servers []*api.Server
+	for _, srv := range future.Configuration().Servers {
+		servers = append(servers, &api.Server{
+			Id:       string(srv.ID),
+			RpcAddr:  string(srv.Address),
+			IsLeader: srv.Address == leader,
+		})
+	}
+	return servers, nil
+}
+
+// Join adds the server to the cluster as a voter
+func (l *DistributedLog) Join(id, addr string) error {
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	serverID := raft.ServerID(id)
+	serverAddr := raft.ServerAddress(addr)
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID || srv.Address == serverAddr {
+			if srv.ID == serverID && srv.Address == serverAddr {
+				// server has already joined
+				return nil
+			}
+			// remove the existing server
+			removeFuture := l.raft.RemoveServer(serverID, 0, 0)
+			if err := removeFuture.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	addFuture := l.raft.AddVoter(serverID, serverAddr, 0, 0)
+	return addFuture.Error()
+}
+
+// Leave removes the server from the cluster
+func (l *DistributedLog) Leave(id string) error {
+	removeFuture := l.raft.RemoveServer(raft.ServerID(id), 0, 0)
+	return removeFuture.Error()
+}
+
+// WaitForLeader blocks until the cluster has elected a leader or times out
+func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
+	timeoutc := time.After(timeout)
+	ticker := time.NewTicker(time.Second / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timeoutc:
+			return errors.New("log: timed out waiting for leader")
+		case <-ticker.C:
+			if l.LeaderAddr() != "" {
+				return nil
+			}
+		}
+	}
+}
+
+func (l *DistributedLog) Close() error {
+	f := l.raft.Shutdown()
+	if err := f.Error(); err != nil {
+		return err
+	}
+	if err := l.raftLog.Close(); err != nil {
+		return err
+	}
+	return l.log.Close()
+}
+
+var _ raft.FSM = (*fsm)(nil)
+
+// fsm applies the committed raft entries to the log
+type fsm struct {
+	log *Log
+}
+
+type RequestType uint8
+
+const (
+	AppendRequestType RequestType = 0
+)
+
+func (f *fsm) Apply(record *raft.Log) interface{} {
+	buf := record.Data
+	if len(buf) == 0 {
+		return ErrInvalidRecord
+	}
+	reqType := RequestType(buf[0])
+	switch reqType {
+	case AppendRequestType:
+		return f.applyAppend(buf[1:])
+	}
+	return nil
+}
+
+func (f *fsm) applyAppend(b []byte) interface{} {
+	record, err := decodeRecord(b)
+	if err != nil {
+		return err
+	}
+	off, err := f.log.AppendRecord(record)
+	if err != nil {
+		return err
+	}
+	return off
+}
+
+// snapshotVersion is the first byte of the snapshots that keep the offsets
+// of their records. the ones before start with the lowest offset, whose
+// first byte is zero for any log that isn't absurdly long
+const snapshotVersion = 1
+
+// Snapshot is the lowest and next offsets of the log followed by every
+// record along with its offset, so a restored log has the same gaps as
+// this one. the segments are kept around until the snapshot's released
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	l := f.log
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	s := &snapshot{
+		log:    l,
+		lowest: l.segments[0].baseOffset,
+		next:   l.activeSegment.nextOffset,
+	}
+	for _, segment := range l.segments {
+		segment.acquire()
+		s.segments = append(s.segments, segment)
+	}
+	return s, nil
+}
+
+func (f *fsm) Restore(r io.ReadCloser) error {
+	br := bufio.NewReader(r)
+	version, err := br.Peek(1)
+	if err != nil {
+		return err
+	}
+	if version[0] != snapshotVersion {
+		return f.restoreStores(br)
+	}
+	header := make([]byte, 1+2*lenWidth)
+	if _, err = io.ReadFull(br, header); err != nil {
+		return err
+	}
+	lowest, next := enc.Uint64(header[1:]), enc.Uint64(header[1+lenWidth:])
+	if err = f.log.reset(lowest); err != nil {
+		return err
+	}
+	frame := make([]byte, 2*lenWidth)
+	for {
+		if _, err = io.ReadFull(br, frame); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		b := make([]byte, enc.Uint64(frame[lenWidth:]))
+		if _, err = io.ReadFull(br, b); err != nil {
+			return err
+		}
+		record, err := decodeRecord(b)
+		if err != nil {
+			return err
+		}
+		if err = f.log.appendAt(enc.Uint64(frame), record); err != nil {
+			return err
+		}
+	}
+	// the records after the last one may have been compacted away
+	return f.log.skipTo(next)
+}
+
+// restoreStores restores the snapshots that were the lowest offset
+// followed by the stores, their records are appended densely
+func (f *fsm) restoreStores(r io.Reader) error {
+	lowest := make([]byte, 8)
+	if _, err := io.ReadFull(r, lowest); err != nil {
+		return err
+	}
+	if err := f.log.reset(enc.Uint64(lowest)); err != nil {
+		return err
+	}
+	return readStores(r, f.log.Config, func(b []byte) error {
+		record, err := decodeRecord(b)
+		if err != nil {
+			return err
+		}
+		_, err = f.log.AppendRecord(record)
+		return err
+	})
+}
+
+var _ raft.FSMSnapshot = (*snapshot)(nil)
+
+// snapshot holds on to the segments the log had when it was taken,
+// the ones it hasn't written yet are released by Release
+type snapshot struct {
+	log      *Log
+	lowest   uint64
+	next     uint64
+	segments []*segment
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.persist(sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// persist writes the records of a segment at a time, each read under the
+// log's lock since compaction may punch holes into it in the meantime.
+// the records appended after the snapshot was taken are left out
+func (s *snapshot) persist(w io.Writer) error {
+	header := append([]byte{snapshotVersion}, enc.AppendUint64(nil, s.lowest)...)
+	if _, err := w.Write(enc.AppendUint64(header, s.next)); err != nil {
+		return err
+	}
+	for len(s.segments) > 0 {
+		segment := s.segments[0]
+		var buf []byte
+		s.log.mu.RLock()
+		err := segment.walk(0, func(off, pos uint64) (bool, error) {
+			if off >= s.next {
+				return true, nil
+			}
+			b, err := segment.store.Read(pos)
+			if err != nil {
+				return false, &OffsetError{off, err}
+			}
+			buf = enc.AppendUint64(buf, off)
+			buf = enc.AppendUint64(buf, uint64(len(b)))
+			buf = append(buf, b...)
+			return false, nil
+		})
+		s.log.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+		if err = segment.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snapshot) Release() {
+	for _, segment := range s.segments {
+		_ = segment.release()
+	}
+	s.segments = nil
+}
+
+var _ raft.LogStore = (*logStore)(nil)
+
+// logStore keeps raft's own log entries in a Log
+// raft indexes are the offsets of the log
+type logStore struct {
+	*Log
+}
+
+func newLogStore(dir string, c Config) (*logStore, error) {
+	log, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{log}, nil
+}
+
+func (l *logStore) FirstIndex() (uint64, error) {
+	return l.LowestOffset()
+}
+
+func (l *logStore) LastIndex() (uint64, error) {
+	return l.HighestOffset()
+}
+
+func (l *logStore) GetLog(index uint64, out *raft.Log) error {
+	record, err := l.ReadRecord(index)
+	if errors.Is(err, ErrOffsetOutOfRange) {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeRaftLog(index, record.Value, out)
+}
+
+func (l *logStore) StoreLog(record *raft.Log) error {
+	return l.StoreLogs([]*raft.Log{record})
+}
+
+func (l *logStore) StoreLogs(records []*raft.Log) error {
+	values := make([][]byte, len(records))
+	for i, record := range records {
+		values[i] = encodeRaftLog(record)
+	}
+	_, _, err := l.AppendBatch(values)
+	return err
+}
+
+// DeleteRange is called by raft to drop old entries once they're in a snapshot
+// and to drop entries that conflict with the leader's log
+func (l *logStore) DeleteRange(min, max uint64) error {
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return err
+	}
+	if min <= lowest {
+		return l.Truncate(max + 1)
+	}
+	return l.truncateFrom(min)
+}
+
+// raft entries are stored as term (8 bytes), type (1 byte),
+// appended at in unix nanoseconds (8 bytes) followed by the data
+const raftLogHeaderWidth = 8 + 1 + 8
+
+func encodeRaftLog(record *raft.Log) []byte {
+	b := make([]byte, raftLogHeaderWidth, raftLogHeaderWidth+len(record.Data))
+	enc.PutUint64(b[0:8], record.Term)
+	b[8] = byte(record.Type)
+	var appendedAt int64
+	if !record.AppendedAt.IsZero() {
+		appendedAt = record.AppendedAt.UnixNano()
+	}
+	enc.PutUint64(b[9:17], uint64(appendedAt))
+	return append(b, record.Data...)
+}
+
+func decodeRaftLog(index uint64, b []byte, out *raft.Log) error {
+	if len(b) < raftLogHeaderWidth {
+		return ErrInvalidRecord
+	}
+	out.Index = index
+	out.Term = enc.Uint64(b[0:8])
+	out.Type = raft.LogType(b[8])
+	if appendedAt := int64(enc.Uint64(b[9:17])); appendedAt != 0 {
+		out.AppendedAt = time.Unix(0, appendedAt)
+	}
+	out.Data = b[raftLogHeaderWidth:]
+	return nil
+}
+
+var _ raft.StreamLayer = (*StreamLayer)(nil)
+
+// StreamLayer is raft's transport, it shares the listener with the
+// grpc server, raft connections are told apart by their first byte
+// connections are encrypted when tls configs are given, the server config
+// for incoming connections, the peer config for outgoing ones
+type StreamLayer struct {
+	ln              net.Listener
+	serverTLSConfig *tls.Config
+	peerTLSConfig   *tls.Config
+}
+
+func NewStreamLayer(
+	ln net.Listener,
+	serverTLSConfig,
+	peerTLSConfig *tls.Config,
+) *StreamLayer {
+	return &StreamLayer{
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
+	}
+}
+
+const RaftRPC = 1
+
+func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial("tcp", string(addr))
+	if err != nil {
+		return nil, err
+	}
+	// identify to mux this is a raft rpc
+	if _, err = conn.Write([]byte{byte(RaftRPC)}); err != nil {
+		return nil, err
+	}
+	if s.peerTLSConfig != nil {
+		conn = tls.Client(conn, s.peerTLSConfig)
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1)
+	if _, err = conn.Read(b); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal([]byte{byte(RaftRPC)}, b) {
+		return nil, errors.New("log: not a raft rpc")
+	}
+	if s.serverTLSConfig != nil {
+		return tls.Server(conn, s.serverTLSConfig), nil
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *StreamLayer) Addr() net.Addr {
+	return s.ln.Addr()
+}
@@ -0,0 +1,294 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipleNodes(t *testing.T) {
+	var logs []*DistributedLog
+	nodeCount := 3
+
+	for i := 0; i < nodeCount; i++ {
+		dataDir, err := os.MkdirTemp("", "distributed-log-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dataDir)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		config := Config{}
+		config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+
+		if i == 0 {
+			config.Raft.Bootstrap = true
+		}
+
+		l, err := NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+		defer l.Close()
+
+		if i != 0 {
+			err = logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String())
+			require.NoError(t, err)
+		} else {
+			err = l.WaitForLeader(3 * time.Second)
+			require.NoError(t, err)
+		}
+
+		logs = append(logs, l)
+	}
+
+	records := []Record{
+		{Value: []byte("first")},
+		{Key: []byte("key"), Value: []byte("second")},
+	}
+	for _, record := range records {
+		off, err := logs[0].AppendRecord(record)
+		require.NoError(t, err)
+		want, err := logs[0].ReadRecord(off)
+		require.NoError(t, err)
+		require.False(t, want.Timestamp.IsZero())
+		require.Eventually(t, func() bool {
+			for j := 0; j < nodeCount; j++ {
+				got, err := logs[j].ReadRecord(off)
+				if err != nil {
+					return false
+				}
+				// every replica has the timestamp the leader stamped
+				if !reflect.DeepEqual(record.Key, got.Key) ||
+					!reflect.DeepEqual(record.Value, got.Value) ||
+					!want.Timestamp.Equal(got.Timestamp) {
+					return false
+				}
+			}
+			return true
+		}, 500*time.Millisecond, 50*time.Millisecond)
+	}
+
+	// followers know who the leader is, but can't append themselves
+	require.Equal(t, logs[0].config.Raft.StreamLayer.Addr().String(), logs[1].LeaderAddr())
+	_, err := logs[1].AppendRecord(Record{Value: []byte("follower")})
+	require.ErrorIs(t, err, ErrNotLeader)
+
+	servers, err := logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, nodeCount)
+	require.True(t, servers[0].IsLeader)
+	require.False(t, servers[1].IsLeader)
+	require.False(t, servers[2].IsLeader)
+
+	err = logs[0].Leave("1")
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	servers, err = logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, nodeCount-1)
+
+	off, err := logs[0].AppendRecord(Record{Value: []byte("third")})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = logs[1].ReadRecord(off)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	got, err := logs[2].ReadRecord(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), got.Value)
+}
+
+func TestLogStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_store_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.InitialOffset = 1
+	c.Segment.MaxStoreBytes = 64
+	s, err := newLogStore(dir, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var entries []*raft.Log
+	for i := uint64(1); i <= 6; i++ {
+		entries = append(entries, &raft.Log{
+			Index: i,
+			Term:  1,
+			Type:  raft.LogCommand,
+			Data:  []byte(fmt.Sprintf("entry %d", i)),
+		})
+	}
+	require.NoError(t, s.StoreLogs(entries))
+
+	first, err := s.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+	last, err := s.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), last)
+
+	var out raft.Log
+	require.NoError(t, s.GetLog(3, &out))
+	require.Equal(t, entries[2].Data, out.Data)
+	require.Equal(t, uint64(3), out.Index)
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(7, &out))
+
+	// conflicting entries are dropped from the tail
+	require.NoError(t, s.DeleteRange(5, 6))
+	last, err = s.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), last)
+	require.NoError(t, s.StoreLog(&raft.Log{Index: 5, Term: 2, Data: []byte("new")}))
+	require.NoError(t, s.GetLog(5, &out))
+	require.Equal(t, uint64(2), out.Term)
+
+	// snapshotted entries are dropped from the head
+	require.NoError(t, s.DeleteRange(1, 3))
+	first, err = s.FirstIndex()
+	require.NoError(t, err)
+	require.Greater(t, first, uint64(1))
+	require.NoError(t, s.GetLog(4, &out))
+}
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func TestFSMSnapshotRestore(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Segment.InitialOffset = 5
+
+	dir, err := os.MkdirTemp("", "fsm_snapshot_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		_, err = l.AppendRecord(Record{Key: []byte("key"), Value: write})
+		require.NoError(t, err)
+	}
+
+	snap, err := (&fsm{log: l}).Snapshot()
+	require.NoError(t, err)
+	sink := &bufferSink{}
+	require.NoError(t, snap.Persist(sink))
+
+	dir, err = os.MkdirTemp("", "fsm_restore_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	restored, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+	_, err = restored.Append([]byte("overwritten"))
+	require.NoError(t, err)
+
+	err = (&fsm{log: restored}).Restore(io.NopCloser(&sink.Buffer))
+	require.NoError(t, err)
+	lowest, err := restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), lowest)
+	highest, err := restored.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), highest)
+	for off := uint64(5); off <= 7; off++ {
+		got, err := restored.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte("key"), got.Key)
+		require.Equal(t, write, got.Value)
+	}
+}
+
+func TestFSMSnapshotRestoreKeepsGaps(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	l, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		_, err = l.AppendRecord(Record{Key: []byte{byte('a' + i%2)}, Value: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Compact())
+
+	snap, err := (&fsm{log: l}).Snapshot()
+	require.NoError(t, err)
+	// what's appended after the snapshot's taken isn't part of it
+	_, err = l.AppendRecord(Record{Value: []byte("later")})
+	require.NoError(t, err)
+	sink := &bufferSink{}
+	require.NoError(t, snap.Persist(sink))
+	snap.Release()
+
+	restored, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, (&fsm{log: restored}).Restore(io.NopCloser(&sink.Buffer)))
+
+	highest, err := restored.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), highest)
+	var compacted int
+	for off := uint64(0); off <= 9; off++ {
+		want, wantErr := l.ReadRecord(off)
+		got, err := restored.ReadRecord(off)
+		if wantErr != nil {
+			compacted++
+			require.Error(t, err, "offset %d", off)
+			continue
+		}
+		require.NoError(t, err, "offset %d", off)
+		require.Equal(t, want.Value, got.Value)
+	}
+	require.NotZero(t, compacted)
+	// the next record gets the offset it gets on the leader
+	off, err := restored.AppendRecord(Record{Value: []byte("later")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+func TestFSMSnapshotRelease(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	l, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 5; i++ {
+		_, err = l.AppendRecord(Record{Value: write})
+		require.NoError(t, err)
+	}
+	snap, err := (&fsm{log: l}).Snapshot()
+	require.NoError(t, err)
+
+	// the truncated segments stay around until the snapshot's released
+	l.mu.RLock()
+	first := l.segments[0]
+	l.mu.RUnlock()
+	require.NoError(t, l.Truncate(3))
+	_, err = os.Stat(first.store.Name())
+	require.NoError(t, err)
+	snap.Release()
+	_, err = os.Stat(first.store.Name())
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,175 @@
+package log
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrUnknownKey is returned when a key provider doesn't have the requested key
+	ErrUnknownKey = errors.New("log: unknown encryption key")
+	// ErrNoKeyProvider is returned when reading an encrypted record
+	// from a store configured without a key provider
+	ErrNoKeyProvider = errors.New("log: no key provider for encrypted record")
+)
+
+// KeyProvider gives out the AES keys records are encrypted with
+// key ids are stored in the header of every record, so keys can be rotated
+// by changing the current key while keeping the old ones around for reads
+// key id 0 is reserved for records that aren't encrypted, and the ids take
+// 31 bits, the top one of the header's field is the format's.
+// every record gets a random 96 bit nonce, so a key shouldn't encrypt more
+// than 2^32 records, past that the odds of a nonce coming up twice, which
+// gives the key away, are more than the 2^-32 GCM allows for. the log
+// doesn't count them, rotate the current key well before that.
+// EnvelopeKeyProvider has a key management service unwrap the data keys
+type KeyProvider interface {
+	// CurrentKey returns the key new records are encrypted with
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key with the given id
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider serves keys from memory
+type StaticKeyProvider struct {
+	Keys    map[uint32][]byte
+	Current uint32
+}
+
+func (p *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok || id == 0 {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// EnvKeyProvider reads base64 encoded keys from environment variables
+// named Prefix followed by the key id, e.g. VSDLOG_KEY_1
+type EnvKeyProvider struct {
+	Prefix  string
+	Current uint32
+}
+
+func (p *EnvKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+func (p *EnvKeyProvider) Key(id uint32) ([]byte, error) {
+	v, ok := os.LookupEnv(fmt.Sprintf("%s%d", p.Prefix, id))
+	if !ok || id == 0 {
+		return nil, ErrUnknownKey
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// EnvelopeKeyProvider serves data keys kept wrapped by a key management
+// service, only Unwrap needs the service, e.g. a decrypt call to AWS KMS,
+// GCP KMS or Vault's transit engine. keys are unwrapped the first time
+// they're needed and kept in memory from then on
+type EnvelopeKeyProvider struct {
+	// Wrapped are the data keys by id, as the service wrapped them
+	Wrapped map[uint32][]byte
+	Current uint32
+	Unwrap  func(wrapped []byte) ([]byte, error)
+
+	mu   sync.Mutex
+	keys map[uint32][]byte
+}
+
+func (p *EnvelopeKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+func (p *EnvelopeKeyProvider) Key(id uint32) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	wrapped, ok := p.Wrapped[id]
+	if !ok || id == 0 {
+		return nil, ErrUnknownKey
+	}
+	key, err := p.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping key %d: %w", id, err)
+	}
+	if p.keys == nil {
+		p.keys = make(map[uint32][]byte)
+	}
+	p.keys[id] = key
+	return key, nil
+}
+
+// boundKeyBit is set in the key id field of the frames whose contents are
+// sealed with frameAAD, the ones written before that have the id alone
+const boundKeyBit uint32 = 1 << 31
+
+// frameAAD is what the contents of a frame are sealed with besides the key,
+// its codec, key id field and position in the store, so an encrypted frame
+// can't be moved elsewhere in the store or have its header swapped
+func frameAAD(codec byte, keyID uint32, pos uint64) []byte {
+	aad := make([]byte, 0, codecWidth+keyIDWidth+lenWidth)
+	aad = append(aad, codec)
+	aad = enc.AppendUint32(aad, keyID)
+	return enc.AppendUint64(aad, pos)
+}
+
+// currentKey is the provider's current key, with its id
+// as it goes in the header of the frames sealed with it
+func currentKey(keys KeyProvider) (uint32, []byte, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id&boundKeyBit != 0 {
+		return 0, nil, fmt.Errorf("%w: %d takes more than 31 bits", ErrUnknownKey, id)
+	}
+	return id | boundKeyBit, key, nil
+}
+
+// encrypt seals b with AES-GCM, the random nonce is prepended to the result
+func encrypt(key, b, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(b)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, b, aad), nil
+}
+
+func decrypt(key, b, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, ErrCorruptRecord
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// ErrStaleEpoch is returned for a leader epoch older than the log's latest,
+// i.e. for the records or the requests of a leader that was replaced
+var ErrStaleEpoch = errors.New("log: stale leader epoch")
+
+// the epochs are kept next to the segments, they're only written
+// when a new epoch starts
+const epochsFile = "epochs.json"
+
+// epochStart is the offset the records of a leader epoch start at
+type epochStart struct {
+	Epoch  uint64 `json:"epoch"`
+	Offset uint64 `json:"offset"`
+}
+
+// Epoch is the latest leader epoch of the log, zero if it never had one
+func (l *Log) Epoch() uint64 {
+	return l.epoch.Load()
+}
+
+// SetEpoch starts a new leader epoch, the records appended from now on are
+// stamped with it. it's called by whatever elects the leader, with an epoch
+// higher than every one before it. an older epoch fails with ErrStaleEpoch
+func (l *Log) SetEpoch(epoch uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latest := l.epoch.Load()
+	if epoch < latest {
+		return fmt.Errorf("%w: %d, the log's at %d", ErrStaleEpoch, epoch, latest)
+	}
+	if epoch == latest {
+		return nil
+	}
+	return l.startEpoch(epoch, l.activeSegment.nextOffset)
+}
+
+// startEpoch records that epoch starts at off, under the log's lock
+func (l *Log) startEpoch(epoch, off uint64) error {
+	epochs := append(l.epochs, epochStart{Epoch: epoch, Offset: off})
+	b, err := json.Marshal(epochs)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(path.Join(l.Dir, epochsFile), b); err != nil {
+		return err
+	}
+	l.epochs = epochs
+	l.epoch.Store(epoch)
+	return nil
+}
+
+// EndOffsetForEpoch returns the latest epoch of the log up to the given one
+// and the offset its records end at, i.e. the one the next epoch starts at,
+// or the next offset of the log for the latest epoch. what the follower
+// has past it in that epoch diverged from the log and has to be truncated.
+// an epoch later than the log's fails with ErrStaleEpoch, the log is behind
+func (l *Log) EndOffsetForEpoch(epoch uint64) (uint64, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if latest := l.epoch.Load(); epoch > latest {
+		return 0, 0, fmt.Errorf("%w: the log's at %d, not %d", ErrStaleEpoch, latest, epoch)
+	}
+	end := l.activeSegment.nextOffset
+	for i := len(l.epochs) - 1; i >= 0; i-- {
+		if l.epochs[i].Epoch <= epoch {
+			return l.epochs[i].Epoch, end, nil
+		}
+		end = l.epochs[i].Offset
+	}
+	// the records before the first epoch have none
+	return 0, end, nil
+}
+
+// fenceEpoch refuses a record of a replaced leader and starts the epoch of
+// a new one, for the records followers append. called with the log's lock
+func (l *Log) fenceEpoch(off uint64, record Record) error {
+	latest := l.epoch.Load()
+	switch {
+	case record.Epoch < latest:
+		return fmt.Errorf("%w: %d at %d, the log's at %d", ErrStaleEpoch, record.Epoch, off, latest)
+	case record.Epoch > latest:
+		return l.startEpoch(record.Epoch, off)
+	}
+	return nil
+}
+
+// loadEpochs reads the epochs back, the ones starting past the records
+// the log has left are dropped. called when the segments are set up
+func (l *Log) loadEpochs() error {
+	l.epochs = nil
+	l.epoch.Store(0)
+	b, err := os.ReadFile(path.Join(l.Dir, epochsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var epochs []epochStart
+	if err = json.Unmarshal(b, &epochs); err != nil {
+		return err
+	}
+	// an epoch can start at the next offset, before it has any records
+	return l.trimEpochs(epochs, l.activeSegment.nextOffset+1)
+}
+
+// trimEpochs keeps the epochs that start before end
+// and saves them if some were dropped
+func (l *Log) trimEpochs(epochs []epochStart, end uint64) error {
+	kept := epochs
+	for len(kept) > 0 && kept[len(kept)-1].Offset >= end {
+		kept = kept[:len(kept)-1]
+	}
+	if len(kept) < len(epochs) {
+		b, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		if err = writeFileAtomic(path.Join(l.Dir, epochsFile), b); err != nil {
+			return err
+		}
+	}
+	l.epochs = kept
+	l.epoch.Store(0)
+	if len(kept) > 0 {
+		l.epoch.Store(kept[len(kept)-1].Epoch)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEpochs(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	// the records before the first epoch have none
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, log.SetEpoch(1))
+	for i := 0; i < 2; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.SetEpoch(3))
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	require.ErrorIs(t, log.SetEpoch(2), ErrStaleEpoch)
+
+	record, err := log.ReadRecord(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Epoch)
+	record, err = log.ReadRecord(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), record.Epoch)
+
+	for _, test := range []struct {
+		epoch, want, end uint64
+	}{
+		{0, 0, 1},
+		{1, 1, 3},
+		// an epoch the log never had ends where the next one starts
+		{2, 1, 3},
+		{3, 3, 4},
+	} {
+		epoch, end, err := log.EndOffsetForEpoch(test.epoch)
+		require.NoError(t, err)
+		require.Equal(t, test.want, epoch, test.epoch)
+		require.Equal(t, test.end, end, test.epoch)
+	}
+	_, _, err = log.EndOffsetForEpoch(4)
+	require.ErrorIs(t, err, ErrStaleEpoch)
+
+	// the epochs are kept across restarts
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, uint64(3), log.Epoch())
+
+	// followers refuse the records of a replaced leader
+	require.ErrorIs(t, log.appendAt(4, Record{Value: write, Epoch: 2}), ErrStaleEpoch)
+	require.NoError(t, log.appendAt(4, Record{Value: write, Epoch: 5}))
+	require.Equal(t, uint64(5), log.Epoch())
+
+	// and drop the epochs of the records they truncate
+	require.NoError(t, log.truncateFrom(3))
+	require.Equal(t, uint64(1), log.Epoch())
+	_, end, err := log.EndOffsetForEpoch(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), end)
+}
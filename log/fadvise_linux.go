@@ -0,0 +1,19 @@
+package log
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential tells the kernel the file is read front to back,
+// so it reads further ahead of the reads
+func adviseSequential(f *os.File) error {
+	return unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+}
+
+// dropCache drops the pages of the n bytes at off from the page cache,
+// up to the end of the file if n is zero. dirty pages are kept
+func dropCache(f *os.File, off, n int64) error {
+	return unix.Fadvise(int(f.Fd()), off, n, unix.FADV_DONTNEED)
+}
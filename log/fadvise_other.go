@@ -0,0 +1,14 @@
+//go:build !linux
+
+package log
+
+import "os"
+
+// there's no posix_fadvise on darwin, and the hints are linux only elsewhere
+func adviseSequential(f *os.File) error {
+	return nil
+}
+
+func dropCache(f *os.File, off, n int64) error {
+	return nil
+}
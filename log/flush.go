@@ -0,0 +1,42 @@
+package log
+
+import (
+	"errors"
+	"time"
+)
+
+// flushLoop writes out the active store's buffer every Flush.Interval
+func (l *Log) flushLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Flush.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			// a failed flush is retried on the next tick, the appends
+			// run into the same error meanwhile
+			if err := l.flushActive(); err != nil {
+				l.Config.logger().Error("background flush failed", "err", err)
+			}
+		}
+	}
+}
+
+// flushActive flushes the active segment, and fsyncs it with Flush.Sync.
+// it's done outside the log's lock, the segment is held on to so a
+// rotation doesn't close it underneath
+func (l *Log) flushActive() error {
+	l.mu.RLock()
+	s := l.activeSegment
+	s.acquire()
+	l.mu.RUnlock()
+
+	err := s.store.Flush()
+	if err == nil && l.Config.Flush.Sync {
+		err = s.commit()
+	}
+	return errors.Join(err, s.release())
+}
@@ -0,0 +1,30 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackgroundFlush(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Flush.Interval = 10 * time.Millisecond
+	c.Flush.Sync = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(write)
+	require.NoError(t, err)
+
+	// the record reaches the file without anything reading it
+	s := log.activeSegment
+	require.Eventually(t, func() bool {
+		fi, err := os.Stat(s.store.Name())
+		require.NoError(t, err)
+		return uint64(fi.Size()) == s.store.flushed.Load() && fi.Size() > int64(s.store.start)
+	}, time.Second, 10*time.Millisecond)
+}
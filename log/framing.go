@@ -0,0 +1,164 @@
+package log
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Framing is how the length of every record is written in a store
+// it's picked when the store is created, so stores with
+// different framings can live in the same log
+type Framing byte
+
+const (
+	// FramingFixed writes the length as 8 bytes, stores framed this way
+	// have no version byte, so the stores from before it existed read the same.
+	// their first byte is the top byte of a length, which is always zero
+	FramingFixed Framing = iota
+	// FramingVarint writes the length as a uvarint, a byte or two for
+	// small records, the store starts with a version byte to tell it apart
+	FramingVarint
+)
+
+// ErrUnknownFraming is returned for a store version this package doesn't know about
+var ErrUnknownFraming = errors.New("log: unknown store framing")
+
+// writeVersion starts a new store and returns the size of its version byte
+func writeVersion(f io.Writer, framing Framing) (uint64, error) {
+	switch framing {
+	case FramingFixed:
+		return 0, nil
+	case FramingVarint:
+		_, err := f.Write([]byte{byte(framing)})
+		return 1, err
+	}
+	return 0, ErrUnknownFraming
+}
+
+// readVersion returns the framing of an existing store
+// and the size of its version byte
+func readVersion(f io.ReaderAt) (Framing, uint64, error) {
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return 0, 0, err
+	}
+	return parseVersion(b[0])
+}
+
+func parseVersion(b byte) (Framing, uint64, error) {
+	switch Framing(b) {
+	case FramingFixed:
+		return FramingFixed, 0, nil
+	case FramingVarint:
+		return FramingVarint, 1, nil
+	}
+	return 0, 0, ErrUnknownFraming
+}
+
+func appendLength(b []byte, framing Framing, n uint64) []byte {
+	if framing == FramingVarint {
+		return binary.AppendUvarint(b, n)
+	}
+	return enc.AppendUint64(b, n)
+}
+
+// frameSize is how many bytes a record of n bytes takes in the store
+func (s *store) frameSize(n int) uint64 {
+	return uint64(len(appendLength(nil, s.framing, uint64(n))) + metaWidth + n)
+}
+
+// readStores reads the records back from the stores written by Log.Reader
+// it's used to read records outside of their files, e.g. from snapshots and backups
+func readStores(r io.Reader, c Config, fn func(record []byte) error) error {
+	size := make([]byte, lenWidth)
+	for {
+		if _, err := io.ReadFull(r, size); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		store := bufio.NewReader(io.LimitReader(r, int64(enc.Uint64(size))))
+		if err := readStore(store, c, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func readStore(r *bufio.Reader, c Config, fn func(record []byte) error) error {
+	version, err := r.Peek(1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	framing, skip, err := parseVersion(version[0])
+	if err != nil {
+		return err
+	}
+	if _, err = r.Discard(int(skip)); err != nil {
+		return err
+	}
+
+	// the dictionary of the store comes first if it has one. pos is where
+	// the frame is in its store, the encrypted ones are sealed with it
+	var d *zstdDict
+	pos := skip
+	for {
+		var n uint64
+		if framing == FramingVarint {
+			n, err = binary.ReadUvarint(r)
+		} else {
+			length := make([]byte, lenWidth)
+			_, err = io.ReadFull(r, length)
+			n = enc.Uint64(length)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		meta := make([]byte, metaWidth)
+		if _, err = io.ReadFull(r, meta); err != nil {
+			return err
+		}
+		contents := make([]byte, n)
+		if _, err = io.ReadFull(r, contents); err != nil {
+			return err
+		}
+		at := pos
+		pos += uint64(len(appendLength(nil, framing, n))) + metaWidth + n
+		switch meta[codecPos] {
+		case dictCodec:
+			raw, err := openFrame(meta, contents, at, !c.Store.SkipChecksumVerify, c.Store.KeyProvider)
+			if err != nil {
+				return err
+			}
+			if d, err = newZstdDict(raw); err != nil {
+				return err
+			}
+			continue
+		case posCodec:
+			if len(contents) != lenWidth || crc32.Checksum(contents, crcTable) != enc.Uint32(meta[crcPos:codecPos]) {
+				return ErrCorruptRecord
+			}
+			pos = enc.Uint64(contents)
+			continue
+		}
+		record, err := unframe(meta, contents, at, !c.Store.SkipChecksumVerify, c.Store.KeyProvider, d)
+		if errors.Is(err, errHole) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+}
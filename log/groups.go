@@ -0,0 +1,100 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrNoCommittedOffset is returned for a consumer group that hasn't committed an offset
+var ErrNoCommittedOffset = errors.New("log: no committed offset")
+
+// the offsets of consumer groups are kept next to the segments
+const groupsFile = "groups.json"
+
+// consumerGroups keeps the offsets committed by consumer groups
+// in a file that's atomically replaced on every commit
+type consumerGroups struct {
+	mu      sync.Mutex
+	path    string
+	offsets map[string]uint64
+}
+
+func newConsumerGroups(path string) (*consumerGroups, error) {
+	g := &consumerGroups{
+		path:    path,
+		offsets: make(map[string]uint64),
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &g.offsets); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *consumerGroups) commit(group string, offset uint64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.offsets[group] = offset
+	return g.persist()
+}
+
+func (g *consumerGroups) fetch(group string) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	offset, ok := g.offsets[group]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNoCommittedOffset, group)
+	}
+	return offset, nil
+}
+
+// restore writes the offsets back after the log's directory was wiped
+func (g *consumerGroups) restore() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.offsets) == 0 {
+		return nil
+	}
+	return g.persist()
+}
+
+// persist is called with the lock held
+func (g *consumerGroups) persist() error {
+	b, err := json.Marshal(g.offsets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.path, b)
+}
+
+// Commit records the offset the consumer group resumes from
+// i.e. the one after the last record it has processed
+func (l *Log) Commit(group string, offset uint64) error {
+	if l.Config.readOnly {
+		return ErrReadOnly
+	}
+	l.mu.RLock()
+	next := l.activeSegment.nextOffset
+	l.mu.RUnlock()
+	if offset > next {
+		return fmt.Errorf("%w: %d", ErrOffsetOutOfRange, offset)
+	}
+	return l.groups.commit(group, offset)
+}
+
+// Fetch returns the offset the consumer group last committed
+func (l *Log) Fetch(group string) (uint64, error) {
+	return l.groups.fetch(group)
+}
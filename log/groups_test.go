@@ -0,0 +1,48 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerGroups(t *testing.T) {
+	dir, err := os.MkdirTemp("", "groups_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+
+	_, err = log.Fetch("billing")
+	require.ErrorIs(t, err, ErrNoCommittedOffset)
+
+	require.NoError(t, log.Commit("billing", 2))
+	require.NoError(t, log.Commit("audit", 3))
+	// can't commit past the head of the log
+	require.ErrorIs(t, log.Commit("audit", 4), ErrOffsetOutOfRange)
+	require.NoError(t, log.Close())
+
+	// consumers resume where they left off after a restart
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	off, err := log.Fetch("billing")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	off, err = log.Fetch("audit")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	// the offsets survive the log being reset, e.g. by a raft snapshot
+	require.NoError(t, log.reset(0))
+	off, err = log.Fetch("billing")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	require.FileExists(t, dir+"/"+groupsFile)
+}
@@ -0,0 +1,42 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNoLeader is returned by the health check of a replicated
+// log on a node that doesn't know of a leader
+var ErrNoLeader = errors.New("log: no known leader")
+
+// Healthy checks that the log can still take appends: a file can be
+// created in its directory and the active segment's buffer flushes
+func (l *Log) Healthy() error {
+	f, err := os.CreateTemp(l.Dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("log: directory isn't writable: %w", err)
+	}
+	if err = errors.Join(f.Close(), os.Remove(f.Name())); err != nil {
+		return fmt.Errorf("log: directory isn't writable: %w", err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if err = l.activeSegment.store.Flush(); err != nil {
+		return fmt.Errorf("log: flush failed: %w", err)
+	}
+	return nil
+}
+
+// Healthy is the local log's health, and the node has to know
+// the leader to forward produce requests to
+func (l *DistributedLog) Healthy() error {
+	if err := l.log.Healthy(); err != nil {
+		return err
+	}
+	if l.LeaderAddr() == "" {
+		return ErrNoLeader
+	}
+	return nil
+}
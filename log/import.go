@@ -0,0 +1,128 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// an import appends this many records at a time at most
+	importBatchRecords = 512
+	// and stops filling a batch once its keys and values are this big
+	importBatchBytes = 1 << 20
+)
+
+// JSONRecord is a record of an import in json lines, the way vsdlogctl
+// exports them. the offset is left out of the import, the records go at
+// the end of the log, and a keyed record without a value is a tombstone
+type JSONRecord struct {
+	Offset    uint64            `json:"offset"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+	EventTime *time.Time        `json:"event_time,omitempty"`
+	Key       *string           `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     *string           `json:"value"`
+}
+
+func (r JSONRecord) record() Record {
+	record := Record{Headers: r.Headers}
+	if r.Timestamp != nil {
+		record.Timestamp = *r.Timestamp
+	}
+	if r.EventTime != nil {
+		record.EventTime = *r.EventTime
+	}
+	if r.Key != nil {
+		record.Key = []byte(*r.Key)
+	}
+	if r.Value != nil {
+		record.Value = []byte(*r.Value)
+	}
+	return record
+}
+
+// ImportFrom appends the records read from r in batches, like AppendBatch,
+// and returns how many it appended. r is either json lines of JSONRecords
+// or what Log.Reader or Log.CopyTo wrote, e.g. a dump of another log, whose
+// stores are read with the log's config. the records keep their keys,
+// headers and timestamps but not their offsets or epochs, they go at the
+// end of the log. the ones before a record that fails are kept
+func (l *Log) ImportFrom(r io.Reader) (n int, err error) {
+	br := bufio.NewReader(r)
+	var batch []Record
+	var size int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() { batch, size = batch[:0], 0 }()
+		if _, _, err := l.appendBatch(batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		return nil
+	}
+	add := func(record Record) error {
+		batch = append(batch, record)
+		size += len(record.Key) + len(record.Value)
+		if len(batch) < importBatchRecords && size < importBatchBytes {
+			return nil
+		}
+		return flush()
+	}
+
+	// the length of a store never starts with a brace
+	if first, _ := peekNonSpace(br); first == '{' {
+		err = importJSON(br, add)
+	} else {
+		err = readStores(br, l.Config, func(b []byte) error {
+			record, err := decodeRecord(b)
+			if err != nil {
+				return err
+			}
+			return add(record)
+		})
+	}
+	// what was read before it failed goes in too
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return n, fmt.Errorf("import failed after %d records: %w", n, err)
+	}
+	return n, nil
+}
+
+// peekNonSpace returns the first byte of r that isn't white space,
+// without reading past it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		r.Discard(1)
+	}
+}
+
+func importJSON(r io.Reader, add func(Record) error) error {
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record JSONRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: record %d: %v", ErrInvalidRecord, line, err)
+		}
+		if err := add(record.record()); err != nil {
+			return err
+		}
+	}
+}
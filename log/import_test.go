@@ -0,0 +1,82 @@
+package log
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportFrom(t *testing.T) {
+	c := Config{KeyIndex: true}
+	c.Segment.MaxStoreBytes = 256
+	src, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer src.Close()
+	at := time.Unix(1700000000, 0)
+	want := []Record{
+		{Value: []byte("first"), Timestamp: at},
+		{Key: []byte("user"), Value: []byte("second"), Headers: map[string]string{"source": "test"}, Timestamp: at},
+		{Key: []byte("other"), Timestamp: at},
+	}
+	for i := 0; i < 300; i++ {
+		want = append(want, Record{Value: write, Timestamp: at.Add(time.Duration(i) * time.Second)})
+	}
+	for _, record := range want {
+		_, err := src.AppendRecord(record)
+		require.NoError(t, err)
+	}
+
+	dst, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer dst.Close()
+	_, err = dst.Append([]byte("already there"))
+	require.NoError(t, err)
+
+	// a dump of the stores of another log
+	n, err := dst.ImportFrom(src.Reader())
+	require.NoError(t, err)
+	require.Equal(t, len(want), n)
+	for i, record := range want {
+		got, err := dst.ReadRecord(uint64(i + 1))
+		require.NoError(t, err)
+		require.Equal(t, record.Key, got.Key)
+		require.Equal(t, record.Value, got.Value)
+		require.Equal(t, record.Headers, got.Headers)
+		require.True(t, record.Timestamp.Equal(got.Timestamp))
+	}
+	record, err := dst.Get([]byte("user"))
+	require.NoError(t, err)
+	require.Equal(t, "second", string(record.Value))
+
+	// json lines, with a tombstone
+	next := uint64(len(want) + 1)
+	n, err = dst.ImportFrom(strings.NewReader(`
+{"offset":7,"timestamp":"2024-01-02T03:04:05Z","key":"user","value":"third","headers":{"a":"b"}}
+{"key":"other","value":null}
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	record, err = dst.Get([]byte("user"))
+	require.NoError(t, err)
+	require.Equal(t, "third", string(record.Value))
+	require.True(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Equal(record.Timestamp))
+	require.Equal(t, map[string]string{"a": "b"}, record.Headers)
+	record, err = dst.ReadRecord(next + 1)
+	require.NoError(t, err)
+	require.True(t, record.IsTombstone())
+	_, err = dst.Get([]byte("other"))
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	// the records before a bad one are kept
+	n, err = dst.ImportFrom(strings.NewReader("{\"value\":\"kept\"}\nnot json\n"))
+	require.ErrorIs(t, err, ErrInvalidRecord)
+	require.Equal(t, 1, n)
+	record, err = dst.ReadRecord(next + 2)
+	require.NoError(t, err)
+	require.Equal(t, "kept", string(record.Value))
+	n, err = dst.ImportFrom(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
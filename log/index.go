@@ -0,0 +1,162 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tysonmote/gommap"
+)
+
+var (
+	offWidth uint64 = 4
+	posWidth uint64 = 8
+	entWidth        = offWidth + posWidth
+)
+
+// holeBit flags the entries of the records punched out of the store, see
+// punchSegment. the positions of a store never get near the top bit
+const holeBit uint64 = 1 << 63
+
+type index struct {
+	file *os.File
+	mmap gommap.MMap
+	size uint64
+	// readOnly indexes are read into memory instead of being mapped
+	readOnly bool
+}
+
+func newIndex(f *os.File, c Config) (*index, error) {
+	idx := &index{
+		file: f,
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	idx.size = uint64(fi.Size())
+	if c.readOnly {
+		idx.readOnly = true
+		idx.mmap = make(gommap.MMap, idx.size)
+		if _, err = io.ReadFull(f, idx.mmap); err != nil {
+			return nil, err
+		}
+		return idx, nil
+	}
+
+	// grow the file to its max size up front, since a memory mapped file
+	// can't be resized once mapped, it's truncated back to its true size on close
+	// an index bigger than the configured max is kept as is, so no entries are lost
+	if c.Segment.MaxIndexBytes > idx.size {
+		err = os.Truncate(f.Name(), int64(c.Segment.MaxIndexBytes))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Sync commits the memory mapped entries to disk
+func (i *index) Sync() error {
+	if i.readOnly {
+		return nil
+	}
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
+func (i *index) Close() error {
+	if i.readOnly {
+		return i.file.Close()
+	}
+	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+		return err
+	}
+	if err := i.file.Sync(); err != nil {
+		return err
+	}
+	if err := i.file.Truncate(int64(i.size)); err != nil {
+		return err
+	}
+	return i.file.Close()
+}
+
+// Read takes the offset relative to the segment's base offset
+// and returns the position of the record in the store
+// -1 reads the last entry in the index
+func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
+	out, pos, _, err = i.entry(in)
+	return out, pos, err
+}
+
+// entry is Read, hole tells that the entry is that of a hole punched out
+// of the store, which takes up the offsets up to the next entry
+func (i *index) entry(in int64) (out uint32, pos uint64, hole bool, err error) {
+	if i.size == 0 {
+		return 0, 0, false, io.EOF
+	}
+	if in == -1 {
+		out = uint32((i.size / entWidth) - 1)
+	} else {
+		out = uint32(in)
+	}
+	p := uint64(out) * entWidth
+	if i.size < p+entWidth {
+		return 0, 0, false, io.EOF
+	}
+	out = enc.Uint32(i.mmap[p : p+offWidth])
+	pos = enc.Uint64(i.mmap[p+offWidth : p+entWidth])
+	return out, pos &^ holeBit, pos&holeBit != 0, nil
+}
+
+// Floor finds the slot of the last entry with a relative offset up to off
+// entries are dense unless the segment was compacted or sparsely indexed,
+// so the entry at the offset's own slot is tried first before a binary search
+func (i *index) Floor(off uint32) (slot uint64, ok bool) {
+	n := i.size / entWidth
+	if uint64(off) < n {
+		if out, _, _ := i.Read(int64(off)); out == off {
+			return uint64(off), true
+		}
+	}
+	above := sort.Search(int(n), func(j int) bool {
+		out, _, _ := i.Read(int64(j))
+		return out > off
+	})
+	if above == 0 {
+		return 0, false
+	}
+	return uint64(above - 1), true
+}
+
+// Truncate drops the entries from the given slot on
+func (i *index) Truncate(slot uint64) {
+	if slot*entWidth < i.size {
+		i.size = slot * entWidth
+	}
+}
+
+// Write appends the given offset and position to the index
+// offset is relative to the segment's base offset, hence uint32 is enough
+func (i *index) Write(off uint32, pos uint64) error {
+	if i.isFull() {
+		return io.EOF
+	}
+	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
+	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
+	i.size += entWidth
+	return nil
+}
+
+// isFull tells whether there's no space left for another entry
+func (i *index) isFull() bool {
+	return uint64(len(i.mmap)) < i.size+entWidth
+}
+
+func (i *index) Name() string {
+	return i.file.Name()
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	f, err := os.CreateTemp("", "index_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+	_, _, err = idx.Read(-1)
+	require.Error(t, err)
+	require.Equal(t, f.Name(), idx.Name())
+
+	entries := []struct {
+		Off uint32
+		Pos uint64
+	}{
+		{Off: 0, Pos: 0},
+		{Off: 1, Pos: 10},
+	}
+
+	for _, want := range entries {
+		err = idx.Write(want.Off, want.Pos)
+		require.NoError(t, err)
+
+		_, pos, err := idx.Read(int64(want.Off))
+		require.NoError(t, err)
+		require.Equal(t, want.Pos, pos)
+	}
+
+	// reading past existing entries
+	_, _, err = idx.Read(int64(len(entries)))
+	require.Equal(t, io.EOF, err)
+	_ = idx.Close()
+
+	// index should build its state from the existing file
+	f, _ = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	idx, err = newIndex(f, c)
+	require.NoError(t, err)
+	off, pos, err := idx.Read(-1)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), off)
+	require.Equal(t, entries[1].Pos, pos)
+	require.NoError(t, idx.Close())
+
+	// file is truncated back to the entries it holds on close
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(entries))*int64(entWidth), fi.Size())
+
+	// a smaller max doesn't cut off existing entries
+	c.Segment.MaxIndexBytes = entWidth
+	f, _ = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	idx, err = newIndex(f, c)
+	require.NoError(t, err)
+	_, pos, err = idx.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, entries[1].Pos, pos)
+	require.Equal(t, io.EOF, idx.Write(2, 20))
+	require.NoError(t, idx.Close())
+}
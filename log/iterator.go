@@ -0,0 +1,128 @@
+package log
+
+import (
+	"io"
+)
+
+// iteratorReadAhead is how many records an iterator reads in one go
+const iteratorReadAhead = 64
+
+// Iterator walks the records of a log in offset order, skipping the
+// offsets compaction left out. it reads a few records ahead at a time,
+// so it takes the log's lock once for all of them instead of once per record
+type Iterator struct {
+	log *Log
+	// read stands in for the log in the iterators of NewIterator
+	read    func(off uint64) ([]RangeRecord, error)
+	next    uint64
+	off     uint64
+	pending []iteratorRecord
+	// cursor is for the hints of Store.ReadAdvice
+	cursor readCursor
+}
+
+type iteratorRecord struct {
+	off    uint64
+	record Record
+}
+
+// Iterator returns an iterator over the records from startOffset on.
+// like Scan it only covers the local segments
+func (l *Log) Iterator(startOffset uint64) *Iterator {
+	return &Iterator{log: l, next: startOffset}
+}
+
+// NewIterator returns an iterator over the records from startOffset on that
+// read hands out, for logs other than Log, e.g. memlog's. read returns a few
+// of the records at or after off in offset order, and none once it caught up
+func NewIterator(startOffset uint64, read func(off uint64) ([]RangeRecord, error)) *Iterator {
+	return &Iterator{read: read, next: startOffset}
+}
+
+// Next returns the next record, or io.EOF once the iterator
+// caught up with the log. it can be called again after more appends
+func (it *Iterator) Next() (Record, error) {
+	if len(it.pending) == 0 {
+		if err := it.fill(); err != nil {
+			return Record{}, err
+		}
+		if len(it.pending) == 0 {
+			return Record{}, io.EOF
+		}
+	}
+	r := it.pending[0]
+	it.pending = it.pending[1:]
+	it.off = r.off
+	return r.record, nil
+}
+
+// Offset is the offset of the record last returned by Next
+func (it *Iterator) Offset() uint64 {
+	return it.off
+}
+
+// Seek moves the iterator so that Next returns the first record at
+// or after offset, records read ahead are thrown away
+func (it *Iterator) Seek(offset uint64) {
+	it.next = offset
+	it.pending = it.pending[:0]
+}
+
+// fill reads ahead from the first segment with records at or after it.next
+func (it *Iterator) fill() error {
+	if it.read != nil {
+		records, err := it.read(it.next)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			it.pending = append(it.pending, iteratorRecord{off: r.Offset, record: r.Record})
+		}
+		if n := len(records); n > 0 {
+			it.next = records[n-1].Offset + 1
+		}
+		return nil
+	}
+	l := it.log
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, s := range l.segments {
+		if s.nextOffset <= it.next {
+			continue
+		}
+		off := max(it.next, s.baseOffset)
+		var slot, last uint64
+		if off > s.baseOffset {
+			slot, _ = s.index.Floor(uint32(off - s.baseOffset))
+		}
+		err := s.walk(slot, func(o, p uint64) (bool, error) {
+			if o < off {
+				return false, nil
+			}
+			last = p
+			b, err := s.store.Read(p)
+			if err != nil {
+				return false, &OffsetError{o, err}
+			}
+			record, err := decodeRecord(b)
+			if err != nil {
+				return false, &OffsetError{o, err}
+			}
+			it.pending = append(it.pending, iteratorRecord{off: o, record: record})
+			return len(it.pending) == iteratorReadAhead, nil
+		})
+		if err != nil {
+			it.pending = it.pending[:0]
+			return err
+		}
+		if len(it.pending) > 0 {
+			it.next = it.pending[len(it.pending)-1].off + 1
+			l.advise(&it.cursor, s, last)
+			return nil
+		}
+		// nothing was left in the segment
+		it.next = s.nextOffset
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "iterator_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// more records than are read ahead, over several segments,
+	// with a gap like compaction leaves
+	n := uint64(iteratorReadAhead + 10)
+	for off := uint64(0); off < n; off++ {
+		if off == 5 {
+			continue
+		}
+		require.NoError(t, log.appendAt(off, Record{Value: []byte{byte(off)}}))
+	}
+	require.Greater(t, len(log.Segments()), 1)
+
+	it := log.Iterator(3)
+	for off := uint64(3); off < n; off++ {
+		if off == 5 {
+			continue
+		}
+		record, err := it.Next()
+		require.NoError(t, err)
+		require.Equal(t, off, it.Offset())
+		require.Equal(t, []byte{byte(off)}, record.Value)
+	}
+	_, err = it.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// the iterator picks up the records appended after it caught up
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	record, err := it.Next()
+	require.NoError(t, err)
+	require.Equal(t, off, it.Offset())
+	require.Equal(t, write, record.Value)
+
+	it.Seek(5)
+	record, err = it.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), it.Offset())
+	require.Equal(t, []byte{6}, record.Value)
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrKeyNotFound is returned by Get for a key without a record
+// in the log, or whose latest record is a tombstone
+var ErrKeyNotFound = errors.New("log: key not found")
+
+// KeyIndex maps the keys of the log to the offset of their latest record,
+// so a compacted log can be read like a table. it's kept in memory, built
+// from the local segments when the log is opened and updated on append
+type KeyIndex struct {
+	mu      sync.RWMutex
+	offsets map[string]uint64
+}
+
+// Offset returns the offset of the key's latest record
+// false if it has none, or it's a tombstone
+func (k *KeyIndex) Offset(key []byte) (uint64, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	off, ok := k.offsets[string(key)]
+	return off, ok
+}
+
+// Len is the number of keys with a record
+func (k *KeyIndex) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.offsets)
+}
+
+func (k *KeyIndex) track(off uint64, record Record) {
+	if record.Key == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if record.IsTombstone() {
+		delete(k.offsets, string(record.Key))
+	} else {
+		k.offsets[string(record.Key)] = off
+	}
+}
+
+func (k *KeyIndex) forget(key []byte, off uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.offsets[string(key)] == off {
+		delete(k.offsets, string(key))
+	}
+}
+
+// Keys returns the log's key index, nil unless Config.KeyIndex is set
+func (l *Log) Keys() *KeyIndex {
+	return l.keys
+}
+
+// Get returns the latest record of the key, or ErrKeyNotFound. it's
+// looked up in the key index if there's one, the local segments are
+// searched for it otherwise
+func (l *Log) Get(key []byte) (Record, error) {
+	var off uint64
+	var ok bool
+	if l.keys != nil {
+		off, ok = l.keys.Offset(key)
+	} else {
+		var err error
+		if off, ok, err = l.latestOffset(key); err != nil {
+			return Record{}, err
+		}
+	}
+	if !ok {
+		return Record{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	record, err := l.ReadRecord(off)
+	if errors.Is(err, ErrOffsetOutOfRange) || errors.Is(err, ErrOffsetCompacted) {
+		// the retention dropped its segment, or a tombstone after it was compacted
+		if l.keys != nil {
+			l.keys.forget(key, off)
+		}
+		return Record{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	return record, err
+}
+
+// trackKey updates the key index with the appended record
+func (l *Log) trackKey(off uint64, record Record) {
+	if l.keys != nil {
+		l.keys.track(off, record)
+	}
+}
+
+// loadKeys builds the key index from the local segments
+func (l *Log) loadKeys() error {
+	if !l.Config.KeyIndex {
+		return nil
+	}
+	keys := &KeyIndex{offsets: make(map[string]uint64)}
+	for _, s := range l.segments {
+		err := s.scan(func(off uint64, b []byte) error {
+			if recordKey(b) == nil {
+				return nil
+			}
+			record, err := decodeRecord(b)
+			if err != nil {
+				return fmt.Errorf("%w: %d", err, off)
+			}
+			keys.track(off, record)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	l.keys = keys
+	return nil
+}
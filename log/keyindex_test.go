@@ -0,0 +1,69 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyIndex(t *testing.T) {
+	for name, indexed := range map[string]bool{"indexed": true, "searched": false} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "keyindex_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{KeyIndex: indexed}
+			c.Segment.MaxIndexBytes = entWidth * 3
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			for _, record := range []Record{
+				{Key: []byte("a"), Value: []byte("a0")},
+				{Key: []byte("b"), Value: []byte("b0")},
+				{Key: []byte("a"), Value: []byte("a1")},
+				{Value: write},
+				{Key: []byte("b")},
+			} {
+				_, err := log.AppendRecord(record)
+				require.NoError(t, err)
+			}
+
+			get := func(key string) (string, error) {
+				record, err := log.Get([]byte(key))
+				return string(record.Value), err
+			}
+			value, err := get("a")
+			require.NoError(t, err)
+			require.Equal(t, "a1", value)
+			_, err = get("b")
+			require.ErrorIs(t, err, ErrKeyNotFound)
+			_, err = get("c")
+			require.ErrorIs(t, err, ErrKeyNotFound)
+			if indexed {
+				require.Equal(t, 1, log.Keys().Len())
+			} else {
+				require.Nil(t, log.Keys())
+			}
+
+			// the index is built again on open
+			require.NoError(t, log.Close())
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			value, err = get("a")
+			require.NoError(t, err)
+			require.Equal(t, "a1", value)
+
+			// and when records are truncated away
+			require.NoError(t, log.truncateFrom(2))
+			value, err = get("a")
+			require.NoError(t, err)
+			require.Equal(t, "a0", value)
+			value, err = get("b")
+			require.NoError(t, err)
+			require.Equal(t, "b0", value)
+		})
+	}
+}
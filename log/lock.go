@@ -0,0 +1,55 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned by NewLog for a directory another process has open
+var ErrLocked = errors.New("log: directory locked by another process")
+
+// the lock is held on the file for as long as the log is open, the
+// file's left behind on close and holds the pid of the last one to open it
+const lockFile = "LOCK"
+
+// lockDir locks dir for the process, so two of them can't append to the
+// same segments. the lock goes away with the process, whatever way it
+// exits, so a crash doesn't leave it to clean up by hand
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := tryLock(f)
+	if err != nil || !ok {
+		b, _ := os.ReadFile(f.Name())
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s, pid %s", ErrLocked, dir, strings.TrimSpace(string(b)))
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlock lets other processes open the directory, closing the
+// file drops the lock. it's a no-op once the lock's gone
+func (l *Log) unlock() error {
+	if l.lock == nil {
+		return nil
+	}
+	err := l.lock.Close()
+	l.lock = nil
+	return err
+}
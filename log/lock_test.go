@@ -0,0 +1,30 @@
+package log
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLock(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(path.Join(dir, lockFile))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(b))
+
+	// flocks are held by the open file, so a second open in the
+	// same process is refused like another process's would be
+	_, err = NewLog(dir, Config{})
+	require.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+}
@@ -0,0 +1,979 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log manages a directory of segments
+// appends go to the active (last) segment, reads are routed
+// to the segment that contains the requested offset
+type Log struct {
+	mu sync.RWMutex
+	// compactMu serializes compaction with the operations
+	// that remove or close segments, it's always taken before mu
+	compactMu sync.Mutex
+
+	Dir    string
+	Config Config
+
+	activeSegment *segment
+	segments      []*segment
+	// tiered are the segments before the local ones that were offloaded,
+	// tierCache holds the ones fetched back, guarded by tierMu
+	tiered    []tieredSegment
+	tierMu    sync.Mutex
+	tierCache []*segment
+
+	metrics *metrics
+	tracer  trace.Tracer
+	groups  *consumerGroups
+	// producers are the last appends of the idempotent producers
+	producers map[string]producerState
+	// keys is the key index, with Config.KeyIndex
+	keys *KeyIndex
+	// epochs are where the leader epochs start, epoch is the latest of them
+	epochs []epochStart
+	epoch  atomic.Uint64
+	// watermark is the high watermark as of the last report of a follower,
+	// or as the leader told it when following is set, see HighWatermark.
+	// replicas are what the followers last reported, under replicaMu
+	watermark atomic.Uint64
+	following atomic.Bool
+	replicaMu sync.Mutex
+	replicas  map[string]replicaState
+	// commits has appends share their fsyncs with SyncEveryWrite
+	commits *groupCommit
+	space   diskSpace
+	// lastAppend is when a record was last appended, in unix nanoseconds
+	lastAppend atomic.Int64
+	// appended wakes up ReadWait
+	appended appendSignal
+	// lock keeps other processes out of the directory
+	lock *os.File
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	// whether the retention loop's running, see SetRetention
+	retentionMu sync.Mutex
+	retaining   bool
+	stopped     bool
+
+	// queue of AppendAsync, started with the first of them
+	asyncMu     sync.Mutex
+	asyncClosed bool
+	appends     chan asyncAppend
+}
+
+// NewLog opens the log in dir, which is created if need be. the segments
+// already there are picked up by their file names and recovered from
+// whatever a crash left behind: torn records are dropped, and so are the
+// index entries that don't point at a record, while the records missing
+// from an index, or those of a missing index, are indexed again.
+// the directory is locked until Close, opening it from another process
+// meanwhile fails with ErrLocked
+func NewLog(dir string, c Config) (_ *Log, err error) {
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = 1024
+	}
+	if c.Segment.MaxIndexBytes == 0 {
+		c.Segment.MaxIndexBytes = 1024
+	}
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger = c.Logger.With(slog.String("dir", dir))
+	}
+	l := &Log{
+		Dir:    dir,
+		Config: c,
+	}
+	if l.lock, err = lockDir(dir); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			l.unlock()
+		}
+	}()
+	l.tracer = newTracer(c)
+	l.metrics = newMetrics(func() float64 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return float64(len(l.segments))
+	})
+	if l.groups, err = newConsumerGroups(path.Join(dir, groupsFile)); err != nil {
+		return nil, err
+	}
+	if err = l.setup(); err != nil {
+		return nil, err
+	}
+	if c.Sync.Policy == SyncEveryWrite {
+		l.commits = newGroupCommit(l.activeSegment.nextOffset)
+	}
+	if c.tiers() {
+		if err = l.loadTiered(); err != nil {
+			return nil, err
+		}
+	}
+	// the retention loop may be started later on, see SetRetention
+	l.done = make(chan struct{})
+	if c.Compaction.Interval > 0 {
+		l.wg.Add(1)
+		go l.compactLoop()
+	}
+	if c.retains() {
+		l.retaining = true
+		l.wg.Add(1)
+		go l.retentionLoop()
+	}
+	if c.tiers() {
+		l.wg.Add(1)
+		go l.tierLoop()
+	}
+	if c.scrubs() {
+		l.wg.Add(1)
+		go l.scrubLoop()
+	}
+	if c.Flush.Interval > 0 {
+		l.wg.Add(1)
+		go l.flushLoop()
+	}
+	return l, nil
+}
+
+// setup picks up the segments that already exist in the directory
+// or creates the first one if the directory is empty
+func (l *Log) setup() error {
+	// nothing's touched before the format checks out
+	m, err := readManifest(l.Dir)
+	if err != nil {
+		return err
+	}
+	if err = l.recoverCompaction(); err != nil {
+		return err
+	}
+	names, err := l.Config.backend().List(l.Dir)
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+
+	baseOffsets := storeOffsets(names)
+	stores := make(map[string]bool)
+	for _, off := range baseOffsets {
+		stores[strconv.FormatUint(off, 10)] = true
+	}
+
+	// an index without its store is what's left of
+	// a segment whose removal was interrupted
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".index" {
+			continue
+		}
+		if !stores[strings.TrimSuffix(file.Name(), ".index")] {
+			if err = os.Remove(path.Join(l.Dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, off := range baseOffsets {
+		if err = l.newSegment(off); err != nil {
+			return err
+		}
+	}
+	if l.segments == nil {
+		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
+			return err
+		}
+	}
+	if err = l.dropUncommitted(); err != nil {
+		return err
+	}
+	l.checkManifest(m)
+	if err = l.saveManifest(); err != nil {
+		return err
+	}
+	if err = l.loadEpochs(); err != nil {
+		return err
+	}
+	if err = l.loadProducers(); err != nil {
+		return err
+	}
+	return l.loadKeys()
+}
+
+// storeOffsets returns the base offsets of the stores among names in order,
+// stores and indexes share the same base offset so only the stores count
+func storeOffsets(names []string) []uint64 {
+	var baseOffsets []uint64
+	for _, name := range names {
+		if path.Ext(name) != ".store" {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(name, ".store"), 10, 0)
+		if err != nil {
+			continue
+		}
+		baseOffsets = append(baseOffsets, off)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	return baseOffsets
+}
+
+// Append writes the value as a record without a key and returns its offset
+func (l *Log) Append(value []byte) (uint64, error) {
+	return l.AppendRecord(Record{Value: value})
+}
+
+// Delete appends a tombstone for the key and returns its offset, the key's
+// gone for Get from then on and compaction drops its values, see Compact
+func (l *Log) Delete(key []byte) (uint64, error) {
+	if key == nil {
+		return 0, fmt.Errorf("%w: a tombstone needs a key", ErrInvalidRecord)
+	}
+	return l.AppendRecord(Record{Key: key})
+}
+
+// AppendRecord writes the record to the active segment and returns its offset
+// a new segment is created once the active one is maxed
+// the record is stamped with the log's clock if it has no timestamp yet
+// records of an idempotent producer it already appended aren't appended
+// again, see ProducerIDHeader
+func (l *Log) AppendRecord(record Record) (uint64, error) {
+	return l.appendRecord(context.Background(), record, nil)
+}
+
+// AppendIf is AppendRecord if the record goes at expected, i.e. nothing
+// else was appended since the writer last looked at the log's next offset.
+// it fails with ErrOffsetConflict otherwise, for the writer to catch up
+// with what the others appended and try again. a retry of an idempotent
+// producer's record that made it gets its offset back like with AppendRecord
+func (l *Log) AppendIf(record Record, expected uint64) (uint64, error) {
+	return l.appendRecord(context.Background(), record, &expected)
+}
+
+// AppendContext is AppendRecord, giving up once ctx is done while it waits
+// for room on disk or for its fsync to be shared with SyncEveryWrite.
+// a record that made it to the segment before that is kept, its offset's
+// returned along with the error then. the span of the append is ctx's child
+func (l *Log) AppendContext(ctx context.Context, record Record) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return l.appendRecord(ctx, record, nil)
+}
+
+// appendRecord appends the record at expected if it isn't nil, see AppendIf
+func (l *Log) appendRecord(ctx context.Context, record Record, expected *uint64) (off uint64, err error) {
+	ctx, span := l.tracer.Start(ctx, "log.Append")
+	defer func() { endSpan(span, err) }()
+
+	if record.Timestamp.IsZero() {
+		record.Timestamp = l.Config.Clock()
+	}
+	record.Epoch = l.epoch.Load()
+	b := encodeRecord(record)
+	if err = l.Config.checkSize(b); err != nil {
+		return 0, err
+	}
+	if err = l.reserveSpace(ctx, uint64(len(b))); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	var dup bool
+	l.mu.Lock()
+	off, dup, err = l.appendLocked(ctx, record, b, expected)
+	l.mu.Unlock()
+	if err == nil && l.commits != nil {
+		err = l.commits.wait(ctx, off, l.syncFrom)
+	}
+	if err != nil || dup {
+		return off, err
+	}
+	span.SetAttributes(attribute.Int64("vsdlog.offset", int64(off)))
+	l.observeAppend(1, start)
+	return off, nil
+}
+
+// appendLocked writes the record under the log's lock, dup tells
+// that it was a retry of a record the producer already appended
+func (l *Log) appendLocked(ctx context.Context, record Record, b []byte, expected *uint64) (off uint64, dup bool, err error) {
+	// a retry of a record that was already appended gets its offset back
+	if off, dup, err = l.dedupe(record); err != nil || dup {
+		return off, dup, err
+	}
+	if next := l.activeSegment.nextOffset; expected != nil && *expected != next {
+		return 0, false, fmt.Errorf("%w: expected %d, the next one is %d", ErrOffsetConflict, *expected, next)
+	}
+	if l.commits != nil {
+		// the fsync is shared with the appends around it
+		off = l.activeSegment.nextOffset
+		err = l.activeSegment.write(off, b)
+	} else {
+		off, err = l.activeSegment.Append(b)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	l.trackProducer(off, record)
+	l.trackKey(off, record)
+	if l.activeSegment.IsMaxed() {
+		err = l.rotate(ctx, off+1)
+	}
+	return off, false, err
+}
+
+var (
+	// ErrEmptyBatch is returned when appending a batch without any records
+	ErrEmptyBatch = errors.New("log: empty batch")
+	// ErrNotLeader is returned when appending to a replicated log
+	// on a node that isn't the leader of the cluster
+	ErrNotLeader = errors.New("log: not the leader")
+	// ErrOffsetOutOfRange is returned when reading an offset
+	// that isn't in any of the segments
+	ErrOffsetOutOfRange = errors.New("log: offset out of range")
+	// ErrOffsetConflict is returned by AppendIf when the log's next offset
+	// isn't the expected one, another writer appended in the meantime
+	ErrOffsetConflict = errors.New("log: offset conflict")
+	// ErrOffsetCompacted is returned when reading an offset in the range
+	// of the log whose record compaction dropped, readers skip it
+	ErrOffsetCompacted = errors.New("log: offset compacted")
+)
+
+// OffsetError is what reading a record fails with, it tells the offset
+// along with why, which is one of the sentinels above, ErrCorruptRecord
+// or ErrSegmentClosed for the most part. errors.Is looks through it
+type OffsetError struct {
+	Offset uint64
+	Err    error
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("%v: %d", e.Err, e.Offset)
+}
+
+func (e *OffsetError) Unwrap() error {
+	return e.Err
+}
+
+// appendAt writes the record under the given offset, the offsets between
+// the next one and it are left out like compaction leaves them.
+// followers use it so their records have the same offsets as the leader's
+func (l *Log) appendAt(off uint64, record Record) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = l.Config.Clock()
+	}
+	b := encodeRecord(record)
+	if err := l.reserveSpace(context.Background(), uint64(len(b))); err != nil {
+		return err
+	}
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if off < l.activeSegment.nextOffset {
+		return fmt.Errorf("%w: %d is below the next offset", ErrOffsetOutOfRange, off)
+	}
+	if err := l.fenceEpoch(off, record); err != nil {
+		return err
+	}
+	// an empty segment is replaced by one starting at the offset,
+	// and a segment too far behind to index it is rotated
+	if s := l.activeSegment; off > s.baseOffset && s.nextOffset == s.baseOffset {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+		if err := l.newSegment(off); err != nil {
+			return err
+		}
+	} else if off-s.baseOffset > math.MaxUint32 {
+		if err := l.rotate(context.Background(), off); err != nil {
+			return err
+		}
+	}
+	if err := l.activeSegment.AppendAt(off, b); err != nil {
+		return err
+	}
+	l.trackProducer(off, record)
+	l.trackKey(off, record)
+	l.observeAppend(1, start)
+	if l.activeSegment.IsMaxed() {
+		return l.rotate(context.Background(), off+1)
+	}
+	return nil
+}
+
+// skipTo has the log append from the offset on if it's past the next one,
+// the offsets in between are left out like appendAt leaves them
+func (l *Log) skipTo(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.activeSegment
+	if off <= s.nextOffset {
+		return nil
+	}
+	if s.nextOffset == s.baseOffset {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+		return l.newSegment(off)
+	}
+	return l.rotate(context.Background(), off)
+}
+
+// nextOffset is the offset the next record is appended at
+func (l *Log) nextOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.activeSegment.nextOffset
+}
+
+// AppendBatch writes the values as records without a key under a single
+// lock acquisition and returns the offsets of the first and last of them
+// the batch is spread over new segments if it doesn't fit the active one
+func (l *Log) AppendBatch(values [][]byte) (first, last uint64, err error) {
+	if len(values) == 0 {
+		return 0, 0, ErrEmptyBatch
+	}
+	now := l.Config.Clock()
+	records := make([]Record, len(values))
+	for i, value := range values {
+		records[i] = Record{Value: value, Timestamp: now}
+	}
+	return l.appendBatch(records)
+}
+
+// appendBatch writes the records under a single lock acquisition, the ones
+// without a timestamp are stamped with the log's clock
+func (l *Log) appendBatch(batch []Record) (first, last uint64, err error) {
+	now := l.Config.Clock()
+	records := make([][]byte, len(batch))
+	var size uint64
+	for i, record := range batch {
+		if record.Timestamp.IsZero() {
+			record.Timestamp = now
+		}
+		record.Epoch = l.epoch.Load()
+		records[i] = encodeRecord(record)
+		if err := l.Config.checkSize(records[i]); err != nil {
+			return 0, 0, err
+		}
+		size += uint64(len(records[i]))
+	}
+	if err := l.reserveSpace(context.Background(), size); err != nil {
+		return 0, 0, err
+	}
+
+	ctx, span := l.tracer.Start(context.Background(), "log.AppendBatch")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.Int("vsdlog.records", len(batch)))
+
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	first = l.activeSegment.nextOffset
+	for len(records) > 0 {
+		n, _, err := l.activeSegment.AppendBatch(records)
+		if err != nil {
+			return 0, 0, err
+		}
+		records = records[n:]
+		if l.activeSegment.IsMaxed() {
+			if err = l.rotate(ctx, l.activeSegment.nextOffset); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	for i, record := range batch {
+		l.trackProducer(first+uint64(i), record)
+		l.trackKey(first+uint64(i), record)
+	}
+	l.observeAppend(len(batch), start)
+	return first, first + uint64(len(batch)) - 1, nil
+}
+
+// Read returns the value of the record stored at the given offset
+func (l *Log) Read(off uint64) ([]byte, error) {
+	record, err := l.ReadRecord(off)
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// ReadRecord returns the record stored at the given offset
+func (l *Log) ReadRecord(off uint64) (Record, error) {
+	return l.readRecord(context.Background(), off, nil)
+}
+
+// ReadContext is ReadRecord, giving up once ctx is done while the
+// segment of a tiered record is fetched from the tier
+func (l *Log) ReadContext(ctx context.Context, off uint64) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	return l.readRecord(ctx, off, nil)
+}
+
+// ReadInto is ReadRecord, reading the record into buf when it's big enough
+// so consumers reusing a buffer don't allocate one for every record.
+// the key and value of the returned record point into buf,
+// they're only good until buf is reused
+func (l *Log) ReadInto(off uint64, buf []byte) (Record, error) {
+	return l.readRecord(context.Background(), off, buf)
+}
+
+func (l *Log) readRecord(ctx context.Context, off uint64, buf []byte) (record Record, err error) {
+	_, span := l.tracer.Start(ctx, "log.Read", trace.WithAttributes(
+		attribute.Int64("vsdlog.offset", int64(off)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	defer l.metrics.observeRead(time.Now())
+	b, err := l.read(ctx, off, buf)
+	if err != nil {
+		return Record{}, err
+	}
+	if record, err = decodeRecord(b); err != nil {
+		return Record{}, &OffsetError{off, err}
+	}
+	return record, nil
+}
+
+func (l *Log) read(ctx context.Context, off uint64, buf []byte) ([]byte, error) {
+	l.mu.RLock()
+	for _, segment := range l.segments {
+		if segment.baseOffset <= off && off < segment.nextOffset {
+			defer l.mu.RUnlock()
+			b, pos, err := segment.readInto(off, buf)
+			if err == nil {
+				l.advise(cursorFrom(ctx), segment, pos)
+			}
+			return b, readError(off, err)
+		}
+	}
+	// tiered segments don't change, so they're read without the lock
+	t, ok := l.tieredFor(off)
+	// the last records of a segment compaction dropped aren't in its range
+	// once it's reopened, nor are they in the next one's
+	lowest := l.segments[0].baseOffset
+	if len(l.tiered) > 0 {
+		lowest = l.tiered[0].baseOffset
+	}
+	inRange := lowest <= off && off < l.activeSegment.nextOffset
+	l.mu.RUnlock()
+	if !ok && inRange {
+		return nil, &OffsetError{off, ErrOffsetCompacted}
+	}
+	if !ok {
+		return nil, &OffsetError{off, ErrOffsetOutOfRange}
+	}
+	b, err := l.readTiered(ctx, t, off, buf)
+	return b, readError(off, err)
+}
+
+// readError tells the offset of a failed read, and what it means
+// when the segment doesn't have it
+func readError(off uint64, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF):
+		return &OffsetError{off, ErrOffsetCompacted}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return &OffsetError{off, err}
+}
+
+// Reader returns a reader over the raw store files of all segments in offset
+// order, each limited to what it holds at the time of the call and
+// preceded by its size (8 bytes), since stores can be framed differently
+// handy for snapshots and backups, since the bytes are the same as on disk
+// segments removed in the meantime are kept around until they've been read,
+// or until the reader's closed, which it has to be if it isn't read to the end
+func (l *Log) Reader() io.ReadCloser {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r := &logReader{}
+	readers := make([]io.Reader, 0, 2*len(l.segments))
+	for _, segment := range l.segments {
+		segment.acquire()
+		size := segment.store.size
+		sr := &segmentReader{
+			segment: segment,
+			reader:  io.NewSectionReader(segment.store, 0, int64(size)),
+		}
+		r.segments = append(r.segments, sr)
+		readers = append(readers, bytes.NewReader(enc.AppendUint64(nil, size)), sr)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r
+}
+
+// logReader releases the segments that weren't read to the end on Close
+type logReader struct {
+	io.Reader
+	segments []*segmentReader
+}
+
+func (r *logReader) Close() error {
+	var errs []error
+	for _, s := range r.segments {
+		errs = append(errs, s.release())
+	}
+	return errors.Join(errs...)
+}
+
+// segmentReader releases its segment once it's read to the end
+type segmentReader struct {
+	segment  *segment
+	reader   io.Reader
+	released bool
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		if rerr := r.release(); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+func (r *segmentReader) release() error {
+	if r.released {
+		return nil
+	}
+	r.released = true
+	return r.segment.release()
+}
+
+// SegmentInfo describes one of the log's segments
+type SegmentInfo struct {
+	BaseOffset uint64
+	// NextOffset is the offset after the segment's last record
+	NextOffset uint64
+	// Records can be fewer than the offsets the segment spans after compaction
+	Records    uint64
+	StoreBytes uint64
+	IndexBytes uint64
+}
+
+// Segments describes the log's segments in offset order
+func (l *Log) Segments() []SegmentInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	infos := make([]SegmentInfo, len(l.segments))
+	for i, s := range l.segments {
+		infos[i] = SegmentInfo{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Records:    s.store.records,
+			StoreBytes: s.store.size,
+			IndexBytes: s.index.size,
+		}
+	}
+	return infos
+}
+
+// sealed returns the segments but the active one, under the log's lock
+func (l *Log) sealed() []*segment {
+	var sealed []*segment
+	for _, s := range l.segments {
+		if s != l.activeSegment {
+			sealed = append(sealed, s)
+		}
+	}
+	return sealed
+}
+
+// Scan calls fn with every record of the log in offset order
+// while holding the log's read lock, so fn can't append to the log
+func (l *Log) Scan(fn func(off uint64, record Record) error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, s := range l.segments {
+		err := s.scan(func(off uint64, b []byte) error {
+			record, err := decodeRecord(b)
+			if err != nil {
+				return &OffsetError{off, err}
+			}
+			return fn(off, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate removes all the segments whose highest offset is lower than lowest
+// the active segment is never removed, so the log can keep appending
+func (l *Log) Truncate(lowest uint64) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var segments []*segment
+	for i, s := range l.segments {
+		if s != l.activeSegment && s.nextOffset <= lowest {
+			if err := s.Remove(); err != nil {
+				// the segments removed so far are gone, the others are kept
+				l.segments = append(segments, l.segments[i:]...)
+				return err
+			}
+			continue
+		}
+		segments = append(segments, s)
+	}
+	if removed := len(l.segments) - len(segments); removed > 0 {
+		l.Config.logger().Info("log truncated", "lowest", lowest, "segments", removed)
+	}
+	l.segments = segments
+	l.expireProducers(l.segments[0].baseOffset)
+	return l.dropTiered(lowest)
+}
+
+// truncateFrom drops all the records from the given offset on
+// the log continues appending from that offset
+func (l *Log) truncateFrom(off uint64) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the producers may have appended some of the dropped records
+	err := os.Remove(path.Join(l.Dir, producersFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = l.dropFrom(off); err != nil {
+		return err
+	}
+	if err = l.trimEpochs(l.epochs, off); err != nil {
+		return err
+	}
+	if hw := l.watermark.Load(); hw > off {
+		l.watermark.Store(off)
+	}
+	l.Config.logger().Info("records truncated", "from", off)
+	if l.commits != nil {
+		l.commits.rewind(off)
+	}
+	if err = l.loadProducers(); err != nil {
+		return err
+	}
+	return l.loadKeys()
+}
+
+// dropFrom removes the records of truncateFrom, under the log's locks
+func (l *Log) dropFrom(off uint64) error {
+	for len(l.segments) > 0 {
+		s := l.segments[len(l.segments)-1]
+		if s.baseOffset < off {
+			break
+		}
+		if err := s.Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+	}
+	if len(l.segments) == 0 {
+		return l.newSegment(off)
+	}
+
+	l.activeSegment = l.segments[len(l.segments)-1]
+	if err := l.activeSegment.truncateFrom(off); err != nil {
+		return err
+	}
+	if l.activeSegment.IsMaxed() {
+		return l.newSegment(l.activeSegment.nextOffset)
+	}
+	return nil
+}
+
+// reset removes all of the log's data and starts over from the given offset
+func (l *Log) reset(initialOffset uint64) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, segment := range l.segments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+		if err := l.Config.backend().Remove(segment.store.Name()); err != nil {
+			return err
+		}
+	}
+	// everything goes but the lock, which is still held
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == lockFile {
+			continue
+		}
+		if err = os.RemoveAll(path.Join(l.Dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	// the committed offsets aren't part of the log's data
+	if err := l.groups.restore(); err != nil {
+		return err
+	}
+	l.Config.logger().Info("log reset", "initial_offset", initialOffset)
+	l.segments = nil
+	l.Config.Segment.InitialOffset = initialOffset
+	if l.commits != nil {
+		l.commits.rewind(initialOffset)
+	}
+	return l.setup()
+}
+
+// LowestOffset is the offset the log starts at, the first record that can
+// be read is there unless compaction has dropped it
+func (l *Log) LowestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.tiered) > 0 {
+		return l.tiered[0].baseOffset, nil
+	}
+	return l.segments[0].baseOffset, nil
+}
+
+// HighestOffset is the offset of the last record appended to the log
+// it's zero if nothing has been appended to a log that starts at zero
+func (l *Log) HighestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	off := l.segments[len(l.segments)-1].nextOffset
+	if off == 0 {
+		return 0, nil
+	}
+	return off - 1, nil
+}
+
+func (l *Log) Close() error {
+	l.closeOnce.Do(func() {
+		l.appended.close()
+		l.stopAsync()
+		l.retentionMu.Lock()
+		l.stopped = true
+		l.retentionMu.Unlock()
+		close(l.done)
+		l.wg.Wait()
+	})
+
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.Config.readOnly {
+		if err := l.saveManifest(); err != nil {
+			return err
+		}
+	}
+	for _, segment := range l.segments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+	}
+	if err := l.closeTiered(); err != nil {
+		return err
+	}
+	return l.unlock()
+}
+
+// Remove closes the log and removes all of its data
+func (l *Log) Remove() error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	for _, s := range l.segments {
+		if err := l.Config.backend().Remove(s.store.Name()); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(l.Dir)
+}
+
+// rotate seals the active segment and creates a new one
+func (l *Log) rotate(ctx context.Context, off uint64) (err error) {
+	_, span := l.tracer.Start(ctx, "log.rotate", trace.WithAttributes(
+		attribute.Int64("vsdlog.base_offset", int64(off)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	l.metrics.observeRotation()
+	if err = l.newSegment(off); err != nil {
+		l.Config.logger().Error("rotation failed", "base_offset", off, "err", err)
+		return err
+	}
+	if l.Config.Store.Compression == CompressionZstdDict {
+		if err = l.seedDict(); err != nil {
+			return err
+		}
+	}
+	l.Config.logger().Info("segment rotated", "base_offset", off)
+	if err = l.saveManifest(); err != nil {
+		return err
+	}
+	return l.saveProducers(off)
+}
+
+func (l *Log) newSegment(off uint64) error {
+	if l.Config.readOnly {
+		return ErrReadOnly
+	}
+	s, err := newSegment(l.Dir, off, l.Config)
+	if err != nil {
+		return err
+	}
+	s.setMetrics(l.metrics)
+	l.segments = append(l.segments, s)
+	l.activeSegment = s
+	return nil
+}
@@ -0,0 +1,590 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var now = time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func TestLog(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, log *Log,
+	){
+		"append and read a record succeeds": testAppendRead,
+		"offset out of range error":         testOutOfRangeErr,
+		"init with existing segments":       testInitExisting,
+		"truncate old segments":             testTruncate,
+		"truncate failing partway":          testTruncateFails,
+		"append batch across segments":      testAppendBatch,
+		"reader":                            testReader,
+		"records are stamped by the clock":  testTimestamps,
+		"offset range":                      testOffsetRange,
+		"read into a reused buffer":         testReadInto,
+		"records over the max size fail":    testMaxRecordBytes,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "log_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = width
+			c.Clock = func() time.Time { return now }
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			fn(t, log)
+		})
+	}
+}
+
+func testAppendRead(t *testing.T, log *Log) {
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
+func testOutOfRangeErr(t *testing.T, log *Log) {
+	read, err := log.Read(1)
+	require.Nil(t, read)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+}
+
+func testInitExisting(t *testing.T, o *Log) {
+	// every record maxes a segment, so this spans several of them
+	for i := 0; i < 3; i++ {
+		_, err := o.Append(write)
+		require.NoError(t, err)
+	}
+	require.Len(t, o.segments, 4)
+	require.NoError(t, o.Close())
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.Len(t, n.segments, 4)
+	for i := uint64(0); i < 3; i++ {
+		read, err := n.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	off, err := n.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	require.NoError(t, n.Close())
+}
+
+func testTruncate(t *testing.T, log *Log) {
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+
+	err := log.Truncate(2)
+	require.NoError(t, err)
+
+	_, err = log.Read(0)
+	require.Error(t, err)
+	_, err = log.Read(1)
+	require.Error(t, err)
+	read, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	// truncated files are gone, so reopening doesn't bring them back
+	require.NoError(t, log.Close())
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer n.Close()
+	require.Equal(t, uint64(2), n.segments[0].baseOffset)
+
+	// the active segment survives truncation past the end of the log
+	require.NoError(t, n.Truncate(100))
+	off, err := n.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	_, err := log.Append(write)
+	require.NoError(t, err)
+
+	_, _, err = log.AppendBatch(nil)
+	require.ErrorIs(t, err, ErrEmptyBatch)
+
+	batch := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+	first, last, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+	require.Equal(t, uint64(3), last)
+
+	// same segments as if the records were appended one by one,
+	// each of them maxes a segment
+	require.Len(t, log.segments, 5)
+	for i, want := range batch {
+		read, err := log.Read(first + uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, want, read)
+	}
+
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, last+1, off)
+}
+
+func testTruncateFails(t *testing.T, log *Log) {
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+	// the second segment can't be removed, its index is gone
+	require.NoError(t, os.Remove(log.segments[1].index.Name()))
+	require.Error(t, log.Truncate(3))
+
+	// the first segment was removed all the same, it isn't read anymore
+	require.Equal(t, uint64(1), log.segments[0].baseOffset)
+	_, err := log.Read(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	read, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
+func testReader(t *testing.T, log *Log) {
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+
+	reader := log.Reader()
+	b, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// the reader yields every store's size and its framed records exactly as stored
+	for i := 0; i < 3; i++ {
+		require.Equal(t, width+1+timeWidth, enc.Uint64(b[:lenWidth]))
+		b = b[lenWidth:]
+		size := enc.Uint64(b[:lenWidth])
+		record, err := decodeRecord(b[headerSizeBytes : headerSizeBytes+size])
+		require.NoError(t, err)
+		require.Equal(t, write, record.Value)
+		b = b[headerSizeBytes+size:]
+	}
+	// and the empty active segment
+	require.Equal(t, make([]byte, lenWidth), b)
+}
+
+func testReadInto(t *testing.T, log *Log) {
+	off, err := log.AppendRecord(Record{Key: []byte("key"), Value: write})
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	record, err := log.ReadInto(off, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("key"), record.Key)
+	require.Equal(t, write, record.Value)
+	// the record points into the buffer
+	clear(buf)
+	require.NotEqual(t, write, record.Value)
+
+	// a buffer too small is left alone
+	record, err = log.ReadInto(off, make([]byte, 1))
+	require.NoError(t, err)
+	require.Equal(t, write, record.Value)
+}
+
+func testOffsetRange(t *testing.T, log *Log) {
+	requireOffsetRange := func(lowest, highest uint64) {
+		t.Helper()
+		off, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, lowest, off)
+		off, err = log.HighestOffset()
+		require.NoError(t, err)
+		require.Equal(t, highest, off)
+	}
+	requireOffsetRange(0, 0)
+
+	// every record rotates the active segment
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+	requireOffsetRange(0, 2)
+
+	require.NoError(t, log.Truncate(2))
+	requireOffsetRange(2, 2)
+
+	// and the range is recovered from the segments on disk
+	require.NoError(t, log.Close())
+	log, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer log.Close()
+	requireOffsetRange(2, 2)
+}
+
+func testTimestamps(t *testing.T, log *Log) {
+	eventTime := now.Add(-time.Minute)
+	off, err := log.AppendRecord(Record{Value: write, EventTime: eventTime})
+	require.NoError(t, err)
+
+	record, err := log.ReadRecord(off)
+	require.NoError(t, err)
+	require.True(t, now.Equal(record.Timestamp))
+	require.True(t, eventTime.Equal(record.EventTime))
+
+	// replicated records keep the timestamp they were appended with
+	off, err = log.AppendRecord(Record{Value: write, Timestamp: eventTime})
+	require.NoError(t, err)
+	record, err = log.ReadRecord(off)
+	require.NoError(t, err)
+	require.True(t, eventTime.Equal(record.Timestamp))
+}
+
+func TestLogMixedFraming(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_framing_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = log.Append([]byte("fixed"))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// segments created from now on are varint framed
+	c.Store.Framing = FramingVarint
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append([]byte("varint"))
+	require.NoError(t, err)
+	require.Equal(t, FramingFixed, log.segments[0].store.framing)
+	require.Equal(t, FramingVarint, log.segments[1].store.framing)
+
+	var values []string
+	err = readStores(log.Reader(), c, func(b []byte) error {
+		record, err := decodeRecord(b)
+		values = append(values, string(record.Value))
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"fixed", "varint"}, values)
+}
+
+func TestLogMetrics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_metrics_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(log))
+
+	for i := 0; i < 3; i++ {
+		off, err := log.Append(write)
+		require.NoError(t, err)
+		_, err = log.Read(off)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, float64(3), testutil.ToFloat64(log.metrics.appends))
+	require.Equal(t, float64(3), testutil.ToFloat64(log.metrics.rotations))
+	require.Equal(t, float64(4), testutil.ToFloat64(log.metrics.segments))
+	require.Greater(t, testutil.ToFloat64(log.metrics.bytesWritten), float64(3*width))
+
+	n, err := testutil.GatherAndCount(reg,
+		"vsdlog_read_duration_seconds",
+		"vsdlog_flush_duration_seconds",
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
+
+func TestLogTracing(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_tracing_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder := tracetest.NewSpanRecorder()
+	c := Config{}
+	c.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	_, err = log.Read(off)
+	require.NoError(t, err)
+	_, err = log.Read(off + 1)
+	require.Error(t, err)
+
+	names := map[string]int{}
+	for _, span := range recorder.Ended() {
+		names[span.Name()]++
+		if span.Name() == "log.Read" && span.Status().Code == codes.Error {
+			names["log.Read error"]++
+		}
+	}
+	require.Equal(t, 1, names["log.Append"])
+	require.Equal(t, 2, names["log.Read"])
+	require.Equal(t, 1, names["log.Read error"])
+	require.Equal(t, 1, names["store.flush"])
+}
+
+func TestLogAppendAsync(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_async_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	var offsets []uint64
+	for i := 0; i < 100; i++ {
+		log.AppendAsync(Record{Value: write}, func(off uint64, err error) {
+			require.NoError(t, err)
+			offsets = append(offsets, off)
+		})
+	}
+	// closing waits for the queued appends
+	require.NoError(t, log.Close())
+
+	require.Len(t, offsets, 100)
+	for i, off := range offsets {
+		require.Equal(t, uint64(i), off)
+	}
+
+	log.AppendAsync(Record{Value: write}, func(_ uint64, err error) {
+		require.ErrorIs(t, err, ErrLogClosed)
+	})
+}
+
+func testMaxRecordBytes(t *testing.T, log *Log) {
+	log.Config.Store.MaxRecordBytes = uint64(len(encodeRecord(Record{Value: write, Timestamp: now})))
+	_, err := log.Append(write)
+	require.NoError(t, err)
+
+	large := append(write, '!')
+	_, err = log.Append(large)
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	_, _, err = log.AppendBatch([][]byte{write, large})
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	_, _, err = log.AppendAll([][]byte{write, large})
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+
+	// nothing of the failed appends made it
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), highest)
+}
+
+func TestLogRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+	for _, s := range log.segments {
+		require.NoError(t, s.store.Flush())
+	}
+
+	// a crash leaves the files as they are: the active index at its
+	// grown size, with zeros after the entries, plus a torn record
+	crashed := filepath.Join(dir, "crashed")
+	require.NoError(t, os.Mkdir(crashed, 0755))
+	for _, name := range []string{"0.store", "4.store", "4.index"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		if name == "4.store" {
+			b = append(b, 0, 0, 0, 9, 1)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(crashed, name), b, 0644))
+	}
+	require.NoError(t, log.Close())
+	fi, err := os.Stat(filepath.Join(crashed, "4.index"))
+	require.NoError(t, err)
+	require.Equal(t, int64(c.Segment.MaxIndexBytes), fi.Size())
+
+	// the index of the sealed segment went missing altogether
+	var logged bytes.Buffer
+	c.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	recovered, err := NewLog(crashed, c)
+	require.NoError(t, err)
+	defer recovered.Close()
+	for _, msg := range []string{
+		"dropped a partly written record",
+		"dropped index entries past the records",
+		"indexed records missing from the index",
+	} {
+		require.Contains(t, logged.String(), msg)
+	}
+	require.Contains(t, logged.String(), "dir="+crashed)
+	highest, err := recovered.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), highest)
+	for off := uint64(0); off <= highest; off++ {
+		read, err := recovered.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	off, err := recovered.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+}
+
+func TestLogHealthy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, log.Healthy())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, file := range files {
+		require.NotContains(t, file.Name(), ".health")
+	}
+
+	// the directory went away from under the log
+	require.NoError(t, os.RemoveAll(dir))
+	require.Error(t, log.Healthy())
+}
+
+// slowTier takes until the reader gives up to get a blob
+type slowTier struct {
+	*DirTierStore
+}
+
+func (s slowTier) Get(ctx context.Context, _ string) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLogContext(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 3 * width
+	c.Tiering.Store = slowTier{&DirTierStore{Dir: filepath.Join(dir, "tier")}}
+	c.Tiering.KeepLocal = 1
+	c.Tiering.Interval = time.Hour
+	c.Space.MaxBytes = 10 * (width + entWidth)
+	log, err := NewLog(filepath.Join(dir, "log"), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = log.AppendContext(canceled, Record{Value: write})
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = log.ReadContext(canceled, 0)
+	require.ErrorIs(t, err, context.Canceled)
+
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		off, err := log.AppendContext(ctx, Record{Value: write})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+	record, err := log.ReadContext(ctx, 6)
+	require.NoError(t, err)
+	require.Equal(t, write, record.Value)
+
+	// the append waiting for room and the read waiting for the tier give up
+	require.NoError(t, log.Tier())
+	quick, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = log.ReadContext(quick, 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(write)
+		if errors.Is(err, ErrNoSpace) {
+			break
+		}
+		require.NoError(t, err)
+	}
+	log.Config.Space.Wait = time.Hour
+	quick, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = log.AppendContext(quick, Record{Value: write})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLogReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	for _, key := range []string{"a", "a", "b"} {
+		_, err := log.AppendRecord(Record{Key: []byte(key), Value: write})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.rotate(context.Background(), 3))
+	require.NoError(t, log.Compact())
+
+	var offErr *OffsetError
+	_, err = log.ReadRecord(0)
+	require.ErrorIs(t, err, ErrOffsetCompacted)
+	require.ErrorAs(t, err, &offErr)
+	require.Equal(t, uint64(0), offErr.Offset)
+	_, err = log.ReadRecord(3)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	require.ErrorAs(t, err, &offErr)
+	require.Equal(t, uint64(3), offErr.Offset)
+
+	// a record whose checksum doesn't match
+	s := log.segments[0]
+	_, pos, err := s.index.Read(1)
+	require.NoError(t, err)
+	overwrite(t, s.store.Name(), pos-1, []byte("X"))
+	_, err = log.ReadRecord(2)
+	require.NoError(t, err)
+	_, err = log.ReadRecord(1)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+	require.ErrorAs(t, err, &offErr)
+	require.Equal(t, uint64(1), offErr.Offset)
+
+	require.NoError(t, log.Close())
+	_, err = log.ReadRecord(2)
+	require.ErrorIs(t, err, ErrSegmentClosed)
+	_, err = log.Append(write)
+	require.ErrorIs(t, err, ErrSegmentClosed)
+}
@@ -0,0 +1,12 @@
+package log
+
+import "log/slog"
+
+// logger returns the configured logger, or one
+// that discards everything if there's none
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.Logger
+}
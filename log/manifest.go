@@ -0,0 +1,109 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// ErrUnsupportedFormat is returned by NewLog for a directory written in an
+// on-disk format this version of the package doesn't read, either a newer
+// one or one too old that has to be migrated first
+var ErrUnsupportedFormat = errors.New("log: unsupported on-disk format")
+
+const (
+	manifestFile = "manifest.json"
+	// formatVersion is the on-disk format the stores and indexes are written
+	// in, it goes up with every change that older versions would misread
+	formatVersion = 1
+	// minFormatVersion is the oldest format that's still read as it is
+	minFormatVersion = 1
+)
+
+// manifest describes the directory as of the last time it was saved: on
+// open and close, on rotation and when compaction drops a whole segment.
+// a directory without one is taken to be in the first format
+type manifest struct {
+	Version int `json:"version"`
+	// Segments are the base offsets of the local segments
+	Segments []uint64 `json:"segments"`
+	// Fingerprint is a hash of the settings that decide how the
+	// records are written, see Config.fingerprint
+	Fingerprint string `json:"fingerprint"`
+}
+
+// readManifest reads the manifest back, the zero one if there's none,
+// and refuses the formats this version can't read
+func readManifest(dir string) (manifest, error) {
+	m := manifest{Version: minFormatVersion}
+	b, err := os.ReadFile(path.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return m, err
+	}
+	if m.Version < minFormatVersion || m.Version > formatVersion {
+		return m, fmt.Errorf("%w: version %d, this one reads %d to %d", ErrUnsupportedFormat, m.Version, minFormatVersion, formatVersion)
+	}
+	return m, nil
+}
+
+// checkManifest compares the segments that were set up with the manifest
+// of the last time the log was open. segments go missing from the ends
+// between saves, retention and truncation see to that, so only the ones
+// missing between the first and the last are warned about
+func (l *Log) checkManifest(m manifest) {
+	if m.Fingerprint != "" && m.Fingerprint != l.Config.fingerprint() {
+		l.Config.logger().Info("the records are written differently than when the log was last open")
+	}
+	present := make(map[uint64]bool, len(l.segments))
+	for _, s := range l.segments {
+		present[s.baseOffset] = true
+	}
+	lowest, highest := l.segments[0].baseOffset, l.activeSegment.baseOffset
+	for _, off := range m.Segments {
+		if off > lowest && off < highest && !present[off] {
+			l.Config.logger().Warn("segment missing", "base_offset", off)
+		}
+	}
+}
+
+// saveManifest saves the segments of the log, under the log's lock
+func (l *Log) saveManifest() error {
+	m := manifest{
+		Version:     formatVersion,
+		Segments:    make([]uint64, 0, len(l.segments)),
+		Fingerprint: l.Config.fingerprint(),
+	}
+	for _, s := range l.segments {
+		m.Segments = append(m.Segments, s.baseOffset)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(l.Dir, manifestFile), b)
+}
+
+// fingerprint hashes the settings that decide how new records are
+// written and indexed. the segments keep what they were written with,
+// so changing them is fine, but it's logged on open
+func (c Config) fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "framing=%d compression=%d encrypted=%t index_interval=%d index_interval_bytes=%d",
+		c.Store.Framing,
+		c.Store.Compression,
+		c.Store.KeyProvider != nil,
+		c.Segment.IndexInterval,
+		c.Segment.IndexIntervalBytes,
+	)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 2 * width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for range 6 {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	read := func() manifest {
+		b, err := os.ReadFile(path.Join(dir, manifestFile))
+		require.NoError(t, err)
+		var m manifest
+		require.NoError(t, json.Unmarshal(b, &m))
+		return m
+	}
+	m := read()
+	require.Equal(t, formatVersion, m.Version)
+	require.Equal(t, []uint64{0, 2, 4, 6}, m.Segments)
+	require.Equal(t, c.fingerprint(), m.Fingerprint)
+
+	// a segment gone from the middle is warned about
+	require.NoError(t, os.Remove(path.Join(dir, "2.store")))
+	var logged bytes.Buffer
+	c.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	c.Store.Framing = FramingVarint
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Contains(t, logged.String(), "segment missing")
+	require.Contains(t, logged.String(), "written differently")
+	require.NoError(t, log.Close())
+	require.Equal(t, []uint64{0, 4, 6}, read().Segments)
+
+	// a directory of a newer format is refused before anything's touched
+	m = read()
+	m.Version = formatVersion + 1
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), b, 0644))
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+}
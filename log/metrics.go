@@ -0,0 +1,178 @@
+package log
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are kept per log rather than registered globally
+// so several logs can live in the same process
+// the log is a prometheus.Collector, register it to expose them
+type metrics struct {
+	appends        prometheus.Counter
+	bytesWritten   prometheus.Counter
+	appendDuration prometheus.Histogram
+	readDuration   prometheus.Histogram
+	flushDuration  prometheus.Histogram
+	rotations      prometheus.Counter
+	groupCommits   prometheus.Counter
+	corruptions    prometheus.Counter
+	segments       prometheus.GaugeFunc
+	// the lags of the followers are collected as they are, see ReplicaLags
+	replicaLagRecords *prometheus.Desc
+	replicaLagSeconds *prometheus.Desc
+}
+
+func newMetrics(segments func() float64) *metrics {
+	return &metrics{
+		appends: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vsdlog_appends_total",
+			Help: "Number of records appended to the log.",
+		}),
+		bytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vsdlog_written_bytes_total",
+			Help: "Number of bytes written to the stores, headers included.",
+		}),
+		appendDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "vsdlog_append_duration_seconds",
+			Help:    "Time it takes to append to the log.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		readDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "vsdlog_read_duration_seconds",
+			Help:    "Time it takes to read a record from the log.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		flushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "vsdlog_flush_duration_seconds",
+			Help:    "Time it takes to flush the store buffers to their files.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		rotations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vsdlog_segment_rotations_total",
+			Help: "Number of segments created by rotation.",
+		}),
+		groupCommits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vsdlog_group_commits_total",
+			Help: "Number of fsyncs shared by the appends going on at the same time.",
+		}),
+		corruptions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vsdlog_corrupt_records_total",
+			Help: "Number of corrupt records and index entries the scrubber found.",
+		}),
+		segments: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "vsdlog_segments",
+			Help: "Number of segments in the log.",
+		}, segments),
+		replicaLagRecords: prometheus.NewDesc(
+			"vsdlog_replica_lag_records",
+			"Number of offsets a follower is behind the leader.",
+			[]string{"replica"}, nil,
+		),
+		replicaLagSeconds: prometheus.NewDesc(
+			"vsdlog_replica_lag_seconds",
+			"Age of the oldest record a follower doesn't have.",
+			[]string{"replica"}, nil,
+		),
+	}
+}
+
+func (m *metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.appends,
+		m.bytesWritten,
+		m.appendDuration,
+		m.readDuration,
+		m.flushDuration,
+		m.rotations,
+		m.groupCommits,
+		m.corruptions,
+		m.segments,
+	}
+}
+
+// the observe methods are no-ops on a nil receiver, since stores and
+// segments can be used without a log, e.g. in tests
+
+func (m *metrics) observeAppend(n int, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.appends.Add(float64(n))
+	m.appendDuration.Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) observeWrite(bytes uint64) {
+	if m == nil {
+		return
+	}
+	m.bytesWritten.Add(float64(bytes))
+}
+
+func (m *metrics) observeRead(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.readDuration.Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) observeFlush(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.flushDuration.Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) observeRotation() {
+	if m == nil {
+		return
+	}
+	m.rotations.Inc()
+}
+
+func (m *metrics) observeGroupCommit() {
+	if m == nil {
+		return
+	}
+	m.groupCommits.Inc()
+}
+
+func (m *metrics) observeCorruption() {
+	if m == nil {
+		return
+	}
+	m.corruptions.Inc()
+}
+
+var _ prometheus.Collector = (*Log)(nil)
+
+func (l *Log) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range l.metrics.collectors() {
+		c.Describe(ch)
+	}
+	ch <- l.metrics.replicaLagRecords
+	ch <- l.metrics.replicaLagSeconds
+}
+
+func (l *Log) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range l.metrics.collectors() {
+		c.Collect(ch)
+	}
+	for _, lag := range l.ReplicaLags() {
+		ch <- prometheus.MustNewConstMetric(l.metrics.replicaLagRecords, prometheus.GaugeValue, float64(lag.Records), lag.ID)
+		ch <- prometheus.MustNewConstMetric(l.metrics.replicaLagSeconds, prometheus.GaugeValue, lag.Time.Seconds(), lag.ID)
+	}
+}
+
+var _ prometheus.Collector = (*DistributedLog)(nil)
+
+// DistributedLog exposes the metrics of the log holding the records
+// raft's own log isn't included
+func (l *DistributedLog) Describe(ch chan<- *prometheus.Desc) {
+	l.log.Describe(ch)
+}
+
+func (l *DistributedLog) Collect(ch chan<- prometheus.Metric) {
+	l.log.Collect(ch)
+}
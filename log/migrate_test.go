@@ -0,0 +1,51 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4 * width
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := range 10 {
+		_, err = log.AppendRecord(Record{Key: []byte(fmt.Sprint(i % 3)), Value: write})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	c.Store.Framing = FramingVarint
+	n, err := Migrate(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	for _, s := range log.segments {
+		require.Equal(t, FramingVarint, s.store.framing)
+	}
+	for off := range uint64(10) {
+		record, err := log.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(off%3), string(record.Key))
+		require.Equal(t, write, record.Value)
+	}
+	corruptions, err := log.Verify()
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	// a corrupt record fails the migration and is left as it is
+	overwrite(t, log.segments[1].store.Name(), log.segments[1].store.start+20, []byte("X"))
+	require.NoError(t, log.Close())
+	c.Store.Framing = FramingFixed
+	n, err = Migrate(dir, c)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+	require.Equal(t, 0, n)
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+}
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"io"
+	"os"
+
+	"github.com/tysonmote/gommap"
+)
+
+// readAt reads from the store's mapping when it has one, the mapping
+// always covers what's flushed, so reads become plain copies
+func (s *store) readAt(b []byte, off int64) (int, error) {
+	if s.mapped == nil {
+		return s.Storage.ReadAt(b, off)
+	}
+	end := int64(s.flushed.Load())
+	if off >= end {
+		return 0, io.EOF
+	}
+	n := copy(b, s.mapped[off:end])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// mapTo grows the mapping to cover the first size bytes of the store
+// it's mapped past the end of the file, up to the max store size
+// or twice what's needed, so it's seldom remapped. only what's
+// in the file is ever read, the pages past it would fault
+func (s *store) mapTo(size uint64) error {
+	if !s.mmapReads || uint64(len(s.mapped)) >= size && s.mapped != nil {
+		return nil
+	}
+	if err := s.unmap(); err != nil {
+		return err
+	}
+	n := max(s.maxBytes, 2*size, uint64(os.Getpagesize()))
+	f, _ := s.file()
+	mapped, err := gommap.MapRegion(f.Fd(), 0, int64(n), gommap.PROT_READ, gommap.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	s.mapped = mapped
+	return nil
+}
+
+func (s *store) unmap() error {
+	if s.mapped == nil {
+		return nil
+	}
+	err := s.mapped.UnsafeUnmap()
+	s.mapped = nil
+	return err
+}
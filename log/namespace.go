@@ -0,0 +1,151 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// namespaceSeparator separates the tenant from the topic in topic names
+const namespaceSeparator = "."
+
+// namespaceMeasureInterval is how often the size of a tenant at its quota
+// is measured again at most, retention may have made room in the meantime
+const namespaceMeasureInterval = time.Second
+
+// Namespace is the topics of a tenant, they're the topics of Topics
+// prefixed with the tenant's name: "orders" of the tenant "team-a"
+// is "team-a.orders"
+type Namespace struct {
+	Tenant string
+
+	topics *Topics
+
+	// the appends of the tenant are made one at a time, so they
+	// can't get it past its quota together
+	mu       sync.Mutex
+	maxBytes uint64
+	// size is the running count of the bytes the tenant's topics take,
+	// it's measured from the segments when it isn't known or at the quota
+	size     uint64
+	sized    bool
+	measured time.Time
+}
+
+// Namespace returns the namespace of the tenant, whose names can't have
+// dots. maxBytes caps the size of all of the tenant's topics, the appends
+// past it fail with ErrNoSpace, zero doesn't cap them. the namespace is
+// the same for every call with the tenant, maxBytes is the last one given
+func (t *Topics) Namespace(tenant string, maxBytes uint64) (*Namespace, error) {
+	if err := validateTopic(tenant); err != nil || strings.Contains(tenant, namespaceSeparator) {
+		return nil, fmt.Errorf("%w: tenant %q", ErrInvalidTopic, tenant)
+	}
+	t.namespacesMu.Lock()
+	n, ok := t.namespaces[tenant]
+	if !ok {
+		if t.namespaces == nil {
+			t.namespaces = make(map[string]*Namespace)
+		}
+		n = &Namespace{Tenant: tenant, topics: t}
+		t.namespaces[tenant] = n
+	}
+	t.namespacesMu.Unlock()
+
+	n.mu.Lock()
+	n.maxBytes = maxBytes
+	n.mu.Unlock()
+	return n, nil
+}
+
+// MaxBytes is the cap of the size of the tenant's topics
+func (n *Namespace) MaxBytes() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.maxBytes
+}
+
+func (n *Namespace) name(topic string) string {
+	return n.Tenant + namespaceSeparator + topic
+}
+
+// CreateTopic creates a topic of the tenant, see Topics.CreateTopic
+func (n *Namespace) CreateTopic(name string, c TopicConfig) (*Topic, error) {
+	if err := validateTopic(name); err != nil {
+		return nil, err
+	}
+	return n.topics.CreateTopic(n.name(name), c)
+}
+
+// Topic returns the tenant's topic with the given name
+func (n *Namespace) Topic(name string) (*Topic, error) {
+	return n.topics.Topic(n.name(name))
+}
+
+// TopicNames returns the names of the tenant's topics in order,
+// without the tenant's prefix
+func (n *Namespace) TopicNames() []string {
+	prefix := n.name("")
+	var names []string
+	for _, name := range n.topics.TopicNames() {
+		if topic, ok := strings.CutPrefix(name, prefix); ok {
+			names = append(names, topic)
+		}
+	}
+	return names
+}
+
+// DeleteTopic deletes the tenant's topic, see Topics.DeleteTopic
+func (n *Namespace) DeleteTopic(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// the topic's bytes are freed, they're measured again on the next append
+	n.sized = false
+	return n.topics.DeleteTopic(n.name(name))
+}
+
+// Size is how many bytes the segments of the tenant's topics take,
+// measured from every one of them
+func (n *Namespace) Size() uint64 {
+	var size uint64
+	for _, name := range n.TopicNames() {
+		topic, err := n.Topic(name)
+		if err != nil {
+			// deleted in the meantime
+			continue
+		}
+		for _, log := range topic.partitions {
+			for _, s := range log.Segments() {
+				size += s.StoreBytes + s.IndexBytes
+			}
+		}
+	}
+	return size
+}
+
+// Append appends the record to the tenant's topic, unless the tenant's
+// topics are at its quota. the bytes appended are counted as the record's
+// size in the store, and the topics are only measured again once the
+// count gets to the quota
+func (n *Namespace) Append(name string, record Record) (partition int, off uint64, err error) {
+	topic, err := n.Topic(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.maxBytes > 0 {
+		if !n.sized || (n.size >= n.maxBytes && time.Since(n.measured) >= namespaceMeasureInterval) {
+			n.size, n.sized, n.measured = n.Size(), true, time.Now()
+		}
+		if n.size >= n.maxBytes {
+			return 0, 0, fmt.Errorf("%w: the tenant %s takes %d of its %d bytes", ErrNoSpace, n.Tenant, n.size, n.maxBytes)
+		}
+	}
+	partition, off, err = topic.Append(record)
+	if err != nil {
+		return 0, 0, err
+	}
+	n.size += uint64(len(encodeRecord(record))) + lenWidth + entWidth
+	return partition, off, nil
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespace(t *testing.T) {
+	c := TopicConfig{}
+	c.Log.Segment.MaxStoreBytes = width
+	topics, err := NewTopics(t.TempDir(), c)
+	require.NoError(t, err)
+	defer topics.Close()
+
+	_, err = topics.Namespace("team.a", 0)
+	require.ErrorIs(t, err, ErrInvalidTopic)
+	a, err := topics.Namespace("team-a", 0)
+	require.NoError(t, err)
+	_, err = a.CreateTopic("orders", c)
+	require.NoError(t, err)
+	b, err := topics.Namespace("team-b", 1)
+	require.NoError(t, err)
+	_, err = b.CreateTopic("orders", c)
+	require.NoError(t, err)
+
+	// the tenants' topics of the same name are topics of their own
+	require.Equal(t, []string{"team-a.orders", "team-b.orders"}, topics.TopicNames())
+	require.Equal(t, []string{"orders"}, a.TopicNames())
+	for i := 0; i < 3; i++ {
+		_, _, err = a.Append("orders", Record{Value: write})
+		require.NoError(t, err)
+	}
+	require.NotZero(t, a.Size())
+	require.Zero(t, b.Size())
+
+	// the first append gets the tenant over its quota, the second's refused
+	_, _, err = b.Append("orders", Record{Value: write})
+	require.NoError(t, err)
+	_, _, err = b.Append("orders", Record{Value: write})
+	require.ErrorIs(t, err, ErrNoSpace)
+	_, _, err = b.Append("payments", Record{Value: write})
+	require.ErrorIs(t, err, ErrUnknownTopic)
+
+	// the namespace of a tenant is the same one every time
+	again, err := topics.Namespace("team-b", 0)
+	require.NoError(t, err)
+	require.Same(t, b, again)
+	require.Zero(t, b.MaxBytes())
+	_, _, err = b.Append("orders", Record{Value: write})
+	require.NoError(t, err)
+
+	require.NoError(t, b.DeleteTopic("orders"))
+	require.Empty(t, b.TopicNames())
+	require.Equal(t, []string{"team-a.orders"}, topics.TopicNames())
+}
+
+func TestNamespaceConcurrentAppends(t *testing.T) {
+	topics, err := NewTopics(t.TempDir(), TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	n, err := topics.Namespace("team-a", 1)
+	require.NoError(t, err)
+	_, err = n.CreateTopic("orders", TopicConfig{Partitions: 4})
+	require.NoError(t, err)
+
+	// only the first of the appends fits under the quota
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := n.Append("orders", Record{Value: write})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var appended int
+	for err := range errs {
+		if err == nil {
+			appended++
+			continue
+		}
+		require.ErrorIs(t, err, ErrNoSpace)
+	}
+	require.Equal(t, 1, appended)
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// topicManifestFile keeps the overrides of a topic, in its directory
+const topicManifestFile = "topic.json"
+
+// TopicOverrides are the settings a topic has of its own, instead of the
+// ones of Topics.Config.Log. the fields that are nil aren't overridden.
+// they're kept in the topic's manifest, so the topic's opened with them again
+type TopicOverrides struct {
+	// MaxStoreBytes and MaxIndexBytes are the size of the segments
+	MaxStoreBytes *uint64 `json:"max_store_bytes,omitempty"`
+	MaxIndexBytes *uint64 `json:"max_index_bytes,omitempty"`
+	// RetentionMaxAge and RetentionMaxBytes are the retention, see
+	// Config.Retention. zero turns them off for the topic
+	RetentionMaxAge   *time.Duration `json:"retention_max_age,omitempty"`
+	RetentionMaxBytes *uint64        `json:"retention_max_bytes,omitempty"`
+	// CompactionInterval and PunchHoles are how compaction's done,
+	// see Config.Compaction. a zero interval turns it off for the topic
+	CompactionInterval *time.Duration `json:"compaction_interval,omitempty"`
+	PunchHoles         *bool          `json:"punch_holes,omitempty"`
+	// Compression is the codec of the records appended to the topic
+	Compression *Compression `json:"compression,omitempty"`
+}
+
+// apply returns the config with the overrides
+func (o TopicOverrides) apply(c Config) Config {
+	if o.MaxStoreBytes != nil {
+		c.Segment.MaxStoreBytes = *o.MaxStoreBytes
+	}
+	if o.MaxIndexBytes != nil {
+		c.Segment.MaxIndexBytes = *o.MaxIndexBytes
+	}
+	if o.RetentionMaxAge != nil {
+		c.Retention.MaxAge = *o.RetentionMaxAge
+	}
+	if o.RetentionMaxBytes != nil {
+		c.Retention.MaxBytes = *o.RetentionMaxBytes
+	}
+	if o.CompactionInterval != nil {
+		c.Compaction.Interval = *o.CompactionInterval
+	}
+	if o.PunchHoles != nil {
+		c.Compaction.PunchHoles = *o.PunchHoles
+	}
+	if o.Compression != nil {
+		c.Store.Compression = *o.Compression
+	}
+	return c
+}
+
+// validate refuses the overrides the logs couldn't be opened with
+func (o TopicOverrides) validate() error {
+	if o.Compression != nil && *o.Compression > CompressionZstdDict {
+		return ErrUnknownCompression
+	}
+	return nil
+}
+
+// readTopicOverrides reads the overrides back from the topic's
+// manifest, there are none without one
+func readTopicOverrides(dir string) (TopicOverrides, error) {
+	var o TopicOverrides
+	b, err := os.ReadFile(filepath.Join(dir, topicManifestFile))
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return o, err
+	}
+	if err = json.Unmarshal(b, &o); err != nil {
+		return o, err
+	}
+	return o, o.validate()
+}
+
+func writeTopicOverrides(dir string, o TopicOverrides) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, topicManifestFile), b)
+}
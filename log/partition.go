@@ -0,0 +1,43 @@
+package log
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Partitioner picks the partition of a topic a record is appended to
+type Partitioner interface {
+	Partition(record Record, partitions int) int
+}
+
+// PartitionerFunc lets a plain function pick partitions
+// e.g. to route records by hand
+type PartitionerFunc func(record Record, partitions int) int
+
+func (f PartitionerFunc) Partition(record Record, partitions int) int {
+	return f(record, partitions)
+}
+
+// HashPartitioner sends records with the same key to the same partition
+// so they stay in order, records without a key are spread round robin
+type HashPartitioner struct {
+	roundRobin RoundRobinPartitioner
+}
+
+func (p *HashPartitioner) Partition(record Record, partitions int) int {
+	if record.Key == nil {
+		return p.roundRobin.Partition(record, partitions)
+	}
+	h := fnv.New32a()
+	h.Write(record.Key)
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// RoundRobinPartitioner spreads records evenly over the partitions
+type RoundRobinPartitioner struct {
+	next atomic.Uint64
+}
+
+func (p *RoundRobinPartitioner) Partition(_ Record, partitions int) int {
+	return int((p.next.Add(1) - 1) % uint64(partitions))
+}
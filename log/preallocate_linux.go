@@ -0,0 +1,19 @@
+package log
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes of disk for the file without changing
+// its size, so appends don't grow it block by block.
+// filesystems that can't do it just don't get the hint
+func preallocate(f *os.File, size uint64) error {
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, int64(size))
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	return err
+}
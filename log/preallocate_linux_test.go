@@ -0,0 +1,36 @@
+package log
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePreallocate(t *testing.T) {
+	f, err := os.CreateTemp("", "store_preallocate_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.Preallocate = true
+	c.Segment.MaxStoreBytes = 1 << 20
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	// the disk is reserved but the file is only as big as its record
+	require.Equal(t, int64(width), fi.Size())
+	require.GreaterOrEqual(t, fi.Sys().(*syscall.Stat_t).Blocks*512, int64(c.Segment.MaxStoreBytes))
+
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.Equal(t, width, s.size)
+}
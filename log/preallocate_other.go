@@ -0,0 +1,10 @@
+//go:build !linux
+
+package log
+
+import "os"
+
+// preallocate needs fallocate to keep the file's size, which is linux only
+func preallocate(f *os.File, size uint64) error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+)
+
+// an idempotent producer puts these headers on its records, its id for
+// the session and a sequence number that goes up by one with every record
+// it sends to a log. a retried append that already made it is then
+// answered with the offset it got instead of being appended twice
+const (
+	ProducerIDHeader  = "vsdlog-producer-id"
+	ProducerSeqHeader = "vsdlog-producer-seq"
+)
+
+var (
+	// ErrDuplicateSequence is returned for a record older than
+	// the last one the producer appended
+	ErrDuplicateSequence = errors.New("log: duplicate sequence number")
+	// ErrOutOfOrderSequence is returned for a record that skips sequence
+	// numbers, i.e. when records before it were lost
+	ErrOutOfOrderSequence = errors.New("log: out of order sequence number")
+	// ErrInvalidSequence is returned for a sequence header that isn't a number
+	ErrInvalidSequence = errors.New("log: invalid sequence number")
+)
+
+// ProducerWindow is how many of a producer's latest records a retry can
+// go back to and still get its offset, older ones fail with
+// ErrDuplicateSequence. producers that retry batches keep them below it
+const ProducerWindow = 512
+
+// MaxProducers is how many producers a log keeps track of, past that the
+// one whose last record is the oldest is forgotten. a client that gives
+// up on its id starts a new one, so ids that aren't used anymore pile up
+const MaxProducers = 4096
+
+// the state of the producers is saved whenever a segment is sealed, so
+// only the records after it have to be read on open
+const producersFile = "producers.json"
+
+// producerState is the last record a producer appended, and the offsets
+// of the ones it appended right before it, oldest first
+type producerState struct {
+	Seq     uint64   `json:"seq"`
+	Offset  uint64   `json:"offset"`
+	Earlier []uint64 `json:"earlier,omitempty"`
+}
+
+type producersSnapshot struct {
+	// NextOffset is the offset the records not in the snapshot start at
+	NextOffset uint64                   `json:"next_offset"`
+	Producers  map[string]producerState `json:"producers"`
+}
+
+// producer returns the id and sequence number of the record's producer
+func (r Record) producer() (id string, seq uint64, ok bool, err error) {
+	id, ok = r.Headers[ProducerIDHeader]
+	if !ok {
+		return "", 0, false, nil
+	}
+	seq, err = strconv.ParseUint(r.Headers[ProducerSeqHeader], 10, 64)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("%w: %q", ErrInvalidSequence, r.Headers[ProducerSeqHeader])
+	}
+	return id, seq, true, nil
+}
+
+// dedupe returns the offset the record was already appended at,
+// if its producer sent it before. called with the log's lock held
+func (l *Log) dedupe(record Record) (off uint64, dup bool, err error) {
+	id, seq, ok, err := record.producer()
+	if !ok {
+		return 0, false, err
+	}
+	last, known := l.producers[id]
+	switch {
+	// a producer the log doesn't know yet starts wherever it likes
+	case !known:
+		return 0, false, nil
+	case seq == last.Seq:
+		return last.Offset, true, nil
+	case last.Seq-seq <= uint64(len(last.Earlier)):
+		return last.Earlier[uint64(len(last.Earlier))-(last.Seq-seq)], true, nil
+	case seq < last.Seq:
+		return 0, false, fmt.Errorf("%w: %d from %s, the last one was %d", ErrDuplicateSequence, seq, id, last.Seq)
+	case seq > last.Seq+1:
+		return 0, false, fmt.Errorf("%w: %d from %s, the last one was %d", ErrOutOfOrderSequence, seq, id, last.Seq)
+	}
+	return 0, false, nil
+}
+
+// trackProducer records the append of the record, under the log's lock
+func (l *Log) trackProducer(off uint64, record Record) {
+	id, seq, ok, _ := record.producer()
+	if !ok {
+		return
+	}
+	state := producerState{Seq: seq, Offset: off}
+	last, known := l.producers[id]
+	if known && seq == last.Seq+1 {
+		state.Earlier = append(last.Earlier, last.Offset)
+		if len(state.Earlier) > ProducerWindow-1 {
+			state.Earlier = state.Earlier[1:]
+		}
+	}
+	if !known && len(l.producers) >= MaxProducers {
+		oldest, first := "", true
+		for id, p := range l.producers {
+			if first || p.Offset < l.producers[oldest].Offset {
+				oldest, first = id, false
+			}
+		}
+		delete(l.producers, oldest)
+	}
+	l.producers[id] = state
+}
+
+// expireProducers forgets the producers whose last record is below lowest,
+// i.e. was truncated or retained away, under the log's lock
+func (l *Log) expireProducers(lowest uint64) {
+	for id, p := range l.producers {
+		if p.Offset < lowest {
+			delete(l.producers, id)
+		}
+	}
+}
+
+// loadProducers picks up the saved state of the producers and
+// the records appended after it, under the log's lock
+func (l *Log) loadProducers() error {
+	l.producers = make(map[string]producerState)
+	from := l.segments[0].baseOffset
+	b, err := os.ReadFile(path.Join(l.Dir, producersFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var snapshot producersSnapshot
+		if err = json.Unmarshal(b, &snapshot); err != nil {
+			return err
+		}
+		// a snapshot past the head is of records that were truncated away
+		if snapshot.NextOffset <= l.activeSegment.nextOffset && snapshot.Producers != nil {
+			l.producers = snapshot.Producers
+			from = max(from, snapshot.NextOffset)
+		}
+	}
+
+	for _, s := range l.segments {
+		if s.nextOffset <= from {
+			continue
+		}
+		err := s.scan(func(off uint64, b []byte) error {
+			if off < from {
+				return nil
+			}
+			record, err := decodeRecord(b)
+			if err != nil {
+				return fmt.Errorf("%w: %d", err, off)
+			}
+			l.trackProducer(off, record)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// the snapshot may be from before the log was truncated
+	l.expireProducers(l.segments[0].baseOffset)
+	return nil
+}
+
+// saveProducers snapshots the producers up to off, under the log's lock
+func (l *Log) saveProducers(off uint64) error {
+	if len(l.producers) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(producersSnapshot{NextOffset: off, Producers: l.producers})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(l.Dir, producersFile), b)
+}
@@ -0,0 +1,166 @@
+package log
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotentProducer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "producer_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	produce := func(id string, seq int) (uint64, error) {
+		return log.AppendRecord(Record{
+			Value: write,
+			Headers: map[string]string{
+				ProducerIDHeader:  id,
+				ProducerSeqHeader: strconv.Itoa(seq),
+			},
+		})
+	}
+
+	for seq := 0; seq < 4; seq++ {
+		off, err := produce("a", seq)
+		require.NoError(t, err)
+		require.Equal(t, uint64(seq), off)
+	}
+	// a retry of the last record gets its offset back
+	off, err := produce("a", 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), highest)
+
+	// and so do the ones of the records before it
+	off, err = produce("a", 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = produce("a", 5)
+	require.ErrorIs(t, err, ErrOutOfOrderSequence)
+	_, err = log.AppendRecord(Record{Value: write, Headers: map[string]string{
+		ProducerIDHeader:  "a",
+		ProducerSeqHeader: "four",
+	}})
+	require.ErrorIs(t, err, ErrInvalidSequence)
+
+	// other producers and records without one don't get in the way
+	off, err = produce("b", 7)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	off, err = log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	off, err = produce("b", 8)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+	// the log never saw the ones before the first
+	_, err = produce("b", 6)
+	require.ErrorIs(t, err, ErrDuplicateSequence)
+
+	// the state is picked up again from the snapshot taken when the
+	// last segment was sealed and the records after it
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.Segments(), 3)
+	off, err = produce("a", 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	off, err = produce("a", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	off, err = produce("b", 8)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+
+	// truncating drops the appends that went with the records
+	require.NoError(t, log.truncateFrom(3))
+	off, err = produce("a", 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	off, err = produce("b", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	require.NoError(t, log.Close())
+}
+
+func TestProducerWindow(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	produce := func(seq int) (uint64, error) {
+		return log.AppendRecord(Record{Value: write, Headers: map[string]string{
+			ProducerIDHeader:  "a",
+			ProducerSeqHeader: strconv.Itoa(seq),
+		}})
+	}
+	n := ProducerWindow + 10
+	for seq := 0; seq < n; seq++ {
+		_, err := produce(seq)
+		require.NoError(t, err)
+	}
+	off, err := produce(n - ProducerWindow)
+	require.NoError(t, err)
+	require.Equal(t, uint64(n-ProducerWindow), off)
+	_, err = produce(n - ProducerWindow - 1)
+	require.ErrorIs(t, err, ErrDuplicateSequence)
+}
+
+func TestProducerExpiry(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	produce := func(id string, seq int) (uint64, error) {
+		return log.AppendRecord(Record{Value: write, Headers: map[string]string{
+			ProducerIDHeader:  id,
+			ProducerSeqHeader: strconv.Itoa(seq),
+		}})
+	}
+	_, err = produce("gone", 0)
+	require.NoError(t, err)
+	for seq := 0; len(log.segments) < 3; seq++ {
+		_, err = produce("kept", seq)
+		require.NoError(t, err)
+	}
+
+	// the producers whose records were truncated away are forgotten
+	require.NoError(t, log.Truncate(log.segments[1].baseOffset))
+	require.NotContains(t, log.producers, "gone")
+	require.Contains(t, log.producers, "kept")
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.NotContains(t, log.producers, "gone")
+	require.NoError(t, log.Close())
+
+	// and past MaxProducers the oldest one is
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.MaxIndexBytes = 1 << 20
+	log, err = NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = produce("kept", 0)
+	require.NoError(t, err)
+	for i := range MaxProducers {
+		_, err = produce("p"+strconv.Itoa(i), 0)
+		require.NoError(t, err)
+	}
+	require.Len(t, log.producers, MaxProducers)
+	require.NotContains(t, log.producers, "kept")
+	require.Contains(t, log.producers, "p0")
+}
@@ -0,0 +1,231 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// hole is a run of dropped records of a store, from pos up to end,
+// off is the offset of the first of them
+type hole struct {
+	off, pos, end uint64
+}
+
+// punchSegment compacts the sealed segment in place, with
+// Compaction.PunchHoles. every run of records that keep drops is made a
+// single frame, a hole, that the index flags so reads skip it, and the
+// blocks under it are handed back to the filesystem. ok is false if
+// none of the records are kept, the segment is rewritten away then
+func (l *Log) punchSegment(s *segment, keep func(off uint64, b []byte) (bool, error)) (ok bool, err error) {
+	type frame struct {
+		off, pos, end uint64
+		dead          bool
+	}
+	var frames []frame
+	live := false
+	f := newBloomFilter(s.store.records)
+	err = s.walk(0, func(off, pos uint64) (bool, error) {
+		b, err := s.store.Read(pos)
+		if err != nil {
+			return false, fmt.Errorf("%w: %d", err, off)
+		}
+		kept, err := keep(off, b)
+		if err != nil {
+			return false, err
+		}
+		end, err := s.store.next(pos)
+		if err != nil {
+			return false, err
+		}
+		if kept {
+			if key := recordKey(b); key != nil {
+				f.add(key)
+			}
+		}
+		live = live || kept
+		frames = append(frames, frame{off: off, pos: pos, end: end, dead: !kept})
+		return false, nil
+	})
+	if err != nil || !live {
+		return live, err
+	}
+
+	// the runs of dropped records, and the records right after them,
+	// which need entries of their own since walking stops at holes
+	var holes []hole
+	after := make(map[uint64]uint64)
+	for i, fr := range frames {
+		if !fr.dead {
+			if n := len(holes); i > 0 && frames[i-1].dead && holes[n-1].end == fr.pos {
+				after[fr.pos] = fr.off
+			}
+			continue
+		}
+		if n := len(holes); i > 0 && frames[i-1].dead && frames[i-1].end == fr.pos {
+			holes[n-1].end = fr.end
+			continue
+		}
+		holes = append(holes, hole{off: fr.off, pos: fr.pos, end: fr.end})
+	}
+	if len(holes) == 0 {
+		return true, nil
+	}
+
+	// the entries that don't point into the holes are kept
+	type entry struct {
+		out uint32
+		pos uint64
+	}
+	var entries []entry
+	n := s.index.size / entWidth
+	for slot, h := uint64(0), 0; slot < n; slot++ {
+		out, pos, isHole, err := s.index.entry(int64(slot))
+		if err != nil {
+			return true, err
+		}
+		for h < len(holes) && holes[h].end <= pos {
+			h++
+		}
+		if h < len(holes) && holes[h].pos <= pos {
+			continue
+		}
+		if isHole {
+			pos |= holeBit
+		}
+		entries = append(entries, entry{out, pos})
+		delete(after, pos)
+	}
+	for _, h := range holes {
+		entries = append(entries, entry{uint32(h.off - s.baseOffset), h.pos | holeBit})
+	}
+	for pos, off := range after {
+		entries = append(entries, entry{uint32(off - s.baseOffset), pos})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].pos&^holeBit < entries[j].pos&^holeBit
+	})
+	b := make([]byte, 0, uint64(len(entries))*entWidth)
+	for _, e := range entries {
+		b = enc.AppendUint32(b, e.out)
+		b = enc.AppendUint64(b, e.pos)
+	}
+
+	// the index is the commit point, it's renamed over the segment's
+	// own once complete, like a compaction's, see recoverCompaction
+	indexName := s.index.Name() + compactedExt
+	if err = writeFileAtomic(indexName, b); err != nil {
+		return true, err
+	}
+	return true, l.swapIndex(s, indexName, holes, f)
+}
+
+// swapIndex replaces the index of the segment with the one of its holes
+// and punches them. nothing points into their records by then. the holes
+// are punched in place, so a segment readers hold on to is left as it is,
+// for a later compaction to punch
+func (l *Log) swapIndex(s *segment, indexName string, holes []hole, f *bloomFilter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := -1
+	for j, segment := range l.segments {
+		if segment == s {
+			i = j
+			break
+		}
+	}
+	// readers take hold of segments under the lock, so none can meanwhile
+	if i == -1 || s.busy() {
+		return os.Remove(indexName)
+	}
+
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(indexName, s.index.Name()); err != nil {
+		return l.reopenSegment(i, err)
+	}
+	freed, err := punchHoles(s.store.Name(), s.store.framing, holes)
+	if err != nil {
+		return l.reopenSegment(i, err)
+	}
+	ns, err := newSegment(l.Dir, s.baseOffset, l.Config)
+	if err != nil {
+		return l.reopenSegment(i, err)
+	}
+	ns.setMetrics(l.metrics)
+	ns.setKeyFilter(f)
+	l.segments[i] = ns
+	l.Config.logger().Info("punched holes", "base_offset", s.baseOffset, "holes", len(holes), "bytes", freed)
+	return nil
+}
+
+// reopenSegment opens the i-th segment again from its files, after a swap
+// failed once it was closed, so the log doesn't keep reading a closed
+// segment. under the log's lock
+func (l *Log) reopenSegment(i int, err error) error {
+	s := l.segments[i]
+	ns, rerr := newSegment(l.Dir, s.baseOffset, l.Config)
+	if rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	ns.setMetrics(l.metrics)
+	l.segments[i] = ns
+	return err
+}
+
+// punchHoles writes the frame of every hole over the records it starts
+// with and punches out the rest, it returns how many bytes that frees
+func punchHoles(name string, framing Framing, holes []hole) (uint64, error) {
+	// the store's own file is opened for appends, which can't write at an offset
+	f, err := os.OpenFile(name, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	for _, h := range holes {
+		if _, err = f.WriteAt(holeHeader(framing, h.end-h.pos), int64(h.pos)); err != nil {
+			return 0, err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	var freed uint64
+	for _, h := range holes {
+		start := h.pos + uint64(len(holeHeader(framing, h.end-h.pos)))
+		if err = punchHole(f, int64(start), int64(h.end-start)); err != nil {
+			return 0, err
+		}
+		freed += h.end - start
+	}
+	return freed, f.Sync()
+}
+
+// holeHeader is the header of a hole that takes up size bytes of the store,
+// frame included. a varint length is padded so the frame ends where the
+// hole does, uvarints may take more bytes than they need
+func holeHeader(framing Framing, size uint64) []byte {
+	var b []byte
+	if framing == FramingVarint {
+		w := uint64(1)
+		for uint64(len(binary.AppendUvarint(nil, size-w-metaWidth))) > w {
+			w++
+		}
+		n := size - w - metaWidth
+		for ; w > 1; w-- {
+			b = append(b, byte(n)|0x80)
+			n >>= 7
+		}
+		b = append(b, byte(n))
+	} else {
+		b = enc.AppendUint64(b, size-lenWidth-metaWidth)
+	}
+	meta := make([]byte, metaWidth)
+	meta[codecPos] = holeCodec
+	return append(b, meta...)
+}
@@ -0,0 +1,192 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPunchHoles(t *testing.T) {
+	for name, setup := range map[string]func(c *Config){
+		"fixed framing": func(c *Config) {},
+		"varint framing": func(c *Config) {
+			c.Store.Framing = FramingVarint
+		},
+		"sparse index": func(c *Config) {
+			c.Segment.IndexInterval = 3
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testPunchHoles(t, setup)
+		})
+	}
+}
+
+func testPunchHoles(t *testing.T, setup func(c *Config)) {
+	dir := t.TempDir()
+	c := Config{}
+	// segments of 8 records
+	c.Segment.MaxStoreBytes = 8 * 8 << 10
+	c.Compaction.PunchHoles = true
+	setup(&c)
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// big enough values for the holes to free whole blocks
+	value := func(off int) []byte {
+		return bytes.Repeat([]byte{byte(off)}, 8<<10)
+	}
+	keys := []string{
+		"a", "b", "a", "c", "a", "b", "", "d",
+		"a", "e", "e", "e", "f", "f", "f", "f",
+		"g",
+	}
+	for off, key := range keys {
+		record := Record{Value: value(off)}
+		if key != "" {
+			record.Key = []byte(key)
+		}
+		_, err := log.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 3)
+	blocks := func() int64 {
+		fi, err := os.Stat(log.segments[0].store.Name())
+		require.NoError(t, err)
+		return fi.Sys().(*syscall.Stat_t).Blocks
+	}
+	before := blocks()
+
+	require.NoError(t, log.Compact())
+	require.Less(t, blocks(), before)
+
+	dead := map[uint64]bool{0: true, 1: true, 2: true, 4: true, 9: true, 10: true, 12: true, 13: true, 14: true}
+	check := func() {
+		t.Helper()
+		var live []uint64
+		for off := range keys {
+			if !dead[uint64(off)] {
+				live = append(live, uint64(off))
+			}
+		}
+		for off := range keys {
+			record, err := log.ReadRecord(uint64(off))
+			if dead[uint64(off)] {
+				require.Error(t, err, "offset %d", off)
+				continue
+			}
+			require.NoError(t, err, "offset %d", off)
+			require.Equal(t, value(off), record.Value)
+		}
+
+		var scanned []uint64
+		require.NoError(t, log.Scan(func(off uint64, _ Record) error {
+			scanned = append(scanned, off)
+			return nil
+		}))
+		require.Equal(t, live, scanned)
+
+		var iterated []uint64
+		it := log.Iterator(0)
+		for {
+			_, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			iterated = append(iterated, it.Offset())
+		}
+		require.Equal(t, live, iterated)
+
+		ranged, err := log.ReadRange(0, 8, 1<<20)
+		require.NoError(t, err)
+		var offsets []uint64
+		for _, r := range ranged {
+			offsets = append(offsets, r.Offset)
+		}
+		require.Equal(t, []uint64{3, 5, 6, 7}, offsets)
+
+		// the frames of the holes are skipped when the stores are read as they are
+		var n int
+		require.NoError(t, readStores(log.Reader(), c, func([]byte) error {
+			n++
+			return nil
+		}))
+		require.Equal(t, len(live), n)
+
+		corruptions, err := log.Verify()
+		require.NoError(t, err)
+		require.Empty(t, corruptions, fmt.Sprint(corruptions))
+	}
+	check()
+
+	// there's nothing left to punch
+	require.NoError(t, log.Compact())
+	check()
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	check()
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(keys)), off)
+
+	// truncating into a hole keeps the offsets before it taken
+	require.NoError(t, log.truncateFrom(1))
+	off, err = log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	got, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, write, got)
+	_, err = log.Read(0)
+	require.Error(t, err)
+	corruptions, err := log.Verify()
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+}
+
+func TestPunchHolesWhileRead(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 8 * 8 << 10
+	c.Compaction.PunchHoles = true
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	value := bytes.Repeat([]byte("v"), 8<<10)
+	// every other record is kept
+	for i := range 17 {
+		key := []byte("a")
+		if i%2 == 1 {
+			key = []byte(fmt.Sprint(i))
+		}
+		_, err := log.AppendRecord(Record{Key: key, Value: value})
+		require.NoError(t, err)
+	}
+	before, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+
+	// the segments being read aren't punched, they're left for later
+	r := log.Reader()
+	require.NoError(t, log.Compact())
+	_, err = log.ReadRecord(0)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, before, got)
+	require.NoError(t, r.Close())
+
+	require.NoError(t, log.Compact())
+	_, err = log.ReadRecord(0)
+	require.Error(t, err)
+	corruptions, err := log.Verify()
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"context"
+)
+
+// RangeRecord is a record read by ReadRange along with its offset
+type RangeRecord struct {
+	Offset uint64
+	Record
+}
+
+// ReadRange returns the records from start up to end (exclusive), as many
+// of them as fit in maxBytes of the store, and at least one so the caller
+// always gets somewhere. the records come from the segment holding start
+// and are read from its store in one go, the caller reads on from the
+// offset after the last one. the records share the memory they were read into
+func (l *Log) ReadRange(start, end uint64, maxBytes int) ([]RangeRecord, error) {
+	if end <= start {
+		return nil, nil
+	}
+	l.mu.RLock()
+	for _, s := range l.segments {
+		if s.baseOffset <= start && start < s.nextOffset {
+			defer l.mu.RUnlock()
+			return s.readRange(start, end, maxBytes)
+		}
+	}
+	// tiered segments don't change, so they're read without the lock
+	t, ok := l.tieredFor(start)
+	l.mu.RUnlock()
+	if !ok {
+		return nil, &OffsetError{start, ErrOffsetOutOfRange}
+	}
+	s, err := l.fetchTiered(context.Background(), t)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.readRange(start, end, maxBytes)
+	if rerr := s.release(); err == nil {
+		err = rerr
+	}
+	return records, err
+}
+
+// readRange finds where the records start and end by walking the index,
+// then reads all of them with a single read of the store
+func (s *segment) readRange(start, end uint64, maxBytes int) ([]RangeRecord, error) {
+	if s.closed.Load() {
+		return nil, ErrSegmentClosed
+	}
+	var slot uint64
+	if start > s.baseOffset {
+		slot, _ = s.index.Floor(uint32(start - s.baseOffset))
+	}
+	var offsets, positions []uint64
+	last := s.store.size
+	err := s.walk(slot, func(o, p uint64) (bool, error) {
+		if o < start {
+			return false, nil
+		}
+		if o >= end || len(positions) > 0 && p-positions[0] > uint64(maxBytes) {
+			last = p
+			return true, nil
+		}
+		offsets, positions = append(offsets, o), append(positions, p)
+		return false, nil
+	})
+	if err != nil || len(positions) == 0 {
+		return nil, err
+	}
+	// the last record may not fit either, unless it's the only one
+	if len(positions) > 1 && last-positions[0] > uint64(maxBytes) {
+		last = positions[len(positions)-1]
+		offsets, positions = offsets[:len(offsets)-1], positions[:len(positions)-1]
+	}
+
+	b := make([]byte, last-positions[0])
+	if _, err = s.store.ReadAt(b, int64(positions[0])); err != nil {
+		return nil, err
+	}
+	records := make([]RangeRecord, len(positions))
+	for i, p := range positions {
+		meta, contents, err := s.store.splitFrame(b[p-positions[0]:])
+		if err != nil {
+			return nil, &OffsetError{offsets[i], err}
+		}
+		contents, err = unframe(meta, contents, p, s.store.verify, s.store.keys, s.store.dict)
+		if err != nil {
+			return nil, &OffsetError{offsets[i], err}
+		}
+		if records[i].Record, err = decodeRecord(contents); err != nil {
+			return nil, &OffsetError{offsets[i], err}
+		}
+		records[i].Offset = offsets[i]
+	}
+	return records, nil
+}
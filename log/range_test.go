@@ -0,0 +1,59 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "range_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 5
+	c.Segment.IndexInterval = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// offset 2 is left out, like compaction does
+	for _, off := range []uint64{0, 1, 3, 4, 5, 6, 7} {
+		require.NoError(t, log.appendAt(off, Record{Value: []byte{byte(off)}}))
+	}
+	size := func(off uint64) int {
+		return int(log.activeSegment.store.frameSize(len(encodeRecord(Record{
+			Value:     []byte{byte(off)},
+			Timestamp: log.Config.Clock(),
+		}))))
+	}
+	offsets := func(records []RangeRecord) []uint64 {
+		var offs []uint64
+		for _, r := range records {
+			require.Equal(t, []byte{byte(r.Offset)}, r.Value)
+			offs = append(offs, r.Offset)
+		}
+		return offs
+	}
+
+	records, err := log.ReadRange(1, 6, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3, 4, 5}, offsets(records))
+
+	records, err = log.ReadRange(2, 100, 2*size(0))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 4}, offsets(records))
+
+	// a record larger than maxBytes is still returned on its own
+	records, err = log.ReadRange(4, 100, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4}, offsets(records))
+
+	_, err = log.ReadRange(8, 100, 1<<20)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	records, err = log.ReadRange(5, 5, 1<<20)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
@@ -0,0 +1,168 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+// ErrReadOnly is returned by whatever would write to a log opened by OpenReadOnly
+var ErrReadOnly = errors.New("log: opened read-only")
+
+// OpenReadOnly opens the log in dir for reads only, to inspect it without
+// changing a byte of it. unlike NewLog it doesn't lock the directory or
+// recover what a crash left behind: a torn record at the end of a store and
+// the index entries past it are kept out of reach and reported by Verify,
+// the batches that were never committed are read like the other records
+// and the records missing from an index aren't indexed. an index that's
+// missing or doesn't check out fails the open, see RebuildIndexes.
+// nothing runs in the background, the tiered segments aren't loaded
+// and what would write to the log fails with ErrReadOnly
+func OpenReadOnly(dir string, c Config) (*Log, error) {
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = 1024
+	}
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	if _, ok := c.backend().(ReadOnlyBackend); !ok {
+		return nil, fmt.Errorf("%w: the storage backend can't open stores read-only", ErrReadOnly)
+	}
+	c.readOnly = true
+	if c.Logger != nil {
+		c.Logger = c.Logger.With(slog.String("dir", dir))
+	}
+	l := &Log{
+		Dir:    dir,
+		Config: c,
+		done:   make(chan struct{}),
+	}
+	l.tracer = newTracer(c)
+	l.metrics = newMetrics(func() float64 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return float64(len(l.segments))
+	})
+	var err error
+	if l.groups, err = newConsumerGroups(path.Join(dir, groupsFile)); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := c.backend().List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, off := range storeOffsets(names) {
+		s, err := newSegment(dir, off, c)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		s.setMetrics(l.metrics)
+		l.segments = append(l.segments, s)
+		l.activeSegment = s
+	}
+	if l.segments == nil {
+		return nil, fmt.Errorf("%w: no segments in %s", os.ErrNotExist, dir)
+	}
+	l.checkManifest(m)
+	if err = l.loadEpochs(); err == nil {
+		if err = l.loadProducers(); err == nil {
+			err = l.loadKeys()
+		}
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// openReadOnly opens the segment's store and index for OpenReadOnly
+func (s *segment) openReadOnly(storeName, indexName string) error {
+	storage, err := s.config.backend().(ReadOnlyBackend).OpenReadOnly(storeName)
+	if err != nil {
+		return err
+	}
+	if s.store, err = newStore(storage, s.config); err != nil {
+		storage.Close()
+		return err
+	}
+	indexFile, err := os.Open(indexName)
+	if os.IsNotExist(err) {
+		err = fmt.Errorf("%w: %s is missing", ErrCorruptIndex, indexName)
+	}
+	if err == nil {
+		if s.index, err = newIndex(indexFile, s.config); err == nil {
+			err = s.loadIndex()
+		}
+		if err != nil {
+			indexFile.Close()
+		}
+	}
+	if err != nil {
+		s.store.Close()
+		return err
+	}
+	return nil
+}
+
+// loadIndex is recoverIndex for a read-only segment, the index is set
+// straight in memory and nothing's rebuilt. the zeroed room of an index
+// that wasn't closed is left out, only the first entry of a store without
+// a version is all zeros, and so are the entries past the records
+func (s *segment) loadIndex() error {
+	entries := s.index.size / entWidth
+	for ; entries > 0; entries-- {
+		p := (entries - 1) * entWidth
+		if p == 0 && s.store.start == 0 || !bytes.Equal(s.index.mmap[p:p+entWidth], make([]byte, entWidth)) {
+			break
+		}
+	}
+	for ; entries > 0; entries-- {
+		_, pos, err := s.index.Read(int64(entries - 1))
+		if err != nil {
+			return err
+		}
+		if pos < s.store.size {
+			break
+		}
+		s.pastRecords++
+	}
+	s.index.Truncate(entries)
+	valid, err := s.validIndex()
+	if err != nil {
+		return err
+	}
+	if !valid || entries == 0 && s.store.size > s.store.start {
+		return fmt.Errorf("%w: %s doesn't match its store", ErrCorruptIndex, s.index.Name())
+	}
+
+	s.nextOffset = s.baseOffset
+	if entries == 0 {
+		return nil
+	}
+	if err = s.recoverIndexed(); err != nil {
+		return err
+	}
+	pos := s.store.size
+	if !s.indexedHole {
+		if pos, err = s.store.next(s.indexedPos); err != nil {
+			return err
+		}
+	}
+	// the records after the last entry are found from it either way
+	for s.nextOffset = s.indexedOff + 1; pos < s.store.size; s.nextOffset++ {
+		if pos, err = s.store.next(pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
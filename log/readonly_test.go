@@ -0,0 +1,77 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dirBytes reads every file of the directory
+func dirBytes(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = b
+	}
+	return files
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = log.Append([]byte("record"))
+		require.NoError(t, err)
+	}
+	// left open like it crashed, with a torn record at the end
+	require.NoError(t, log.Sync())
+	active := segmentPath(dir, log.activeSegment.baseOffset, ".store")
+	f, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 9, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before := dirBytes(t, dir)
+
+	ro, err := OpenReadOnly(dir, c)
+	require.NoError(t, err)
+	highest, err := ro.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), highest)
+	var n int
+	require.NoError(t, ro.Scan(func(_ uint64, record Record) error {
+		require.Equal(t, "record", string(record.Value))
+		n++
+		return nil
+	}))
+	require.Equal(t, 5, n)
+
+	corruptions, err := ro.Verify()
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.ErrorIs(t, corruptions[0], ErrCorruptRecord)
+
+	_, err = ro.Append([]byte("record"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, ro.Truncate(5), ErrReadOnly)
+	require.ErrorIs(t, ro.Commit("group", 1), ErrReadOnly)
+	require.NoError(t, ro.Close())
+	require.Equal(t, before, dirBytes(t, dir))
+	require.NoError(t, log.Close())
+
+	// an index that doesn't match its store fails the open
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.index"), []byte("garbage"), 0644))
+	_, err = OpenReadOnly(dir, c)
+	require.ErrorIs(t, err, ErrCorruptIndex)
+	_, err = OpenReadOnly(t.TempDir(), c)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,51 @@
+package log
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// RebuildIndexes throws away the indexes of the segments in dir and has
+// NewLog rebuild them from the stores, it returns how many it rebuilt.
+// opening the log already rebuilds the indexes that are missing or don't
+// check out, this is for the ones that look fine and still lead astray.
+// the gaps compaction left in a segment's offsets are lost, the records
+// left are numbered one after the other from the segment's base offset.
+// the tiered segments are left alone, and the log can't be open meanwhile
+func RebuildIndexes(dir string, c Config) (n int, err error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	names, err := c.backend().List(dir)
+	if err == nil {
+		for _, name := range names {
+			if path.Ext(name) != ".store" {
+				continue
+			}
+			indexName := path.Join(dir, strings.TrimSuffix(name, ".store")+".index")
+			if err = os.Remove(indexName); err != nil && !os.IsNotExist(err) {
+				break
+			}
+			err = nil
+			n++
+		}
+	}
+	if cerr := lock.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return 0, err
+	}
+	if err = l.Close(); err != nil {
+		return 0, err
+	}
+	l.Config.logger().Info("indexes rebuilt", "segments", n)
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildCorruptIndex(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err := log.Append([]byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	for name, corrupt := range map[string]func(){
+		// an entry in the middle points past the records
+		"bad entry": func() {
+			overwrite(t, filepath.Join(dir, "0.index"), entWidth+offWidth, bytes.Repeat([]byte{0xff}, 4))
+		},
+		// the entries are out of order
+		"swapped entries": func() {
+			b, err := os.ReadFile(filepath.Join(dir, "0.index"))
+			require.NoError(t, err)
+			overwrite(t, filepath.Join(dir, "0.index"), 0, append(b[entWidth:2*entWidth:2*entWidth], b[:entWidth]...))
+		},
+		// the index was cut short mid entry
+		"torn entry": func() {
+			require.NoError(t, os.Truncate(filepath.Join(dir, "4.index"), int64(entWidth+3)))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			corrupt()
+			var logged bytes.Buffer
+			c.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for off := uint64(0); off < 6; off++ {
+				read, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, []byte{byte(off)}, read)
+			}
+			off, err := log.Append([]byte{6})
+			require.NoError(t, err)
+			require.Equal(t, uint64(6), off)
+			require.NoError(t, log.truncateFrom(6))
+			require.NoError(t, log.Close())
+			if name != "torn entry" {
+				require.Contains(t, logged.String(), "rebuilding a corrupt index")
+			}
+		})
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err := log.Append([]byte{byte(i)})
+		require.NoError(t, err)
+	}
+
+	// the log is in use
+	_, err = RebuildIndexes(dir, c)
+	require.ErrorIs(t, err, ErrLocked)
+	require.NoError(t, log.Close())
+
+	// an index that checks out but points at the wrong records
+	b, err := os.ReadFile(filepath.Join(dir, "0.index"))
+	require.NoError(t, err)
+	overwrite(t, filepath.Join(dir, "0.index"), entWidth+offWidth, b[2*entWidth+offWidth:3*entWidth])
+	overwrite(t, filepath.Join(dir, "0.index"), 2*entWidth+offWidth, b[3*entWidth+offWidth:4*entWidth])
+	require.NoError(t, os.Truncate(filepath.Join(dir, "0.index"), int64(3*entWidth)))
+
+	n, err := RebuildIndexes(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for off := uint64(0); off < 6; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(off)}, read)
+	}
+}
@@ -0,0 +1,222 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrInvalidRecord is returned when stored bytes can't be decoded into
+	// a record, or for a record that can't be appended, e.g. a keyless delete
+	ErrInvalidRecord = errors.New("log: invalid record")
+	// ErrRecordTooLarge is returned when appending a record
+	// larger than Store.MaxRecordBytes
+	ErrRecordTooLarge = errors.New("log: record too large")
+)
+
+// Record is the unit written to the log
+// Key is optional, records with a key can be compacted
+// a keyed record with a nil Value is a tombstone for that key
+type Record struct {
+	Key   []byte
+	Value []byte
+	// Headers are optional metadata, e.g. to propagate tracing context
+	// with propagation.MapCarrier
+	Headers map[string]string
+	// Timestamp is when the record was appended, it's set from
+	// the log's clock unless the record already has one
+	Timestamp time.Time
+	// EventTime is optional and up to the producer
+	EventTime time.Time
+	// Epoch is the leader epoch the record was appended in, see SetEpoch
+	Epoch uint64
+}
+
+// IsTombstone tells whether the record marks its key as deleted
+func (r Record) IsTombstone() bool {
+	return r.Key != nil && r.Value == nil
+}
+
+const (
+	attrKey byte = 1 << iota
+	attrTombstone
+	attrTimestamp
+	attrEventTime
+	attrHeaders
+	// attrPending marks the records of an AppendAll batch but its last,
+	// so a batch cut short by a crash is told apart on open
+	attrPending
+	attrEpoch
+)
+
+const timeWidth = 8
+
+// encodeRecord lays out the record as attributes (1 byte),
+// [timestamp (8 bytes)], [event time (8 bytes)], [epoch (8 bytes)], [key length (uvarint), key],
+// [header count (uvarint), (name length (uvarint), name, value length (uvarint), value)...], value
+// times are unix nanoseconds, headers are sorted by name
+func encodeRecord(r Record) []byte {
+	var attrs byte
+	size := 1 + len(r.Value)
+	if !r.Timestamp.IsZero() {
+		attrs |= attrTimestamp
+		size += timeWidth
+	}
+	if !r.EventTime.IsZero() {
+		attrs |= attrEventTime
+		size += timeWidth
+	}
+	if r.Epoch != 0 {
+		attrs |= attrEpoch
+		size += timeWidth
+	}
+	if r.Key != nil {
+		attrs |= attrKey
+		size += binary.MaxVarintLen64 + len(r.Key)
+	}
+	if len(r.Headers) > 0 {
+		attrs |= attrHeaders
+		size += binary.MaxVarintLen64
+		for name, value := range r.Headers {
+			size += 2*binary.MaxVarintLen64 + len(name) + len(value)
+		}
+	}
+	if r.IsTombstone() {
+		attrs |= attrTombstone
+	}
+
+	b := make([]byte, 1, size)
+	b[0] = attrs
+	if attrs&attrTimestamp != 0 {
+		b = enc.AppendUint64(b, uint64(r.Timestamp.UnixNano()))
+	}
+	if attrs&attrEventTime != 0 {
+		b = enc.AppendUint64(b, uint64(r.EventTime.UnixNano()))
+	}
+	if attrs&attrEpoch != 0 {
+		b = enc.AppendUint64(b, r.Epoch)
+	}
+	if r.Key != nil {
+		b = appendBytes(b, r.Key)
+	}
+	if attrs&attrHeaders != 0 {
+		names := make([]string, 0, len(r.Headers))
+		for name := range r.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b = binary.AppendUvarint(b, uint64(len(names)))
+		for _, name := range names {
+			b = appendBytes(b, []byte(name))
+			b = appendBytes(b, []byte(r.Headers[name]))
+		}
+	}
+	return append(b, r.Value...)
+}
+
+// checkSize fails for an encoded record larger than Store.MaxRecordBytes
+func (c Config) checkSize(b []byte) error {
+	if max := c.Store.MaxRecordBytes; max > 0 && uint64(len(b)) > max {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrRecordTooLarge, len(b), max)
+	}
+	return nil
+}
+
+func decodeRecord(b []byte) (Record, error) {
+	var r Record
+	if len(b) == 0 {
+		return r, ErrInvalidRecord
+	}
+	attrs, b := b[0], b[1:]
+
+	var ok bool
+	if attrs&attrTimestamp != 0 {
+		if r.Timestamp, b, ok = decodeTime(b); !ok {
+			return r, ErrInvalidRecord
+		}
+	}
+	if attrs&attrEventTime != 0 {
+		if r.EventTime, b, ok = decodeTime(b); !ok {
+			return r, ErrInvalidRecord
+		}
+	}
+	if attrs&attrEpoch != 0 {
+		if len(b) < timeWidth {
+			return r, ErrInvalidRecord
+		}
+		r.Epoch, b = enc.Uint64(b), b[timeWidth:]
+	}
+	if attrs&attrKey != 0 {
+		if r.Key, b, ok = decodeBytes(b); !ok {
+			return r, ErrInvalidRecord
+		}
+	}
+	if attrs&attrHeaders != 0 {
+		n, w := binary.Uvarint(b)
+		// every header takes at least two bytes
+		if w <= 0 || n > uint64(len(b)-w)/2 {
+			return r, ErrInvalidRecord
+		}
+		b = b[w:]
+		r.Headers = make(map[string]string, n)
+		for ; n > 0; n-- {
+			var name, value []byte
+			if name, b, ok = decodeBytes(b); !ok {
+				return r, ErrInvalidRecord
+			}
+			if value, b, ok = decodeBytes(b); !ok {
+				return r, ErrInvalidRecord
+			}
+			r.Headers[string(name)] = string(value)
+		}
+	}
+	if attrs&attrTombstone == 0 {
+		r.Value = b
+	}
+	return r, nil
+}
+
+// recordKey returns the key of the encoded record without decoding
+// the rest of it, nil if it has none
+func recordKey(b []byte) []byte {
+	if len(b) == 0 || b[0]&attrKey == 0 {
+		return nil
+	}
+	attrs, b := b[0], b[1:]
+	for _, attr := range []byte{attrTimestamp, attrEventTime, attrEpoch} {
+		if attrs&attr != 0 {
+			if len(b) < timeWidth {
+				return nil
+			}
+			b = b[timeWidth:]
+		}
+	}
+	key, _, ok := decodeBytes(b)
+	if !ok {
+		return nil
+	}
+	return key
+}
+
+func decodeTime(b []byte) (time.Time, []byte, bool) {
+	if len(b) < timeWidth {
+		return time.Time{}, b, false
+	}
+	return time.Unix(0, int64(enc.Uint64(b))), b[timeWidth:], true
+}
+
+func appendBytes(b, field []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(field)))
+	return append(b, field...)
+}
+
+func decodeBytes(b []byte) (field, rest []byte, ok bool) {
+	n, w := binary.Uvarint(b)
+	if w <= 0 || uint64(len(b)-w) < n {
+		return nil, b, false
+	}
+	return b[w : w+int(n)], b[w+int(n):], true
+}
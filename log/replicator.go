@@ -0,0 +1,241 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultReplicatorBackoff     = time.Second
+	defaultReplicatorLagInterval = time.Second
+)
+
+// Replicator pulls the records of a leader over ConsumeStream and appends
+// them to the local log under the same offsets. it starts from the local
+// head and picks up from there again whenever the stream breaks. before
+// that, the records the local log has past the leader's end of its latest
+// epoch are truncated, they were never replicated by the leader and would
+// otherwise diverge from its log, see OffsetForEpoch
+type Replicator struct {
+	// Leader is the address of the server to pull from
+	Leader string
+	// DialOptions are used to connect to the leader, insecure if empty
+	DialOptions []grpc.DialOption
+	// Log is the local log the records are appended to
+	Log *Log
+	// Backoff is how long to wait before reconnecting, defaults to a second
+	Backoff time.Duration
+	// LagInterval is how often the leader's head is checked and the local
+	// head reported to it, see ReportReplica. defaults to a second
+	LagInterval time.Duration
+	// ID tells the follower apart when it reports to the leader,
+	// defaults to the host name and the directory of the log
+	ID string
+
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	leader  atomic.Uint64
+	checked atomic.Bool
+}
+
+// Start connects to the leader and replicates until Close is called
+func (r *Replicator) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return nil
+	}
+	if r.logger == nil {
+		r.logger = slog.Default().With("component", "replicator", "leader", r.Leader)
+	}
+	if r.ID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		r.ID = host + ":" + r.Log.Dir
+	}
+
+	opts := r.DialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(r.Leader, opts...)
+	if err != nil {
+		return err
+	}
+	client := api.NewLogClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(2)
+	go func() {
+		defer r.wg.Done()
+		defer conn.Close()
+		r.run(ctx, client)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.trackLag(ctx, client)
+	}()
+	return nil
+}
+
+func (r *Replicator) run(ctx context.Context, client api.LogClient) {
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = defaultReplicatorBackoff
+	}
+	for {
+		err := r.replicate(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Error("failed to replicate", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// replicate streams from the local head until the stream breaks
+func (r *Replicator) replicate(ctx context.Context, client api.LogClient) error {
+	if err := r.truncateDivergent(ctx, client); err != nil {
+		return err
+	}
+	// records the leader no longer has are skipped
+	off := r.Log.nextOffset()
+	offsets, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	if err != nil {
+		return err
+	}
+	off = max(off, offsets.LowestOffset)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: off})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if res.Record == nil {
+			return errors.New("leader sent an empty response")
+		}
+		record := Record{
+			Key:     res.Record.Key,
+			Value:   res.Record.Value,
+			Headers: res.Record.Headers,
+			Epoch:   res.Record.Epoch,
+		}
+		// the follower keeps the leader's timestamp, not its own
+		if res.Record.Timestamp != nil {
+			record.Timestamp = res.Record.Timestamp.AsTime()
+		}
+		if err = r.Log.appendAt(res.Record.Offset, record); err != nil {
+			return err
+		}
+	}
+}
+
+// truncateDivergent asks the leader where the local log's latest epoch
+// ends and drops the local records past it. leaders that don't keep
+// epochs are trusted to have the records the follower has
+func (r *Replicator) truncateDivergent(ctx context.Context, client api.LogClient) error {
+	if r.Log.nextOffset() == 0 {
+		return nil
+	}
+	res, err := client.OffsetForEpoch(ctx, &api.OffsetForEpochRequest{Epoch: r.Log.Epoch()})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the leader may not have the epoch itself, then it's the local end
+	// of the leader's epoch before it that both logs agree on
+	_, end, err := r.Log.EndOffsetForEpoch(res.Epoch)
+	if err != nil {
+		return err
+	}
+	end = min(end, res.EndOffset)
+	if next := r.Log.nextOffset(); end < next {
+		r.logger.Warn("truncating divergent records", "from", end, "to", next, "epoch", res.Epoch)
+		return r.Log.truncateFrom(end)
+	}
+	return nil
+}
+
+// trackLag keeps checking the leader's highest offset for Lag, and
+// reporting the local head to it in return for the high watermark
+func (r *Replicator) trackLag(ctx context.Context, client api.LogClient) {
+	interval := r.LagInterval
+	if interval <= 0 {
+		interval = defaultReplicatorLagInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// a failed check keeps the last known offset until the next tick
+		if offsets, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{}); err == nil {
+			r.leader.Store(offsets.HighestOffset)
+			r.checked.Store(true)
+		}
+		// leaders that don't track their replicas are left alone
+		res, err := client.ReportReplica(ctx, &api.ReportReplicaRequest{
+			Replica:    r.ID,
+			NextOffset: r.Log.nextOffset(),
+		})
+		if err == nil {
+			r.Log.setHighWatermark(res.HighWatermark)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lag is how many records the local log is behind the leader,
+// as of the last check of the leader's head
+func (r *Replicator) Lag() uint64 {
+	if !r.checked.Load() {
+		return 0
+	}
+	leader := r.leader.Load()
+	local, _ := r.Log.HighestOffset()
+	if leader <= local {
+		return 0
+	}
+	return leader - local
+}
+
+// Close stops replicating and waits for the replicator to be done
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	cancel := r.cancel
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		r.wg.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// leaderServer serves a log the way the server package does,
+// which can't be imported from here
+type leaderServer struct {
+	api.UnimplementedLogServer
+	log *Log
+}
+
+func (s *leaderServer) GetOffsets(context.Context, *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	lowest, _ := s.log.LowestOffset()
+	highest, _ := s.log.HighestOffset()
+	return &api.GetOffsetsResponse{LowestOffset: lowest, HighestOffset: highest}, nil
+}
+
+func (s *leaderServer) OffsetForEpoch(_ context.Context, req *api.OffsetForEpochRequest) (*api.OffsetForEpochResponse, error) {
+	epoch, end, err := s.log.EndOffsetForEpoch(req.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	return &api.OffsetForEpochResponse{Epoch: epoch, EndOffset: end}, nil
+}
+
+func (s *leaderServer) ReportReplica(_ context.Context, req *api.ReportReplicaRequest) (*api.ReportReplicaResponse, error) {
+	hw, err := s.log.ReportReplica(req.Replica, req.NextOffset)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ReportReplicaResponse{HighWatermark: hw}, nil
+}
+
+func (s *leaderServer) ConsumeStream(req *api.ConsumeRequest, stream grpc.ServerStreamingServer[api.ConsumeResponse]) error {
+	for off := req.Offset; ; {
+		record, err := s.log.ReadRecord(off)
+		if errors.Is(err, ErrOffsetCompacted) {
+			off++
+			continue
+		}
+		if errors.Is(err, ErrOffsetOutOfRange) {
+			select {
+			case <-stream.Context().Done():
+				return nil
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = stream.Send(&api.ConsumeResponse{Record: &api.Record{
+			Key:       record.Key,
+			Value:     record.Value,
+			Offset:    off,
+			Epoch:     record.Epoch,
+			Timestamp: timestamppb.New(record.Timestamp),
+		}})
+		if err != nil {
+			return err
+		}
+		off++
+	}
+}
+
+func newReplicatorLog(t *testing.T) *Log {
+	dir, err := os.MkdirTemp("", "replicator_test")
+	require.NoError(t, err)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Replication.Replicas = 3
+	c.Replication.Followers = []string{"follower"}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Remove() })
+	return log
+}
+
+// serveLeader serves the log over grpc and returns its address
+func serveLeader(t *testing.T, log *Log) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	api.RegisterLogServer(server, &leaderServer{log: log})
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	return l.Addr().String()
+}
+
+func TestReplicator(t *testing.T) {
+	leader, follower := newReplicatorLog(t), newReplicatorLog(t)
+
+	// the leader's offsets have a gap, like compaction leaves
+	require.NoError(t, leader.appendAt(0, Record{Key: []byte("a"), Value: write}))
+	require.NoError(t, leader.appendAt(3, Record{Key: []byte("b"), Value: write}))
+
+	r := &Replicator{
+		Leader:      serveLeader(t, leader),
+		Log:         follower,
+		ID:          "follower",
+		Backoff:     10 * time.Millisecond,
+		LagInterval: 10 * time.Millisecond,
+	}
+	require.NoError(t, r.Start())
+	defer r.Close()
+
+	caughtUp := func(off uint64) func() bool {
+		return func() bool {
+			highest, _ := follower.HighestOffset()
+			return highest == off && r.Lag() == 0
+		}
+	}
+	require.Eventually(t, caughtUp(3), 5*time.Second, 10*time.Millisecond)
+
+	// records coming in later are followed too
+	off, err := leader.AppendRecord(Record{Key: []byte("c"), Value: write})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	require.Eventually(t, caughtUp(4), 5*time.Second, 10*time.Millisecond)
+
+	for off, key := range map[uint64]string{0: "a", 3: "b", 4: "c"} {
+		record, err := follower.ReadRecord(off)
+		require.NoError(t, err)
+		require.Equal(t, key, string(record.Key))
+		require.Equal(t, write, record.Value)
+		appended, err := leader.ReadRecord(off)
+		require.NoError(t, err)
+		require.True(t, appended.Timestamp.Equal(record.Timestamp))
+	}
+	// the follower makes two of the leader's replicas, so everything's committed
+	require.Eventually(t, func() bool {
+		return leader.HighWatermark() == 5 && follower.HighWatermark() == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the gap is left out like on the leader
+	_, err = follower.ReadRecord(1)
+	require.Error(t, err)
+
+	// offsets the follower already has are refused
+	err = follower.appendAt(2, Record{Value: write})
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	require.NoError(t, r.Close())
+}
+
+func TestReplicatorTruncatesDivergence(t *testing.T) {
+	leader, follower := newReplicatorLog(t), newReplicatorLog(t)
+
+	// both logs have the first epoch's records up to 2, the follower led
+	// that epoch and has two more the new leader never got
+	for _, log := range []*Log{leader, follower} {
+		require.NoError(t, log.SetEpoch(1))
+		for i := 0; i < 3; i++ {
+			_, err := log.Append(write)
+			require.NoError(t, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		_, err := follower.Append([]byte("diverged"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, leader.SetEpoch(2))
+	_, err := leader.Append([]byte("new leader"))
+	require.NoError(t, err)
+
+	r := &Replicator{
+		Leader:      serveLeader(t, leader),
+		Log:         follower,
+		ID:          "follower",
+		Backoff:     10 * time.Millisecond,
+		LagInterval: 10 * time.Millisecond,
+	}
+	require.NoError(t, r.Start())
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		record, err := follower.ReadRecord(3)
+		return err == nil && string(record.Value) == "new leader"
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = follower.ReadRecord(4)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	require.Equal(t, uint64(2), follower.Epoch())
+}
@@ -0,0 +1,130 @@
+package log
+
+import (
+	"time"
+)
+
+const defaultRetentionInterval = time.Minute
+
+// retains tells whether any retention policy is configured
+func (c Config) retains() bool {
+	return c.Retention.MaxAge > 0 || c.Retention.MaxBytes > 0
+}
+
+// EnforceRetention removes the oldest segments whose newest record is older
+// than Retention.MaxAge, or while the log is bigger than Retention.MaxBytes
+// it stops at the first segment that's retained, so the log never has gaps
+// the active segment is never removed
+func (l *Log) EnforceRetention() error {
+	l.mu.RLock()
+	retains := l.Config.retains()
+	l.mu.RUnlock()
+	if !retains {
+		return nil
+	}
+	dropped, err := l.enforceRetention()
+	if onDrop := l.Config.Retention.OnDrop; onDrop != nil {
+		for _, s := range dropped {
+			onDrop(s.baseOffset, s.nextOffset-1)
+		}
+	}
+	return err
+}
+
+func (l *Log) enforceRetention() (dropped []*segment, err error) {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var size uint64
+	for _, s := range l.segments {
+		size += s.size()
+	}
+	maxBytes := l.Config.Retention.MaxBytes
+	cutoff := l.Config.Clock().Add(-l.Config.Retention.MaxAge)
+	for len(l.segments) > 1 {
+		s := l.segments[0]
+		expired := false
+		if l.Config.Retention.MaxAge > 0 {
+			newest, err := s.newestTimestamp()
+			if err != nil {
+				return dropped, err
+			}
+			// records without a timestamp are kept, their age is unknown
+			expired = !newest.IsZero() && newest.Before(cutoff)
+		}
+		if !expired && (maxBytes == 0 || size <= maxBytes) {
+			break
+		}
+
+		size -= s.size()
+		if err = s.Remove(); err != nil {
+			return dropped, err
+		}
+		l.segments = l.segments[1:]
+		if s.nextOffset > s.baseOffset {
+			dropped = append(dropped, s)
+		}
+	}
+	l.expireProducers(l.segments[0].baseOffset)
+	return dropped, nil
+}
+
+// SetRetention changes Retention.MaxAge and Retention.MaxBytes of the open
+// log, they're enforced from the next check of the retention on, which is
+// started if the log had no retention so far. zero turns them off
+func (l *Log) SetRetention(maxAge time.Duration, maxBytes uint64) {
+	l.mu.Lock()
+	l.Config.Retention.MaxAge = maxAge
+	l.Config.Retention.MaxBytes = maxBytes
+	retains := l.Config.retains()
+	l.mu.Unlock()
+
+	l.retentionMu.Lock()
+	defer l.retentionMu.Unlock()
+	if retains && !l.retaining && !l.stopped {
+		l.retaining = true
+		l.wg.Add(1)
+		go l.retentionLoop()
+	}
+}
+
+func (l *Log) retentionLoop() {
+	defer l.wg.Done()
+
+	interval := l.Config.Retention.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			// a failed run is simply retried on the next tick
+			if err := l.EnforceRetention(); err != nil {
+				l.Config.logger().Error("retention failed", "err", err)
+			}
+		}
+	}
+}
+
+// newestTimestamp is the timestamp of the segment's last record
+// zero if the segment is empty
+func (s *segment) newestTimestamp() (time.Time, error) {
+	if s.nextOffset == s.baseOffset {
+		return time.Time{}, nil
+	}
+	b, err := s.Read(s.nextOffset - 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	record, err := decodeRecord(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return record.Timestamp, nil
+}
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clock := now
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	c.Clock = func() time.Time { return clock }
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// every record maxes a segment, one a day
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+		clock = clock.Add(24 * time.Hour)
+	}
+	require.Len(t, log.segments, 4)
+
+	// retention is off by default
+	require.NoError(t, log.EnforceRetention())
+	require.Len(t, log.segments, 4)
+
+	log.Config.Retention.MaxAge = 36 * time.Hour
+	require.NoError(t, log.EnforceRetention())
+	requireLowestOffset(t, log, 2)
+	_, err = log.Read(1)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	// the active segment is kept no matter how old the log gets
+	clock = clock.Add(365 * 24 * time.Hour)
+	require.NoError(t, log.EnforceRetention())
+	require.Len(t, log.segments, 1)
+	requireLowestOffset(t, log, 3)
+}
+
+func TestRetentionWaitsForReaders(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clock := now
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	c.Retention.MaxAge = time.Hour
+	c.Clock = func() time.Time { return clock }
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	storeName := log.segments[0].store.Name()
+
+	reader := log.Reader()
+	clock = clock.Add(2 * time.Hour)
+	require.NoError(t, log.EnforceRetention())
+	require.Len(t, log.segments, 1)
+
+	// the removed segment is still readable until the reader is done with it
+	require.FileExists(t, storeName)
+	b, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	// both stores are preceded by their size, the active one is empty
+	require.Equal(t, 2*lenWidth+int(width)+1+timeWidth, len(b))
+	require.NoFileExists(t, storeName)
+	require.NoError(t, reader.Close())
+
+	// or until it's closed, if it isn't read to the end
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	storeName = log.segments[0].store.Name()
+	reader = log.Reader()
+	_, err = reader.Read(make([]byte, 1))
+	require.NoError(t, err)
+	clock = clock.Add(2 * time.Hour)
+	require.NoError(t, log.EnforceRetention())
+	require.FileExists(t, storeName)
+	require.NoError(t, reader.Close())
+	require.NoFileExists(t, storeName)
+}
+
+func TestRetentionMaxBytes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	type span struct{ first, last uint64 }
+	var dropped []span
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	c.Retention.OnDrop = func(first, last uint64) {
+		dropped = append(dropped, span{first, last})
+	}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	// four full segments and the empty active one
+	size := log.segments[0].size()
+	log.Config.Retention.MaxBytes = 2 * size
+	require.NoError(t, log.EnforceRetention())
+
+	require.Equal(t, []span{{0, 0}, {1, 1}}, dropped)
+	require.Len(t, log.segments, 3)
+	requireLowestOffset(t, log, 2)
+}
+
+func requireLowestOffset(t *testing.T, log *Log, want uint64) {
+	t.Helper()
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, want, lowest)
+}
+
+func TestSetRetention(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = width
+	c.Retention.Interval = time.Millisecond
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	// the log had no retention, the loop's started for it
+	log.SetRetention(0, 2*log.segments[0].size())
+	require.Eventually(t, func() bool {
+		lowest, err := log.LowestOffset()
+		return err == nil && lowest == 2
+	}, time.Second, time.Millisecond)
+
+	log.SetRetention(0, 0)
+	require.NoError(t, log.EnforceRetention())
+	requireLowestOffset(t, log, 2)
+}
@@ -0,0 +1,638 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSegmentClosed is returned for reads and appends that get to a segment
+// after it was closed, i.e. after the log was closed or the segment removed
+var ErrSegmentClosed = errors.New("log: segment closed")
+
+// segment wraps a store and an index together
+// records are written to the store, and their positions to the index
+type segment struct {
+	store      *store
+	index      *index
+	baseOffset uint64
+	nextOffset uint64
+	config     Config
+
+	// the last index entry, a sparse index only
+	// indexes a record every so often after it
+	indexedOff  uint64
+	indexedPos  uint64
+	indexedHole bool
+	// pastRecords is how many entries of a read-only segment point
+	// past its records, they're left out of the index
+	pastRecords uint64
+
+	// used by the sync policy to decide when to fsync
+	unsynced uint64
+	lastSync time.Time
+
+	// bloom filters the keys of a sealed segment, see keyFilter
+	bloomMu sync.Mutex
+	bloom   *bloomFilter
+
+	// refs counts the readers that hold on to the segment outside
+	// the log's lock, removing the segment waits until they're done
+	// so its files aren't unlinked mid-read
+	refMu   sync.Mutex
+	refs    int
+	removed bool
+	// retired is set once compaction swapped in another segment for
+	// this one, the last reader closes it then, see retire
+	retired bool
+	closed  atomic.Bool
+}
+
+func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	return openSegment(
+		segmentPath(dir, baseOffset, ".store"),
+		segmentPath(dir, baseOffset, ".index"),
+		baseOffset,
+		c,
+	)
+}
+
+func segmentPath(dir string, baseOffset uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ext))
+}
+
+// openSegment opens a segment from the given store and index files
+func openSegment(storeName, indexName string, baseOffset uint64, c Config) (*segment, error) {
+	s := &segment{
+		baseOffset: baseOffset,
+		config:     c,
+		lastSync:   time.Now(),
+	}
+
+	if c.readOnly {
+		return s, s.openReadOnly(storeName, indexName)
+	}
+	storage, err := c.backend().Open(storeName)
+	if err != nil {
+		return nil, err
+	}
+	if s.store, err = newStore(storage, c); err != nil {
+		return nil, err
+	}
+
+	indexFile, err := os.OpenFile(
+		indexName,
+		os.O_RDWR|os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.index, err = newIndex(indexFile, c); err != nil {
+		return nil, err
+	}
+
+	if err = s.recoverIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// recoverIndex drops the entries that don't point at a record, i.e. those
+// of records torn off the store and the zeroed room the index grew its file
+// by when it wasn't closed, and indexes the records after the last entry left.
+// an index that went missing or got corrupted is rebuilt that way too, the
+// offsets of a compacted segment are lost with it though, the store doesn't
+// keep them
+func (s *segment) recoverIndex() error {
+	logger := s.config.logger().With("base_offset", s.baseOffset)
+	entries := s.index.size / entWidth
+	// a torn entry at the end
+	s.index.Truncate(entries)
+	for n := entries; n > 0; n-- {
+		out, pos, err := s.index.Read(int64(n - 1))
+		if err != nil {
+			return err
+		}
+		valid := pos >= s.store.start && pos < s.store.size
+		if valid && n > 1 {
+			prevOut, prevPos, err := s.index.Read(int64(n - 2))
+			if err != nil {
+				return err
+			}
+			valid = out > prevOut && pos > prevPos
+		}
+		if valid {
+			break
+		}
+		s.index.Truncate(n - 1)
+	}
+	if dropped := entries - s.index.size/entWidth; dropped > 0 {
+		logger.Warn("dropped index entries past the records", "entries", dropped)
+	}
+	valid, err := s.validIndex()
+	if err != nil {
+		return err
+	}
+	if !valid {
+		logger.Warn("rebuilding a corrupt index", "entries", s.index.size/entWidth)
+		s.index.Truncate(0)
+	}
+
+	// the next offset follows the last record, which is either the last
+	// indexed one or comes after it in a sparse index.
+	// it isn't necessarily the number of entries, since compaction leaves gaps
+	s.nextOffset = s.baseOffset
+	if err := s.recoverIndexed(); err != nil {
+		return err
+	}
+	off, pos := s.baseOffset, s.store.start
+	if s.index.size > 0 {
+		next, err := s.store.next(s.indexedPos)
+		if err != nil {
+			return err
+		}
+		// a hole takes up the rest of the store, the records
+		// appended after it would have entries of their own
+		if s.indexedHole {
+			next = s.store.size
+		}
+		off, pos = s.indexedOff+1, next
+		s.nextOffset = off
+	}
+	kept := s.index.size
+	for ; pos < s.store.size; off++ {
+		// an index that's too small for the records leaves the rest unindexed
+		if s.needsEntry(off, pos) && s.index.isFull() {
+			s.nextOffset = off + 1
+		} else if err := s.indexRecord(off, pos); err != nil {
+			return err
+		}
+		next, err := s.store.next(pos)
+		if err != nil {
+			return err
+		}
+		pos = next
+	}
+	if added := (s.index.size - kept) / entWidth; added > 0 {
+		logger.Warn("indexed records missing from the index", "entries", added)
+	}
+	return nil
+}
+
+// validIndex tells whether the entries left point at the records in order,
+// the first one at the first record
+func (s *segment) validIndex() (bool, error) {
+	var prevOut uint32
+	var prevPos uint64
+	for n := int64(0); uint64(n) < s.index.size/entWidth; n++ {
+		out, pos, err := s.index.Read(n)
+		if err != nil {
+			return false, err
+		}
+		if pos >= s.store.size || n == 0 && pos != s.store.start ||
+			n > 0 && (out <= prevOut || pos <= prevPos) {
+			return false, nil
+		}
+		prevOut, prevPos = out, pos
+	}
+	return true, nil
+}
+
+// recoverIndexed picks up the last index entry to carry on from
+func (s *segment) recoverIndexed() error {
+	out, pos, err := s.index.Read(-1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, _, s.indexedHole, _ = s.index.entry(-1)
+	s.indexedOff, s.indexedPos = s.baseOffset+uint64(out), pos
+	return nil
+}
+
+func (s *segment) setMetrics(m *metrics) {
+	s.store.metrics = m
+}
+
+// Append writes the record to the segment and returns its offset
+func (s *segment) Append(record []byte) (offset uint64, err error) {
+	cur := s.nextOffset
+	if err = s.AppendAt(cur, record); err != nil {
+		return 0, err
+	}
+	return cur, nil
+}
+
+// AppendAt writes the record under the given absolute offset
+// which can't be below the next one
+func (s *segment) AppendAt(off uint64, record []byte) error {
+	if err := s.write(off, record); err != nil {
+		return err
+	}
+	if s.shouldSync() {
+		return s.Sync()
+	}
+	return nil
+}
+
+// AppendBatch writes as many of the records as the segment takes
+// before it gets maxed, and returns the number of records written
+// along with the offset of the first one
+func (s *segment) AppendBatch(records [][]byte) (n int, first uint64, err error) {
+	if s.closed.Load() {
+		return 0, 0, ErrSegmentClosed
+	}
+	if s.config.readOnly {
+		return 0, 0, ErrReadOnly
+	}
+	if n = s.fitting(records); n == 0 {
+		return 0, 0, io.EOF
+	}
+
+	positions, err := s.store.AppendBatch(records[:n])
+	if err != nil {
+		return 0, 0, err
+	}
+	first = s.nextOffset
+	for i, pos := range positions {
+		if err = s.indexRecord(first+uint64(i), pos); err != nil {
+			return 0, 0, err
+		}
+	}
+	s.unsynced += uint64(n)
+
+	if s.shouldSync() {
+		if err = s.Sync(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return n, first, nil
+}
+
+// write stores the record under the given absolute offset
+// which must be higher than the offset of any record already in the segment
+func (s *segment) write(off uint64, record []byte) error {
+	if s.closed.Load() {
+		return ErrSegmentClosed
+	}
+	if s.config.readOnly {
+		return ErrReadOnly
+	}
+	// records past the last entry are picked up from the store
+	// so one mustn't be appended without the entry it needs
+	if s.needsEntry(off, s.store.size) && s.index.isFull() {
+		return io.EOF
+	}
+	_, pos, err := s.store.Append(record)
+	if err != nil {
+		return err
+	}
+
+	if err = s.indexRecord(off, pos); err != nil {
+		return err
+	}
+	s.unsynced++
+	return nil
+}
+
+// indexRecord indexes the record at pos, unless the index is sparse
+// and it's not yet time for another entry. a record that doesn't follow
+// the one before it always gets an entry, so the records between
+// two entries are the ones right after the first
+func (s *segment) indexRecord(off, pos uint64) error {
+	if s.needsEntry(off, pos) {
+		// index offsets are relative to the base offset
+		if err := s.index.Write(uint32(off-s.baseOffset), pos); err != nil {
+			return err
+		}
+		s.indexedOff, s.indexedPos, s.indexedHole = off, pos, false
+	}
+	s.nextOffset = off + 1
+	return nil
+}
+
+func (s *segment) needsEntry(off, pos uint64) bool {
+	c := s.config.Segment
+	dense := c.IndexInterval <= 1 && c.IndexIntervalBytes == 0
+	// the records after a hole are only found from their own entry
+	return dense || s.index.size == 0 || off != s.nextOffset || s.indexedHole ||
+		c.IndexInterval > 1 && off-s.indexedOff >= c.IndexInterval ||
+		c.IndexIntervalBytes > 0 && pos-s.indexedPos >= c.IndexIntervalBytes
+}
+
+func (s *segment) shouldSync() bool {
+	switch s.config.Sync.Policy {
+	case SyncEveryWrite:
+		return true
+	case SyncEveryNRecords:
+		return s.unsynced >= s.config.Sync.EveryN
+	case SyncEveryInterval:
+		return time.Since(s.lastSync) >= s.config.Sync.Interval
+	default:
+		return false
+	}
+}
+
+// Sync commits both the store and the index to disk
+func (s *segment) Sync() error {
+	if err := errors.Join(s.store.Sync(), s.index.Sync()); err != nil {
+		s.config.logger().Error("sync failed", "base_offset", s.baseOffset, "err", err)
+		return err
+	}
+	s.unsynced = 0
+	s.lastSync = time.Now()
+	return nil
+}
+
+// commit is Sync for group commits, it leaves the policy's counters alone
+// so it can be called without the log's lock
+func (s *segment) commit() error {
+	if err := errors.Join(s.store.syncShared(), s.index.Sync()); err != nil {
+		s.config.logger().Error("sync failed", "base_offset", s.baseOffset, "err", err)
+		return err
+	}
+	return nil
+}
+
+// Read returns the record at the given absolute offset
+func (s *segment) Read(off uint64) ([]byte, error) {
+	return s.ReadInto(off, nil)
+}
+
+// ReadInto is Read, reading into buf if it's big enough
+func (s *segment) ReadInto(off uint64, buf []byte) ([]byte, error) {
+	b, _, err := s.readInto(off, buf)
+	return b, err
+}
+
+// readInto is ReadInto, returning the record's position in the store too
+func (s *segment) readInto(off uint64, buf []byte) ([]byte, uint64, error) {
+	if s.closed.Load() {
+		return nil, 0, ErrSegmentClosed
+	}
+	pos, err := s.locate(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := s.store.ReadInto(pos, buf)
+	return b, pos, err
+}
+
+// locate returns the position of the record at the given absolute offset
+func (s *segment) locate(off uint64) (uint64, error) {
+	slot, ok := s.index.Floor(uint32(off - s.baseOffset))
+	if !ok {
+		return 0, io.EOF
+	}
+	var pos uint64
+	found := false
+	err := s.walk(slot, func(o, p uint64) (bool, error) {
+		if o == off {
+			pos, found = p, true
+		}
+		return o >= off, nil
+	})
+	if err == nil && !found {
+		err = io.EOF
+	}
+	return pos, err
+}
+
+// walk calls fn with the offset and position of every record from the
+// index entry at slot on, until fn returns true. the records between
+// entries are found by following the store's headers from the entry
+// before them, a dense index never needs to. holes are skipped
+func (s *segment) walk(slot uint64, fn func(off, pos uint64) (bool, error)) error {
+	n := s.index.size / entWidth
+	for ; slot < n; slot++ {
+		out, pos, hole, err := s.index.entry(int64(slot))
+		if err != nil {
+			return err
+		}
+		// nothing's left of the records of a hole
+		if hole {
+			continue
+		}
+		// the records of this entry end where the next entry's begin
+		off, nextOff, end := s.baseOffset+uint64(out), s.nextOffset, s.store.size
+		if slot+1 < n {
+			o, p, err := s.index.Read(int64(slot + 1))
+			if err != nil {
+				return err
+			}
+			nextOff, end = s.baseOffset+uint64(o), p
+		}
+		for {
+			if stop, err := fn(off, pos); stop || err != nil {
+				return err
+			}
+			if off++; off == nextOff {
+				break
+			}
+			if pos, err = s.store.next(pos); err != nil {
+				return fmt.Errorf("%w: %d", err, off)
+			}
+			if pos >= end {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// scan calls fn with every record of the segment in offset order
+func (s *segment) scan(fn func(off uint64, record []byte) error) error {
+	return s.walk(0, func(off, pos uint64) (bool, error) {
+		record, err := s.store.Read(pos)
+		if err != nil {
+			return false, &OffsetError{off, err}
+		}
+		return false, fn(off, record)
+	})
+}
+
+// truncateFrom drops the records from the given absolute offset on
+func (s *segment) truncateFrom(off uint64) error {
+	if s.config.readOnly {
+		return ErrReadOnly
+	}
+	if off <= s.baseOffset {
+		off = s.baseOffset
+	}
+	// start from the entry before, to find the record the segment ends with
+	var start uint64
+	if slot, ok := s.index.Floor(uint32(off - s.baseOffset)); ok && slot > 0 {
+		start = slot - 1
+	}
+	next, pos, found := s.baseOffset, uint64(0), false
+	err := s.walk(start, func(o, p uint64) (bool, error) {
+		if o >= off {
+			pos, found = p, true
+			return true, nil
+		}
+		next = o + 1
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	n := s.index.size / entWidth
+	slot := uint64(sort.Search(int(n), func(j int) bool {
+		out, _, _ := s.index.Read(int64(j))
+		return s.baseOffset+uint64(out) >= off
+	}))
+	// walk skips holes, one from off on goes too and one before
+	// it keeps its offsets taken
+	if slot < n {
+		_, p, hole, err := s.index.entry(int64(slot))
+		if err != nil {
+			return err
+		}
+		if hole && (!found || p < pos) {
+			pos, found = p, true
+		}
+	}
+	if slot > 0 {
+		out, _, hole, err := s.index.entry(int64(slot - 1))
+		if err != nil {
+			return err
+		}
+		if hole {
+			next = max(next, s.baseOffset+uint64(out)+1)
+		}
+	}
+	if !found {
+		return nil
+	}
+	if err = s.store.Truncate(pos); err != nil {
+		return err
+	}
+	s.index.Truncate(slot)
+	s.nextOffset = next
+	// the segment may be appended to again
+	s.setKeyFilter(nil)
+	return s.recoverIndexed()
+}
+
+// IsMaxed tells whether the segment has reached its max size
+// either by store bytes or by index bytes, so it's time to rotate
+func (s *segment) IsMaxed() bool {
+	return s.isMaxed(s.store.size, s.index.size)
+}
+
+// size is how many bytes the segment takes up on disk
+// without the room the index grew its file by for the mmap
+func (s *segment) size() uint64 {
+	return s.store.size + s.index.size
+}
+
+// the index is maxed once there's no room left for another entry
+func (s *segment) isMaxed(storeSize, indexSize uint64) bool {
+	return storeSize >= s.config.Segment.MaxStoreBytes ||
+		indexSize+entWidth > s.config.Segment.MaxIndexBytes
+}
+
+// fitting is how many of the records the segment takes before it gets maxed
+// same as appending one by one: a record is accepted
+// as long as the segment isn't maxed before it
+func (s *segment) fitting(records [][]byte) (n int) {
+	storeSize, indexSize := s.store.size, s.index.size
+	for n < len(records) && !s.isMaxed(storeSize, indexSize) {
+		storeSize += s.store.frameSize(len(records[n]))
+		indexSize += entWidth
+		n++
+	}
+	return n
+}
+
+// Remove closes the segment and removes its files
+// or has the last of its readers do it once they're done
+func (s *segment) Remove() error {
+	if s.config.readOnly {
+		return ErrReadOnly
+	}
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	s.removed = true
+	if s.refs > 0 {
+		return nil
+	}
+	return s.remove()
+}
+
+// acquire keeps the segment from being removed until it's released
+func (s *segment) acquire() {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	s.refs++
+}
+
+func (s *segment) release() error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	s.refs--
+	switch {
+	case s.refs == 0 && s.removed:
+		return s.remove()
+	case s.refs == 0 && s.retired:
+		return s.Close()
+	}
+	return nil
+}
+
+// retire closes the segment or has the last of its readers do it once
+// they're done. its files are left alone, they're the ones of the segment
+// that replaced it by then, the readers keep reading the replaced ones
+// through the files they have open
+func (s *segment) retire() error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	s.retired = true
+	if s.refs > 0 {
+		return nil
+	}
+	return s.Close()
+}
+
+// busy is whether readers hold on to the segment
+func (s *segment) busy() bool {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.refs > 0
+}
+
+// remove closes the segment and removes its files, the store goes first,
+// since a segment is discovered by its store file. an index left behind
+// by a crash in between is cleaned up on setup
+func (s *segment) remove() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := s.config.backend().Remove(s.store.Name()); err != nil {
+		return err
+	}
+	return os.Remove(s.index.Name())
+}
+
+func (s *segment) Close() error {
+	s.closed.Store(true)
+	// don't lose whatever the policy hasn't synced yet
+	if s.config.Sync.Policy != SyncNever && s.unsynced > 0 {
+		if err := s.store.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := s.index.Close(); err != nil {
+		return err
+	}
+	return s.store.Close()
+}
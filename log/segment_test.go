@@ -0,0 +1,212 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "segment_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = entWidth * 3
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), s.nextOffset)
+	require.False(t, s.IsMaxed())
+
+	for i := uint64(0); i < 3; i++ {
+		off, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, 16+i, off)
+
+		got, err := s.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, write, got)
+	}
+
+	// index is full
+	_, err = s.Append(write)
+	require.Error(t, err)
+	require.True(t, s.IsMaxed())
+	require.NoError(t, s.Close())
+
+	// maxed store
+	c.Segment.MaxStoreBytes = uint64(len(write) * 3)
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(19), s.nextOffset)
+	require.True(t, s.IsMaxed())
+	got, err := s.Read(18)
+	require.NoError(t, err)
+	require.Equal(t, write, got)
+
+	require.NoError(t, s.Remove())
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.False(t, s.IsMaxed())
+	require.NoError(t, s.Close())
+}
+
+func TestSegmentTornWrite(t *testing.T) {
+	dir, err := os.MkdirTemp("", "segment_torn_write_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.Append(write)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	// the last record was indexed but only made it halfway to disk
+	require.NoError(t, os.Truncate(s.store.Name(), int64(2*width+5)))
+
+	s, err = newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, uint64(2), s.nextOffset)
+	_, err = s.Read(2)
+	require.Error(t, err)
+
+	off, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, write, got)
+}
+
+func TestSegmentSyncPolicy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "segment_sync_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+	c.Sync.Policy = SyncEveryNRecords
+	c.Sync.EveryN = 2
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), s.unsynced)
+	// nothing reached the file yet, it's all in the buffer
+	fi, err := os.Stat(s.store.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), fi.Size())
+
+	_, err = s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), s.unsynced)
+	fi, err = os.Stat(s.store.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(width*2), fi.Size())
+
+	s.config.Sync.Policy = SyncEveryWrite
+	_, err = s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), s.unsynced)
+}
+
+func TestSegmentSparseIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "segment_sparse_index_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.IndexInterval = 4
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	for i := uint64(0); i < 10; i++ {
+		off, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, 16+i, off)
+	}
+	// 16, 20 and 24
+	require.Equal(t, 3*entWidth, s.index.size)
+
+	requireRecords := func(s *segment, from, to uint64) {
+		t.Helper()
+		for off := from; off < to; off++ {
+			got, err := s.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, write, got)
+		}
+		_, err := s.Read(to)
+		require.Error(t, err)
+	}
+	requireRecords(s, 16, 26)
+
+	// the records past the last entry are found on reopening
+	require.NoError(t, s.Close())
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(26), s.nextOffset)
+	require.Equal(t, uint64(10), s.store.records)
+	requireRecords(s, 16, 26)
+
+	// down to the middle of an entry's records
+	require.NoError(t, s.truncateFrom(22))
+	require.Equal(t, uint64(22), s.nextOffset)
+	require.Equal(t, 2*entWidth, s.index.size)
+	require.Equal(t, uint64(6), s.store.records)
+	requireRecords(s, 16, 22)
+	off, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(22), off)
+	requireRecords(s, 16, 23)
+
+	// records after a gap always get an entry of their own
+	require.NoError(t, s.write(30, write))
+	require.NoError(t, s.write(31, write))
+	require.Equal(t, 3*entWidth, s.index.size)
+	_, err = s.Read(25)
+	require.Error(t, err)
+	requireRecords(s, 30, 32)
+
+	var offs []uint64
+	require.NoError(t, s.scan(func(off uint64, _ []byte) error {
+		offs = append(offs, off)
+		return nil
+	}))
+	require.Equal(t, []uint64{16, 17, 18, 19, 20, 21, 22, 30, 31}, offs)
+	pos, err := s.seek(25)
+	require.NoError(t, err)
+	require.Equal(t, 7*width, pos)
+	require.NoError(t, s.Close())
+
+	// or an entry every so many bytes
+	c.Segment.IndexInterval = 0
+	c.Segment.IndexIntervalBytes = 3 * width
+	s, err = newSegment(dir, 100, c)
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		_, err := s.Append(write)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3*entWidth, s.index.size)
+	requireRecords(s, 100, 107)
+	require.NoError(t, s.Remove())
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoSpace is returned by appends when the disk has less than
+// Space.MinFreeBytes left or the log is at its Space.MaxBytes quota
+var ErrNoSpace = errors.New("log: no space left")
+
+const (
+	// the free space of the disk is asked for this often, what's appended
+	// in between is taken off the last answer
+	spaceCheckInterval = time.Second
+	// how often an append waiting for room checks again
+	spaceWaitInterval = 100 * time.Millisecond
+)
+
+// limitsSpace tells whether appends are checked for room
+func (c Config) limitsSpace() bool {
+	return c.Space.MinFreeBytes > 0 || c.Space.MaxBytes > 0
+}
+
+// diskSpace keeps track of the free space of the log's disk
+type diskSpace struct {
+	mu      sync.Mutex
+	checked time.Time
+	free    uint64
+}
+
+// take returns the free space before n more bytes are written
+// known tells whether the platform can tell the free space at all
+func (d *diskSpace) take(dir string, n uint64) (free uint64, known bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if time.Since(d.checked) >= spaceCheckInterval {
+		if d.free, known, err = diskFree(dir); err != nil || !known {
+			return 0, known, err
+		}
+		d.checked = time.Now()
+	}
+	free = d.free
+	d.free -= min(n, d.free)
+	return free, true, nil
+}
+
+// reserveSpace makes sure n more bytes can be appended, waiting up to
+// Space.Wait for the retention or a truncation to make room, or until ctx is done
+func (l *Log) reserveSpace(ctx context.Context, n uint64) error {
+	if !l.Config.limitsSpace() {
+		return nil
+	}
+	deadline := time.Now().Add(l.Config.Space.Wait)
+	for {
+		err := l.hasSpace(n)
+		left := time.Until(deadline)
+		if !errors.Is(err, ErrNoSpace) || left <= 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(left, spaceWaitInterval)):
+		}
+	}
+}
+
+func (l *Log) hasSpace(n uint64) error {
+	if quota := l.Config.Space.MaxBytes; quota > 0 {
+		l.mu.RLock()
+		var size uint64
+		for _, s := range l.segments {
+			size += s.size()
+		}
+		l.mu.RUnlock()
+		if size+n > quota {
+			return fmt.Errorf("%w: the log takes %d of its %d bytes", ErrNoSpace, size, quota)
+		}
+	}
+	if minFree := l.Config.Space.MinFreeBytes; minFree > 0 {
+		free, known, err := l.space.take(l.Dir, n)
+		if err != nil {
+			return err
+		}
+		if known && free < minFree+n {
+			return fmt.Errorf("%w: %d bytes free on the disk, %d have to stay free", ErrNoSpace, free, minFree)
+		}
+	}
+	return nil
+}
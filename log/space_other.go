@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package log
+
+// diskFree can't tell the free space here, so it isn't checked
+func diskFree(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
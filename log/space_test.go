@@ -0,0 +1,64 @@
+package log
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpaceQuota(t *testing.T) {
+	dir, err := os.MkdirTemp("", "space_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Space.MaxBytes = 3 * (width + entWidth)
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	_, err = log.Append(write)
+	require.ErrorIs(t, err, ErrNoSpace)
+	_, _, err = log.AppendBatch([][]byte{write})
+	require.ErrorIs(t, err, ErrNoSpace)
+
+	// a waiting append goes through once a truncation makes room
+	log.Config.Space.Wait = 5 * time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		log.Truncate(2)
+	}()
+	off, err := log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func TestSpaceMinFree(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the free space is only checked on linux and darwin")
+	}
+	dir, err := os.MkdirTemp("", "space_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Space.MinFreeBytes = math.MaxUint64 / 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(write)
+	require.ErrorIs(t, err, ErrNoSpace)
+
+	log.Config.Space.MinFreeBytes = 1
+	_, err = log.Append(write)
+	require.NoError(t, err)
+}
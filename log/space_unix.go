@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package log
+
+import "golang.org/x/sys/unix"
+
+// diskFree is how many bytes of the disk holding dir are available
+func diskFree(dir string) (uint64, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true, nil
+}
@@ -0,0 +1,107 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+var _ raft.StableStore = (*stableStore)(nil)
+
+// raft tells a missing key apart by this exact message
+var errKeyNotFound = errors.New("not found")
+
+// stableStore keeps raft's few bits of state (current term, last vote)
+// in a single file that's atomically replaced on every update
+type stableStore struct {
+	mu   sync.Mutex
+	path string
+	kv   map[string][]byte
+}
+
+func newStableStore(path string) (*stableStore, error) {
+	s := &stableStore{
+		path: path,
+		kv:   make(map[string][]byte),
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &s.kv); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *stableStore) Set(key, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.kv[string(key)] = append([]byte(nil), val...)
+	return s.persist()
+}
+
+func (s *stableStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok := s.kv[string(key)]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	return val, nil
+}
+
+func (s *stableStore) SetUint64(key []byte, val uint64) error {
+	b := make([]byte, 8)
+	enc.PutUint64(b, val)
+	return s.Set(key, b)
+}
+
+func (s *stableStore) GetUint64(key []byte) (uint64, error) {
+	b, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, ErrInvalidRecord
+	}
+	return enc.Uint64(b), nil
+}
+
+func (s *stableStore) persist() error {
+	b, err := json.Marshal(s.kv)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// writeFileAtomic writes to a temporary file first
+// so a crash never leaves a half written file behind
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
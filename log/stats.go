@@ -0,0 +1,58 @@
+package log
+
+import "time"
+
+// Stats is a snapshot of the log for monitoring it, see Log.Stats
+type Stats struct {
+	// SegmentCount is the number of local segments, Segments describes them
+	SegmentCount int
+	Segments     []SegmentInfo
+	// Bytes is what the stores and indexes of the local segments take up
+	Bytes uint64
+	// LowestOffset and HighestOffset are those of LowestOffset
+	// and HighestOffset, the tiered segments included
+	LowestOffset  uint64
+	HighestOffset uint64
+	// Records counts the records of the local segments, it's less than
+	// the offsets they span once compaction dropped some
+	Records uint64
+	// LastAppend is when a record was last appended, it's the timestamp of
+	// the last record if none was since the log was opened, zero if it's empty
+	LastAppend time.Time
+	// Replicas are the lags of the followers of a leader, see ReplicaLags
+	Replicas []ReplicaLag
+}
+
+// Stats returns the log's stats
+func (l *Log) Stats() (Stats, error) {
+	st := Stats{Segments: l.Segments()}
+	st.SegmentCount = len(st.Segments)
+	for _, s := range st.Segments {
+		st.Bytes += s.StoreBytes + s.IndexBytes
+		st.Records += s.Records
+	}
+	var err error
+	if st.LowestOffset, err = l.LowestOffset(); err != nil {
+		return Stats{}, err
+	}
+	if st.HighestOffset, err = l.HighestOffset(); err != nil {
+		return Stats{}, err
+	}
+	if nanos := l.lastAppend.Load(); nanos != 0 {
+		st.LastAppend = time.Unix(0, nanos)
+	} else if l.nextOffset() > st.LowestOffset {
+		// LastAppend stays zero if compaction dropped the last record
+		if record, err := l.ReadRecord(st.HighestOffset); err == nil {
+			st.LastAppend = record.Timestamp
+		}
+	}
+	st.Replicas = l.ReplicaLags()
+	return st, nil
+}
+
+// observeAppend records that n records were appended, started at start
+func (l *Log) observeAppend(n int, start time.Time) {
+	l.lastAppend.Store(l.Config.Clock().UnixNano())
+	l.metrics.observeAppend(n, start)
+	l.appended.notify()
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	c := Config{Clock: func() time.Time { return now }}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	st, err := log.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, st.SegmentCount)
+	require.Equal(t, uint64(0), st.Records)
+	require.True(t, st.LastAppend.IsZero())
+
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+	now = now.Add(time.Minute)
+	_, _, err = log.AppendBatch([][]byte{write})
+	require.NoError(t, err)
+
+	st, err = log.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 2, st.SegmentCount)
+	require.Len(t, st.Segments, 2)
+	require.Equal(t, uint64(4), st.Segments[1].BaseOffset)
+	require.Equal(t, uint64(3), st.Segments[1].Records)
+	require.Equal(t, uint64(7), st.Records)
+	require.Equal(t, uint64(0), st.LowestOffset)
+	require.Equal(t, uint64(6), st.HighestOffset)
+	var bytes uint64
+	for _, s := range st.Segments {
+		bytes += s.StoreBytes + s.IndexBytes
+	}
+	require.Equal(t, bytes, st.Bytes)
+	require.True(t, now.Equal(st.LastAppend))
+
+	// after a restart it's that of the last record
+	require.NoError(t, log.Close())
+	now = now.Add(time.Hour)
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	st, err = log.Stats()
+	require.NoError(t, err)
+	require.True(t, now.Add(-time.Hour).Equal(st.LastAppend))
+	require.Equal(t, uint64(7), st.Records)
+}
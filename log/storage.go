@@ -0,0 +1,109 @@
+package log
+
+import (
+	"io"
+	"os"
+)
+
+// Storage holds the bytes of a store, writes always append to it while
+// reads can be anywhere in what was written. the store buffers the
+// writes and flushes them before every read that needs them
+type Storage interface {
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	// Name is what the storage was opened by
+	Name() string
+	Size() (int64, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// StorageBackend opens the storages of the stores by name and manages
+// them, the names are paths into the log's directory, e.g. dir/0.store.
+// the indexes are files in the directory either way
+type StorageBackend interface {
+	// Open opens the storage, it's created if it doesn't exist
+	Open(name string) (Storage, error)
+	// Remove returns an error wrapping os.ErrNotExist for a missing storage
+	Remove(name string) error
+	Rename(from, to string) error
+	// List returns the base names of the storages in dir
+	List(dir string) ([]string, error)
+}
+
+// ReadOnlyBackend is a StorageBackend that can open a storage for reads
+// only, without creating it, which OpenReadOnly takes
+type ReadOnlyBackend interface {
+	OpenReadOnly(name string) (Storage, error)
+}
+
+// FileBackend keeps every store in a file of its own, it's the default
+type FileBackend struct{}
+
+func (FileBackend) Open(name string) (Storage, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage{f}, nil
+}
+
+func (FileBackend) OpenReadOnly(name string) (Storage, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage{f}, nil
+}
+
+func (FileBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (FileBackend) Rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+func (FileBackend) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// fileStorage is a store file, opened for appends or only for reads
+type fileStorage struct {
+	*os.File
+}
+
+func (f fileStorage) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// file is the store's file with the default backend, what needs a file
+// descriptor is only done with one: preallocation, direct io, io_uring
+// writes and mapped reads, as well as punching holes and sendfile copies
+func (s *store) file() (*os.File, bool) {
+	f, ok := s.Storage.(fileStorage)
+	return f.File, ok
+}
+
+// backend is Store.Backend, or the files by default
+func (c Config) backend() StorageBackend {
+	if c.Store.Backend == nil {
+		return FileBackend{}
+	}
+	return c.Store.Backend
+}
@@ -0,0 +1,159 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memBackend keeps the stores in memory, the contents outlive the
+// storages opened on them like files do
+type memBackend struct {
+	mu    sync.Mutex
+	files map[string]*[]byte
+}
+
+func (b *memBackend) Open(name string) (Storage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.files == nil {
+		b.files = make(map[string]*[]byte)
+	}
+	if b.files[name] == nil {
+		b.files[name] = new([]byte)
+	}
+	return &memStorage{name: name, b: b, data: b.files[name]}, nil
+}
+
+func (b *memBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.files[name] == nil {
+		return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	delete(b.files, name)
+	return nil
+}
+
+func (b *memBackend) Rename(from, to string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.files[from] == nil {
+		return fmt.Errorf("%s: %w", from, os.ErrNotExist)
+	}
+	b.files[to] = b.files[from]
+	delete(b.files, from)
+	return nil
+}
+
+func (b *memBackend) List(dir string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name := range b.files {
+		if filepath.Dir(name) == filepath.Clean(dir) {
+			names = append(names, path.Base(name))
+		}
+	}
+	return names, nil
+}
+
+type memStorage struct {
+	name string
+	b    *memBackend
+	data *[]byte
+}
+
+func (s *memStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if off >= int64(len(*s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, (*s.data)[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *memStorage) Write(p []byte) (int, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	*s.data = append(*s.data, p...)
+	return len(p), nil
+}
+
+func (s *memStorage) Size() (int64, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return int64(len(*s.data)), nil
+}
+
+func (s *memStorage) Truncate(size int64) error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	*s.data = (*s.data)[:size]
+	return nil
+}
+
+func (s *memStorage) Name() string { return s.name }
+func (s *memStorage) Sync() error  { return nil }
+func (s *memStorage) Close() error { return nil }
+
+func TestStorageBackend(t *testing.T) {
+	dir := t.TempDir()
+	backend := &memBackend{}
+	c := Config{}
+	c.Store.Backend = backend
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	records := []Record{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("1")},
+		{Key: []byte("a"), Value: []byte("2")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte("1")},
+	}
+	for _, record := range records {
+		_, err = log.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 2)
+	require.NoError(t, log.Compact())
+	_, err = log.ReadRecord(0)
+	require.Error(t, err)
+
+	// none of the stores are files
+	matches, err := filepath.Glob(path.Join(dir, "*.store"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	check := func(log *Log) {
+		t.Helper()
+		for off := uint64(2); off < uint64(len(records)); off++ {
+			record, err := log.ReadRecord(off)
+			require.NoError(t, err)
+			require.Equal(t, records[off].Value, record.Value)
+		}
+	}
+	check(log)
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	check(log)
+
+	require.NoError(t, log.truncateFrom(3))
+	_, err = log.ReadRecord(3)
+	require.Error(t, err)
+	require.NoError(t, log.Remove())
+	require.Empty(t, backend.files)
+}
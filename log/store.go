@@ -0,0 +1,682 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tysonmote/gommap"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	enc = binary.BigEndian
+
+	// castagnoli polynomial (crc32c) has hardware support on most cpus
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrCorruptRecord is returned when the checksum of a record
+	// doesn't match the checksum stored in its header
+	ErrCorruptRecord = errors.New("log: corrupt record")
+)
+
+const (
+	// a header is appended to the buffer
+	// then the actual contents of the record is written
+	// because while reading, we'll need to read haeder first, then the contents
+	// the header is uint64 (hence 8 bytes) showing length of the record
+	// followed by uint32 (hence 4 bytes) crc32c checksum of the contents
+	// a byte for the compression codec of the contents
+	// and uint32 (hence 4 bytes) id of the key the contents are encrypted with
+	// with varint framing the length is a uvarint instead, see Framing
+	lenWidth        = 8
+	crcWidth        = 4
+	codecWidth      = 1
+	keyIDWidth      = 4
+	metaWidth       = crcWidth + codecWidth + keyIDWidth
+	headerSizeBytes = lenWidth + metaWidth
+)
+
+// offsets of the header fields that follow the length
+const (
+	crcPos   = 0
+	codecPos = crcPos + crcWidth
+	keyIDPos = codecPos + codecWidth
+)
+
+// store frames the records of a Storage, a file by default
+type store struct {
+	Storage
+	mu   sync.RWMutex
+	buf  storeWriter
+	size uint64
+	// start is where the first record goes, past the version byte
+	start uint64
+	// records is how many records the store holds
+	records uint64
+	// flushed is the size of the store at the last flush, records
+	// before it are in the file and can be read without flushing
+	flushed atomic.Uint64
+	// mapped is set with Config.Store.MmapReads and covers what's flushed
+	mapped    gommap.MMap
+	mmapReads bool
+	maxBytes  uint64
+	framing   Framing
+	verify    bool
+	codec     Compression
+	keys      KeyProvider
+	// dict is the store's dictionary for CompressionZstdDict, and
+	// dictFrame the frame it's kept in, between the version and start
+	dict      *zstdDict
+	dictFrame []byte
+	// readOnly stores leave what's past the last complete frame,
+	// torn is how many bytes that is
+	readOnly bool
+	torn     uint64
+
+	metrics *metrics
+	tracer  trace.Tracer
+}
+
+func newStore(f Storage, c Config) (*store, error) {
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		Storage: f,
+		buf:     bufio.NewWriter(f),
+		verify:  !c.Store.SkipChecksumVerify,
+		codec:   c.Store.Compression,
+		keys:    c.Store.KeyProvider,
+		tracer:  newTracer(c),
+
+		maxBytes: c.Segment.MaxStoreBytes,
+		readOnly: c.readOnly,
+	}
+	file, isFile := s.file()
+	s.mmapReads = c.Store.MmapReads && isFile
+	// existing stores keep the framing they were created with
+	if size == 0 && c.readOnly {
+		s.framing = c.Store.Framing
+	} else if size == 0 {
+		if c.Store.Preallocate && isFile {
+			if err = preallocate(file, c.Segment.MaxStoreBytes); err != nil {
+				return nil, err
+			}
+		}
+		if s.size, err = writeVersion(f, c.Store.Framing); err != nil {
+			return nil, err
+		}
+		s.framing = c.Store.Framing
+	} else if s.framing, s.size, err = readVersion(f); err != nil {
+		return nil, err
+	} else if err = s.loadDict(uint64(size)); err != nil {
+		return nil, err
+	}
+	s.start = s.size
+	if s.size, err = s.recoverSize(s.start, uint64(size)); err != nil {
+		return nil, err
+	}
+	if torn := uint64(size) - s.size; torn > 0 && c.readOnly {
+		s.torn = torn
+	} else if torn > 0 {
+		c.logger().Warn("dropped a partly written record", "store", f.Name(), "bytes", torn)
+	}
+	s.flushed.Store(s.size)
+	if err = s.mapTo(s.size); err != nil {
+		return nil, err
+	}
+	switch {
+	case c.readOnly:
+	case c.Store.DirectIO && isFile:
+		s.buf, err = newDirectWriter(file, s.size)
+	case c.Store.IOUring && isFile:
+		s.buf, err = newURingWriter(file, s.size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// recoverSize walks the frame headers from pos and returns where the last complete
+// frame ends, a crash mid-write leaves a partial frame past that
+// which is truncated away, so appends carry on from a clean tail.
+// a read-only store keeps it. the records are counted along the way
+func (s *store) recoverSize(pos, fileSize uint64) (uint64, error) {
+	header := make([]byte, binary.MaxVarintLen64+metaWidth)
+	for pos < fileSize {
+		n, _, w, err := s.readHeader(pos, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		// records are never empty, a zero length is the padding
+		// a crash can leave past the end of a direct write
+		if n == 0 || n > fileSize-pos-w {
+			break
+		}
+		pos += w + n
+		s.records++
+	}
+	if pos < fileSize && !s.readOnly {
+		if err := s.Storage.Truncate(int64(pos)); err != nil {
+			return 0, err
+		}
+	}
+	return pos, nil
+}
+
+func (s *store) Append(record []byte) (uint64, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.append(record)
+}
+
+// AppendBatch writes all the records under a single lock acquisition
+// and returns the position of each of them, the frames of the batch
+// are handed to the buffer in one go
+func (s *store) AppendBatch(records [][]byte) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	frames := getFrames()
+	defer putFrames(frames)
+	positions := make([]uint64, 0, len(records))
+	sizes := make([]uint64, 0, len(records))
+	for _, record := range records {
+		n := len(*frames)
+		var err error
+		if *frames, err = s.frame(*frames, s.size+uint64(n), record); err != nil {
+			return nil, err
+		}
+		positions = append(positions, s.size+uint64(n))
+		sizes = append(sizes, uint64(len(*frames)-n))
+	}
+	if err := s.write(*frames); err != nil {
+		return nil, err
+	}
+	for _, size := range sizes {
+		s.metrics.observeWrite(size)
+	}
+	s.size += uint64(len(*frames))
+	s.records += uint64(len(records))
+	return positions, nil
+}
+
+func (s *store) append(record []byte) (uint64, uint64, error) {
+	// write to the end of the file
+	pos := s.size
+
+	frames := getFrames()
+	defer putFrames(frames)
+	var err error
+	if *frames, err = s.frame(*frames, pos, record); err != nil {
+		return 0, 0, err
+	}
+	if err = s.write(*frames); err != nil {
+		return 0, 0, err
+	}
+	w := uint64(len(*frames))
+	s.size += w
+	s.records++
+	s.metrics.observeWrite(w)
+	return w, pos, nil
+}
+
+// frame appends the frame of the record, which goes at pos, to dst
+func (s *store) frame(dst []byte, pos uint64, record []byte) ([]byte, error) {
+	// contents are compressed before framing
+	// so the length and the checksum are of what's actually on disk
+	codec := s.codec
+	if codec == CompressionZstdDict && s.dict == nil {
+		codec = CompressionZstd
+	}
+	record, err := compress(codec, record, s.dict)
+	if err != nil {
+		return nil, err
+	}
+
+	// then encrypted, compressing encrypted bytes wouldn't get us anywhere
+	var keyID uint32
+	if s.keys != nil {
+		var key []byte
+		if keyID, key, err = currentKey(s.keys); err != nil {
+			return nil, err
+		}
+		if record, err = encrypt(key, record, frameAAD(byte(codec), keyID, pos)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the length of the record, its checksum (4 bytes),
+	// its codec (1 byte) and its key id (4 bytes) before the content
+	dst = appendLength(dst, s.framing, uint64(len(record)))
+	n := len(dst)
+	dst = append(dst, make([]byte, metaWidth)...)
+	meta := dst[n:]
+	enc.PutUint32(meta[crcPos:codecPos], crc32.Checksum(record, crcTable))
+	meta[codecPos] = byte(codec)
+	enc.PutUint32(meta[keyIDPos:], keyID)
+	return append(dst, record...), nil
+}
+
+// write hands the frames to the buffer with a single write. frames that
+// don't fit what's left of it go after what's buffered is written out, so
+// they reach the file whole, in one write of their own
+func (s *store) write(frames []byte) error {
+	if b, ok := s.buf.(interface{ Available() int }); ok && len(frames) > b.Available() && s.buf.Buffered() > 0 {
+		if err := s.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := s.buf.Write(frames)
+	return err
+}
+
+// the frames of the appends are put together in pooled buffers,
+// the ones grown past maxPooledFrames aren't kept
+const maxPooledFrames = 1 << 20
+
+var framePool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 4<<10)
+		return &b
+	},
+}
+
+func getFrames() *[]byte {
+	return framePool.Get().(*[]byte)
+}
+
+func putFrames(b *[]byte) {
+	if cap(*b) > maxPooledFrames {
+		return
+	}
+	*b = (*b)[:0]
+	framePool.Put(b)
+}
+
+func (s *store) Read(pos uint64) ([]byte, error) {
+	return s.ReadInto(pos, nil)
+}
+
+// ReadInto is Read, reading the record into buf if it's big enough
+// the returned record points into buf unless it had to be decompressed
+func (s *store) ReadInto(pos uint64, buf []byte) ([]byte, error) {
+	// flush the writer buffer, in case we’re about to try to read a record
+	// that the buffer hasn’t flushed to disk yet
+	if err := s.flushTo(pos + 1); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// read the length of the content
+	// to know how many bytes we need to read
+	header := headerPool.Get().(*[]byte)
+	defer headerPool.Put(header)
+	n, meta, w, err := s.readHeader(pos, *header)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the actual contents
+	contents := buf[:0]
+	if uint64(cap(buf)) < n {
+		contents = make([]byte, n)
+	}
+	contents = contents[:n]
+	if _, err := s.readAt(contents, int64(pos+w)); err != nil {
+		return nil, err
+	}
+
+	return unframe(meta, contents, pos, s.verify, s.keys, s.dict)
+}
+
+// next returns the position of the record that follows the one at pos
+func (s *store) next(pos uint64) (uint64, error) {
+	if err := s.flushTo(pos + 1); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	header := headerPool.Get().(*[]byte)
+	defer headerPool.Put(header)
+	n, _, w, err := s.readHeader(pos, *header)
+	if err != nil {
+		return 0, err
+	}
+	return pos + w + n, nil
+}
+
+// headers are read into pooled buffers, long enough for either framing
+var headerPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, binary.MaxVarintLen64+metaWidth)
+		return &b
+	},
+}
+
+// readHeader reads the header of the frame at pos and returns
+// the length of the contents, the fields that follow it and the header's size
+// readHeader reads the header of the record at pos into header
+func (s *store) readHeader(pos uint64, header []byte) (n uint64, meta []byte, w uint64, err error) {
+	if s.framing == FramingFixed {
+		header = header[:headerSizeBytes]
+		if _, err = s.readAt(header, int64(pos)); err != nil {
+			return 0, nil, 0, err
+		}
+		return enc.Uint64(header[:lenWidth]), header[lenWidth:], headerSizeBytes, nil
+	}
+
+	// the length takes a varying number of bytes, so read as many
+	// as the longest header takes, it's fine to hit the end of the file
+	header = header[:binary.MaxVarintLen64+metaWidth]
+	k, err := s.readAt(header, int64(pos))
+	if err != nil && (err != io.EOF || k == 0) {
+		return 0, nil, 0, err
+	}
+	n, l := binary.Uvarint(header[:k])
+	if l <= 0 || k < l+metaWidth {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	return n, header[l : l+metaWidth], uint64(l + metaWidth), nil
+}
+
+// splitFrame splits the frame at the start of b into the header fields
+// that follow the length and the contents, like readHeader does from the file
+func (s *store) splitFrame(b []byte) (meta, contents []byte, err error) {
+	var n uint64
+	var w int
+	if s.framing == FramingFixed {
+		if len(b) < lenWidth {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		n, w = enc.Uint64(b[:lenWidth]), lenWidth
+	} else if n, w = binary.Uvarint(b); w <= 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if len(b) < w+metaWidth || uint64(len(b)-w-metaWidth) < n {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	end := w + metaWidth + int(n)
+	return b[w : w+metaWidth], b[w+metaWidth : end : end], nil
+}
+
+// holeCodec marks the frames of the holes punched in a store, see
+// punchSegment, they have no record in them
+const holeCodec = 0xff
+
+// dictCodec marks the frame of a store's dictionary, see CompressionZstdDict
+const dictCodec = 0xfe
+
+// posCodec marks the frame Log.CopyTo puts before a section that doesn't
+// start with the first record, its contents are the position of the next
+// frame in its store
+const posCodec = 0xfd
+
+// errHole is returned for a hole, readers that go through the index never see them
+var errHole = errors.New("log: hole in the store")
+
+// unframe verifies, decrypts and decompresses the contents of the frame
+// at pos according to the header fields that follow their length,
+// d is the dictionary of the store they're from
+func unframe(meta, contents []byte, pos uint64, verify bool, keys KeyProvider, d *zstdDict) ([]byte, error) {
+	if meta[codecPos] == holeCodec {
+		return nil, errHole
+	}
+	contents, err := openFrame(meta, contents, pos, verify, keys)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(Compression(meta[codecPos]), contents, d)
+}
+
+// openFrame verifies and decrypts the contents, what's
+// left is compressed the way their codec says
+func openFrame(meta, contents []byte, pos uint64, verify bool, keys KeyProvider) ([]byte, error) {
+	// make sure the contents weren't corrupted on disk
+	if verify && crc32.Checksum(contents, crcTable) != enc.Uint32(meta[crcPos:codecPos]) {
+		return nil, ErrCorruptRecord
+	}
+
+	if keyID := enc.Uint32(meta[keyIDPos:]); keyID != 0 {
+		if keys == nil {
+			return nil, ErrNoKeyProvider
+		}
+		key, err := keys.Key(keyID &^ boundKeyBit)
+		if err != nil {
+			return nil, err
+		}
+		var aad []byte
+		if keyID&boundKeyBit != 0 {
+			aad = frameAAD(meta[codecPos], keyID, pos)
+		}
+		if contents, err = decrypt(key, contents, aad); err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+// writeDict puts the dictionary at the start of an empty store, it's
+// encrypted like the records are so the samples it was trained on aren't
+// on disk in the clear. the records appended after it are compressed with it
+func (s *store) writeDict(raw []byte) error {
+	d, err := newZstdDict(raw)
+	if err != nil {
+		return err
+	}
+	contents := raw
+	var keyID uint32
+	if s.keys != nil {
+		var key []byte
+		if keyID, key, err = currentKey(s.keys); err != nil {
+			return err
+		}
+		// the store's empty, the dictionary goes right after the version
+		if contents, err = encrypt(key, contents, frameAAD(dictCodec, keyID, s.size)); err != nil {
+			return err
+		}
+	}
+	meta := make([]byte, metaWidth)
+	enc.PutUint32(meta[crcPos:codecPos], crc32.Checksum(contents, crcTable))
+	meta[codecPos] = dictCodec
+	enc.PutUint32(meta[keyIDPos:], keyID)
+	frame := append(appendLength(nil, s.framing, uint64(len(contents))), meta...)
+	frame = append(frame, contents...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.buf.Write(frame); err != nil {
+		return err
+	}
+	s.size += uint64(len(frame))
+	s.start = s.size
+	s.dict, s.dictFrame = d, frame
+	return nil
+}
+
+// loadDict reads the dictionary at the start of the store if it has one,
+// when it's opened. a dictionary that was torn off is left to recoverSize
+func (s *store) loadDict(fileSize uint64) error {
+	pos := s.size
+	header := make([]byte, binary.MaxVarintLen64+metaWidth)
+	n, meta, w, err := s.readHeader(pos, header)
+	if err != nil || meta[codecPos] != dictCodec || pos+w+n > fileSize {
+		return nil
+	}
+	frame := make([]byte, w+n)
+	if _, err = s.readAt(frame, int64(pos)); err != nil {
+		return err
+	}
+	raw, err := openFrame(meta, frame[w:], pos, true, s.keys)
+	if err != nil {
+		return fmt.Errorf("%w: dictionary of %s", err, s.Name())
+	}
+	if s.dict, err = newZstdDict(raw); err != nil {
+		return err
+	}
+	s.dictFrame = frame
+	s.size += w + n
+	return nil
+}
+
+func (s *store) ReadAt(b []byte, off int64) (int, error) {
+	if err := s.flushTo(uint64(off) + uint64(len(b))); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readAt(b, off)
+}
+
+// flushTo makes sure everything before end is in the file
+// readers only take the write lock when what they read is still buffered
+func (s *store) flushTo(end uint64) error {
+	if end <= s.flushed.Load() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// Flush writes the buffer to the file, so it can be read from directly
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// flush writes the buffer to the file, the caller holds the lock
+func (s *store) flush() error {
+	// the buffer may have flushed itself when it filled up, but only
+	// an explicit flush is sure to leave whole records in the file
+	if s.buf.Buffered() == 0 {
+		if err := s.mapTo(s.size); err != nil {
+			return err
+		}
+		s.flushed.Store(s.size)
+		return nil
+	}
+	// flushes happen under the store's lock wherever they're needed
+	// so they're traced as spans of their own
+	_, span := s.tracer.Start(context.Background(), "store.flush", trace.WithAttributes(
+		attribute.Int("vsdlog.bytes", s.buf.Buffered()),
+	))
+	defer s.metrics.observeFlush(time.Now())
+	err := s.buf.Flush()
+	if err == nil {
+		err = s.mapTo(s.size)
+	}
+	endSpan(span, err)
+	if err == nil {
+		s.flushed.Store(s.size)
+	}
+	return err
+}
+
+// Truncate drops everything from the given position on
+func (s *store) Truncate(pos uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	// the pages past the new end are gone, so they're unmapped too
+	if err := s.unmap(); err != nil {
+		return err
+	}
+	if err := s.Storage.Truncate(int64(pos)); err != nil {
+		return err
+	}
+	s.size = pos
+	s.flushed.Store(pos)
+	if err := s.mapTo(pos); err != nil {
+		return err
+	}
+	// count what's left
+	s.records = 0
+	if _, err := s.recoverSize(s.start, pos); err != nil {
+		return err
+	}
+	if r, ok := s.buf.(resetter); ok {
+		return r.reset(pos)
+	}
+	return nil
+}
+
+// Sync flushes the buffer and commits the file contents to disk
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// some writers fsync along with their flush
+	if w, ok := s.buf.(syncWriter); ok {
+		if err := w.FlushSync(); err != nil {
+			return err
+		}
+		if err := s.mapTo(s.size); err != nil {
+			return err
+		}
+		s.flushed.Store(s.size)
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.Storage.Sync()
+}
+
+// syncShared is Sync, the fsync is done without the store's lock
+// so that appends can go on meanwhile
+func (s *store) syncShared() error {
+	s.mu.Lock()
+	if _, ok := s.buf.(syncWriter); ok {
+		s.mu.Unlock()
+		return s.Sync()
+	}
+	err := s.flush()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.Storage.Sync()
+}
+
+func (s *store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.unmap(); err != nil {
+		return err
+	}
+	if c, ok := s.buf.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return s.Storage.Close()
+}
@@ -0,0 +1,607 @@
+package log
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	write = []byte("hello world")
+	width = uint64(len(write) + headerSizeBytes)
+)
+
+func TestStoreAppendRead(t *testing.T) {
+	f, err := os.CreateTemp("", "store_append_read_test")
+	require.NoError(t, err)
+	defer os.Remove(t.Name())
+
+	s, err := newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+
+	testAppend(t, s)
+	testRead(t, s)
+
+	s, err = newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	testRead(t, s)
+}
+
+func testAppend(t *testing.T, s *store) {
+	t.Helper()
+	for i := uint64(1); i < 4; i++ {
+		n, pos, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, pos+n, width*i)
+	}
+}
+
+func testRead(t *testing.T, s *store) {
+	t.Helper()
+	var pos uint64
+	for i := uint64(1); i < 4; i++ {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+		pos += width
+	}
+}
+
+// countingStorage records the size of every write to the storage
+type countingStorage struct {
+	Storage
+	writes []int
+}
+
+func (s *countingStorage) Write(p []byte) (int, error) {
+	s.writes = append(s.writes, len(p))
+	return s.Storage.Write(p)
+}
+
+func TestStoreWrites(t *testing.T) {
+	storage := &countingStorage{Storage: &memStorage{name: "0.store", b: &memBackend{}, data: new([]byte)}}
+	s, err := newStore(storage, Config{})
+	require.NoError(t, err)
+	storage.writes = nil
+
+	// a record too big for the buffer goes in a write of its own, in one piece
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	large := bytes.Repeat([]byte("x"), 8<<10)
+	_, pos, err := s.Append(large)
+	require.NoError(t, err)
+	require.Equal(t, []int{int(width), int(s.frameSize(len(large)))}, storage.writes)
+
+	// and so do the frames of a batch
+	storage.writes = nil
+	batch := make([][]byte, 512)
+	for i := range batch {
+		batch[i] = write
+	}
+	positions, err := s.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, []int{len(batch) * int(width)}, storage.writes)
+
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, large, read)
+	for _, pos := range positions {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	f, err := os.CreateTemp("", "store_close_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	s, err := newStore(fileStorage{f}, Config{})
+
+	require.NoError(t, err)
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+
+	f, beforeSize, err := openFile(f.Name())
+	require.NoError(t, err)
+
+	err = s.Close()
+	require.NoError(t, err)
+
+	_, afterSize, err := openFile(f.Name())
+	require.NoError(t, err)
+	require.True(t, afterSize > beforeSize)
+}
+
+func openFile(name string) (file *os.File, size int64, err error) {
+	f, err := os.OpenFile(
+		name,
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+func TestStoreCorruptRecord(t *testing.T) {
+	f, err := os.CreateTemp("", "store_corrupt_record_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	_, err = s.Read(pos)
+	require.NoError(t, err)
+
+	// flip a byte of the contents behind the store's back
+	_, err = f.WriteAt([]byte{'H'}, int64(pos+headerSizeBytes))
+	require.NoError(t, err)
+
+	_, err = s.Read(pos)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+
+	c := Config{}
+	c.Store.SkipChecksumVerify = true
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello world"), read)
+}
+
+func TestStoreFlushedReads(t *testing.T) {
+	f, err := os.CreateTemp("", "store_flushed_reads_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	_, first, err := s.Append(write)
+	require.NoError(t, err)
+	_, err = s.Read(first)
+	require.NoError(t, err)
+	require.Equal(t, width, s.flushed.Load())
+
+	// reading what's already in the file leaves the buffer alone
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	_, err = s.Read(first)
+	require.NoError(t, err)
+	require.NotZero(t, s.buf.Buffered())
+
+	// and reading what isn't flushes it
+	_, err = s.Read(width)
+	require.NoError(t, err)
+	require.Zero(t, s.buf.Buffered())
+	require.Equal(t, 2*width, s.flushed.Load())
+
+	// readers and the writer can run at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				read, err := s.Read(first)
+				require.NoError(t, err)
+				require.Equal(t, write, read)
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		_, pos, err := s.Append(write)
+		require.NoError(t, err)
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	wg.Wait()
+}
+
+func TestStoreReadInto(t *testing.T) {
+	f, err := os.CreateTemp("", "store_read_into_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, s.Flush())
+
+	buf := make([]byte, 64)
+	var read []byte
+	allocs := testing.AllocsPerRun(100, func() {
+		read, err = s.ReadInto(pos, buf)
+	})
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	require.Zero(t, allocs)
+}
+
+func TestStoreMmapReads(t *testing.T) {
+	f, err := os.CreateTemp("", "store_mmap_reads_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	// appends after the truncation need to go to the end of the file
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Store.MmapReads = true
+	c.Segment.MaxStoreBytes = 1024
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.NotNil(t, s.mapped)
+
+	// past the max store bytes, so the store is remapped as it grows
+	n := 2*os.Getpagesize()/int(width) + 1
+	for i := 0; i < n; i++ {
+		_, pos, err := s.Append(write)
+		require.NoError(t, err)
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	require.GreaterOrEqual(t, uint64(len(s.mapped)), s.size)
+
+	b := make([]byte, width)
+	_, err = s.ReadAt(b, int64(width))
+	require.NoError(t, err)
+	_, err = s.ReadAt(b, int64(s.size-width/2))
+	require.Equal(t, io.EOF, err)
+
+	require.NoError(t, s.Truncate(width))
+	_, err = s.Read(width)
+	require.Error(t, err)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	require.NoError(t, s.Close())
+	require.Nil(t, s.mapped)
+}
+
+func TestStoreTornWrite(t *testing.T) {
+	f, err := os.CreateTemp("", "store_torn_write_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	testAppend(t, s)
+	require.NoError(t, s.Sync())
+
+	// a crash in the middle of the next record leaves its header
+	// without all of its contents
+	header := make([]byte, headerSizeBytes)
+	enc.PutUint64(header, uint64(len(write)))
+	_, err = f.Write(append(header, write[:3]...))
+	require.NoError(t, err)
+
+	// appends go to the end of the file, as with the segment's files
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	require.Equal(t, width*3, s.size)
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(width*3), fi.Size())
+
+	testRead(t, s)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, width*3, pos)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
+func TestStoreVarintFraming(t *testing.T) {
+	f, err := os.CreateTemp("", "store_varint_framing_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.Framing = FramingVarint
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+
+	// a version byte, then a byte of length instead of 8 per record
+	varintWidth := width - lenWidth + 1
+	var positions []uint64
+	for i := uint64(0); i < 3; i++ {
+		n, pos, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, varintWidth, n)
+		require.Equal(t, 1+i*varintWidth, pos)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, s.Sync())
+
+	// a torn write is cut off with varint framing too
+	_, err = f.Write([]byte{byte(len(write)), 0, 0})
+	require.NoError(t, err)
+
+	// the store keeps its framing whatever the config says
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	require.Equal(t, FramingVarint, s.framing)
+	require.Equal(t, 1+3*varintWidth, s.size)
+
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	for _, pos := range append(positions, pos) {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+
+	b := make([]byte, s.size)
+	_, err = s.ReadAt(b, 0)
+	require.NoError(t, err)
+	var records [][]byte
+	err = readStores(bytes.NewReader(append(enc.AppendUint64(nil, s.size), b...)), c, func(record []byte) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{write, write, write, write}, records)
+}
+
+func TestStoreCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("compress me please "), 100)
+
+	for _, codec := range []Compression{
+		CompressionNone,
+		CompressionSnappy,
+		CompressionLZ4,
+		CompressionZstd,
+	} {
+		f, err := os.CreateTemp("", "store_compression_test")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		c := Config{}
+		c.Store.Compression = codec
+		s, err := newStore(fileStorage{f}, c)
+		require.NoError(t, err)
+
+		n, pos, err := s.Append(data)
+		require.NoError(t, err)
+		if codec != CompressionNone {
+			require.Less(t, n, uint64(len(data)), "codec %d", codec)
+		}
+
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+
+		// records are decompressed with their own codec
+		// regardless of the one the store is configured with
+		s, err = newStore(fileStorage{f}, Config{})
+		require.NoError(t, err)
+		read, err = s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	}
+}
+
+func TestStoreDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, event(i))
+	}
+	raw, err := trainDict(samples)
+	require.NoError(t, err)
+
+	f, err := os.CreateTemp("", "store_dict_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	c := Config{}
+	c.Store.Compression = CompressionZstdDict
+	c.Store.KeyProvider = &StaticKeyProvider{
+		Keys:    map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)},
+		Current: 1,
+	}
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.NoError(t, s.writeDict(raw))
+	_, pos, err := s.Append(event(1000))
+	require.NoError(t, err)
+	require.Equal(t, s.start, pos)
+	require.NoError(t, s.Close())
+
+	// the dictionary's encrypted with the records
+	b, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "page_view")
+
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, pos, s.start)
+	require.Equal(t, uint64(1), s.records)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, event(1000), read)
+
+	// without the dictionary the record can't be read
+	meta, contents, _, err := s.readFrame(pos, s.size)
+	require.NoError(t, err)
+	_, err = unframe(meta, contents, pos, true, c.Store.KeyProvider, nil)
+	require.ErrorIs(t, err, ErrNoDictionary)
+}
+
+func TestStoreEncryption(t *testing.T) {
+	f, err := os.CreateTemp("", "store_encryption_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	keys := &StaticKeyProvider{
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte{1}, 32),
+			2: bytes.Repeat([]byte{2}, 32),
+		},
+		Current: 1,
+	}
+	c := Config{}
+	c.Store.KeyProvider = keys
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+
+	_, first, err := s.Append(write)
+	require.NoError(t, err)
+
+	// rotated keys still decrypt older records
+	keys.Current = 2
+	_, second, err := s.Append(write)
+	require.NoError(t, err)
+
+	for _, pos := range []uint64{first, second} {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+
+	// the plain text never hits the disk
+	b := make([]byte, s.size)
+	_, err = s.ReadAt(b, 0)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(b, write))
+
+	s, err = newStore(fileStorage{f}, Config{})
+	require.NoError(t, err)
+	_, err = s.Read(first)
+	require.ErrorIs(t, err, ErrNoKeyProvider)
+
+	// a frame moved elsewhere in the store doesn't open
+	frame := make([]byte, second-first)
+	_, err = f.ReadAt(frame, int64(first))
+	require.NoError(t, err)
+	_, err = f.WriteAt(frame, int64(second))
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	_, err = s.Read(second)
+	require.Error(t, err)
+
+	// the frames sealed before the positions were are still read
+	contents, err := encrypt(keys.Keys[2], write, nil)
+	require.NoError(t, err)
+	legacy := enc.AppendUint64(nil, uint64(len(contents)))
+	legacy = enc.AppendUint32(legacy, crc32.Checksum(contents, crcTable))
+	legacy = append(legacy, byte(CompressionNone))
+	legacy = enc.AppendUint32(legacy, 2)
+	_, err = f.Write(append(legacy, contents...))
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	read, err := s.Read(second + uint64(len(frame)))
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	delete(keys.Keys, 1)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	_, err = s.Read(first)
+	require.ErrorIs(t, err, ErrUnknownKey)
+
+	// the top bit of the key id field isn't the key's
+	keys.Keys[1<<31|2] = keys.Keys[2]
+	keys.Current = 1<<31 | 2
+	_, _, err = s.Append(write)
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestLogEncryption(t *testing.T) {
+	c := Config{}
+	c.Store.KeyProvider = &StaticKeyProvider{
+		Keys:    map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)},
+		Current: 1,
+	}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 10; i++ {
+		_, err = log.Append(event(i))
+		require.NoError(t, err)
+	}
+
+	var scanned int
+	require.NoError(t, ScanStores(log.Reader(), c, func(record Record) error {
+		require.Equal(t, event(scanned), record.Value)
+		scanned++
+		return nil
+	}))
+	require.Equal(t, 10, scanned)
+
+	// a copy from the middle of a segment says where it starts,
+	// the records are sealed with their positions
+	var b bytes.Buffer
+	next, _, err := log.CopyTo(&b, 1)
+	require.NoError(t, err)
+	off := 1
+	require.NoError(t, ScanStores(&b, c, func(record Record) error {
+		require.Equal(t, event(off), record.Value)
+		off++
+		return nil
+	}))
+	require.Equal(t, int(next), off)
+}
+
+func TestEnvelopeKeyProvider(t *testing.T) {
+	var unwraps int
+	p := &EnvelopeKeyProvider{
+		Wrapped: map[uint32][]byte{1: []byte("wrapped")},
+		Current: 1,
+		Unwrap: func(wrapped []byte) ([]byte, error) {
+			unwraps++
+			require.Equal(t, "wrapped", string(wrapped))
+			return bytes.Repeat([]byte{1}, 32), nil
+		},
+	}
+	for range 2 {
+		id, key, err := p.CurrentKey()
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), id)
+		require.Len(t, key, 32)
+	}
+	require.Equal(t, 1, unwraps)
+	_, err := p.Key(2)
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	t.Setenv("VSDLOG_TEST_KEY_3", "AAAAAAAAAAAAAAAAAAAAAA==")
+	p := &EnvKeyProvider{Prefix: "VSDLOG_TEST_KEY_", Current: 3}
+
+	id, key, err := p.CurrentKey()
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), id)
+	require.Len(t, key, 16)
+
+	_, err = p.Key(4)
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
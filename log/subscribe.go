@@ -0,0 +1,103 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// SlowConsumerPolicy decides what a subscription does when its
+// subscriber doesn't keep up and the channel is full
+type SlowConsumerPolicy int
+
+const (
+	// SubscribeBlock waits for the subscriber to make room
+	SubscribeBlock SlowConsumerPolicy = iota
+	// SubscribeDrop drops the records that don't fit, the next one that
+	// does tells how many were dropped before it
+	SubscribeDrop
+)
+
+const defaultSubscribeBuffer = 64
+
+// SubscribedRecord is a record sent to a subscriber along with its offset
+type SubscribedRecord struct {
+	Offset uint64
+	Record
+	// Dropped is how many records were dropped right before this one
+	// because the subscriber fell behind, with SubscribeDrop
+	Dropped uint64
+}
+
+// Subscribe sends the records from fromOffset on to the returned channel,
+// the ones already there and then the ones appended after them, as they're
+// appended. like an Iterator it covers the local segments and skips the
+// offsets compaction left out. the channel is closed once ctx is done,
+// the log is closed or a read fails, see Config.Subscribe
+func (l *Log) Subscribe(ctx context.Context, fromOffset uint64) (<-chan SubscribedRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	closing := l.appended.closing()
+	select {
+	case <-closing:
+		return nil, ErrLogClosed
+	default:
+	}
+	buffer := l.Config.Subscribe.Buffer
+	if buffer <= 0 {
+		buffer = defaultSubscribeBuffer
+	}
+	ch := make(chan SubscribedRecord, buffer)
+	go l.subscription(ctx, l.Iterator(fromOffset), ch, closing)
+	return ch, nil
+}
+
+func (l *Log) subscription(ctx context.Context, it *Iterator, ch chan<- SubscribedRecord, closing <-chan struct{}) {
+	defer close(ch)
+
+	var dropped uint64
+	for {
+		// the channel's taken before the read so an append in between isn't missed
+		appended := l.appended.wait()
+		if appended == nil {
+			return
+		}
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-appended:
+			}
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrSegmentClosed) {
+				l.Config.logger().Warn("subscription stopped", slog.Any("error", err))
+			}
+			return
+		}
+
+		r := SubscribedRecord{Offset: it.Offset(), Record: record, Dropped: dropped}
+		if l.Config.Subscribe.Policy == SubscribeDrop {
+			select {
+			case ch <- r:
+				dropped = 0
+			case <-ctx.Done():
+				return
+			default:
+				dropped++
+			}
+			continue
+		}
+		select {
+		case ch <- r:
+		case <-ctx.Done():
+			return
+		case <-closing:
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receive returns the next record of the subscription
+func receive(t *testing.T, ch <-chan SubscribedRecord) SubscribedRecord {
+	t.Helper()
+	select {
+	case r, ok := <-ch:
+		require.True(t, ok, "subscription closed")
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+	return SubscribedRecord{}
+}
+
+// closed waits for the subscription to be closed
+func closed(t *testing.T, ch <-chan SubscribedRecord) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("subscription still open")
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c := Config{}
+	c.Subscribe.Buffer = 2
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append([]byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := log.Subscribe(ctx, 1)
+	require.NoError(t, err)
+	// a second subscription doesn't get in the way of the first
+	other, err := log.Subscribe(ctx, 0)
+	require.NoError(t, err)
+
+	for i := 1; i < 3; i++ {
+		r := receive(t, ch)
+		require.Equal(t, uint64(i), r.Offset)
+		require.Equal(t, fmt.Sprint(i), string(r.Value))
+	}
+	// the records appended later follow, none are dropped while blocked
+	for i := 3; i < 6; i++ {
+		_, err := log.Append([]byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+	}
+	for i := 3; i < 6; i++ {
+		r := receive(t, ch)
+		require.Equal(t, uint64(i), r.Offset)
+		require.Zero(t, r.Dropped)
+	}
+	for i := 0; i < 6; i++ {
+		require.Equal(t, uint64(i), receive(t, other).Offset)
+	}
+
+	cancel()
+	closed(t, ch)
+	closed(t, other)
+
+	// closing the log closes the subscriptions
+	ch, err = log.Subscribe(context.Background(), 6)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	closed(t, ch)
+	_, err = log.Subscribe(context.Background(), 0)
+	require.ErrorIs(t, err, ErrLogClosed)
+}
+
+func TestSubscribeDrop(t *testing.T) {
+	c := Config{}
+	c.Subscribe.Buffer = 1
+	c.Subscribe.Policy = SubscribeDrop
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+
+	ch, err := log.Subscribe(context.Background(), 0)
+	require.NoError(t, err)
+	// the first record fills the channel, the rest are dropped
+	require.Eventually(t, func() bool {
+		return len(ch) == 1
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, uint64(0), receive(t, ch).Offset)
+
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	r := receive(t, ch)
+	require.Equal(t, uint64(5), r.Offset)
+	require.Equal(t, uint64(4), r.Dropped)
+}
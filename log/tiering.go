@@ -0,0 +1,374 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTierInterval = time.Minute
+	defaultTierCache    = 4
+	// tierCacheDir holds the fetched segments, under the log's directory
+	// it's emptied when the log is opened
+	tierCacheDir = "tiered"
+)
+
+// TierStore is where sealed segments are offloaded to, it can be backed by
+// S3, GCS, MinIO or anything else that stores named blobs
+type TierStore interface {
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get returns an error wrapping os.ErrNotExist for a missing blob
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]string, error)
+}
+
+// DirTierStore keeps the offloaded segments in a directory,
+// e.g. on a network filesystem or a cheaper disk
+type DirTierStore struct {
+	Dir string
+}
+
+var _ TierStore = (*DirTierStore)(nil)
+
+func (d *DirTierStore) Put(_ context.Context, name string, r io.Reader) error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(d.Dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (d *DirTierStore) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.Dir, name))
+}
+
+func (d *DirTierStore) Delete(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(d.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *DirTierStore) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".tmp") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// tiers tells whether sealed segments are offloaded
+func (c Config) tiers() bool {
+	return c.Tiering.Store != nil
+}
+
+// tieredSegment is a segment that only lives in the tier store
+type tieredSegment struct {
+	baseOffset uint64
+	nextOffset uint64
+}
+
+// tiered segments are named after both their offsets, so
+// they're known from listing the store without fetching anything
+func (t tieredSegment) name(ext string) string {
+	return fmt.Sprintf("%d-%d%s", t.baseOffset, t.nextOffset, ext)
+}
+
+// loadTiered lists the segments in the tier store that come before the
+// local ones. a segment that was uploaded but not yet removed locally
+// is read from the local copy
+func (l *Log) loadTiered() error {
+	if err := os.RemoveAll(filepath.Join(l.Dir, tierCacheDir)); err != nil {
+		return err
+	}
+	names, err := l.Config.Tiering.Store.List(context.Background())
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for _, name := range names {
+		listed[name] = true
+	}
+	for _, name := range names {
+		var t tieredSegment
+		if _, err := fmt.Sscanf(name, "%d-%d.store", &t.baseOffset, &t.nextOffset); err != nil || t.name(".store") != name {
+			continue
+		}
+		// the store is uploaded last, but its index could've been deleted since
+		if !listed[t.name(".index")] || t.baseOffset >= l.segments[0].baseOffset {
+			continue
+		}
+		l.tiered = append(l.tiered, t)
+	}
+	sort.Slice(l.tiered, func(i, j int) bool {
+		return l.tiered[i].baseOffset < l.tiered[j].baseOffset
+	})
+	return nil
+}
+
+// Tier uploads the oldest sealed segments to Tiering.Store and removes
+// them locally, keeping the newest Tiering.KeepLocal of them around.
+// reads of their offsets fetch them back, Scan, Iterator, Reader, CopyTo
+// and Backup only cover the local segments
+func (l *Log) Tier() error {
+	if !l.Config.tiers() {
+		return nil
+	}
+	for {
+		s, t, err := l.tierOne()
+		if err != nil || s == nil {
+			return err
+		}
+
+		l.compactMu.Lock()
+		l.mu.Lock()
+		// compaction or retention may have gotten to it in the meantime
+		if len(l.segments) > 1 && l.segments[0] == s {
+			err = s.Remove()
+			l.segments = l.segments[1:]
+			l.tiered = append(l.tiered, t)
+		}
+		l.mu.Unlock()
+		l.compactMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tierOne uploads the oldest segment if it's due to be tiered
+func (l *Log) tierOne() (*segment, tieredSegment, error) {
+	l.mu.RLock()
+	// the active segment stays, so do the newest of the sealed ones
+	if len(l.segments)-1 <= l.Config.Tiering.KeepLocal {
+		l.mu.RUnlock()
+		return nil, tieredSegment{}, nil
+	}
+	s := l.segments[0]
+	t := tieredSegment{baseOffset: s.baseOffset, nextOffset: s.nextOffset}
+	// opened under the lock, so the files can't be swapped by compaction
+	files, sizes, err := openSegmentFiles(s)
+	l.mu.RUnlock()
+	if err != nil {
+		return nil, t, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	// the store goes last, since it's what tells that a segment was tiered
+	ctx := context.Background()
+	for i, ext := range []string{".index", ".store"} {
+		r := io.NewSectionReader(files[i], 0, sizes[i])
+		if err := l.Config.Tiering.Store.Put(ctx, t.name(ext), r); err != nil {
+			return nil, t, err
+		}
+	}
+	return s, t, nil
+}
+
+// openSegmentFiles opens the index and the store of a segment,
+// along with how much of them holds data
+func openSegmentFiles(s *segment) ([]*os.File, []int64, error) {
+	if err := s.store.Flush(); err != nil {
+		return nil, nil, err
+	}
+	var files []*os.File
+	for _, name := range []string{s.index.Name(), s.store.Name()} {
+		f, err := os.Open(name)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	return files, []int64{int64(s.index.size), int64(s.store.size)}, nil
+}
+
+// tieredFor returns the tiered segment holding the offset, under the log's lock
+func (l *Log) tieredFor(off uint64) (tieredSegment, bool) {
+	i := sort.Search(len(l.tiered), func(i int) bool {
+		return l.tiered[i].nextOffset > off
+	})
+	if i == len(l.tiered) || l.tiered[i].baseOffset > off {
+		return tieredSegment{}, false
+	}
+	return l.tiered[i], true
+}
+
+// readTiered reads the record from the tiered segment
+// fetching it into the cache if it isn't there yet
+func (l *Log) readTiered(ctx context.Context, t tieredSegment, off uint64, buf []byte) ([]byte, error) {
+	s, err := l.fetchTiered(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.ReadInto(off, buf)
+	return b, errors.Join(err, s.release())
+}
+
+// fetchTiered returns the cached copy of the segment, acquired for the caller
+func (l *Log) fetchTiered(ctx context.Context, t tieredSegment) (*segment, error) {
+	l.tierMu.Lock()
+	defer l.tierMu.Unlock()
+
+	// the most recently read segments are at the end
+	for i, s := range l.tierCache {
+		if s.baseOffset == t.baseOffset {
+			l.tierCache = append(append(l.tierCache[:i:i], l.tierCache[i+1:]...), s)
+			s.acquire()
+			return s, nil
+		}
+	}
+
+	dir := filepath.Join(l.Dir, tierCacheDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	storeName := segmentPath(dir, t.baseOffset, ".store")
+	indexName := segmentPath(dir, t.baseOffset, ".index")
+	for _, file := range []struct{ ext, path string }{{".store", storeName}, {".index", indexName}} {
+		if err := l.downloadTiered(ctx, t.name(file.ext), file.path); err != nil {
+			return nil, err
+		}
+	}
+	s, err := openSegment(storeName, indexName, t.baseOffset, l.Config)
+	if err != nil {
+		return nil, err
+	}
+	s.acquire()
+	l.tierCache = append(l.tierCache, s)
+
+	max := l.Config.Tiering.CacheSegments
+	if max <= 0 {
+		max = defaultTierCache
+	}
+	for len(l.tierCache) > max {
+		// readers still holding on to it remove it once they're done
+		if err := l.tierCache[0].Remove(); err != nil {
+			return s, err
+		}
+		l.tierCache = l.tierCache[1:]
+	}
+	return s, nil
+}
+
+func (l *Log) downloadTiered(ctx context.Context, name, path string) error {
+	r, err := l.Config.Tiering.Store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// dropTiered deletes the tiered segments below lowest, under the log's lock
+func (l *Log) dropTiered(lowest uint64) error {
+	ctx := context.Background()
+	for len(l.tiered) > 0 && l.tiered[0].nextOffset <= lowest {
+		t := l.tiered[0]
+		// the store goes first, so a failure leaves no half a segment behind
+		for _, ext := range []string{".store", ".index"} {
+			if err := l.Config.Tiering.Store.Delete(ctx, t.name(ext)); err != nil {
+				return err
+			}
+		}
+		l.tiered = l.tiered[1:]
+		if err := l.evictTiered(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) evictTiered(t tieredSegment) error {
+	l.tierMu.Lock()
+	defer l.tierMu.Unlock()
+
+	for i, s := range l.tierCache {
+		if s.baseOffset == t.baseOffset {
+			l.tierCache = append(l.tierCache[:i:i], l.tierCache[i+1:]...)
+			return s.Remove()
+		}
+	}
+	return nil
+}
+
+// closeTiered closes the cached segments, the cache is emptied on open
+func (l *Log) closeTiered() error {
+	l.tierMu.Lock()
+	defer l.tierMu.Unlock()
+
+	var errs []error
+	for _, s := range l.tierCache {
+		errs = append(errs, s.Remove())
+	}
+	l.tierCache = nil
+	return errors.Join(errs...)
+}
+
+func (l *Log) tierLoop() {
+	defer l.wg.Done()
+
+	interval := l.Config.Tiering.Interval
+	if interval <= 0 {
+		interval = defaultTierInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			// a failed run is simply retried on the next tick
+			if err := l.Tier(); err != nil {
+				l.Config.logger().Error("tiering failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package log
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiering(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tiering_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tier := &DirTierStore{Dir: filepath.Join(dir, "tier")}
+	logDir := filepath.Join(dir, "log")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	c := Config{}
+	c.Segment.MaxStoreBytes = 3 * width
+	c.Tiering.Store = tier
+	c.Tiering.KeepLocal = 1
+	c.Tiering.CacheSegments = 1
+	c.Tiering.Interval = time.Hour
+	log, err := NewLog(logDir, c)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(write)
+		require.NoError(t, err)
+	}
+
+	// segments 0, 3 and 6 are sealed, the newest of them stays local
+	require.NoError(t, log.Tier())
+	require.Len(t, log.Segments(), 2)
+	names, err := tier.List(context.Background())
+	require.NoError(t, err)
+	sort.Strings(names)
+	require.Equal(t, []string{"0-3.index", "0-3.store", "3-6.index", "3-6.store"}, names)
+
+	requireReads := func(log *Log, from, to uint64) {
+		t.Helper()
+		requireLowestOffset(t, log, from)
+		for off := from; off < to; off++ {
+			read, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, write, read)
+		}
+	}
+	requireReads(log, 0, 10)
+	// only one segment is cached at a time
+	require.Len(t, log.tierCache, 1)
+	require.Equal(t, uint64(3), log.tierCache[0].baseOffset)
+	entries, err := os.ReadDir(filepath.Join(logDir, tierCacheDir))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// the tiered segments are found again on reopening
+	require.NoError(t, log.Close())
+	log, err = NewLog(logDir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	requireReads(log, 0, 10)
+
+	// and truncating deletes them
+	require.NoError(t, log.Truncate(4))
+	requireReads(log, 3, 10)
+	_, err = log.Read(2)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	names, err = tier.List(context.Background())
+	require.NoError(t, err)
+	sort.Strings(names)
+	require.Equal(t, []string{"3-6.index", "3-6.store"}, names)
+}
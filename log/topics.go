@@ -0,0 +1,285 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownTopic is returned for a topic that doesn't exist
+	ErrUnknownTopic = errors.New("log: unknown topic")
+	// ErrTopicExists is returned when creating a topic that already exists
+	ErrTopicExists = errors.New("log: topic already exists")
+	// ErrInvalidTopic is returned for names that can't be used as a directory
+	ErrInvalidTopic = errors.New("log: invalid topic name")
+	// ErrUnknownPartition is returned for a partition a topic doesn't have
+	ErrUnknownPartition = errors.New("log: unknown partition")
+)
+
+type TopicConfig struct {
+	// Partitions the topic is created with, defaults to one
+	Partitions int
+	// Partitioner picks the partition of appended records
+	// defaults to hashing their keys
+	Partitioner Partitioner
+	// Log configures the log of every partition
+	Log Config
+	// Overrides are what the topic changes of Log, they're kept with
+	// the topic and only given to CreateTopic
+	Overrides TopicOverrides
+}
+
+// Topics manages named topics, each in its own directory under Dir
+// with a directory per partition
+type Topics struct {
+	mu sync.RWMutex
+
+	Dir string
+	// Config is what topics are created with unless they're given their own
+	// and what the existing topics are opened with
+	Config TopicConfig
+
+	topics map[string]*Topic
+
+	// the namespaces of the tenants, see Namespace
+	namespacesMu sync.Mutex
+	namespaces   map[string]*Namespace
+}
+
+// NewTopics opens the topics that already exist in dir
+func NewTopics(dir string, c TopicConfig) (*Topics, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	t := &Topics{
+		Dir:    dir,
+		Config: c,
+		topics: make(map[string]*Topic),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || validateTopic(entry.Name()) != nil {
+			continue
+		}
+		topic, err := openTopic(filepath.Join(dir, entry.Name()), entry.Name(), c)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.topics[entry.Name()] = topic
+	}
+	return t, nil
+}
+
+// CreateTopic creates a topic with its own config, the overrides
+// apply whenever the topic's opened again
+func (t *Topics) CreateTopic(name string, c TopicConfig) (*Topic, error) {
+	if err := validateTopic(name); err != nil {
+		return nil, err
+	}
+	if err := c.Overrides.validate(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.topics[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTopicExists, name)
+	}
+	if c.Partitions <= 0 {
+		c.Partitions = 1
+	}
+	dir := filepath.Join(t.Dir, name)
+	// the partitions are told by their directories
+	if err := os.MkdirAll(partitionDir(dir, c.Partitions-1), 0755); err != nil {
+		return nil, err
+	}
+	if err := writeTopicOverrides(dir, c.Overrides); err != nil {
+		return nil, err
+	}
+	topic, err := openTopic(dir, name, c)
+	if err != nil {
+		return nil, err
+	}
+	t.topics[name] = topic
+	return topic, nil
+}
+
+// Topic returns the topic with the given name
+func (t *Topics) Topic(name string) (*Topic, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	topic, ok := t.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	return topic, nil
+}
+
+// TopicNames returns the names of all topics in order
+func (t *Topics) TopicNames() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	names := make([]string, 0, len(t.topics))
+	for name := range t.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteTopic closes the topic and removes all of its data
+func (t *Topics) DeleteTopic(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	topic, ok := t.topics[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	delete(t.topics, name)
+	if err := topic.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(topic.Dir)
+}
+
+// Close closes all topics
+func (t *Topics) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var errs []error
+	for _, topic := range t.topics {
+		errs = append(errs, topic.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// topic names are used as directory names, so they're kept to
+// letters, digits, dots, dashes and underscores, and can't start with a dot
+func validateTopic(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '-', r == '_':
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidTopic, name)
+		}
+	}
+	return nil
+}
+
+// Topic is a named set of partitions, each of them a log of its own
+// with its own offsets, records are only ordered within a partition
+type Topic struct {
+	Name string
+	Dir  string
+
+	partitioner Partitioner
+	partitions  []*Log
+	overrides   TopicOverrides
+}
+
+// openTopic opens the partitions found in the topic's directory,
+// with the overrides of its manifest
+func openTopic(dir, name string, c TopicConfig) (*Topic, error) {
+	overrides, err := readTopicOverrides(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.Log = overrides.apply(c.Log)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if p, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() && p >= n {
+			n = p + 1
+		}
+	}
+	// every topic has at least one partition
+	if n == 0 {
+		n = 1
+	}
+
+	t := &Topic{
+		Name:        name,
+		Dir:         dir,
+		partitioner: c.Partitioner,
+		overrides:   overrides,
+	}
+	if t.partitioner == nil {
+		t.partitioner = &HashPartitioner{}
+	}
+	for p := 0; p < n; p++ {
+		if err := os.MkdirAll(partitionDir(dir, p), 0755); err != nil {
+			t.Close()
+			return nil, err
+		}
+		log, err := NewLog(partitionDir(dir, p), c.Log)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.partitions = append(t.partitions, log)
+	}
+	return t, nil
+}
+
+func partitionDir(dir string, partition int) string {
+	return filepath.Join(dir, strconv.Itoa(partition))
+}
+
+// Append appends the record to the partition the partitioner picks
+// and returns the partition along with the record's offset in it
+func (t *Topic) Append(record Record) (partition int, off uint64, err error) {
+	partition = t.partitioner.Partition(record, len(t.partitions))
+	log, err := t.Partition(partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	off, err = log.AppendRecord(record)
+	return partition, off, err
+}
+
+// Partition returns the log of the given partition
+func (t *Topic) Partition(partition int) (*Log, error) {
+	if partition < 0 || partition >= len(t.partitions) {
+		return nil, fmt.Errorf("%w: %s/%d", ErrUnknownPartition, t.Name, partition)
+	}
+	return t.partitions[partition], nil
+}
+
+// Overrides are the settings the topic has of its own
+func (t *Topic) Overrides() TopicOverrides {
+	return t.overrides
+}
+
+// Partitions is how many partitions the topic has
+func (t *Topic) Partitions() int {
+	return len(t.partitions)
+}
+
+func (t *Topic) Close() error {
+	var errs []error
+	for _, log := range t.partitions {
+		errs = append(errs, log.Close())
+	}
+	return errors.Join(errs...)
+}
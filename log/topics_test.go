@@ -0,0 +1,169 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "topics_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topics, err := NewTopics(dir, TopicConfig{})
+	require.NoError(t, err)
+
+	c := TopicConfig{}
+	c.Log.Segment.MaxStoreBytes = width
+	orders, err := topics.CreateTopic("orders", c)
+	require.NoError(t, err)
+	payments, err := topics.CreateTopic("payments", TopicConfig{})
+	require.NoError(t, err)
+
+	_, err = topics.CreateTopic("orders", TopicConfig{})
+	require.ErrorIs(t, err, ErrTopicExists)
+	for _, name := range []string{"", ".", "..", "a/b", ".hidden"} {
+		_, err = topics.CreateTopic(name, TopicConfig{})
+		require.ErrorIs(t, err, ErrInvalidTopic)
+	}
+
+	// every topic has its own offsets and segments
+	for i := 0; i < 2; i++ {
+		_, _, err = orders.Append(Record{Value: write})
+		require.NoError(t, err)
+	}
+	_, off, err := payments.Append(Record{Value: []byte("paid")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	log, err := orders.Partition(0)
+	require.NoError(t, err)
+	require.Len(t, log.segments, 3)
+	require.Equal(t, []string{"orders", "payments"}, topics.TopicNames())
+	require.NoError(t, topics.Close())
+
+	// topics are picked up again from the directory
+	topics, err = NewTopics(dir, TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	require.Equal(t, []string{"orders", "payments"}, topics.TopicNames())
+	payments, err = topics.Topic("payments")
+	require.NoError(t, err)
+	log, err = payments.Partition(0)
+	require.NoError(t, err)
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("paid"), read)
+
+	require.NoError(t, topics.DeleteTopic("orders"))
+	_, err = topics.Topic("orders")
+	require.ErrorIs(t, err, ErrUnknownTopic)
+	require.NoDirExists(t, filepath.Join(dir, "orders"))
+	require.ErrorIs(t, topics.DeleteTopic("orders"), ErrUnknownTopic)
+}
+
+func TestTopicPartitions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "partitions_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topics, err := NewTopics(dir, TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+
+	topic, err := topics.CreateTopic("events", TopicConfig{Partitions: 4})
+	require.NoError(t, err)
+	require.Equal(t, 4, topic.Partitions())
+
+	// records of a key always land on the same partition, in order
+	var partitions []int
+	for i := 0; i < 3; i++ {
+		p, off, err := topic.Append(Record{Key: []byte("user-1"), Value: write})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+		partitions = append(partitions, p)
+	}
+	require.Equal(t, []int{partitions[0], partitions[0], partitions[0]}, partitions)
+
+	// those without a key are spread over all of them
+	seen := make(map[int]bool)
+	for i := 0; i < 4; i++ {
+		p, _, err := topic.Append(Record{Value: write})
+		require.NoError(t, err)
+		seen[p] = true
+	}
+	require.Len(t, seen, 4)
+
+	_, err = topic.Partition(4)
+	require.ErrorIs(t, err, ErrUnknownPartition)
+
+	// records can be routed by hand
+	manual, err := topics.CreateTopic("manual", TopicConfig{
+		Partitions: 2,
+		Partitioner: PartitionerFunc(func(record Record, _ int) int {
+			if string(record.Key) == "odd" {
+				return 1
+			}
+			return 0
+		}),
+	})
+	require.NoError(t, err)
+	p, _, err := manual.Append(Record{Key: []byte("odd"), Value: write})
+	require.NoError(t, err)
+	require.Equal(t, 1, p)
+
+	rr := &RoundRobinPartitioner{}
+	for i := 0; i < 6; i++ {
+		require.Equal(t, i%3, rr.Partition(Record{}, 3))
+	}
+}
+
+func TestTopicOverrides(t *testing.T) {
+	dir := t.TempDir()
+	c := TopicConfig{}
+	c.Log.Retention.MaxAge = 24 * time.Hour
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+
+	maxStoreBytes := uint64(width)
+	maxAge := time.Duration(0)
+	compression := CompressionSnappy
+	c.Overrides = TopicOverrides{
+		MaxStoreBytes:   &maxStoreBytes,
+		RetentionMaxAge: &maxAge,
+		Compression:     &compression,
+	}
+	_, err = topics.CreateTopic("orders", c)
+	require.NoError(t, err)
+	_, err = topics.CreateTopic("payments", TopicConfig{})
+	require.NoError(t, err)
+
+	unknown := Compression(99)
+	_, err = topics.CreateTopic("unknown", TopicConfig{Overrides: TopicOverrides{Compression: &unknown}})
+	require.ErrorIs(t, err, ErrUnknownCompression)
+	require.NoError(t, topics.Close())
+
+	// the overrides come back with the topic, the rest is the topics' config
+	topics, err = NewTopics(dir, c)
+	require.NoError(t, err)
+	defer topics.Close()
+	orders, err := topics.Topic("orders")
+	require.NoError(t, err)
+	require.Equal(t, c.Overrides, orders.Overrides())
+	log, err := orders.Partition(0)
+	require.NoError(t, err)
+	require.Equal(t, maxStoreBytes, log.Config.Segment.MaxStoreBytes)
+	require.Equal(t, time.Duration(0), log.Config.Retention.MaxAge)
+	require.Equal(t, CompressionSnappy, log.Config.Store.Compression)
+
+	payments, err := topics.Topic("payments")
+	require.NoError(t, err)
+	require.Equal(t, TopicOverrides{}, payments.Overrides())
+	log, err = payments.Partition(0)
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, log.Config.Retention.MaxAge)
+	require.Equal(t, CompressionNone, log.Config.Store.Compression)
+}
@@ -0,0 +1,294 @@
+//go:build linux && iouring
+
+package log
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// what's needed of linux/io_uring.h, x/sys doesn't have it
+const (
+	uringOpFsync = 3
+	uringOpWrite = 23
+
+	// uringLink makes the next entry wait for this one
+	uringLink      = 1 << 2
+	uringGetEvents = 1
+
+	uringOffSQRing      = 0
+	uringOffCQRing      = 0x8000000
+	uringOffSQEs        = 0x10000000
+	uringFeatSingleMmap = 1
+)
+
+const (
+	// uringEntries is the size of the rings, a flush takes at most two
+	uringEntries = 8
+	// uringBufferSize is how much is batched into a single write
+	uringBufferSize = 64 << 10
+)
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	pad      [3]uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is a submission and a completion ring shared with the kernel
+// it's only used by one writer at a time, under the store's lock
+type uring struct {
+	fd   int
+	mmap [][]byte
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []uringSQE
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []uringCQE
+}
+
+func newURing(entries uint32) (r *uring, err error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r = &uring{fd: int(fd)}
+	defer func() {
+		if err != nil {
+			r.Close()
+		}
+	}()
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	single := p.features&uringFeatSingleMmap != 0
+	if single {
+		sqSize = max(sqSize, cqSize)
+	}
+	sqRing, err := r.mapRegion(uringOffSQRing, sqSize)
+	if err != nil {
+		return nil, err
+	}
+	cqRing := sqRing
+	if !single {
+		if cqRing, err = r.mapRegion(uringOffCQRing, cqSize); err != nil {
+			return nil, err
+		}
+	}
+	sqes, err := r.mapRegion(uringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(uringSQE{})))
+	if err != nil {
+		return nil, err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&sqes[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&cqRing[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+func (r *uring) mapRegion(off int64, size int) ([]byte, error) {
+	b, err := unix.Mmap(r.fd, off, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+	r.mmap = append(r.mmap, b)
+	return b, nil
+}
+
+// run submits the entries in one go and waits for all of them,
+// returning their results in the same order
+func (r *uring) run(sqes ...uringSQE) ([]int32, error) {
+	tail := atomic.LoadUint32(r.sqTail)
+	for i, sqe := range sqes {
+		idx := (tail + uint32(i)) & r.sqMask
+		sqe.userData = uint64(i)
+		r.sqes[idx] = sqe
+		r.sqArray[idx] = idx
+	}
+	atomic.StoreUint32(r.sqTail, tail+uint32(len(sqes)))
+
+	res := make([]int32, len(sqes))
+	for done := 0; done < len(sqes); {
+		submit := atomic.LoadUint32(r.sqTail) - atomic.LoadUint32(r.sqHead)
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd),
+			uintptr(submit), uintptr(len(sqes)-done), uringGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR {
+			return nil, os.NewSyscallError("io_uring_enter", errno)
+		}
+		head := atomic.LoadUint32(r.cqHead)
+		for ; head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := r.cqes[head&r.cqMask]
+			res[cqe.userData] = cqe.res
+			done++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+	return res, nil
+}
+
+func (r *uring) Close() error {
+	for _, b := range r.mmap {
+		unix.Munmap(b)
+	}
+	return unix.Close(r.fd)
+}
+
+// newURingWriter batches the appends of a store into single writes
+// submitted through io_uring, along with the fsync when syncing
+func newURingWriter(f *os.File, size uint64) (storeWriter, error) {
+	ring, err := newURing(uringEntries)
+	if err != nil {
+		return nil, err
+	}
+	return &uringWriter{
+		ring: ring,
+		fd:   int32(f.Fd()),
+		buf:  make([]byte, 0, uringBufferSize),
+		off:  size,
+	}, nil
+}
+
+type uringWriter struct {
+	ring *uring
+	fd   int32
+	buf  []byte
+	// off is where buf goes in the file
+	off uint64
+}
+
+func (w *uringWriter) Write(p []byte) (int, error) {
+	if len(w.buf) > 0 && len(w.buf)+len(p) > cap(w.buf) {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *uringWriter) Buffered() int {
+	return len(w.buf)
+}
+
+func (w *uringWriter) Flush() error {
+	return w.submit(false)
+}
+
+// FlushSync writes what's buffered and fsyncs the file with a single syscall
+func (w *uringWriter) FlushSync() error {
+	return w.submit(true)
+}
+
+func (w *uringWriter) submit(sync bool) error {
+	for len(w.buf) > 0 || sync {
+		var sqes []uringSQE
+		if len(w.buf) > 0 {
+			sqe := uringSQE{
+				opcode: uringOpWrite,
+				fd:     w.fd,
+				off:    w.off,
+				addr:   uint64(uintptr(unsafe.Pointer(&w.buf[0]))),
+				len:    uint32(len(w.buf)),
+			}
+			if sync {
+				sqe.flags = uringLink
+			}
+			sqes = append(sqes, sqe)
+		}
+		if sync {
+			sqes = append(sqes, uringSQE{opcode: uringOpFsync, fd: w.fd})
+		}
+		res, err := w.ring.run(sqes...)
+		runtime.KeepAlive(w.buf)
+		if err != nil {
+			return err
+		}
+
+		if len(w.buf) > 0 {
+			n := res[0]
+			if n < 0 {
+				return os.NewSyscallError("write", syscall.Errno(-n))
+			}
+			if n == 0 {
+				return io.ErrShortWrite
+			}
+			w.off += uint64(n)
+			w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+		}
+		if sync {
+			n := res[len(res)-1]
+			// a short write cuts the link, the rest is written first
+			if n == -int32(unix.ECANCELED) && len(w.buf) > 0 {
+				continue
+			}
+			if n < 0 {
+				return os.NewSyscallError("fsync", syscall.Errno(-n))
+			}
+			sync = false
+		}
+	}
+	return nil
+}
+
+func (w *uringWriter) reset(size uint64) error {
+	w.buf = w.buf[:0]
+	w.off = size
+	return nil
+}
+
+func (w *uringWriter) Close() error {
+	return w.ring.Close()
+}
@@ -0,0 +1,59 @@
+//go:build linux && iouring
+
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreIOUring(t *testing.T) {
+	f, err := os.CreateTemp("", "store_io_uring_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.IOUring = true
+	s, err := newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.IsType(t, &uringWriter{}, s.buf)
+
+	// a few buffers worth of records, read back as they're written
+	n := 3*uringBufferSize/int(width) + 1
+	for i := 0; i < n; i++ {
+		_, pos, err := s.Append(write)
+		require.NoError(t, err)
+		if i%3 == 0 {
+			read, err := s.Read(pos)
+			require.NoError(t, err)
+			require.Equal(t, write, read)
+		}
+		if i%100 == 0 {
+			require.NoError(t, s.Sync())
+		}
+	}
+	require.NoError(t, s.Sync())
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(n)*int64(width), fi.Size())
+
+	require.NoError(t, s.Truncate(width))
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, width, pos)
+	require.NoError(t, s.Close())
+
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	s, err = newStore(fileStorage{f}, c)
+	require.NoError(t, err)
+	require.Equal(t, 2*width, s.size)
+	for pos := uint64(0); pos < s.size; pos += width {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,14 @@
+//go:build !linux || !iouring
+
+package log
+
+import (
+	"bufio"
+	"os"
+)
+
+// newURingWriter needs linux and the iouring build tag,
+// without them stores are written through the usual buffer
+func newURingWriter(f *os.File, size uint64) (storeWriter, error) {
+	return bufio.NewWriter(f), nil
+}
@@ -0,0 +1,244 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCorruptIndex is reported by Verify for index entries
+// that don't point at the start of a record
+var ErrCorruptIndex = errors.New("log: corrupt index")
+
+// how often the scrubber sleeps to keep to its rate
+const scrubThrottleInterval = 100 * time.Millisecond
+
+// Corruption is what Verify found wrong at an offset, Err wraps
+// ErrCorruptRecord or ErrCorruptIndex with the details
+type Corruption struct {
+	Offset uint64
+	Err    error
+}
+
+func (c Corruption) Error() string {
+	return fmt.Sprintf("offset %d: %v", c.Offset, c.Err)
+}
+
+func (c Corruption) Unwrap() error {
+	return c.Err
+}
+
+// scrubs tells whether the sealed segments are verified in the background
+func (c Config) scrubs() bool {
+	return c.Scrub.Interval > 0
+}
+
+// Verify walks the local segments and checks that their frames fit in the
+// stores, that the records pass their checksums and decode, and that the
+// index entries point at records, in order. checksums are checked even
+// with Store.SkipChecksumVerify. a log opened by OpenReadOnly reports the
+// torn records and the stray entries it kept too. the corruptions are returned, the error
+// is for what kept Verify from reading the segments at all
+func (l *Log) Verify() ([]Corruption, error) {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	sealed := l.sealed()
+	l.mu.RUnlock()
+
+	var corruptions []Corruption
+	report := func(c Corruption) { corruptions = append(corruptions, c) }
+	for _, s := range sealed {
+		if err := s.verify(report, nil); err != nil {
+			return corruptions, err
+		}
+	}
+	// the active segment is verified under the lock, it's still appended to
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	err := l.activeSegment.verify(report, nil)
+	return corruptions, err
+}
+
+// verify reports what's wrong with the segment's records and index,
+// throttle is called with the size of every frame read, if it's set, and
+// verify stops if it returns false
+func (s *segment) verify(report func(Corruption), throttle func(n uint64) bool) error {
+	// a read-only segment keeps what a crash left, the others recovered from it
+	if s.store.torn > 0 {
+		report(Corruption{s.nextOffset, fmt.Errorf("%w: %d bytes of a torn frame at the end of the store", ErrCorruptRecord, s.store.torn)})
+	}
+	if s.pastRecords > 0 {
+		report(Corruption{s.nextOffset, fmt.Errorf("%w: %d entries point past the records", ErrCorruptIndex, s.pastRecords)})
+	}
+	// the positions of the index entries that look right, with their offsets,
+	// and where the holes end, their frames aren't read
+	n := s.index.size / entWidth
+	entries := make(map[uint64]uint64, n)
+	holes := make(map[uint64]uint64)
+	var prevOut uint32
+	var prevPos uint64
+	var holeAt *uint64
+	for slot := uint64(0); slot < n; slot++ {
+		out, pos, hole, err := s.index.entry(int64(slot))
+		if err != nil {
+			return err
+		}
+		off := s.baseOffset + uint64(out)
+		switch {
+		case pos < s.store.start || pos >= s.store.size:
+			report(Corruption{off, fmt.Errorf("%w: entry points past the store", ErrCorruptIndex)})
+		case slot > 0 && (out <= prevOut || pos <= prevPos):
+			report(Corruption{off, fmt.Errorf("%w: entry out of order", ErrCorruptIndex)})
+		default:
+			entries[pos] = off
+			if holeAt != nil {
+				holes[*holeAt], holeAt = pos, nil
+			}
+			if hole {
+				holeAt = &pos
+			}
+		}
+		prevOut, prevPos = out, pos
+	}
+	if holeAt != nil {
+		holes[*holeAt] = s.store.size
+	}
+
+	off := s.baseOffset
+	end := s.store.size
+	for pos := s.store.start; pos < end; off++ {
+		// the offsets skip ahead where compaction left gaps
+		if o, ok := entries[pos]; ok {
+			off = o
+			delete(entries, pos)
+		}
+		if end, ok := holes[pos]; ok {
+			pos = end
+			continue
+		}
+		meta, contents, next, err := s.store.readFrame(pos, end)
+		if errors.Is(err, ErrCorruptRecord) {
+			// the frames after it can't be found
+			report(Corruption{off, err})
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b, err := unframe(meta, contents, pos, true, s.store.keys, s.store.dict)
+		if err == nil {
+			_, err = decodeRecord(b)
+		}
+		if err != nil {
+			report(Corruption{off, fmt.Errorf("%w: %w", ErrCorruptRecord, err)})
+		}
+		if throttle != nil && !throttle(next-pos) {
+			return nil
+		}
+		pos = next
+	}
+	for _, o := range entries {
+		report(Corruption{o, fmt.Errorf("%w: entry doesn't point at a record", ErrCorruptIndex)})
+	}
+	return nil
+}
+
+// readFrame reads the whole frame at pos, which has to end by end
+func (s *store) readFrame(pos, end uint64) (meta, contents []byte, next uint64, err error) {
+	if err = s.flushTo(end); err != nil {
+		return nil, nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	header := make([]byte, binary.MaxVarintLen64+metaWidth)
+	n, meta, w, err := s.readHeader(pos, header)
+	if err != nil || n == 0 || w > end-pos || n > end-pos-w {
+		return nil, nil, 0, fmt.Errorf("%w: frame at %d runs past the end of the store", ErrCorruptRecord, pos)
+	}
+	contents = make([]byte, n)
+	if _, err = s.readAt(contents, int64(pos+w)); err != nil {
+		return nil, nil, 0, err
+	}
+	return meta, contents, pos + w + n, nil
+}
+
+// scrubLoop verifies the sealed segments every Scrub.Interval, at no more
+// than Scrub.BytesPerSecond, and reports the corruptions it finds
+func (l *Log) scrubLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Scrub.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.scrub(); err != nil {
+				l.Config.logger().Error("scrub failed", "err", err)
+			}
+		}
+	}
+}
+
+func (l *Log) scrub() error {
+	l.mu.RLock()
+	sealed := l.sealed()
+	l.mu.RUnlock()
+
+	report := func(c Corruption) {
+		l.metrics.observeCorruption()
+		l.Config.logger().Error("corrupt record", "offset", c.Offset, "err", c.Err)
+		if fn := l.Config.Scrub.OnCorruption; fn != nil {
+			fn(c)
+		}
+	}
+	var owed uint64
+	throttle := func(n uint64) bool {
+		rate := l.Config.Scrub.BytesPerSecond
+		if rate == 0 {
+			return true
+		}
+		// sleep off every tenth of a second worth of bytes
+		if owed += n; owed < rate/10 {
+			return true
+		}
+		owed = 0
+		select {
+		case <-l.done:
+			return false
+		case <-time.After(scrubThrottleInterval):
+			return true
+		}
+	}
+
+	for _, s := range sealed {
+		// the segment is verified while compaction can't swap it out,
+		// one at a time so the compaction doesn't wait for all of them
+		l.compactMu.Lock()
+		l.mu.RLock()
+		current := false
+		for _, c := range l.segments {
+			current = current || c == s
+		}
+		l.mu.RUnlock()
+		var err error
+		if current {
+			err = s.verify(report, throttle)
+		}
+		l.compactMu.Unlock()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-l.done:
+			return nil
+		default:
+		}
+	}
+	return nil
+}
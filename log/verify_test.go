@@ -0,0 +1,97 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 8; i++ {
+		_, err := log.AppendRecord(Record{Value: write})
+		require.NoError(t, err)
+	}
+	corruptions, err := log.Verify()
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	// flip the last byte of offset 1, it fails its checksum
+	s := log.segments[0]
+	require.NoError(t, s.store.Flush())
+	_, next, err := s.index.Read(2)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	_, err = s.store.Storage.ReadAt(b, int64(next-1))
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	overwrite(t, s.store.Name(), next-1, b)
+
+	// and offset 4's entry points in the middle of its record
+	s = log.segments[1]
+	_, pos, err := s.index.Read(1)
+	require.NoError(t, err)
+	enc.PutUint64(s.index.mmap[entWidth+offWidth:], pos+1)
+
+	corruptions, err = log.Verify()
+	require.NoError(t, err)
+	require.Len(t, corruptions, 2)
+	require.Equal(t, uint64(1), corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0], ErrCorruptRecord)
+	require.Equal(t, uint64(4), corruptions[1].Offset)
+	require.ErrorIs(t, corruptions[1], ErrCorruptIndex)
+}
+
+func TestScrub(t *testing.T) {
+	found := make(chan Corruption, 1)
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	c.Scrub.Interval = 10 * time.Millisecond
+	c.Scrub.BytesPerSecond = 1 << 20
+	c.Scrub.OnCorruption = func(c Corruption) {
+		select {
+		case found <- c:
+		default:
+		}
+	}
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := log.AppendRecord(Record{Value: write})
+		require.NoError(t, err)
+	}
+	s := log.segments[0]
+	require.NoError(t, s.store.Flush())
+	_, pos, err := s.index.Read(0)
+	require.NoError(t, err)
+	// a length that runs past the store
+	overwrite(t, s.store.Name(), pos, []byte{0xff, 0xff, 0xff, 0x7f})
+
+	select {
+	case c := <-found:
+		require.Equal(t, uint64(0), c.Offset)
+		require.ErrorIs(t, c, ErrCorruptRecord)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scrubber found nothing")
+	}
+	require.GreaterOrEqual(t, testutil.ToFloat64(log.metrics.corruptions), float64(1))
+}
+
+// overwrite writes b at off in the file, the stores only append to theirs
+func overwrite(t *testing.T, name string, off uint64, b []byte) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt(b, int64(off))
+	require.NoError(t, err)
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// appendSignal wakes up the reads waiting for the next record, the
+// channel is closed on every append and made again by the next waiter
+type appendSignal struct {
+	mu     sync.Mutex
+	ch     chan struct{}
+	closed bool
+	// done is closed along with the log
+	done chan struct{}
+}
+
+// wait returns the channel closed with the next append,
+// or nil if the log is closed
+func (s *appendSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *appendSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// closing returns the channel closed with the log
+func (s *appendSignal) closing() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	return s.done
+}
+
+// close wakes up everyone waiting for good
+func (s *appendSignal) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	close(s.done)
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// ReadWait is ReadContext, except that a read of the offset the next
+// record is appended at waits for that record to be appended, until ctx
+// is done. the offsets past that one fail right away, like with ReadRecord
+func (l *Log) ReadWait(ctx context.Context, off uint64) (Record, error) {
+	for {
+		// the channel's taken before the read so an append in between isn't missed
+		appended := l.appended.wait()
+		if appended == nil {
+			return Record{}, ErrLogClosed
+		}
+		record, err := l.ReadContext(ctx, off)
+		if !errors.Is(err, ErrOffsetOutOfRange) || off != l.nextOffset() {
+			return record, err
+		}
+		select {
+		case <-ctx.Done():
+			return Record{}, ctx.Err()
+		case <-appended:
+		}
+	}
+}
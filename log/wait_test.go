@@ -0,0 +1,67 @@
+package log
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWait(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	record, err := log.ReadWait(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, write, record.Value)
+
+	// the next offset waits for its record
+	got := make(chan Record)
+	go func() {
+		record, _ := log.ReadWait(ctx, 1)
+		got <- record
+	}()
+	select {
+	case <-got:
+		t.Fatal("read before the append")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = log.AppendRecord(Record{Value: []byte("next")})
+	require.NoError(t, err)
+	select {
+	case record := <-got:
+		require.Equal(t, "next", string(record.Value))
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after the append")
+	}
+
+	// the ones past it don't
+	_, err = log.ReadWait(ctx, 3)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = log.ReadWait(timeout, 2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// closing the log wakes up the reads
+	closed := make(chan error)
+	go func() {
+		_, err := log.ReadWait(ctx, 2)
+		closed <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, log.Close())
+	select {
+	case err := <-closed:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after close")
+	}
+	_, err = log.ReadWait(ctx, 2)
+	require.ErrorIs(t, err, ErrLogClosed)
+}
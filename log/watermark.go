@@ -0,0 +1,130 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrUnknownReplica is returned for the reports of followers
+// that aren't one of the Replication.Followers
+var ErrUnknownReplica = errors.New("log: unknown replica")
+
+// defaultReportTimeout is how long a report counts unless
+// Replication.ReportTimeout says otherwise
+const defaultReportTimeout = 10 * time.Second
+
+// HighWatermark is the offset the uncommitted records start at, those below
+// it are on a majority of the Replication.Replicas and survive the leader
+// failing over, the ones from it on may not. a leader works it out from what
+// its followers report, see ReportReplica, and a follower is told it by its
+// leader. a log that isn't replicated has all of its records committed
+func (l *Log) HighWatermark() uint64 {
+	next := l.nextOffset()
+	if l.Config.Replication.Replicas <= 1 && !l.following.Load() {
+		return next
+	}
+	return min(l.watermark.Load(), next)
+}
+
+// ReportReplica records that the follower has the records before next and
+// returns the high watermark, which moves up once a majority of the
+// replicas, the leader included, have a record. it never moves back. only
+// the Replication.Followers that reported within the ReportTimeout count,
+// and a follower can't have more than the leader has
+func (l *Log) ReportReplica(id string, next uint64) (uint64, error) {
+	if !slices.Contains(l.Config.Replication.Followers, id) {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownReplica, id)
+	}
+	head := l.nextOffset()
+	now := l.Config.Clock()
+	timeout := l.Config.Replication.ReportTimeout
+	if timeout <= 0 {
+		timeout = defaultReportTimeout
+	}
+	l.replicaMu.Lock()
+	if l.replicas == nil {
+		l.replicas = make(map[string]replicaState)
+	}
+	l.replicas[id] = replicaState{next: min(next, head), reported: now}
+	offsets := []uint64{head}
+	for _, replica := range l.replicas {
+		if now.Sub(replica.reported) < timeout {
+			offsets = append(offsets, replica.next)
+		}
+	}
+	l.replicaMu.Unlock()
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	if quorum := l.Config.Replication.Replicas/2 + 1; len(offsets) >= quorum {
+		l.raiseWatermark(offsets[quorum-1])
+	}
+	return l.HighWatermark(), nil
+}
+
+// raiseWatermark moves the high watermark up to off, if it's below
+func (l *Log) raiseWatermark(off uint64) {
+	for {
+		hw := l.watermark.Load()
+		if off <= hw || l.watermark.CompareAndSwap(hw, off) {
+			return
+		}
+	}
+}
+
+// setHighWatermark is what a follower's leader told it the high watermark is
+func (l *Log) setHighWatermark(off uint64) {
+	l.following.Store(true)
+	l.watermark.Store(off)
+}
+
+// replicaState is what a follower last reported
+type replicaState struct {
+	next     uint64
+	reported time.Time
+}
+
+// ReplicaLag is how far behind the leader a follower is
+type ReplicaLag struct {
+	// ID is the one the follower reports with
+	ID string
+	// Next is the offset the records the follower doesn't have start at
+	Next uint64
+	// Records is how many offsets the follower is behind
+	Records uint64
+	// Time is how long ago the oldest record the follower doesn't
+	// have was appended, zero once the follower caught up
+	Time time.Duration
+	// Reported is when the follower last reported
+	Reported time.Time
+}
+
+// ReplicaLags returns the lag of every follower that reported to the
+// leader, by ID. a follower behind LowestOffset won't catch up anymore,
+// retention or compaction removed records it doesn't have. the ones past
+// the ReportTimeout are listed too, they don't count towards the majority
+func (l *Log) ReplicaLags() []ReplicaLag {
+	l.replicaMu.Lock()
+	lags := make([]ReplicaLag, 0, len(l.replicas))
+	for id, replica := range l.replicas {
+		lags = append(lags, ReplicaLag{ID: id, Next: replica.next, Reported: replica.reported})
+	}
+	l.replicaMu.Unlock()
+	sort.Slice(lags, func(i, j int) bool { return lags[i].ID < lags[j].ID })
+
+	next := l.nextOffset()
+	now := l.Config.Clock()
+	for i, lag := range lags {
+		if lag.Next >= next {
+			continue
+		}
+		lags[i].Records = next - lag.Next
+		// the first record from there on, compaction may have dropped the one at it
+		if record, err := l.Iterator(lag.Next).Next(); err == nil && now.After(record.Timestamp) {
+			lags[i].Time = now.Sub(record.Timestamp)
+		}
+	}
+	return lags
+}
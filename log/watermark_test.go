@@ -0,0 +1,140 @@
+package log
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHighWatermark(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := Config{Clock: func() time.Time { return now }}
+	c.Replication.Replicas = 3
+	c.Replication.Followers = []string{"b", "c"}
+	c.Replication.ReportTimeout = 10 * time.Second
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	report := func(id string, next uint64) uint64 {
+		t.Helper()
+		hw, err := log.ReportReplica(id, next)
+		require.NoError(t, err)
+		return hw
+	}
+	// nothing's on a majority before the followers report
+	require.Equal(t, uint64(0), log.HighWatermark())
+	require.Equal(t, uint64(2), report("b", 2))
+	require.Equal(t, uint64(3), report("c", 3))
+	// it never moves back
+	require.Equal(t, uint64(3), report("c", 1))
+	require.Equal(t, uint64(4), report("b", 4))
+
+	// but the records truncated away aren't committed anymore
+	require.NoError(t, log.truncateFrom(2))
+	require.Equal(t, uint64(2), log.HighWatermark())
+
+	// the followers that aren't known don't count
+	_, err = log.ReportReplica("x", 100)
+	require.ErrorIs(t, err, ErrUnknownReplica)
+	require.Equal(t, uint64(2), log.HighWatermark())
+
+	// a follower can't have more than the leader has
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint64(6), report("c", 100))
+	lags := log.ReplicaLags()
+	require.Equal(t, uint64(6), lags[1].Next)
+}
+
+func TestHighWatermarkReportTimeout(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := Config{Clock: func() time.Time { return now }}
+	c.Replication.Replicas = 5
+	c.Replication.Followers = []string{"b", "c", "d", "e"}
+	c.Replication.ReportTimeout = 10 * time.Second
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+	}
+
+	hw, err := log.ReportReplica("c", 4)
+	require.NoError(t, err)
+	require.Zero(t, hw)
+	// c hasn't reported in a while, it's taken for dead
+	now = now.Add(11 * time.Second)
+	hw, err = log.ReportReplica("d", 4)
+	require.NoError(t, err)
+	require.Zero(t, hw)
+	// until it reports again
+	hw, err = log.ReportReplica("c", 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), hw)
+}
+
+func TestHighWatermarkNotReplicated(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), log.HighWatermark())
+
+	// a follower goes by its leader's
+	log.setHighWatermark(0)
+	require.Equal(t, uint64(0), log.HighWatermark())
+	log.setHighWatermark(5)
+	require.Equal(t, uint64(1), log.HighWatermark())
+}
+
+func TestReplicaLags(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := Config{Clock: func() time.Time { return now }}
+	c.Replication.Replicas = 3
+	c.Replication.Followers = []string{"b", "c"}
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(write)
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+	require.Empty(t, log.ReplicaLags())
+	log.ReportReplica("c", 4)
+	log.ReportReplica("b", 1)
+	now = now.Add(time.Second)
+
+	// b is missing the records from the second on, appended 4s ago
+	lags := log.ReplicaLags()
+	require.Equal(t, []ReplicaLag{
+		{ID: "b", Next: 1, Records: 3, Time: 4 * time.Second, Reported: now.Add(-time.Second)},
+		{ID: "c", Next: 4, Reported: now.Add(-time.Second)},
+	}, lags)
+	st, err := log.Stats()
+	require.NoError(t, err)
+	require.Equal(t, lags, st.Replicas)
+
+	expected := `
+# HELP vsdlog_replica_lag_records Number of offsets a follower is behind the leader.
+# TYPE vsdlog_replica_lag_records gauge
+vsdlog_replica_lag_records{replica="b"} 3
+vsdlog_replica_lag_records{replica="c"} 0
+`
+	require.NoError(t, testutil.CollectAndCompare(log, strings.NewReader(expected), "vsdlog_replica_lag_records"))
+	require.Equal(t, 4, testutil.CollectAndCount(log, "vsdlog_replica_lag_records", "vsdlog_replica_lag_seconds"))
+}
@@ -0,0 +1,543 @@
+// Package memlog is an in-memory stand-in for log.Log, for the tests of
+// applications embedding vsdlog. it's a log.CommitLog like the log, the
+// methods that don't deal in files, failing with the same errors, and keeps
+// the records in a slice, so nothing touches the filesystem
+package memlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+)
+
+var _ log.CommitLog = (*Log)(nil)
+
+// how many records an iterator gets in one go, and a subscription's
+// channel holds unless Config.Subscribe.Buffer says otherwise
+const (
+	iteratorReadAhead = 64
+	defaultBuffer     = 64
+)
+
+// Log keeps the records in memory, it's safe for concurrent use
+type Log struct {
+	mu sync.RWMutex
+	// records are the ones from base on, Truncate drops the oldest
+	records []log.Record
+	base    uint64
+	clock   func() time.Time
+	groups  map[string]uint64
+	// epochs are where the leader epochs start, like the log's
+	epochs []epochStart
+	closed bool
+	// appended is closed on every append and made again, done on close
+	appended  chan struct{}
+	done      chan struct{}
+	subscribe struct {
+		buffer int
+		policy log.SlowConsumerPolicy
+	}
+}
+
+type epochStart struct {
+	epoch, offset uint64
+}
+
+// New returns an empty log. only Config.Segment.InitialOffset, Config.Clock
+// and Config.Subscribe are looked at, the rest is about files
+func New(c log.Config) *Log {
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	l := &Log{
+		base:     c.Segment.InitialOffset,
+		clock:    c.Clock,
+		groups:   make(map[string]uint64),
+		appended: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	l.subscribe.buffer, l.subscribe.policy = c.Subscribe.Buffer, c.Subscribe.Policy
+	if l.subscribe.buffer <= 0 {
+		l.subscribe.buffer = defaultBuffer
+	}
+	return l
+}
+
+// Append writes the value as a record without a key and returns its offset
+func (l *Log) Append(value []byte) (uint64, error) {
+	return l.AppendRecord(log.Record{Value: value})
+}
+
+// Delete appends a tombstone for the key and returns its offset
+func (l *Log) Delete(key []byte) (uint64, error) {
+	if key == nil {
+		return 0, fmt.Errorf("%w: a tombstone needs a key", log.ErrInvalidRecord)
+	}
+	return l.AppendRecord(log.Record{Key: key})
+}
+
+// AppendRecord stores a copy of the record and returns its offset,
+// it's stamped with the clock if it has no timestamp yet
+func (l *Log) AppendRecord(record log.Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, log.ErrLogClosed
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = l.clock()
+	}
+	return l.append(record), nil
+}
+
+// AppendContext is AppendRecord, an append never waits so ctx is only
+// looked at before it
+func (l *Log) AppendContext(ctx context.Context, record log.Record) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return l.AppendRecord(record)
+}
+
+// AppendAsync appends the record and calls done with its offset before
+// it returns, so the records are appended in the order they're given
+func (l *Log) AppendAsync(record log.Record, done func(off uint64, err error)) {
+	done(l.AppendRecord(record))
+}
+
+// AppendIf is AppendRecord if the record goes at expected,
+// it fails with log.ErrOffsetConflict otherwise
+func (l *Log) AppendIf(record log.Record, expected uint64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, log.ErrLogClosed
+	}
+	if next := l.next(); next != expected {
+		return 0, fmt.Errorf("%w: expected %d, the next one is %d", log.ErrOffsetConflict, expected, next)
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = l.clock()
+	}
+	return l.append(record), nil
+}
+
+// AppendBatch writes the values as records without a key
+// and returns the offsets of the first and last of them
+func (l *Log) AppendBatch(values [][]byte) (first, last uint64, err error) {
+	if len(values) == 0 {
+		return 0, 0, log.ErrEmptyBatch
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, 0, log.ErrLogClosed
+	}
+	now := l.clock()
+	first = l.next()
+	for _, value := range values {
+		last = l.append(log.Record{Value: value, Timestamp: now})
+	}
+	return first, last, nil
+}
+
+// AppendAll is AppendBatch, a batch in memory is all or nothing anyway
+func (l *Log) AppendAll(values [][]byte) (first, last uint64, err error) {
+	return l.AppendBatch(values)
+}
+
+// append is called with the lock held
+func (l *Log) append(record log.Record) uint64 {
+	off := l.next()
+	record = clone(record)
+	record.Epoch = l.epoch()
+	l.records = append(l.records, record)
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return off
+}
+
+// Read returns the value of the record stored at the given offset
+func (l *Log) Read(off uint64) ([]byte, error) {
+	record, err := l.ReadRecord(off)
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// ReadRecord returns a copy of the record stored at the given offset
+func (l *Log) ReadRecord(off uint64) (log.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return log.Record{}, log.ErrLogClosed
+	}
+	if off < l.base || off >= l.next() {
+		return log.Record{}, fmt.Errorf("%w: %d", log.ErrOffsetOutOfRange, off)
+	}
+	return clone(l.records[off-l.base]), nil
+}
+
+// ReadContext is ReadRecord, a read never waits so ctx is only looked at before it
+func (l *Log) ReadContext(ctx context.Context, off uint64) (log.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return log.Record{}, err
+	}
+	return l.ReadRecord(off)
+}
+
+// ReadWait is ReadContext, except that a read of the offset the next
+// record is appended at waits for that record to be appended, until ctx
+// is done. the offsets past that one fail right away, like with ReadRecord
+func (l *Log) ReadWait(ctx context.Context, off uint64) (log.Record, error) {
+	for {
+		l.mu.RLock()
+		appended, next, closed := l.appended, l.next(), l.closed
+		l.mu.RUnlock()
+		if closed {
+			return log.Record{}, log.ErrLogClosed
+		}
+		if off != next {
+			return l.ReadContext(ctx, off)
+		}
+		select {
+		case <-ctx.Done():
+			return log.Record{}, ctx.Err()
+		case <-l.done:
+			return log.Record{}, log.ErrLogClosed
+		case <-appended:
+		}
+	}
+}
+
+// ReadRange returns copies of the records from start up to end (exclusive),
+// as many of them as have maxBytes of keys and values between them, and at
+// least one so the caller always gets somewhere
+func (l *Log) ReadRange(start, end uint64, maxBytes int) ([]log.RangeRecord, error) {
+	if end <= start {
+		return nil, nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return nil, log.ErrLogClosed
+	}
+	if start < l.base || start >= l.next() {
+		return nil, fmt.Errorf("%w: %d", log.ErrOffsetOutOfRange, start)
+	}
+	var records []log.RangeRecord
+	size := 0
+	for off := start; off < min(end, l.next()); off++ {
+		record := l.records[off-l.base]
+		if size += len(record.Key) + len(record.Value); size > maxBytes && len(records) > 0 {
+			break
+		}
+		records = append(records, log.RangeRecord{Offset: off, Record: clone(record)})
+	}
+	return records, nil
+}
+
+// Iterator returns an iterator over the records from startOffset on,
+// the ones Truncate dropped are skipped
+func (l *Log) Iterator(startOffset uint64) *log.Iterator {
+	return log.NewIterator(startOffset, func(off uint64) ([]log.RangeRecord, error) {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+
+		if l.closed {
+			return nil, log.ErrLogClosed
+		}
+		var records []log.RangeRecord
+		for off = max(off, l.base); off < l.next() && len(records) < iteratorReadAhead; off++ {
+			records = append(records, log.RangeRecord{Offset: off, Record: clone(l.records[off-l.base])})
+		}
+		return records, nil
+	})
+}
+
+// Subscribe sends the records from fromOffset on to the returned channel,
+// the ones already there and then the ones appended after them, as they're
+// appended. the channel is closed once ctx is done or the log is closed,
+// a subscriber that falls behind is handled as Config.Subscribe.Policy says
+func (l *Log) Subscribe(ctx context.Context, fromOffset uint64) (<-chan log.SubscribedRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return nil, log.ErrLogClosed
+	}
+	ch := make(chan log.SubscribedRecord, l.subscribe.buffer)
+	go l.subscription(ctx, l.Iterator(fromOffset), ch)
+	return ch, nil
+}
+
+func (l *Log) subscription(ctx context.Context, it *log.Iterator, ch chan<- log.SubscribedRecord) {
+	defer close(ch)
+
+	var dropped uint64
+	for {
+		// the channel's taken before the read so an append in between isn't missed
+		l.mu.RLock()
+		appended := l.appended
+		l.mu.RUnlock()
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			case <-appended:
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		r := log.SubscribedRecord{Offset: it.Offset(), Record: record, Dropped: dropped}
+		if l.subscribe.policy == log.SubscribeDrop {
+			select {
+			case ch <- r:
+				dropped = 0
+			case <-ctx.Done():
+				return
+			default:
+				dropped++
+			}
+			continue
+		}
+		select {
+		case ch <- r:
+		case <-ctx.Done():
+			return
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Get returns the latest record of the key, or ErrKeyNotFound
+// if there's none or it's a tombstone
+func (l *Log) Get(key []byte) (log.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return log.Record{}, log.ErrLogClosed
+	}
+	for i := len(l.records) - 1; i >= 0; i-- {
+		record := l.records[i]
+		if record.Key == nil || !bytes.Equal(record.Key, key) {
+			continue
+		}
+		if record.IsTombstone() {
+			break
+		}
+		return clone(record), nil
+	}
+	return log.Record{}, fmt.Errorf("%w: %q", log.ErrKeyNotFound, key)
+}
+
+// HasKey tells whether the latest record of the key isn't a tombstone
+func (l *Log) HasKey(key []byte) (bool, error) {
+	_, err := l.Get(key)
+	if errors.Is(err, log.ErrLogClosed) {
+		return false, err
+	}
+	return err == nil, nil
+}
+
+// Scan calls fn with every record of the log in offset order
+// while holding the log's read lock, so fn can't append to the log
+func (l *Log) Scan(fn func(off uint64, record log.Record) error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return log.ErrLogClosed
+	}
+	for i, record := range l.records {
+		if err := fn(l.base+uint64(i), clone(record)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate drops the records below lowest. unlike the log, which drops
+// whole segments, it drops exactly those
+func (l *Log) Truncate(lowest uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return log.ErrLogClosed
+	}
+	if lowest <= l.base {
+		return nil
+	}
+	n := min(lowest-l.base, uint64(len(l.records)))
+	l.records = append([]log.Record(nil), l.records[n:]...)
+	l.base += n
+	return nil
+}
+
+// LowestOffset is the offset of the oldest record the log holds
+// or the one the next record gets if it's empty
+func (l *Log) LowestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.base, nil
+}
+
+// HighestOffset is the offset of the last record appended to the log
+// it's zero if nothing has been appended to a log that starts at zero
+func (l *Log) HighestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if off := l.next(); off > 0 {
+		return off - 1, nil
+	}
+	return 0, nil
+}
+
+// HighWatermark is the offset the next record gets,
+// the log isn't replicated so all of its records are committed
+func (l *Log) HighWatermark() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.next()
+}
+
+// next is the offset of the next record, called with the lock held
+func (l *Log) next() uint64 {
+	return l.base + uint64(len(l.records))
+}
+
+// Commit records the offset the consumer group resumes from
+func (l *Log) Commit(group string, offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset > l.next() {
+		return fmt.Errorf("%w: %d", log.ErrOffsetOutOfRange, offset)
+	}
+	l.groups[group] = offset
+	return nil
+}
+
+// Fetch returns the offset the consumer group last committed
+func (l *Log) Fetch(group string) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	offset, ok := l.groups[group]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", log.ErrNoCommittedOffset, group)
+	}
+	return offset, nil
+}
+
+// Epoch is the latest leader epoch of the log, zero if it never had one
+func (l *Log) Epoch() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.epoch()
+}
+
+func (l *Log) epoch() uint64 {
+	if len(l.epochs) == 0 {
+		return 0
+	}
+	return l.epochs[len(l.epochs)-1].epoch
+}
+
+// SetEpoch starts a new leader epoch, an older one fails with ErrStaleEpoch
+func (l *Log) SetEpoch(epoch uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latest := l.epoch()
+	if epoch < latest {
+		return fmt.Errorf("%w: %d, the log's at %d", log.ErrStaleEpoch, epoch, latest)
+	}
+	if epoch > latest {
+		l.epochs = append(l.epochs, epochStart{epoch, l.next()})
+	}
+	return nil
+}
+
+// EndOffsetForEpoch returns the latest epoch of the log up to the given one
+// and the offset its records end at, like Log.EndOffsetForEpoch
+func (l *Log) EndOffsetForEpoch(epoch uint64) (uint64, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if latest := l.epoch(); epoch > latest {
+		return 0, 0, fmt.Errorf("%w: the log's at %d, not %d", log.ErrStaleEpoch, latest, epoch)
+	}
+	end := l.next()
+	for i := len(l.epochs) - 1; i >= 0; i-- {
+		if l.epochs[i].epoch <= epoch {
+			return l.epochs[i].epoch, end, nil
+		}
+		end = l.epochs[i].offset
+	}
+	return 0, end, nil
+}
+
+// Sync has nothing to do, there's no disk
+func (l *Log) Sync() error {
+	return nil
+}
+
+// Close makes the appends and reads fail with ErrLogClosed from then on
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.close()
+	return nil
+}
+
+// close wakes up the reads and subscriptions waiting, under the lock
+func (l *Log) close() {
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+}
+
+// Remove closes the log and drops its records
+func (l *Log) Remove() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.close()
+	l.records = nil
+	return nil
+}
+
+// clone copies what the record points to, so neither the caller
+// nor the log see what the other does to it afterwards
+func clone(record log.Record) log.Record {
+	record.Key = bytes.Clone(record.Key)
+	record.Value = bytes.Clone(record.Value)
+	record.Headers = maps.Clone(record.Headers)
+	return record
+}
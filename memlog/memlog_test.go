@@ -0,0 +1,223 @@
+package memlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ server.CommitLog    = (*Log)(nil)
+	_ server.OffsetRanger = (*Log)(nil)
+	_ server.EpochLog     = (*Log)(nil)
+	_ server.ContextLog   = (*Log)(nil)
+	_ server.WatermarkLog = (*Log)(nil)
+	_ log.RecordLog       = (*Log)(nil)
+)
+
+func TestMemLog(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := log.Config{}
+	c.Segment.InitialOffset = 10
+	c.Clock = func() time.Time { return now }
+
+	for name, open := range map[string]func(t *testing.T) log.CommitLog{
+		"memlog": func(t *testing.T) log.CommitLog {
+			return New(c)
+		},
+		"log": func(t *testing.T) log.CommitLog {
+			l, err := log.NewLog(t.TempDir(), c)
+			require.NoError(t, err)
+			return l
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testCommitLog(t, open(t), now)
+		})
+	}
+}
+
+func testCommitLog(t *testing.T, l log.CommitLog, now time.Time) {
+	defer l.Close()
+
+	off, err := l.Append([]byte("first"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+	key := []byte("user")
+	value := []byte("second")
+	off, err = l.AppendRecord(log.Record{Key: key, Value: value, Headers: map[string]string{"source": "test"}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), off)
+	// the log keeps what it was given, not what the caller does to it later
+	value[0] = 'X'
+
+	record, err := l.ReadRecord(11)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(record.Value))
+	require.Equal(t, "test", record.Headers["source"])
+	require.True(t, now.Equal(record.Timestamp))
+	record, err = l.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(record.Value))
+
+	_, err = l.Read(9)
+	require.ErrorIs(t, err, log.ErrOffsetOutOfRange)
+	_, err = l.Read(12)
+	require.ErrorIs(t, err, log.ErrOffsetOutOfRange)
+
+	_, _, err = l.AppendBatch(nil)
+	require.ErrorIs(t, err, log.ErrEmptyBatch)
+	require.NoError(t, l.SetEpoch(2))
+	first, last, err := l.AppendBatch([][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{12, 13}, []uint64{first, last})
+	record, err = l.ReadRecord(13)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Epoch)
+	epoch, end, err := l.EndOffsetForEpoch(1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 12}, []uint64{epoch, end})
+	require.ErrorIs(t, l.SetEpoch(1), log.ErrStaleEpoch)
+
+	// a tombstone hides the key
+	_, err = l.Delete(key)
+	require.NoError(t, err)
+	_, err = l.Delete(nil)
+	require.ErrorIs(t, err, log.ErrInvalidRecord)
+	_, err = l.Get(key)
+	require.ErrorIs(t, err, log.ErrKeyNotFound)
+	ok, err := l.HasKey(key)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	var offsets []uint64
+	require.NoError(t, l.Scan(func(off uint64, _ log.Record) error {
+		offsets = append(offsets, off)
+		return nil
+	}))
+	require.Equal(t, []uint64{10, 11, 12, 13, 14}, offsets)
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), lowest)
+	highest, err := l.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(14), highest)
+
+	// a conditional append only goes at the offset it expects
+	_, err = l.AppendIf(log.Record{Value: []byte("late")}, 14)
+	require.ErrorIs(t, err, log.ErrOffsetConflict)
+	off, err = l.AppendIf(log.Record{Value: []byte("next")}, 15)
+	require.NoError(t, err)
+	require.Equal(t, uint64(15), off)
+	_, err = l.AppendIf(log.Record{Value: []byte("next")}, 15)
+	require.ErrorIs(t, err, log.ErrOffsetConflict)
+
+	_, err = l.Fetch("group")
+	require.ErrorIs(t, err, log.ErrNoCommittedOffset)
+	require.NoError(t, l.Commit("group", 12))
+	committed, err := l.Fetch("group")
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), committed)
+	require.ErrorIs(t, l.Commit("group", 17), log.ErrOffsetOutOfRange)
+}
+
+func TestMemLogTruncate(t *testing.T) {
+	l := New(log.Config{})
+	for range 5 {
+		_, err := l.Append([]byte("record"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Truncate(3))
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	_, err = l.Read(2)
+	require.ErrorIs(t, err, log.ErrOffsetOutOfRange)
+	_, err = l.Read(3)
+	require.NoError(t, err)
+
+	// truncating past the end leaves an empty log appending where it was
+	require.NoError(t, l.Truncate(100))
+	off, err := l.Append([]byte("record"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+
+	require.NoError(t, l.Close())
+	_, err = l.Read(5)
+	require.ErrorIs(t, err, log.ErrLogClosed)
+}
+
+func TestMemLogReads(t *testing.T) {
+	l := New(log.Config{})
+	for _, value := range []string{"a", "bb", "ccc"} {
+		_, err := l.Append([]byte(value))
+		require.NoError(t, err)
+	}
+
+	records, err := l.ReadRange(0, 3, 3)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(1), records[1].Offset)
+	// at least one record, whatever maxBytes says
+	records, err = l.ReadRange(2, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	_, err = l.ReadRange(3, 4, 100)
+	require.ErrorIs(t, err, log.ErrOffsetOutOfRange)
+
+	it := l.Iterator(1)
+	var values []string
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		values = append(values, string(record.Value))
+	}
+	require.Equal(t, []string{"bb", "ccc"}, values)
+	require.Equal(t, uint64(2), it.Offset())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := l.Subscribe(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "ccc", string((<-sub).Value))
+
+	// the next offset waits for its append, the ones past it don't
+	_, err = l.ReadWait(ctx, 5)
+	require.ErrorIs(t, err, log.ErrOffsetOutOfRange)
+	read := make(chan log.Record)
+	go func() {
+		record, err := l.ReadWait(ctx, 3)
+		require.NoError(t, err)
+		read <- record
+	}()
+	var done sync.WaitGroup
+	done.Add(1)
+	l.AppendAsync(log.Record{Value: []byte("dddd")}, func(off uint64, err error) {
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), off)
+		done.Done()
+	})
+	done.Wait()
+	require.Equal(t, "dddd", string((<-read).Value))
+	got := <-sub
+	require.Equal(t, uint64(3), got.Offset)
+	require.Equal(t, uint64(4), l.HighWatermark())
+
+	require.NoError(t, l.Close())
+	_, ok := <-sub
+	require.False(t, ok)
+	_, err = l.ReadWait(ctx, 4)
+	require.ErrorIs(t, err, log.ErrLogClosed)
+	_, err = l.Subscribe(ctx, 0)
+	require.ErrorIs(t, err, log.ErrLogClosed)
+}
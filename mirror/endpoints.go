@@ -0,0 +1,120 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/client"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	_ Source = (*LogSource)(nil)
+	_ Source = (*ClusterSource)(nil)
+	_ Sink   = (*LogSink)(nil)
+	_ Sink   = (*ClusterSink)(nil)
+)
+
+// LogSource mirrors a log opened from its directory
+type LogSource struct {
+	Log *log.Log
+}
+
+func (s *LogSource) Consume(ctx context.Context, off uint64, fn func(uint64, log.Record) error) error {
+	for {
+		record, err := s.Log.ReadWait(ctx, off)
+		switch {
+		case err == nil:
+		case errors.Is(err, log.ErrOffsetCompacted):
+			off++
+			continue
+		case errors.Is(err, log.ErrOffsetOutOfRange):
+			// the records before the lowest offset are gone
+			lowest, lerr := s.Log.LowestOffset()
+			if lerr != nil || off >= lowest {
+				return err
+			}
+			off = lowest
+			continue
+		default:
+			return err
+		}
+		if err = fn(off, record); err != nil {
+			return err
+		}
+		off++
+	}
+}
+
+// LogSink appends to a log opened from its directory
+type LogSink struct {
+	Log *log.Log
+}
+
+func (s *LogSink) Append(ctx context.Context, record log.Record) error {
+	_, err := s.Log.AppendContext(ctx, record)
+	return err
+}
+
+// Flush syncs the log, so the checkpoint isn't ahead of what's on disk
+func (s *LogSink) Flush(context.Context) error {
+	return s.Log.Sync()
+}
+
+// ClusterSource mirrors a cluster over the client's ConsumeStream
+type ClusterSource struct {
+	Client *client.Client
+}
+
+func (s *ClusterSource) Consume(ctx context.Context, off uint64, fn func(uint64, log.Record) error) error {
+	return s.Client.ConsumeStream(ctx, off, func(record *api.Record) error {
+		r := log.Record{
+			Key:     record.Key,
+			Value:   record.Value,
+			Headers: record.Headers,
+		}
+		if record.Timestamp != nil {
+			r.Timestamp = record.Timestamp.AsTime()
+		}
+		return fn(record.Offset, r)
+	})
+}
+
+// ClusterSink produces to a cluster with the client's ProduceAsync,
+// so the records go out in batches
+type ClusterSink struct {
+	Client *client.Client
+
+	pending []*client.Future
+}
+
+func (s *ClusterSink) Append(_ context.Context, record log.Record) error {
+	r := &api.Record{
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+	}
+	if !record.Timestamp.IsZero() {
+		r.Timestamp = timestamppb.New(record.Timestamp)
+	}
+	s.pending = append(s.pending, s.Client.ProduceAsync(r))
+	return nil
+}
+
+// Flush waits for the records produced so far, it fails
+// if any of them did
+func (s *ClusterSink) Flush(ctx context.Context) error {
+	pending := s.pending
+	s.pending = nil
+	if err := s.Client.Flush(ctx); err != nil {
+		return err
+	}
+	for _, f := range pending {
+		if _, err := f.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
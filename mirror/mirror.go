@@ -0,0 +1,203 @@
+// Package mirror copies the records of one log to another, e.g. from one
+// cluster to a cluster of another region. the records keep their keys,
+// headers and timestamps, and get the offsets of the destination. how far
+// the mirror got is checkpointed to a file, so a restarted mirror picks up
+// from there. the records after the last checkpoint are mirrored again
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/log"
+)
+
+const (
+	defaultBackoff            = time.Second
+	defaultCheckpointInterval = time.Second
+)
+
+// Source is the log the records are mirrored from
+type Source interface {
+	// Consume calls fn with the records from off on, and the ones appended
+	// after them, until ctx is done or fn fails
+	Consume(ctx context.Context, off uint64, fn func(off uint64, record log.Record) error) error
+}
+
+// Sink is the log the records are mirrored to
+type Sink interface {
+	// Append appends the record, it may still be on its way when it returns
+	Append(ctx context.Context, record log.Record) error
+	// Flush returns once the records appended so far are in the log
+	Flush(ctx context.Context) error
+}
+
+// Mirror consumes the records of the source and appends them to the sink
+type Mirror struct {
+	Source Source
+	Sink   Sink
+	// Checkpoint is the file the offset of the next record of the source
+	// is kept in, the mirror starts from the source's first record without it
+	Checkpoint string
+	// CheckpointInterval is how often the checkpoint is written while
+	// records come in, it's written on Close too. defaults to a second
+	CheckpointInterval time.Duration
+	// Backoff is how long to wait before trying again after the source or
+	// the sink failed, defaults to a second
+	Backoff time.Duration
+	// Logger gets the failures, slog.Default() if nil
+	Logger *slog.Logger
+
+	logger *slog.Logger
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	next   atomic.Uint64
+	// checkpointed is the next offset as of the last checkpoint,
+	// everything before it is known to be in the sink
+	checkpointed uint64
+}
+
+// checkpoint is what's kept in the checkpoint file
+type checkpoint struct {
+	Next uint64 `json:"next"`
+}
+
+// Start picks up the checkpoint and mirrors until Close is called
+func (m *Mirror) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return nil
+	}
+	m.logger = m.Logger
+	if m.logger == nil {
+		m.logger = slog.Default()
+	}
+	m.logger = m.logger.With("component", "mirror")
+	if m.Checkpoint != "" {
+		b, err := os.ReadFile(m.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			var c checkpoint
+			if err = json.Unmarshal(b, &c); err != nil {
+				return err
+			}
+			m.next.Store(c.Next)
+			m.checkpointed = c.Next
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel, m.done = cancel, make(chan struct{})
+	go m.run(ctx)
+	return nil
+}
+
+// Next is the offset of the next record of the source to be mirrored
+func (m *Mirror) Next() uint64 {
+	return m.next.Load()
+}
+
+func (m *Mirror) run(ctx context.Context) {
+	defer close(m.done)
+
+	backoff := m.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	for {
+		err := m.mirror(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// what was appended since the last checkpoint may not have made it
+		m.logger.Error("failed to mirror", "error", err, "next", m.checkpointed)
+		m.next.Store(m.checkpointed)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// mirror consumes from where the last run left off until it fails
+func (m *Mirror) mirror(ctx context.Context) error {
+	interval := m.CheckpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	last := time.Now()
+	return m.Source.Consume(ctx, m.Next(), func(off uint64, record log.Record) error {
+		if err := m.Sink.Append(ctx, record); err != nil {
+			return err
+		}
+		m.next.Store(off + 1)
+		if time.Since(last) < interval {
+			return nil
+		}
+		last = time.Now()
+		return m.checkpoint(ctx)
+	})
+}
+
+// checkpoint flushes the sink and writes down the next offset, the
+// checkpoint never gets ahead of what's in the sink
+func (m *Mirror) checkpoint(ctx context.Context) error {
+	next := m.Next()
+	if err := m.Sink.Flush(ctx); err != nil {
+		return err
+	}
+	if m.Checkpoint != "" && next != m.checkpointed {
+		b, err := json.Marshal(checkpoint{Next: next})
+		if err != nil {
+			return err
+		}
+		if err = writeFileAtomic(m.Checkpoint, b); err != nil {
+			return err
+		}
+	}
+	m.checkpointed = next
+	return nil
+}
+
+// Close stops mirroring and writes the checkpoint of what made it to the sink
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+	m.cancel = nil
+	return m.checkpoint(context.Background())
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
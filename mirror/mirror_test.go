@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/orkhan-huseyn/vsdlog/client"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/orkhan-huseyn/vsdlog/server"
+	"github.com/stretchr/testify/require"
+)
+
+func newLog(t *testing.T, at time.Time) *log.Log {
+	l, err := log.NewLog(t.TempDir(), log.Config{Clock: func() time.Time { return at }})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// serveLog serves the log like a node of a cluster does and returns a client of it
+func serveLog(t *testing.T, l *log.Log) *client.Client {
+	srv, err := server.NewGRPCServer(&server.Config{CommitLog: l})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	c, err := client.New(client.Config{Addrs: []string{lis.Addr().String()}, Backoff: time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func appendRecords(t *testing.T, l *log.Log, from, to int) {
+	for i := from; i < to; i++ {
+		_, err := l.AppendRecord(log.Record{Key: []byte(fmt.Sprint("key", i%3)), Value: []byte(fmt.Sprint(i))})
+		require.NoError(t, err)
+	}
+}
+
+// mirrored waits for the destination to have n records and checks them
+func mirrored(t *testing.T, dst *log.Log, n int, at time.Time) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		highest, _ := dst.HighestOffset()
+		_, err := dst.ReadRecord(highest)
+		return err == nil && highest == uint64(n-1)
+	}, 5*time.Second, 10*time.Millisecond)
+	for i := 0; i < n; i++ {
+		record, err := dst.ReadRecord(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint("key", i%3), string(record.Key))
+		require.Equal(t, fmt.Sprint(i), string(record.Value))
+		require.True(t, at.Equal(record.Timestamp), "timestamp of %d", i)
+	}
+}
+
+func TestMirrorResumesFromCheckpoint(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	src, dst := newLog(t, at), newLog(t, time.Now())
+	appendRecords(t, src, 0, 10)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	start := func() *Mirror {
+		m := &Mirror{
+			Source:             &LogSource{Log: src},
+			Sink:               &LogSink{Log: dst},
+			Checkpoint:         path,
+			CheckpointInterval: time.Millisecond,
+			Backoff:            10 * time.Millisecond,
+		}
+		require.NoError(t, m.Start())
+		return m
+	}
+	m := start()
+	mirrored(t, dst, 10, at)
+	require.NoError(t, m.Close())
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var c checkpoint
+	require.NoError(t, json.Unmarshal(b, &c))
+	require.Equal(t, uint64(10), c.Next)
+
+	// a restarted mirror carries on after the checkpoint, nothing's mirrored twice
+	appendRecords(t, src, 10, 15)
+	m = start()
+	defer m.Close()
+	require.Equal(t, uint64(10), m.Next())
+	mirrored(t, dst, 15, at)
+	require.Eventually(t, func() bool { return m.Next() == 15 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMirrorClusters(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	src, dst := newLog(t, at), newLog(t, time.Now())
+	appendRecords(t, src, 0, 5)
+
+	m := &Mirror{
+		Source:             &ClusterSource{Client: serveLog(t, src)},
+		Sink:               &ClusterSink{Client: serveLog(t, dst)},
+		Checkpoint:         filepath.Join(t.TempDir(), "checkpoint.json"),
+		CheckpointInterval: time.Millisecond,
+	}
+	require.NoError(t, m.Start())
+	defer m.Close()
+	mirrored(t, dst, 5, at)
+
+	// the records appended later follow
+	appendRecords(t, src, 5, 8)
+	mirrored(t, dst, 8, at)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how many ACKS_NONE records can wait to be appended, the ones past
+// that are refused until the server catches up
+const maxPendingProduces = 1024
+
+// how often an ACKS_ALL produce checks whether its record is committed
+var commitPollInterval = 10 * time.Millisecond
+
+// acks is what the request asks for, or what the server's set up with
+func (s *grpcServer) acks(acks api.Acks) api.Acks {
+	if acks != api.Acks_ACKS_DEFAULT {
+		return acks
+	}
+	if s.Acks != api.Acks_ACKS_DEFAULT {
+		return s.Acks
+	}
+	return api.Acks_ACKS_LEADER
+}
+
+// produceLater queues the record of an ACKS_NONE request, they're
+// appended in the order they came in by a single goroutine, which
+// only runs while there are some waiting
+func (s *grpcServer) produceLater(req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) >= maxPendingProduces {
+		return nil, status.Error(codes.ResourceExhausted, "too many records waiting to be appended")
+	}
+	s.pending = append(s.pending, &api.ProduceRequest{Record: req.Record, Acks: api.Acks_ACKS_LEADER})
+	if !s.draining {
+		s.draining = true
+		go s.drain()
+	}
+	return &api.ProduceResponse{}, nil
+}
+
+func (s *grpcServer) drain() {
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.draining = false
+			s.mu.Unlock()
+			return
+		}
+		req := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+
+		// nobody's waiting for the request anymore
+		if _, err := s.Produce(context.Background(), req); err != nil {
+			s.logger().Error("unacknowledged produce failed", "error", err)
+		}
+	}
+}
+
+// awaitCommit waits until the log has committed the record at off, logs
+// that can't tell have every record committed once they have it
+func (s *grpcServer) awaitCommit(ctx context.Context, off uint64) error {
+	wl, ok := s.CommitLog.(WatermarkLog)
+	if !ok {
+		return nil
+	}
+	ticker := time.NewTicker(commitPollInterval)
+	defer ticker.Stop()
+	for wl.HighWatermark() <= off {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (c *Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var _ api.AdminServer = (*adminServer)(nil)
+
+// adminServer manages Config.Topics, the changes are made
+// as the subject of the request and audited
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+}
+
+func newAdminServer(config *Config) *adminServer {
+	return &adminServer{Config: config}
+}
+
+func (s *adminServer) CreateTopic(ctx context.Context, req *api.CreateTopicRequest) (*api.CreateTopicResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	c := s.Topics.Config
+	c.Partitions = int(req.Partitions)
+	overrides, err := fromTopicConfig(req.Config)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	c.Overrides = overrides
+	topic, err := s.Topics.As(subject(ctx)).CreateTopic(req.Name, c)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.CreateTopicResponse{Topic: toTopic(topic)}, nil
+}
+
+func (s *adminServer) DeleteTopic(ctx context.Context, req *api.DeleteTopicRequest) (*api.DeleteTopicResponse, error) {
+	if err := s.Topics.As(subject(ctx)).DeleteTopic(req.Name); err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.DeleteTopicResponse{}, nil
+}
+
+func (s *adminServer) ListTopics(ctx context.Context, req *api.ListTopicsRequest) (*api.ListTopicsResponse, error) {
+	res := &api.ListTopicsResponse{}
+	for _, name := range s.Topics.TopicNames() {
+		topic, err := s.Topics.Topic(name)
+		if err != nil {
+			// deleted since it was listed
+			continue
+		}
+		res.Topics = append(res.Topics, toTopic(topic))
+	}
+	return res, nil
+}
+
+func (s *adminServer) DescribeTopic(ctx context.Context, req *api.DescribeTopicRequest) (*api.DescribeTopicResponse, error) {
+	topic, err := s.Topics.Topic(req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := toTopic(topic)
+	for p := 0; p < topic.Partitions(); p++ {
+		l, err := topic.Partition(p)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		lowest, err := l.LowestOffset()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		highest, err := l.HighestOffset()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		res.PartitionOffsets = append(res.PartitionOffsets, &api.Partition{
+			Partition:     uint32(p),
+			LowestOffset:  lowest,
+			HighestOffset: highest,
+		})
+	}
+	return &api.DescribeTopicResponse{Topic: res}, nil
+}
+
+func toTopic(topic *log.Topic) *api.Topic {
+	return &api.Topic{
+		Name:       topic.Name,
+		Partitions: uint32(topic.Partitions()),
+		Config:     toTopicConfig(topic.Overrides()),
+	}
+}
+
+func toTopicConfig(o log.TopicOverrides) *api.TopicConfig {
+	c := &api.TopicConfig{
+		MaxStoreBytes:     o.MaxStoreBytes,
+		MaxIndexBytes:     o.MaxIndexBytes,
+		RetentionMaxBytes: o.RetentionMaxBytes,
+		PunchHoles:        o.PunchHoles,
+	}
+	if o.RetentionMaxAge != nil {
+		c.RetentionMaxAge = durationpb.New(*o.RetentionMaxAge)
+	}
+	if o.CompactionInterval != nil {
+		c.CompactionInterval = durationpb.New(*o.CompactionInterval)
+	}
+	if o.Compression != nil {
+		compression := api.Compression(*o.Compression)
+		c.Compression = &compression
+	}
+	return c
+}
+
+// fromTopicConfig is the overrides of the config
+func fromTopicConfig(c *api.TopicConfig) (log.TopicOverrides, error) {
+	var o log.TopicOverrides
+	if c == nil {
+		return o, nil
+	}
+	o.MaxStoreBytes = c.MaxStoreBytes
+	o.MaxIndexBytes = c.MaxIndexBytes
+	o.RetentionMaxBytes = c.RetentionMaxBytes
+	o.PunchHoles = c.PunchHoles
+	if c.RetentionMaxAge != nil {
+		maxAge := c.RetentionMaxAge.AsDuration()
+		o.RetentionMaxAge = &maxAge
+	}
+	if c.CompactionInterval != nil {
+		interval := c.CompactionInterval.AsDuration()
+		o.CompactionInterval = &interval
+	}
+	if c.Compression != nil {
+		if _, ok := api.Compression_name[int32(*c.Compression)]; !ok {
+			return o, log.ErrUnknownCompression
+		}
+		compression := log.Compression(*c.Compression)
+		o.Compression = &compression
+	}
+	return o, nil
+}
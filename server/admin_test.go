@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/auth"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestAdminServer(t *testing.T) {
+	dir := t.TempDir()
+	topics, err := log.NewTopics(filepath.Join(dir, "topics"), log.TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	clog, err := log.NewLog(filepath.Join(dir, "log"), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("ops, *, admin\n"), 0644))
+	authorizer, err := auth.New(policy)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := NewGRPCServer(&Config{
+		CommitLog:      clog,
+		Authorizer:     authorizer,
+		TokenValidator: auth.StaticTokens{"ops-token": "ops", "app-token": "app"},
+		Topics:         topics,
+	})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := api.NewAdminClient(cc)
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	ops := as("ops-token")
+
+	// only the subjects allowed to admin manage the topics
+	_, err = client.ListTopics(as("app-token"), &api.ListTopicsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	maxBytes := uint64(1 << 20)
+	compression := api.Compression_COMPRESSION_ZSTD
+	created, err := client.CreateTopic(ops, &api.CreateTopicRequest{
+		Name:       "orders",
+		Partitions: 3,
+		Config: &api.TopicConfig{
+			RetentionMaxAge:   durationpb.New(time.Hour),
+			RetentionMaxBytes: &maxBytes,
+			Compression:       &compression,
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), created.Topic.Partitions)
+	_, err = client.CreateTopic(ops, &api.CreateTopicRequest{Name: "orders"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.CreateTopic(ops, &api.CreateTopicRequest{Name: "../etc"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	unknown := api.Compression(42)
+	_, err = client.CreateTopic(ops, &api.CreateTopicRequest{
+		Name:   "payments",
+		Config: &api.TopicConfig{Compression: &unknown},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.CreateTopic(ops, &api.CreateTopicRequest{Name: "payments"})
+	require.NoError(t, err)
+
+	orders, err := topics.Topic("orders")
+	require.NoError(t, err)
+	partition, err := orders.Partition(1)
+	require.NoError(t, err)
+	for range 3 {
+		_, err = partition.AppendRecord(log.Record{Value: []byte("order")})
+		require.NoError(t, err)
+	}
+
+	list, err := client.ListTopics(ops, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	var names []string
+	for _, topic := range list.Topics {
+		names = append(names, topic.Name)
+		require.Empty(t, topic.PartitionOffsets)
+	}
+	// the audit topic's created by the first change
+	require.Equal(t, []string{log.AuditTopic, "orders", "payments"}, names)
+
+	described, err := client.DescribeTopic(ops, &api.DescribeTopicRequest{Name: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, described.Topic.Config.RetentionMaxAge.AsDuration())
+	require.Equal(t, maxBytes, described.Topic.Config.GetRetentionMaxBytes())
+	require.Equal(t, compression, described.Topic.Config.GetCompression())
+	require.Nil(t, described.Topic.Config.MaxStoreBytes)
+	require.Len(t, described.Topic.PartitionOffsets, 3)
+	require.Equal(t, uint64(2), described.Topic.PartitionOffsets[1].HighestOffset)
+	_, err = client.DescribeTopic(ops, &api.DescribeTopicRequest{Name: "users"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.DeleteTopic(ops, &api.DeleteTopicRequest{Name: "payments"})
+	require.NoError(t, err)
+	_, err = client.DeleteTopic(ops, &api.DeleteTopicRequest{Name: "payments"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.DeleteTopic(ops, &api.DeleteTopicRequest{Name: log.AuditTopic})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the changes are audited as the token's subject
+	events, err := topics.AuditEvents(0)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	for _, e := range events {
+		require.Equal(t, "ops", e.Principal)
+	}
+	require.Equal(t, log.AuditDeleteTopic, events[2].Action)
+}
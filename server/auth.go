@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TokenValidator checks the bearer tokens of the requests and returns the
+// subjects they're authorized as, see auth.StaticTokens and auth.JWTValidator
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (subject string, err error)
+}
+
+// Authorizer decides whether a subject may perform an action on an object
+type Authorizer interface {
+	Authorize(subject, object, action string) error
+}
+
+const (
+	objectWildcard = "*"
+	produceAction  = "produce"
+	consumeAction  = "consume"
+	adminAction    = "admin"
+	// replicateAction is the followers', what they report
+	// moves the high watermark up
+	replicateAction = "replicate"
+)
+
+// actions maps the rpcs to the action they're authorized with. the ones
+// with no action are open to anyone, GetServers that clients resolve the
+// cluster with whatever they're allowed to do and the health checks. rpcs
+// that aren't listed are denied, so a new one isn't open until it's added
+var actions = map[string]string{
+	api.Log_GetServers_FullMethodName:    "",
+	healthpb.Health_Check_FullMethodName: "",
+	healthpb.Health_List_FullMethodName:  "",
+	healthpb.Health_Watch_FullMethodName: "",
+
+	api.Log_Produce_FullMethodName:        produceAction,
+	api.Log_ProduceBatch_FullMethodName:   produceAction,
+	api.Log_ProduceStream_FullMethodName:  produceAction,
+	api.Log_Consume_FullMethodName:        consumeAction,
+	api.Log_ConsumeStream_FullMethodName:  consumeAction,
+	api.Log_GetOffsets_FullMethodName:     consumeAction,
+	api.Log_OffsetForEpoch_FullMethodName: consumeAction,
+	api.Log_GetReplicaLag_FullMethodName:  consumeAction,
+	api.Log_ReportReplica_FullMethodName:  replicateAction,
+
+	api.Admin_CreateTopic_FullMethodName:   adminAction,
+	api.Admin_DeleteTopic_FullMethodName:   adminAction,
+	api.Admin_ListTopics_FullMethodName:    adminAction,
+	api.Admin_DescribeTopic_FullMethodName: adminAction,
+}
+
+func (s *grpcServer) authorizeUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) authorizeStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream hands the handler the context with the subject
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate validates the bearer token of the request, if it has one,
+// and returns the context with its subject. the subject is the common
+// name of the client's certificate otherwise
+func (s *grpcServer) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := bearerToken(value)
+		if !ok {
+			continue
+		}
+		subject, err := s.validateToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return withSubject(ctx, subject), nil
+	}
+	return ctx, nil
+}
+
+func (s *grpcServer) validateToken(ctx context.Context, token string) (string, error) {
+	if s.TokenValidator == nil {
+		return "", status.Error(codes.Unauthenticated, "bearer tokens aren't accepted")
+	}
+	subject, err := s.TokenValidator.Validate(ctx, token)
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return subject, nil
+}
+
+// bearerToken is the token of an authorization header, if it's a bearer one
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func (s *grpcServer) authorize(ctx context.Context, method string) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	action, ok := actions[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "%s has no action to be authorized with", method)
+	}
+	if action == "" {
+		return nil
+	}
+	return s.Authorizer.Authorize(subject(ctx), objectWildcard, action)
+}
+
+// subjectKey carries the subject of the requests authenticated with a
+// token, and of the http and kafka ones that go through the rpc handlers
+type subjectKey struct{}
+
+func withSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// subject is the common name of the client's verified certificate
+// or empty if the client didn't present one
+func subject(ctx context.Context) string {
+	if subject, ok := ctx.Value(subjectKey{}).(string); ok {
+		return subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
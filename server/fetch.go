@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// Copier is implemented by logs that can copy their records
+// straight from their files to a connection
+type Copier interface {
+	CopyTo(w io.Writer, off uint64) (next uint64, n int64, err error)
+}
+
+// ServeFetch serves consumers that fetch the records in bulk over plain tcp
+// a consumer sends the offset to start from (8 bytes, big endian) and gets
+// every record from there to the head of the log, in the format read by
+// log.ScanStores, after which the connection is closed
+// the records are copied with sendfile instead of going through grpc, which
+// takes encrypting the connection and authorizing the consumer out of the picture,
+// so it's meant for trusted networks only
+func ServeFetch(ln net.Listener, log Copier) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go serveFetch(conn, log)
+	}
+}
+
+func serveFetch(conn net.Conn, log Copier) {
+	defer conn.Close()
+
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return
+	}
+	off := binary.BigEndian.Uint64(b)
+	for {
+		// out of range once it's past the head of the log
+		next, _, err := log.CopyTo(conn, off)
+		if err != nil {
+			return
+		}
+		off = next
+	}
+}
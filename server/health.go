@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthChecker is implemented by commit logs that can tell
+// whether they're fit to serve, it backs the health checks
+type HealthChecker interface {
+	Healthy() error
+}
+
+// how often Watch checks the health again
+var healthWatchInterval = time.Second
+
+// healthServer is grpc.health.v1 over the log's health check, the empty
+// service and the log's are the same, both are the health of the node
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	checker HealthChecker
+}
+
+var healthServices = []string{"", api.Log_ServiceDesc.ServiceName}
+
+func newHealthServer(config *Config) *healthServer {
+	checker, _ := config.CommitLog.(HealthChecker)
+	return &healthServer{checker: checker}
+}
+
+func (h *healthServer) status(service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	if service != healthServices[0] && service != healthServices[1] {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if h.checker != nil && h.checker.Healthy() != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s, err := h.status(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: s}, nil
+}
+
+func (h *healthServer) List(ctx context.Context, req *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	res := &healthpb.HealthListResponse{Statuses: make(map[string]*healthpb.HealthCheckResponse)}
+	for _, service := range healthServices {
+		s, _ := h.status(service)
+		res.Statuses[service] = &healthpb.HealthCheckResponse{Status: s}
+	}
+	return res, nil
+}
+
+// Watch sends the status right away and again whenever it changes,
+// an unknown service is watched as such rather than failing
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		s, _ := h.status(req.Service)
+		if s != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: s}); err != nil {
+				return err
+			}
+			last = s
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewHealthHandler serves the log's health check over http, for probes:
+// 200 if it's healthy, 503 with the error otherwise
+func NewHealthHandler(checker HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := checker.Healthy(); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, httpError{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Status string `json:"status"`
+		}{"ok"})
+	})
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthLog is a commit log whose health is up to the test
+type healthLog struct {
+	CommitLog
+	unhealthy atomic.Bool
+}
+
+func (l *healthLog) Healthy() error {
+	if l.unhealthy.Load() {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	interval := healthWatchInterval
+	healthWatchInterval = 10 * time.Millisecond
+	defer func() { healthWatchInterval = interval }()
+
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	hlog := &healthLog{CommitLog: clog}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	gsrv, err := NewGRPCServer(&Config{CommitLog: hlog})
+	require.NoError(t, err)
+	go gsrv.Serve(ln)
+	defer gsrv.Stop()
+	cc, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	ctx := context.Background()
+
+	for _, service := range []string{"", api.Log_ServiceDesc.ServiceName} {
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+	}
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+	hlog.unhealthy.Store(true)
+	res, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+
+	srv := httptest.NewServer(NewHealthHandler(hlog))
+	defer srv.Close()
+	for _, unhealthy := range []bool{true, false} {
+		hlog.unhealthy.Store(unhealthy)
+		res, err := http.Get(srv.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		if unhealthy {
+			require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		} else {
+			require.Equal(t, http.StatusOK, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPRecord is how records are sent over http, keys and values
+// are base64 in the JSON
+type HTTPRecord struct {
+	Offset  uint64            `json:"offset"`
+	Key     []byte            `json:"key,omitempty"`
+	Value   []byte            `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timestamp is when the record was appended, it's ignored on produce
+	Timestamp time.Time `json:"timestamp,omitzero"`
+}
+
+// httpRecord is the record of a consume response as it's sent over http
+func httpRecord(record *api.Record) HTTPRecord {
+	r := HTTPRecord{
+		Offset:  record.Offset,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+	}
+	if record.Timestamp != nil {
+		r.Timestamp = record.Timestamp.AsTime()
+	}
+	return r
+}
+
+// HTTPProduceResponse is the response to POST /produce
+type HTTPProduceResponse struct {
+	Offset uint64 `json:"offset"`
+}
+
+type httpError struct {
+	Error string `json:"error"`
+}
+
+// NewHTTPHandler serves the log as JSON over http, for clients that can't
+// use grpc easily: POST /produce takes an HTTPRecord, without its offset,
+// and answers with its offset, acks=0|1|all sets the request's api.Acks.
+// GET /consume?offset= returns one. GET /stream?offset= sends the records
+// from offset on as server-sent events, see handleStream, both only get
+// to the committed records with isolation=committed. GET /healthz
+// is the log's health check. the requests go through the same checks as
+// the rpcs, authorized by the client certificate if there's one
+func NewHTTPHandler(config *Config) (http.Handler, error) {
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /produce", srv.handleProduce)
+	mux.HandleFunc("GET /consume", srv.handleConsume)
+	mux.HandleFunc("GET /stream", srv.handleStream)
+	if checker, ok := config.CommitLog.(HealthChecker); ok {
+		mux.Handle("GET /healthz", NewHealthHandler(checker))
+	}
+	return mux, nil
+}
+
+// httpAcks are the values of the acks query parameter, like kafka's
+var httpAcks = map[string]api.Acks{
+	"":    api.Acks_ACKS_DEFAULT,
+	"0":   api.Acks_ACKS_NONE,
+	"1":   api.Acks_ACKS_LEADER,
+	"all": api.Acks_ACKS_ALL,
+}
+
+func (s *grpcServer) handleProduce(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorizeHTTP(w, r, produceAction)
+	if !ok {
+		return
+	}
+	// the body is capped like grpc caps its messages
+	if s.MaxRecordBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, 2*int64(s.MaxRecordBytes)+maxMessageOverhead)
+	}
+	var record HTTPRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, httpError{Error: err.Error()})
+			return
+		}
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	acks, ok := httpAcks[r.URL.Query().Get("acks")]
+	if !ok {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "acks is 0, 1 or all"))
+		return
+	}
+	res, err := s.Produce(r.Context(), &api.ProduceRequest{Record: &api.Record{
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+	}, Acks: acks})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, HTTPProduceResponse{Offset: res.Offset})
+}
+
+func (s *grpcServer) handleConsume(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorizeHTTP(w, r, consumeAction)
+	if !ok {
+		return
+	}
+	off, err := strconv.ParseUint(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "offset is required"))
+		return
+	}
+	isolation, err := parseIsolation(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := s.Consume(r.Context(), &api.ConsumeRequest{Offset: off, Isolation: isolation})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, httpRecord(res.Record))
+}
+
+// handleStream follows the log like ConsumeStream, every record is an
+// event with the offset as its id and the HTTPRecord as its data. a client
+// that reconnects with Last-Event-ID goes on from the record after it
+func (s *grpcServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorizeHTTP(w, r, consumeAction)
+	if !ok {
+		return
+	}
+	param := r.URL.Query().Get("offset")
+	var off uint64
+	var err error
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		off, err = strconv.ParseUint(last, 10, 64)
+		off++
+	} else if param != "" {
+		off, err = strconv.ParseUint(param, 10, 64)
+	}
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "invalid offset"))
+		return
+	}
+	isolation, err := parseIsolation(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, status.Error(codes.Unimplemented, "streaming isn't supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	req := &api.ConsumeRequest{Offset: off, Isolation: isolation}
+	err = s.follow(r.Context(), req, func(res *api.ConsumeResponse) error {
+		data, err := json.Marshal(httpRecord(res.Record))
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", res.Record.Offset, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		// the status is gone already, so the error is an event of its own
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", status.Convert(err).Message())
+		flusher.Flush()
+	}
+}
+
+// parseIsolation reads the isolation query parameter,
+// committed or uncommitted, the latter if it's left out
+func parseIsolation(r *http.Request) (api.Isolation, error) {
+	switch r.URL.Query().Get("isolation") {
+	case "", "uncommitted":
+		return api.Isolation_READ_UNCOMMITTED, nil
+	case "committed":
+		return api.Isolation_READ_COMMITTED, nil
+	}
+	return 0, status.Error(codes.InvalidArgument, "isolation is committed or uncommitted")
+}
+
+// authorizeHTTP authorizes the request like the rpcs, it writes the error
+// and returns false if the client isn't allowed to. the request it returns
+// carries the client's subject
+func (s *grpcServer) authorizeHTTP(w http.ResponseWriter, r *http.Request, action string) (*http.Request, bool) {
+	subject, err := s.httpSubject(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return nil, false
+	}
+	r = r.WithContext(withSubject(r.Context(), subject))
+	if s.Authorizer == nil {
+		return r, true
+	}
+	if err := s.Authorizer.Authorize(subject, objectWildcard, action); err != nil {
+		writeHTTPError(w, err)
+		return nil, false
+	}
+	return r, true
+}
+
+// httpSubject is the subject of the request's bearer token, or the
+// common name of the client's verified certificate without one
+func (s *grpcServer) httpSubject(r *http.Request) (string, error) {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		return s.validateToken(r.Context(), token)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+	}
+	return "", nil
+}
+
+// httpCodes are the http statuses of the grpc codes the handlers return
+var httpCodes = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.NotFound:           http.StatusNotFound,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	code, ok := httpCodes[status.Code(err)]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, httpError{Error: status.Convert(err).Message()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
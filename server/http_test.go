@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/orkhan-huseyn/vsdlog/auth"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	handler, err := NewHTTPHandler(&Config{CommitLog: clog, MaxRecordBytes: 64})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	body, err := json.Marshal(HTTPRecord{Key: []byte("key"), Value: []byte("hello world")})
+	require.NoError(t, err)
+	res, err := http.Post(srv.URL+"/produce", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var produced HTTPProduceResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produced))
+	res.Body.Close()
+	require.Equal(t, uint64(0), produced.Offset)
+
+	res, err = http.Get(srv.URL + "/consume?offset=0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var consumed HTTPRecord
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&consumed))
+	res.Body.Close()
+	require.Equal(t, []byte("key"), consumed.Key)
+	require.Equal(t, []byte("hello world"), consumed.Value)
+	require.False(t, consumed.Timestamp.IsZero())
+
+	for url, code := range map[string]int{
+		"/consume?offset=1":   http.StatusNotFound,
+		"/consume?offset=one": http.StatusBadRequest,
+		"/consume":            http.StatusBadRequest,
+		// not replicated, so it's committed
+		"/consume?offset=0&isolation=committed": http.StatusOK,
+		"/consume?offset=0&isolation=dirty":     http.StatusBadRequest,
+	} {
+		res, err = http.Get(srv.URL + url)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, code, res.StatusCode, url)
+	}
+
+	res, err = http.Post(srv.URL+"/produce?acks=all", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produced))
+	res.Body.Close()
+	require.Equal(t, uint64(1), produced.Offset)
+	res, err = http.Post(srv.URL+"/produce?acks=2", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	body, err = json.Marshal(HTTPRecord{Value: make([]byte, 128)})
+	require.NoError(t, err)
+	res, err = http.Post(srv.URL+"/produce", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestHTTPHandlerUnauthorized(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("root, *, produce\n"), 0644))
+	authorizer, err := auth.New(policy)
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	handler, err := NewHTTPHandler(&Config{
+		CommitLog:      clog,
+		Authorizer:     authorizer,
+		TokenValidator: auth.StaticTokens{"s3cret": "root"},
+	})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// without a client certificate, there's no one to allow
+	res, err := http.Post(srv.URL+"/produce", "application/json", bytes.NewReader([]byte(`{"value":"aGk="}`)))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// unless there's a token
+	for token, code := range map[string]int{"s3cret": http.StatusOK, "guess": http.StatusUnauthorized} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/produce", bytes.NewReader([]byte(`{"value":"aGk="}`)))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, code, res.StatusCode, token)
+	}
+}
+
+func TestHTTPStream(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	for _, value := range []string{"first", "second"} {
+		_, err = clog.Append([]byte(value))
+		require.NoError(t, err)
+	}
+
+	handler, err := NewHTTPHandler(&Config{CommitLog: clog})
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream?offset=0", nil)
+	require.NoError(t, err)
+	// a reconnecting client picks up after the last event it saw
+	req.Header.Set("Last-Event-ID", "0")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(res.Body)
+	next := func() (string, HTTPRecord) {
+		var id string
+		var record HTTPRecord
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case line == "":
+				return id, record
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &record))
+			}
+		}
+		require.NoError(t, events.Err())
+		return id, record
+	}
+
+	id, record := next()
+	require.Equal(t, "1", id)
+	require.Equal(t, []byte("second"), record.Value)
+
+	// records appended later are pushed as they come
+	_, err = clog.Append([]byte("third"))
+	require.NoError(t, err)
+	id, record = next()
+	require.Equal(t, "2", id)
+	require.Equal(t, []byte("third"), record.Value)
+}
@@ -0,0 +1,666 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// KafkaTopic is the topic the log shows up as to kafka clients
+// it has a single partition, 0
+const KafkaTopic = "vsdlog"
+
+// the apis the kafka server speaks
+const (
+	kafkaProduce     int16 = 0
+	kafkaFetch       int16 = 1
+	kafkaListOffsets int16 = 2
+	kafkaMetadata    int16 = 3
+	kafkaAPIVersions int16 = 18
+)
+
+// kafkaVersions are the versions of the apis that are supported, they stop
+// short of the flexible versions, which compact everything they encode
+var kafkaVersions = []struct {
+	key, min, max int16
+}{
+	{kafkaProduce, 3, 7},
+	{kafkaFetch, 4, 6},
+	{kafkaListOffsets, 1, 3},
+	{kafkaMetadata, 0, 4},
+	{kafkaAPIVersions, 0, 2},
+}
+
+// the kafka error codes the server answers with
+const (
+	kafkaNone                     int16 = 0
+	kafkaUnknownServerError       int16 = -1
+	kafkaOffsetOutOfRange         int16 = 1
+	kafkaCorruptMessage           int16 = 2
+	kafkaUnknownTopicOrPartition  int16 = 3
+	kafkaNotLeader                int16 = 6
+	kafkaMessageTooLarge          int16 = 10
+	kafkaTopicAuthorizationFailed int16 = 29
+	kafkaUnsupportedVersion       int16 = 35
+	kafkaInvalidRequest           int16 = 42
+	kafkaUnsupportedMessageFormat int16 = 43
+	kafkaOutOfOrderSequence       int16 = 45
+	kafkaStorageError             int16 = 56
+	kafkaUnsupportedCompression   int16 = 76
+)
+
+// kafkaCodes are the kafka errors of the grpc codes the rpcs return
+var kafkaCodes = map[codes.Code]int16{
+	codes.NotFound:           kafkaOffsetOutOfRange,
+	codes.InvalidArgument:    kafkaMessageTooLarge,
+	codes.PermissionDenied:   kafkaTopicAuthorizationFailed,
+	codes.FailedPrecondition: kafkaOutOfOrderSequence,
+	codes.ResourceExhausted:  kafkaStorageError,
+	codes.Unavailable:        kafkaNotLeader,
+}
+
+func kafkaCode(err error) int16 {
+	if err == nil {
+		return kafkaNone
+	}
+	code, ok := kafkaCodes[status.Code(err)]
+	if !ok {
+		return kafkaUnknownServerError
+	}
+	return code
+}
+
+// kafkaMaxRequestBytes caps the requests like kafka's socket.request.max.bytes
+const kafkaMaxRequestBytes = 100 << 20
+
+// KafkaServer speaks enough of the kafka protocol, Produce, Fetch, ListOffsets
+// and Metadata, for kafka clients and tools to produce to and consume from
+// the log as KafkaTopic. the records go through the same checks as the rpcs,
+// authorized by the client certificate if the listener is a tls one
+// kafka's consumer groups, transactions and sasl aren't supported
+type KafkaServer struct {
+	srv    *grpcServer
+	ranger OffsetRanger
+	// done is canceled on Close, for the fetches waiting on records
+	done   context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	lns    []net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewKafkaServer returns a kafka server for the commit log of the config,
+// which has to be an OffsetRanger for fetches to know where the log ends
+func NewKafkaServer(config *Config) (*KafkaServer, error) {
+	ranger, ok := config.CommitLog.(OffsetRanger)
+	if !ok {
+		return nil, errors.New("kafka: the log doesn't tell its offsets")
+	}
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	done, cancel := context.WithCancel(context.Background())
+	return &KafkaServer{
+		srv:    srv,
+		ranger: ranger,
+		done:   done,
+		cancel: cancel,
+		conns:  make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Serve accepts kafka connections on ln until it's closed
+func (k *KafkaServer) Serve(ln net.Listener) error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return net.ErrClosed
+	}
+	k.lns = append(k.lns, ln)
+	k.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		if !k.track(conn, true) {
+			conn.Close()
+			return net.ErrClosed
+		}
+		go func() {
+			defer k.track(conn, false)
+			defer conn.Close()
+			_ = k.serveConn(conn)
+		}()
+	}
+}
+
+// track adds or removes an open connection, it returns false
+// if the server was closed in the meantime
+func (k *KafkaServer) track(conn net.Conn, add bool) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !add {
+		delete(k.conns, conn)
+		return true
+	}
+	if k.closed {
+		return false
+	}
+	k.conns[conn] = struct{}{}
+	return true
+}
+
+// Close stops the listeners and closes the open connections
+func (k *KafkaServer) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closed = true
+	k.cancel()
+	var errs []error
+	for _, ln := range k.lns {
+		errs = append(errs, ln.Close())
+	}
+	// a connection may be closing on its own already
+	for conn := range k.conns {
+		_ = conn.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// kafkaConn is what the handlers know of the connection of a request
+type kafkaConn struct {
+	subject string
+	local   net.Addr
+	version int16
+}
+
+// serveConn answers the requests of a connection in the order they came in,
+// which is the order kafka clients expect the responses in. it returns
+// once the client goes away or sends something that isn't supported
+func (k *KafkaServer) serveConn(conn net.Conn) error {
+	c := &kafkaConn{local: conn.LocalAddr()}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		if state := tlsConn.ConnectionState(); len(state.VerifiedChains) > 0 {
+			c.subject = state.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > kafkaMaxRequestBytes {
+			return fmt.Errorf("kafka: request of %d bytes", n)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+
+		req := &kafkaReader{b: b}
+		key := req.int16()
+		c.version = req.int16()
+		correlationID := req.int32()
+		req.string() // client id
+		if req.err != nil {
+			return req.err
+		}
+
+		// room for the size, set once the response is done
+		res := &kafkaWriter{b: []byte{0, 0, 0, 0}}
+		res.int32(correlationID)
+		respond, err := k.handle(k.done, c, key, req, res)
+		if err != nil {
+			return err
+		}
+		if !respond {
+			continue
+		}
+		binary.BigEndian.PutUint32(res.b, uint32(len(res.b)-4))
+		if _, err = conn.Write(res.b); err != nil {
+			return err
+		}
+	}
+}
+
+// handle decodes the request and encodes its response, it returns false if
+// there's no response, to produce requests that don't want acks
+func (k *KafkaServer) handle(ctx context.Context, c *kafkaConn, key int16, req *kafkaReader, res *kafkaWriter) (bool, error) {
+	if key == kafkaAPIVersions {
+		k.handleAPIVersions(c, res)
+		return true, nil
+	}
+	supported := false
+	for _, v := range kafkaVersions {
+		if v.key == key {
+			supported = c.version >= v.min && c.version <= v.max
+		}
+	}
+	if !supported {
+		// kafka closes the connection too, clients are
+		// expected to ask for the versions first
+		return false, fmt.Errorf("kafka: api %d version %d isn't supported", key, c.version)
+	}
+
+	respond := true
+	switch key {
+	case kafkaProduce:
+		respond = k.handleProduce(ctx, c, req, res)
+	case kafkaFetch:
+		k.handleFetch(ctx, c, req, res)
+	case kafkaListOffsets:
+		k.handleListOffsets(c, req, res)
+	case kafkaMetadata:
+		k.handleMetadata(c, req, res)
+	}
+	return respond, req.err
+}
+
+func (k *KafkaServer) handleAPIVersions(c *kafkaConn, res *kafkaWriter) {
+	code := kafkaNone
+	if c.version > 2 {
+		// the response is in version 0 so any client can read the versions
+		// there are and try again with one of them
+		code, c.version = kafkaUnsupportedVersion, 0
+	}
+	res.int16(code)
+	res.int32(int32(len(kafkaVersions)))
+	for _, v := range kafkaVersions {
+		res.int16(v.key)
+		res.int16(v.min)
+		res.int16(v.max)
+	}
+	if c.version >= 1 {
+		res.int32(0) // throttle time
+	}
+}
+
+func (k *KafkaServer) handleMetadata(c *kafkaConn, req *kafkaReader, res *kafkaWriter) {
+	// an empty list is every topic in version 0, null is in the later ones
+	topics := []string{KafkaTopic}
+	if n := req.arrayLen(); n > 0 || (n == 0 && c.version > 0) {
+		topics = make([]string, n)
+		for i := range topics {
+			topics[i] = req.string()
+		}
+	}
+	if c.version >= 4 {
+		req.bool() // allow auto topic creation
+	}
+
+	// the node answering is the partition's leader, produce
+	// requests reaching a follower are forwarded like the rpcs
+	host, portStr, _ := net.SplitHostPort(c.local.String())
+	port, _ := strconv.Atoi(portStr)
+	if c.version >= 3 {
+		res.int32(0) // throttle time
+	}
+	res.int32(1)
+	res.int32(0) // node id
+	res.string(host)
+	res.int32(int32(port))
+	if c.version >= 1 {
+		res.nullString() // rack
+	}
+	if c.version >= 2 {
+		res.nullString() // cluster id
+	}
+	if c.version >= 1 {
+		res.int32(0) // controller id
+	}
+	res.int32(int32(len(topics)))
+	for _, topic := range topics {
+		if topic != KafkaTopic {
+			res.int16(kafkaUnknownTopicOrPartition)
+			res.string(topic)
+			if c.version >= 1 {
+				res.bool(false)
+			}
+			res.int32(0)
+			continue
+		}
+		res.int16(kafkaNone)
+		res.string(topic)
+		if c.version >= 1 {
+			res.bool(false) // internal
+		}
+		res.int32(1)
+		res.int16(kafkaNone)
+		res.int32(0) // partition
+		res.int32(0) // leader
+		res.int32(1) // replicas
+		res.int32(0)
+		res.int32(1) // in sync replicas
+		res.int32(0)
+	}
+}
+
+// partitionCode is the error of a partition that isn't
+// KafkaTopic's, or that the client isn't allowed to use
+func (k *KafkaServer) partitionCode(c *kafkaConn, topic string, partition int32, action string) int16 {
+	if topic != KafkaTopic || partition != 0 {
+		return kafkaUnknownTopicOrPartition
+	}
+	if k.srv.Authorizer != nil {
+		if err := k.srv.Authorizer.Authorize(c.subject, objectWildcard, action); err != nil {
+			return kafkaTopicAuthorizationFailed
+		}
+	}
+	return kafkaNone
+}
+
+// handleProduce appends the records of the batches one by one, so the ones
+// appended before an error stay in the log, and returns the first's offset
+func (k *KafkaServer) handleProduce(ctx context.Context, c *kafkaConn, req *kafkaReader, res *kafkaWriter) bool {
+	req.string() // transactional id
+	acks := req.int16()
+	req.int32() // timeout
+	topics := req.arrayLen()
+	res.int32(int32(topics))
+	for range topics {
+		topic := req.string()
+		res.string(topic)
+		partitions := req.arrayLen()
+		res.int32(int32(partitions))
+		for range partitions {
+			partition := req.int32()
+			batches := req.bytes()
+			var base uint64
+			code := k.partitionCode(c, topic, partition, produceAction)
+			if code == kafkaNone {
+				base, code = k.produce(withSubject(ctx, c.subject), batches, acks)
+			}
+			res.int32(partition)
+			res.int16(code)
+			if code == kafkaNone {
+				res.int64(int64(base))
+			} else {
+				res.int64(-1)
+			}
+			res.int64(-1) // log append time, the ones producers set are kept
+			if c.version >= 5 {
+				lowest, _ := k.ranger.LowestOffset()
+				res.int64(int64(lowest))
+			}
+		}
+	}
+	res.int32(0) // throttle time
+	return acks != 0
+}
+
+// produce appends the records of the batches, with the timestamps their
+// producers set, in one ProduceBatch as kafka takes a batch as a whole.
+// acks=-1 waits for them to be committed, acks=0 only goes without the
+// response, the records of a connection are still appended in order
+// before its next request
+func (k *KafkaServer) produce(ctx context.Context, batches []byte, acks int16) (uint64, int16) {
+	records, err := decodeRecordBatches(batches)
+	switch {
+	case errors.Is(err, errKafkaCompression):
+		return 0, kafkaUnsupportedCompression
+	case errors.Is(err, errKafkaMagic):
+		return 0, kafkaUnsupportedMessageFormat
+	case err != nil:
+		return 0, kafkaCorruptMessage
+	case len(records) == 0:
+		return 0, kafkaInvalidRequest
+	}
+	req := &api.ProduceBatchRequest{Acks: api.Acks_ACKS_LEADER}
+	if acks == -1 {
+		req.Acks = api.Acks_ACKS_ALL
+	}
+	for _, record := range records {
+		r := &api.Record{
+			Key:     record.Key,
+			Value:   record.Value,
+			Headers: record.Headers,
+		}
+		// -1 is no timestamp, the append time's taken then
+		if record.Timestamp >= 0 {
+			r.Timestamp = timestamppb.New(time.UnixMilli(record.Timestamp))
+		}
+		req.Records = append(req.Records, r)
+	}
+	res, err := k.srv.ProduceBatch(ctx, req)
+	if err != nil {
+		return 0, kafkaCode(err)
+	}
+	// the batch is failed if only part of it made it
+	if len(res.Offsets) != len(records) {
+		return 0, kafkaUnknownServerError
+	}
+	return res.Offsets[0], kafkaNone
+}
+
+type kafkaFetchPartition struct {
+	topic     string
+	partition int32
+	offset    int64
+	maxBytes  int32
+}
+
+// handleFetch returns the records from the requested offsets on, waiting up
+// to the request's max wait for some to be appended if there aren't any yet
+func (k *KafkaServer) handleFetch(ctx context.Context, c *kafkaConn, req *kafkaReader, res *kafkaWriter) {
+	req.int32() // replica id
+	maxWait := time.Duration(req.int32()) * time.Millisecond
+	minBytes := req.int32()
+	maxBytes := int(req.int32())
+	req.int8() // isolation level, nothing's transactional
+	var fetches []kafkaFetchPartition
+	topics := req.arrayLen()
+	for range topics {
+		topic := req.string()
+		partitions := req.arrayLen()
+		for range partitions {
+			f := kafkaFetchPartition{topic: topic, partition: req.int32(), offset: req.int64()}
+			if c.version >= 5 {
+				req.int64() // log start offset, only followers send it
+			}
+			f.maxBytes = req.int32()
+			fetches = append(fetches, f)
+		}
+	}
+	if req.err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(maxWait)
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		body := &kafkaWriter{}
+		got := k.fetch(c, fetches, maxBytes, body)
+		if got >= int(minBytes) || !time.Now().Before(deadline) {
+			res.b = append(res.b, body.b...)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch encodes the fetch response and returns how many bytes of records it holds
+func (k *KafkaServer) fetch(c *kafkaConn, fetches []kafkaFetchPartition, maxBytes int, res *kafkaWriter) int {
+	res.int32(0) // throttle time
+	var topics [][]kafkaFetchPartition
+	for _, f := range fetches {
+		if n := len(topics); n > 0 && topics[n-1][0].topic == f.topic {
+			topics[n-1] = append(topics[n-1], f)
+		} else {
+			topics = append(topics, []kafkaFetchPartition{f})
+		}
+	}
+
+	got := 0
+	res.int32(int32(len(topics)))
+	for _, partitions := range topics {
+		res.string(partitions[0].topic)
+		res.int32(int32(len(partitions)))
+		for _, f := range partitions {
+			var records []byte
+			lowest, hw, err := k.offsets()
+			code := kafkaCode(err)
+			if code == kafkaNone {
+				code = k.partitionCode(c, f.topic, f.partition, consumeAction)
+			}
+			if code == kafkaNone {
+				if f.offset < int64(lowest) || f.offset > int64(hw) {
+					code = kafkaOffsetOutOfRange
+				} else {
+					limit := min(int(f.maxBytes), maxBytes-got)
+					records, code = k.readBatch(uint64(f.offset), hw, limit, got == 0)
+					got += len(records)
+				}
+			}
+			res.int32(f.partition)
+			res.int16(code)
+			res.int64(int64(hw))
+			res.int64(int64(hw)) // last stable offset
+			if c.version >= 5 {
+				res.int64(int64(lowest))
+			}
+			res.int32(0) // aborted transactions
+			res.bytes(records)
+		}
+	}
+	return got
+}
+
+// offsets returns the lowest offset and the high watermark,
+// the offset the next record appended will get
+func (k *KafkaServer) offsets() (lowest, hw uint64, err error) {
+	if lowest, err = k.ranger.LowestOffset(); err != nil {
+		return 0, 0, toStatus(err)
+	}
+	highest, err := k.ranger.HighestOffset()
+	if err != nil {
+		return 0, 0, toStatus(err)
+	}
+	if highest < lowest {
+		return lowest, lowest, nil
+	}
+	// a log that's empty and one with a single record
+	// have the same offsets, the record tells them apart
+	if highest == lowest {
+		if _, err = k.srv.CommitLog.ReadRecord(lowest); errors.Is(err, log.ErrOffsetOutOfRange) || errors.Is(err, log.ErrOffsetCompacted) {
+			return lowest, lowest, nil
+		}
+	}
+	return lowest, highest + 1, nil
+}
+
+// readBatch reads the records from off up to hw as a record batch of at most
+// limit bytes, or of one record if atLeastOne is set and the first is larger
+func (k *KafkaServer) readBatch(off, hw uint64, limit int, atLeastOne bool) ([]byte, int16) {
+	var records []kafkaRecord
+	size := batchRecordsOffset
+	for ; off < hw; off++ {
+		record, err := k.srv.CommitLog.ReadRecord(off)
+		if errors.Is(err, log.ErrOffsetCompacted) || errors.Is(err, log.ErrOffsetOutOfRange) {
+			// compacted away, or truncated meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, kafkaCode(toStatus(err))
+		}
+		// a few bytes more than the record takes
+		size += len(record.Key) + len(record.Value) + 32
+		for key, value := range record.Headers {
+			size += len(key) + len(value) + 10
+		}
+		if size > limit && (len(records) > 0 || !atLeastOne) {
+			break
+		}
+		timestamp := int64(-1)
+		if !record.Timestamp.IsZero() {
+			timestamp = record.Timestamp.UnixMilli()
+		}
+		records = append(records, kafkaRecord{
+			Offset:    off,
+			Timestamp: timestamp,
+			Key:       record.Key,
+			Value:     record.Value,
+			Headers:   record.Headers,
+		})
+	}
+	if len(records) == 0 {
+		return []byte{}, kafkaNone
+	}
+	return appendRecordBatch(nil, records), kafkaNone
+}
+
+// the timestamps ListOffsets asks for the ends of the log with
+const (
+	kafkaLatest   = -1
+	kafkaEarliest = -2
+)
+
+// handleListOffsets returns the offsets the log starts and ends at, there's
+// no index of the records by time to look up the other timestamps with
+func (k *KafkaServer) handleListOffsets(c *kafkaConn, req *kafkaReader, res *kafkaWriter) {
+	req.int32() // replica id
+	if c.version >= 2 {
+		req.int8() // isolation level
+		res.int32(0)
+	}
+	topics := req.arrayLen()
+	res.int32(int32(topics))
+	for range topics {
+		topic := req.string()
+		res.string(topic)
+		partitions := req.arrayLen()
+		res.int32(int32(partitions))
+		for range partitions {
+			partition := req.int32()
+			timestamp := req.int64()
+			lowest, hw, err := k.offsets()
+			code := kafkaCode(err)
+			if code == kafkaNone {
+				code = k.partitionCode(c, topic, partition, consumeAction)
+			}
+			off := int64(-1)
+			switch {
+			case code != kafkaNone:
+			case timestamp == kafkaLatest:
+				off = int64(hw)
+			case timestamp == kafkaEarliest:
+				off = int64(lowest)
+			default:
+				code = kafkaInvalidRequest
+			}
+			res.int32(partition)
+			res.int16(code)
+			res.int64(-1) // timestamp
+			res.int64(off)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var (
+	errKafkaShort       = errors.New("kafka: request is too short")
+	errKafkaCorrupt     = errors.New("kafka: corrupt record batch")
+	errKafkaMagic       = errors.New("kafka: record batches before magic 2 aren't supported")
+	errKafkaCompression = errors.New("kafka: unsupported compression")
+)
+
+// kafkaReader decodes the big endian primitives kafka requests are made of
+// the first error sticks, the reads after it return zero values
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errKafkaShort
+		return nil
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) bool() bool {
+	return r.int8() != 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string with an int16 length, null reads as empty
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// bytes reads bytes with an int32 length, null reads as nil
+func (r *kafkaReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return r.next(int(n))
+}
+
+// arrayLen reads the length of an array, -1 if it's null. every element
+// takes at least a byte, which keeps a bad length from allocating much
+func (r *kafkaReader) arrayLen() int {
+	n := int(r.int32())
+	if n > len(r.b) && r.err == nil {
+		r.err = errKafkaShort
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *kafkaReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errKafkaShort
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// varBytes reads bytes with a varint length, null reads as nil
+func (r *kafkaReader) varBytes() []byte {
+	n := r.varint()
+	if n < 0 {
+		return nil
+	}
+	return r.next(int(n))
+}
+
+// kafkaWriter encodes the primitives kafka responses are made of
+type kafkaWriter struct {
+	b []byte
+}
+
+func (w *kafkaWriter) int8(v int8) {
+	w.b = append(w.b, byte(v))
+}
+
+func (w *kafkaWriter) bool(v bool) {
+	if v {
+		w.int8(1)
+	} else {
+		w.int8(0)
+	}
+}
+
+func (w *kafkaWriter) int16(v int16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, uint16(v))
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, uint32(v))
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, uint64(v))
+}
+
+func (w *kafkaWriter) string(v string) {
+	w.int16(int16(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *kafkaWriter) nullString() {
+	w.int16(-1)
+}
+
+// bytes writes bytes with an int32 length, nil is written as null
+func (w *kafkaWriter) bytes(v []byte) {
+	if v == nil {
+		w.int32(-1)
+		return
+	}
+	w.int32(int32(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *kafkaWriter) varint(v int64) {
+	w.b = binary.AppendVarint(w.b, v)
+}
+
+func (w *kafkaWriter) varBytes(v []byte) {
+	if v == nil {
+		w.varint(-1)
+		return
+	}
+	w.varint(int64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+// kafkaRecord is a record of a kafka record batch. kafka timestamps are
+// milliseconds since the epoch, -1 when there's none
+type kafkaRecord struct {
+	Offset    uint64
+	Timestamp int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// the layout of a record batch, magic 2, up to its records
+const (
+	batchLengthOffset = 8
+	// the length counts the bytes after it
+	batchLengthEnd       = 12
+	batchMagicOffset     = 16
+	batchCRCOffset       = 17
+	batchAttrsOffset     = 21
+	batchRecordsOffset   = 61
+	batchCompressionMask = 0x07
+	batchControlFlag     = 0x20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// decodeRecordBatches decodes the record batches a producer sent
+// control batches, which only transactions write, are skipped
+func decodeRecordBatches(b []byte) ([]kafkaRecord, error) {
+	var records []kafkaRecord
+	for len(b) > 0 {
+		if len(b) < batchRecordsOffset {
+			return nil, errKafkaCorrupt
+		}
+		size := int(int32(binary.BigEndian.Uint32(b[batchLengthOffset:])))
+		if size < batchRecordsOffset-batchLengthEnd || len(b) < batchLengthEnd+size {
+			return nil, errKafkaCorrupt
+		}
+		batch := b[:batchLengthEnd+size]
+		b = b[len(batch):]
+		if batch[batchMagicOffset] != 2 {
+			return nil, errKafkaMagic
+		}
+		if crc32.Checksum(batch[batchAttrsOffset:], castagnoli) != binary.BigEndian.Uint32(batch[batchCRCOffset:]) {
+			return nil, errKafkaCorrupt
+		}
+
+		r := &kafkaReader{b: batch[batchAttrsOffset:]}
+		attrs := r.int16()
+		r.int32() // last offset delta
+		baseTimestamp := r.int64()
+		r.next(8 + 8 + 2 + 4) // max timestamp, producer id, epoch and base sequence
+		count := r.int32()
+		if attrs&batchControlFlag != 0 {
+			continue
+		}
+		raw, err := decompressKafka(attrs&batchCompressionMask, r.b)
+		if err != nil {
+			return nil, err
+		}
+		r = &kafkaReader{b: raw}
+		for range count {
+			rec := &kafkaReader{b: r.varBytes()}
+			rec.int8() // attributes, unused
+			record := kafkaRecord{Timestamp: baseTimestamp + rec.varint()}
+			rec.varint() // offset delta, it's the log that hands out offsets
+			record.Key = rec.varBytes()
+			record.Value = rec.varBytes()
+			if record.Value == nil {
+				record.Value = []byte{}
+			}
+			headers := rec.varint()
+			if headers > int64(len(rec.b)) {
+				return nil, errKafkaCorrupt
+			}
+			for range headers {
+				if record.Headers == nil {
+					record.Headers = make(map[string]string, headers)
+				}
+				key := string(rec.varBytes())
+				record.Headers[key] = string(rec.varBytes())
+			}
+			if r.err != nil || rec.err != nil {
+				return nil, errKafkaCorrupt
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// xerial framing is what the java clients wrap snappy blocks in
+var xerialMagic = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+// kafkaMaxBatchBytes is how far a compressed batch may inflate,
+// a batch that goes past it is taken as corrupt
+var kafkaMaxBatchBytes = kafkaMaxRequestBytes
+
+// decompressKafka decompresses the records of a batch, up to kafkaMaxBatchBytes
+func decompressKafka(codec int16, b []byte) ([]byte, error) {
+	switch codec {
+	case 0:
+		return b, nil
+	case 1:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, errKafkaCorrupt
+		}
+		return readLimited(r)
+	case 2:
+		if !bytes.HasPrefix(b, xerialMagic) {
+			return decodeSnappy(nil, b)
+		}
+		// the magic is followed by two int32 versions, then the
+		// blocks, each of them with an int32 length
+		r := &kafkaReader{b: b[len(xerialMagic):]}
+		r.next(8)
+		var out []byte
+		for r.err == nil && len(r.b) > 0 {
+			var err error
+			if out, err = decodeSnappy(out, r.bytes()); err != nil {
+				return nil, err
+			}
+		}
+		return out, r.err
+	case 3:
+		return readLimited(lz4.NewReader(bytes.NewReader(b)))
+	case 4:
+		d, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, errKafkaCorrupt
+		}
+		defer d.Close()
+		return readLimited(d)
+	default:
+		return nil, errKafkaCompression
+	}
+}
+
+// readLimited reads r up to kafkaMaxBatchBytes
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, int64(kafkaMaxBatchBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > kafkaMaxBatchBytes {
+		return nil, errKafkaCorrupt
+	}
+	return b, nil
+}
+
+// decodeSnappy appends the snappy block to dst, as long as they
+// don't go past kafkaMaxBatchBytes between them. the block's
+// length is checked before anything's allocated for it
+func decodeSnappy(dst, block []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(dst)+n > kafkaMaxBatchBytes {
+		return nil, errKafkaCorrupt
+	}
+	out, err := snappy.Decode(nil, block)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, out...), nil
+}
+
+// appendRecordBatch appends the records as an uncompressed record batch
+// the offsets are deltas from the first record's, so gaps left
+// by compaction show as they are
+func appendRecordBatch(b []byte, records []kafkaRecord) []byte {
+	base := records[0]
+	maxTimestamp := base.Timestamp
+	w := &kafkaWriter{b: b}
+	w.int64(int64(base.Offset))
+	lengthAt := len(w.b)
+	w.int32(0) // batch length, set below
+	w.int32(0) // partition leader epoch
+	w.int8(2)  // magic
+	crcAt := len(w.b)
+	w.int32(0) // crc, set below
+	w.int16(0) // attributes: no compression, create time
+	w.int32(int32(records[len(records)-1].Offset - base.Offset))
+	w.int64(base.Timestamp)
+	maxAt := len(w.b)
+	w.int64(0)  // max timestamp, set below
+	w.int64(-1) // producer id
+	w.int16(-1) // producer epoch
+	w.int32(-1) // base sequence
+	w.int32(int32(len(records)))
+
+	var rec kafkaWriter
+	for _, record := range records {
+		maxTimestamp = max(maxTimestamp, record.Timestamp)
+		rec.b = rec.b[:0]
+		rec.int8(0)
+		rec.varint(record.Timestamp - base.Timestamp)
+		rec.varint(int64(record.Offset - base.Offset))
+		rec.varBytes(record.Key)
+		rec.varBytes(record.Value)
+		keys := make([]string, 0, len(record.Headers))
+		for k := range record.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		rec.varint(int64(len(keys)))
+		for _, k := range keys {
+			rec.varBytes([]byte(k))
+			rec.varBytes([]byte(record.Headers[k]))
+		}
+		w.varBytes(rec.b)
+	}
+
+	binary.BigEndian.PutUint64(w.b[maxAt:], uint64(maxTimestamp))
+	binary.BigEndian.PutUint32(w.b[lengthAt:], uint32(len(w.b)-lengthAt-4))
+	binary.BigEndian.PutUint32(w.b[crcAt:], crc32.Checksum(w.b[crcAt+4:], castagnoli))
+	return w.b
+}
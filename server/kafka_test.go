@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// kafkaClient sends kafka requests the way a client would
+type kafkaClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   int32
+}
+
+// send sends a request and returns its response, without the correlation id
+func (c *kafkaClient) send(key, version int16, body func(w *kafkaWriter)) *kafkaReader {
+	c.write(key, version, body)
+	return c.read()
+}
+
+func (c *kafkaClient) write(key, version int16, body func(w *kafkaWriter)) {
+	c.id++
+	w := &kafkaWriter{b: []byte{0, 0, 0, 0}}
+	w.int16(key)
+	w.int16(version)
+	w.int32(c.id)
+	w.string("test")
+	body(w)
+	binary.BigEndian.PutUint32(w.b, uint32(len(w.b)-4))
+	_, err := c.conn.Write(w.b)
+	require.NoError(c.t, err)
+}
+
+func (c *kafkaClient) read() *kafkaReader {
+	size := make([]byte, 4)
+	_, err := io.ReadFull(c.conn, size)
+	require.NoError(c.t, err)
+	b := make([]byte, binary.BigEndian.Uint32(size))
+	_, err = io.ReadFull(c.conn, b)
+	require.NoError(c.t, err)
+	r := &kafkaReader{b: b}
+	require.Equal(c.t, c.id, r.int32())
+	return r
+}
+
+func (c *kafkaClient) produce(acks int16, topic string, records ...kafkaRecord) (int16, int64) {
+	batch := appendRecordBatch(nil, records)
+	write := func(w *kafkaWriter) {
+		w.nullString()
+		w.int16(acks)
+		w.int32(1000)
+		w.int32(1)
+		w.string(topic)
+		w.int32(1)
+		w.int32(0)
+		w.bytes(batch)
+	}
+	if acks == 0 {
+		c.write(kafkaProduce, 5, write)
+		return 0, 0
+	}
+	r := c.send(kafkaProduce, 5, write)
+	require.Equal(c.t, int32(1), r.int32())
+	require.Equal(c.t, topic, r.string())
+	require.Equal(c.t, int32(1), r.int32())
+	require.Equal(c.t, int32(0), r.int32())
+	code := r.int16()
+	base := r.int64()
+	require.NoError(c.t, r.err)
+	return code, base
+}
+
+func (c *kafkaClient) fetch(offset int64) (int16, int64, []kafkaRecord) {
+	r := c.send(kafkaFetch, 5, func(w *kafkaWriter) {
+		w.int32(-1)
+		w.int32(200)
+		w.int32(1)
+		w.int32(1 << 20)
+		w.int8(0)
+		w.int32(1)
+		w.string(KafkaTopic)
+		w.int32(1)
+		w.int32(0)
+		w.int64(offset)
+		w.int64(-1)
+		w.int32(1 << 20)
+	})
+	r.int32() // throttle time
+	require.Equal(c.t, int32(1), r.int32())
+	require.Equal(c.t, KafkaTopic, r.string())
+	require.Equal(c.t, int32(1), r.int32())
+	require.Equal(c.t, int32(0), r.int32())
+	code := r.int16()
+	hw := r.int64()
+	r.int64() // last stable offset
+	r.int64() // log start offset
+	r.int32() // aborted transactions
+	batches := r.bytes()
+	require.NoError(c.t, r.err)
+	records, err := decodeRecordBatches(batches)
+	require.NoError(c.t, err)
+	return code, hw, records
+}
+
+func TestKafkaServer(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	srv, err := NewKafkaServer(&Config{CommitLog: clog})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := &kafkaClient{t: t, conn: conn}
+
+	// versions past the supported ones get the list in version 0
+	r := c.send(kafkaAPIVersions, 3, func(w *kafkaWriter) {})
+	require.Equal(t, kafkaUnsupportedVersion, r.int16())
+	require.Equal(t, int32(len(kafkaVersions)), r.int32())
+
+	r = c.send(kafkaMetadata, 1, func(w *kafkaWriter) { w.int32(-1) })
+	require.Equal(t, int32(1), r.int32())
+	require.Equal(t, int32(0), r.int32())
+	require.Equal(t, "127.0.0.1", r.string())
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	require.Equal(t, port, strconv.Itoa(int(r.int32())))
+	r.string() // rack
+	r.int32()  // controller
+	require.Equal(t, int32(1), r.int32())
+	require.Equal(t, kafkaNone, r.int16())
+	require.Equal(t, KafkaTopic, r.string())
+	require.NoError(t, r.err)
+
+	code, _, records := c.fetch(0)
+	require.Equal(t, kafkaNone, code)
+	require.Empty(t, records)
+
+	code, base := c.produce(1, KafkaTopic,
+		kafkaRecord{Timestamp: 1000, Key: []byte("key"), Value: []byte("first")},
+		kafkaRecord{Offset: 1, Timestamp: 1001, Value: []byte("second"), Headers: map[string]string{"h": "v"}},
+	)
+	require.Equal(t, kafkaNone, code)
+	require.Equal(t, int64(0), base)
+	code, _ = c.produce(1, "other", kafkaRecord{Value: []byte("nope")})
+	require.Equal(t, kafkaUnknownTopicOrPartition, code)
+	// no acks, no response
+	c.produce(0, KafkaTopic, kafkaRecord{Value: []byte("third")})
+
+	code, hw, records := c.fetch(0)
+	require.Equal(t, kafkaNone, code)
+	require.Equal(t, int64(3), hw)
+	require.Len(t, records, 3)
+	require.Equal(t, []byte("key"), records[0].Key)
+	require.Equal(t, []byte("first"), records[0].Value)
+	require.Equal(t, map[string]string{"h": "v"}, records[1].Headers)
+	require.Equal(t, []byte("third"), records[2].Value)
+	// the producers' timestamps are kept
+	require.Equal(t, int64(1000), records[0].Timestamp)
+	require.Equal(t, int64(1001), records[1].Timestamp)
+	stored, err := clog.ReadRecord(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), stored.Value)
+	require.Equal(t, time.UnixMilli(1001), stored.Timestamp)
+
+	code, _, _ = c.fetch(4)
+	require.Equal(t, kafkaOffsetOutOfRange, code)
+
+	r = c.send(kafkaListOffsets, 1, func(w *kafkaWriter) {
+		w.int32(-1)
+		w.int32(1)
+		w.string(KafkaTopic)
+		w.int32(2)
+		w.int32(0)
+		w.int64(kafkaEarliest)
+		w.int32(0)
+		w.int64(kafkaLatest)
+	})
+	r.int32()
+	r.string()
+	require.Equal(t, int32(2), r.int32())
+	for _, want := range []int64{0, 3} {
+		r.int32()
+		require.Equal(t, kafkaNone, r.int16())
+		r.int64()
+		require.Equal(t, want, r.int64())
+	}
+	require.NoError(t, r.err)
+
+	// apis it doesn't speak close the connection, like kafka does
+	c.write(kafkaFetch, 13, func(w *kafkaWriter) {})
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecompressKafka(t *testing.T) {
+	limit := kafkaMaxBatchBytes
+	kafkaMaxBatchBytes = 1 << 20
+	defer func() { kafkaMaxBatchBytes = limit }()
+	compress := map[int16]func([]byte) []byte{
+		1: func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(b)
+			w.Close()
+			return buf.Bytes()
+		},
+		2: func(b []byte) []byte {
+			// xerial framed, in blocks that are fine on their own
+			out := append([]byte{}, xerialMagic...)
+			out = binary.BigEndian.AppendUint64(out, 1<<32|1)
+			for len(b) > 0 {
+				n := min(len(b), 64<<10)
+				block := snappy.Encode(nil, b[:n])
+				out = binary.BigEndian.AppendUint32(out, uint32(len(block)))
+				out, b = append(out, block...), b[n:]
+			}
+			return out
+		},
+		3: func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := lz4.NewWriter(&buf)
+			w.Write(b)
+			w.Close()
+			return buf.Bytes()
+		},
+		4: func(b []byte) []byte {
+			w, _ := zstd.NewWriter(nil)
+			return w.EncodeAll(b, nil)
+		},
+	}
+	big := make([]byte, kafkaMaxBatchBytes+1)
+	for codec, fn := range compress {
+		out, err := decompressKafka(codec, fn([]byte("records")))
+		require.NoError(t, err)
+		require.Equal(t, "records", string(out))
+		// what inflates past the limit is refused
+		_, err = decompressKafka(codec, fn(big))
+		require.ErrorIs(t, err, errKafkaCorrupt, "codec %d", codec)
+	}
+	_, err := decompressKafka(2, snappy.Encode(nil, big))
+	require.ErrorIs(t, err, errKafkaCorrupt)
+}
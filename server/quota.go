@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Quota limits how fast a tenant produces, a tenant gets to burst a
+// second's worth. zero doesn't limit
+type Quota struct {
+	RecordsPerSecond uint64
+	BytesPerSecond   uint64
+	// MaxBytes caps the size of the topics of the tenant's namespace,
+	// see log.Topics.Namespace
+	MaxBytes uint64
+}
+
+// limiter is the token buckets of a tenant
+type limiter struct {
+	mu      sync.Mutex
+	quota   Quota
+	records float64
+	bytes   float64
+	last    time.Time
+}
+
+func newLimiter(q Quota, now time.Time) *limiter {
+	return &limiter{
+		quota:   q,
+		records: float64(q.RecordsPerSecond),
+		bytes:   float64(q.BytesPerSecond),
+		last:    now,
+	}
+}
+
+// allow takes the records and bytes from the buckets if there's enough in
+// them. a request bigger than a second's worth goes through once they're
+// full and the buckets are in debt afterwards
+func (l *limiter) allow(now time.Time, records, bytes uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	ok := true
+	take := func(bucket *float64, rate, n uint64) {
+		if rate == 0 {
+			return
+		}
+		*bucket = min(*bucket+elapsed*float64(rate), float64(rate))
+		if *bucket < float64(min(n, rate)) {
+			ok = false
+		}
+	}
+	take(&l.records, l.quota.RecordsPerSecond, records)
+	take(&l.bytes, l.quota.BytesPerSecond, bytes)
+	if ok {
+		l.records -= float64(records)
+		l.bytes -= float64(bytes)
+	}
+	return ok
+}
+
+// limit refuses the records if they'd get the tenant of the request over
+// its quota. every node keeps limiters of its own, the leader's see all of
+// the tenant's records since the followers forward them, along with the
+// tenant, so it's the leader's that hold a tenant to its quota
+func (s *grpcServer) limit(ctx context.Context, records ...*api.Record) error {
+	if len(s.Quotas) == 0 {
+		return nil
+	}
+	tenant := s.tenant(ctx)
+	quota, ok := s.quota(tenant)
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	s.limitersMu.Lock()
+	l, ok := s.limiters[tenant]
+	if !ok {
+		if s.limiters == nil {
+			s.limiters = make(map[string]*limiter)
+		}
+		l = newLimiter(quota, now)
+		s.limiters[tenant] = l
+	}
+	s.limitersMu.Unlock()
+
+	var bytes uint64
+	for _, record := range records {
+		bytes += uint64(proto.Size(record))
+	}
+	if !l.allow(now, uint64(len(records)), bytes) {
+		return status.Errorf(codes.ResourceExhausted, "%q is producing faster than its quota", tenant)
+	}
+	return nil
+}
+
+// quota is the tenant's quota, the one of "*" if it has none of its own
+func (s *grpcServer) quota(tenant string) (Quota, bool) {
+	quota, ok := s.Quotas[tenant]
+	if !ok {
+		quota, ok = s.Quotas[objectWildcard]
+	}
+	return quota, ok
+}
+
+// tenant is who the request's limited as, its subject unless it's
+// forwarded by one of the ServerSubjects, which pass along the tenant
+// they were sent the request by
+func (s *grpcServer) tenant(ctx context.Context) string {
+	sub := subject(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	tenants := md.Get(tenantKey)
+	if len(md.Get(forwardedKey)) == 0 || len(tenants) == 0 || !slices.Contains(s.ServerSubjects, sub) {
+		return sub
+	}
+	return tenants[0]
+}
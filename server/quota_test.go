@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newLimiter(Quota{RecordsPerSecond: 10, BytesPerSecond: 100}, now)
+	require.True(t, l.allow(now, 5, 50))
+	require.True(t, l.allow(now, 5, 10))
+	require.False(t, l.allow(now, 1, 1))
+
+	// the buckets fill up with time, up to a second's worth
+	now = now.Add(100 * time.Millisecond)
+	require.True(t, l.allow(now, 1, 10))
+	require.False(t, l.allow(now, 1, 10))
+	now = now.Add(time.Hour)
+	require.True(t, l.allow(now, 10, 100))
+	require.False(t, l.allow(now, 0, 1))
+
+	// more than a second's worth goes through on full buckets only
+	now = now.Add(time.Second)
+	require.True(t, l.allow(now, 50, 0))
+	now = now.Add(time.Second)
+	require.False(t, l.allow(now, 1, 0))
+}
+
+func TestServerQuotas(t *testing.T) {
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = nil
+		c.Quotas = map[string]Quota{
+			"root": {RecordsPerSecond: 3},
+			"*":    {RecordsPerSecond: 1},
+		}
+	})
+	defer teardown()
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("hello")}
+
+	_, err := root.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	_, err = root.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{record, record}})
+	require.NoError(t, err)
+	_, err = root.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the other tenants have a limit of their own
+	_, err = nobody.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	_, err = nobody.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// consuming isn't limited
+	_, err = root.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+}
+
+func TestServerQuotasForwarded(t *testing.T) {
+	leaderLog, err := log.NewLog(filepath.Join(t.TempDir(), "leader"), log.Config{})
+	require.NoError(t, err)
+	defer leaderLog.Close()
+	config := &Config{
+		CommitLog: leaderLog,
+		Quotas: map[string]Quota{
+			"root": {RecordsPerSecond: 2},
+			"*":    {RecordsPerSecond: 1},
+		},
+		// the follower connects without a certificate
+		ServerSubjects: []string{""},
+	}
+	leader, err := NewGRPCServer(config)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go leader.Serve(ln)
+	defer leader.Stop()
+
+	// the follower doesn't limit, the leader does
+	follower := &followerLog{leader: ln.Addr().String()}
+	root, _, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = nil
+		follower.CommitLog = c.CommitLog
+		c.CommitLog = follower
+	})
+	defer teardown()
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("hello")}
+
+	// the records are limited as the tenant the follower passes along
+	for range 2 {
+		_, err = root.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.NoError(t, err)
+	}
+	_, err = root.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServerQuotasForwardedByClient(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	srv, err := NewGRPCServer(&Config{
+		CommitLog: clog,
+		Quotas:    map[string]Quota{"*": {RecordsPerSecond: 1}},
+	})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	defer srv.Stop()
+	cc, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := api.NewLogClient(cc)
+
+	// the marker of a forwarded request doesn't get a client past its quota
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		forwardedKey, "true", tenantKey, "someone-else")
+	record := &api.Record{Value: []byte("hello")}
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,594 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CommitLog is what the server needs from the log
+// so it can be swapped out in tests
+type CommitLog interface {
+	AppendRecord(log.Record) (uint64, error)
+	ReadRecord(uint64) (log.Record, error)
+}
+
+// ContextLog is implemented by commit logs whose appends and reads can
+// take a while, they give up once the request's canceled or times out
+type ContextLog interface {
+	AppendContext(context.Context, log.Record) (uint64, error)
+	ReadContext(context.Context, uint64) (log.Record, error)
+}
+
+// OffsetRanger is implemented by commit logs that can tell
+// the range of offsets they hold, it's needed for GetOffsets
+type OffsetRanger interface {
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+}
+
+// EpochLog is implemented by commit logs that keep leader epochs,
+// it's needed for OffsetForEpoch
+type EpochLog interface {
+	EndOffsetForEpoch(epoch uint64) (uint64, uint64, error)
+}
+
+// WatermarkLog is implemented by replicated commit logs that know which
+// of their records are committed, it's needed for READ_COMMITTED consumers
+type WatermarkLog interface {
+	HighWatermark() uint64
+}
+
+// ReplicaTracker is implemented by commit logs that work out the high
+// watermark from what their followers have, it's needed for ReportReplica
+type ReplicaTracker interface {
+	ReportReplica(id string, next uint64) (uint64, error)
+}
+
+// LagTracker is implemented by commit logs that know how far behind
+// their followers are, it's needed for GetReplicaLag
+type LagTracker interface {
+	ReplicaLags() []log.ReplicaLag
+}
+
+// ServerGetter is implemented by replicated commit logs that know the
+// servers of their cluster, it's needed for GetServers
+type ServerGetter interface {
+	GetServers() ([]*api.Server, error)
+}
+
+// LeaderLocator is implemented by replicated commit logs
+// so produce requests hitting a follower can be forwarded to the leader
+type LeaderLocator interface {
+	LeaderAddr() string
+}
+
+type Config struct {
+	CommitLog CommitLog
+	// Authorizer is checked for every rpc, with the common name of
+	// the client's certificate as the subject, everything's allowed if nil
+	Authorizer Authorizer
+	// TokenValidator authenticates the clients that send a bearer token
+	// in the authorization header, they're authorized as the token's
+	// subject instead of their certificate's. tokens are refused if nil
+	TokenValidator TokenValidator
+	// TracerProvider traces the rpcs, they aren't traced if nil
+	TracerProvider trace.TracerProvider
+	// ForwardDialOptions are used to connect to the leader
+	// when forwarding produce requests, insecure if empty
+	ForwardDialOptions []grpc.DialOption
+	// MaxRecordBytes caps the size of the produced records, larger ones
+	// are refused before they reach the log. the messages the server
+	// receives are capped accordingly, zero leaves it to the log
+	MaxRecordBytes uint64
+	// Acks is when produce requests that leave it to the server are
+	// answered, once the leader has the record if it's ACKS_DEFAULT
+	Acks api.Acks
+	// Quotas limit how fast the tenants produce, and how much the topics of
+	// their namespaces in Topics take. the tenant of a request is
+	// the subject the Authorizer's checked with. the quota of "*" is the one
+	// of the tenants without their own, each of them is limited on its own
+	Quotas map[string]Quota
+	// ServerSubjects are the subjects of the cluster's own nodes, the
+	// produce requests they forward are limited as the tenant they pass
+	// along. the others are limited as their own subject
+	ServerSubjects []string
+	// Topics are managed through the Admin service, and produced to and
+	// consumed from the tenants' namespaces by the requests naming a topic.
+	// neither is served if nil
+	Topics *log.Topics
+	// Logger gets the errors nobody's waiting for, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+// maxMessageOverhead is how much bigger than a record its request can be
+const maxMessageOverhead = 1024
+
+var _ api.LogServer = (*grpcServer)(nil)
+
+var _ ReplicaTracker = (*log.Log)(nil)
+
+type grpcServer struct {
+	api.UnimplementedLogServer
+	*Config
+
+	mu         sync.Mutex
+	leaderAddr string
+	leader     *grpc.ClientConn
+	// the ACKS_NONE requests waiting to be appended, see produceLater
+	pending  []*api.ProduceRequest
+	draining bool
+	// the produce rates of the tenants with a quota
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+}
+
+// set on requests forwarded to the leader, so they aren't forwarded again
+// when the cluster's view of the leader is out of date
+const forwardedKey = "vsdlog-forwarded"
+
+// set on requests forwarded to the leader, it's the tenant the follower
+// got the request from, see grpcServer.tenant
+const tenantKey = "vsdlog-tenant"
+
+func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(srv.authorizeUnary),
+		grpc.ChainStreamInterceptor(srv.authorizeStream),
+	)
+	if config.TracerProvider != nil {
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithTracerProvider(config.TracerProvider),
+		)))
+	}
+	if config.MaxRecordBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(config.MaxRecordBytes)+maxMessageOverhead))
+	}
+	gsrv := grpc.NewServer(opts...)
+	api.RegisterLogServer(gsrv, srv)
+	if config.Topics != nil {
+		api.RegisterAdminServer(gsrv, newAdminServer(config))
+	}
+	healthpb.RegisterHealthServer(gsrv, newHealthServer(config))
+	return gsrv, nil
+}
+
+func newgrpcServer(config *Config) (*grpcServer, error) {
+	return &grpcServer{Config: config}, nil
+}
+
+// Produce appends the record and answers when the acks of the request say
+// so, see api.Acks. a follower forwards the request to its leader, which
+// applies the acks the follower worked out
+func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if err := s.checkRecord(req.Record); err != nil {
+		return nil, err
+	}
+	if err := s.limit(ctx, req.Record); err != nil {
+		return nil, err
+	}
+	if req.Topic != "" {
+		return s.produceTopic(ctx, req)
+	}
+	acks := s.acks(req.Acks)
+	if acks == api.Acks_ACKS_NONE {
+		return s.produceLater(req)
+	}
+	off, err := s.append(ctx, req.Record)
+	if errors.Is(err, log.ErrNotLeader) {
+		return s.forwardProduce(ctx, &api.ProduceRequest{Record: req.Record, Acks: acks})
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if acks == api.Acks_ACKS_ALL {
+		if err = s.awaitCommit(ctx, off); err != nil {
+			return nil, err
+		}
+	}
+	return &api.ProduceResponse{Offset: off}, nil
+}
+
+// ProduceBatch appends the records one by one, like Produce would, and
+// answers once the acks of the request say so for the last of them. it
+// stops at the first that fails, the ones before it stay in the log
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	if len(req.Records) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "records are required")
+	}
+	for _, record := range req.Records {
+		if err := s.checkRecord(record); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.limit(ctx, req.Records...); err != nil {
+		return nil, err
+	}
+	if req.Topic != "" {
+		return s.produceTopicBatch(ctx, req)
+	}
+	acks := s.acks(req.Acks)
+	res := &api.ProduceBatchResponse{}
+	// only what failed before anything made it is an error
+	partial := func(err error) (*api.ProduceBatchResponse, error) {
+		if len(res.Offsets) == 0 {
+			return nil, err
+		}
+		return res, nil
+	}
+	for _, record := range req.Records {
+		if acks == api.Acks_ACKS_NONE {
+			if _, err := s.produceLater(&api.ProduceRequest{Record: record}); err != nil {
+				return partial(err)
+			}
+			res.Offsets = append(res.Offsets, 0)
+			continue
+		}
+		off, err := s.append(ctx, record)
+		if errors.Is(err, log.ErrNotLeader) && len(res.Offsets) == 0 {
+			return s.forwardProduceBatch(ctx, &api.ProduceBatchRequest{Records: req.Records, Acks: acks})
+		}
+		if err != nil {
+			return partial(toStatus(err))
+		}
+		res.Offsets = append(res.Offsets, off)
+	}
+	if acks == api.Acks_ACKS_ALL {
+		if err := s.awaitCommit(ctx, res.Offsets[len(res.Offsets)-1]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// checkRecord refuses the records that can't be produced
+func (s *grpcServer) checkRecord(record *api.Record) error {
+	if record == nil {
+		return status.Error(codes.InvalidArgument, "record is required")
+	}
+	if max := s.MaxRecordBytes; max > 0 && uint64(proto.Size(record)) > max {
+		return status.Errorf(codes.InvalidArgument, "record is larger than %d bytes", max)
+	}
+	return nil
+}
+
+func (s *grpcServer) append(ctx context.Context, record *api.Record) (uint64, error) {
+	r := logRecord(record)
+	if cl, ok := s.CommitLog.(ContextLog); ok {
+		return cl.AppendContext(ctx, r)
+	}
+	return s.CommitLog.AppendRecord(r)
+}
+
+// logRecord is the record as it's appended to the log
+func logRecord(record *api.Record) log.Record {
+	r := log.Record{
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+	}
+	if record.Timestamp != nil {
+		r.Timestamp = record.Timestamp.AsTime()
+	}
+	return r
+}
+
+func (s *grpcServer) read(ctx context.Context, off uint64) (log.Record, error) {
+	if cl, ok := s.CommitLog.(ContextLog); ok {
+		return cl.ReadContext(ctx, off)
+	}
+	return s.CommitLog.ReadRecord(off)
+}
+
+func (s *grpcServer) forwardProduce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	client, ctx, err := s.forward(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Produce(ctx, req)
+}
+
+func (s *grpcServer) forwardProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	client, ctx, err := s.forward(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ProduceBatch(ctx, req)
+}
+
+// forward returns a client of the leader and the context to call it with
+func (s *grpcServer) forward(ctx context.Context) (api.LogClient, context.Context, error) {
+	locator, ok := s.CommitLog.(LeaderLocator)
+	md, _ := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(forwardedKey)) > 0 {
+		return nil, nil, toStatus(log.ErrNotLeader)
+	}
+	conn, err := s.leaderConn(locator.LeaderAddr())
+	if err != nil {
+		return nil, nil, toStatus(err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, forwardedKey, "true", tenantKey, s.tenant(ctx))
+	return api.NewLogClient(conn), ctx, nil
+}
+
+// leaderConn returns a connection to the leader
+// it's kept around until the leader changes
+func (s *grpcServer) leaderConn(addr string) (*grpc.ClientConn, error) {
+	if addr == "" {
+		return nil, log.ErrNotLeader
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leader != nil && s.leaderAddr == addr {
+		return s.leader, nil
+	}
+	if s.leader != nil {
+		s.leader.Close()
+		s.leader = nil
+	}
+
+	opts := s.ForwardDialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.leaderAddr, s.leader = addr, conn
+	return conn, nil
+}
+
+// Consume reads the record at the requested offset. with READ_COMMITTED
+// the records from the high watermark on aren't found yet, no matter
+// whether the log has them, they could be lost if the leader fails over
+func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	if req.Topic != "" {
+		return s.consumeTopic(ctx, req)
+	}
+	res, err := s.consume(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return res, nil
+}
+
+// consume is Consume with the errors of the log
+func (s *grpcServer) consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	if req.Isolation == api.Isolation_READ_COMMITTED {
+		if wl, ok := s.CommitLog.(WatermarkLog); ok && req.Offset >= wl.HighWatermark() {
+			return nil, fmt.Errorf("%w: %d isn't committed", log.ErrOffsetOutOfRange, req.Offset)
+		}
+	}
+	record, err := s.read(ctx, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ConsumeResponse{Record: apiRecord(req.Offset, record)}, nil
+}
+
+// apiRecord is the record at off as the api sends it
+func apiRecord(off uint64, record log.Record) *api.Record {
+	r := &api.Record{
+		Offset:  off,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+		Epoch:   record.Epoch,
+	}
+	if !record.Timestamp.IsZero() {
+		r.Timestamp = timestamppb.New(record.Timestamp)
+	}
+	return r
+}
+
+// how often ConsumeStream checks for new records once it reached the head of the log
+var streamPollInterval = 100 * time.Millisecond
+
+// ConsumeStream sends the records starting from the requested offset
+// once it catches up with the head of the log, it waits for new records
+// to be appended until the client goes away
+func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	if req.Topic != "" {
+		return status.Error(codes.InvalidArgument, "topics are consumed with Consume")
+	}
+	return s.follow(stream.Context(), req, stream.Send)
+}
+
+// follow calls send with the records from the requested offset on, and
+// the ones appended after that, until ctx is done. the offsets compaction
+// and the retention left out are skipped
+func (s *grpcServer) follow(ctx context.Context, req *api.ConsumeRequest, send func(*api.ConsumeResponse) error) error {
+	ctx = log.SequentialReads(ctx)
+	off := req.Offset
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		res, err := s.consume(ctx, &api.ConsumeRequest{Offset: off, Isolation: req.Isolation})
+		switch {
+		case err == nil:
+		case errors.Is(err, log.ErrOffsetCompacted):
+			off++
+			continue
+		case errors.Is(err, log.ErrOffsetOutOfRange):
+			// compaction removed whole segments before the log's start,
+			// or the retention got to them in the meantime
+			if ranger, ok := s.CommitLog.(OffsetRanger); ok {
+				if lowest, err := ranger.LowestOffset(); err == nil && off < lowest {
+					off = lowest
+					continue
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			continue
+		case ctx.Err() != nil:
+			return nil
+		default:
+			return toStatus(err)
+		}
+
+		if err = send(res); err != nil {
+			return err
+		}
+		off++
+	}
+}
+
+// GetOffsets returns the lowest and highest offsets of the log
+// followers use it to know where to start and how far behind they are
+func (s *grpcServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	ranger, ok := s.CommitLog.(OffsetRanger)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log doesn't tell its offsets")
+	}
+	lowest, err := ranger.LowestOffset()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	highest, err := ranger.HighestOffset()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.GetOffsetsResponse{LowestOffset: lowest, HighestOffset: highest}, nil
+}
+
+// OffsetForEpoch returns the latest epoch of the log up to the requested
+// one and the offset it ends at, so a follower can truncate the records
+// it has past it before it catches up, see log.Log.EndOffsetForEpoch
+func (s *grpcServer) OffsetForEpoch(ctx context.Context, req *api.OffsetForEpochRequest) (*api.OffsetForEpochResponse, error) {
+	epochs, ok := s.CommitLog.(EpochLog)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log doesn't keep epochs")
+	}
+	epoch, end, err := epochs.EndOffsetForEpoch(req.Epoch)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.OffsetForEpochResponse{Epoch: epoch, EndOffset: end}, nil
+}
+
+// ReportReplica records the next offset of a follower and returns the
+// high watermark, see log.Log.ReportReplica
+func (s *grpcServer) ReportReplica(ctx context.Context, req *api.ReportReplicaRequest) (*api.ReportReplicaResponse, error) {
+	tracker, ok := s.CommitLog.(ReplicaTracker)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log doesn't track its replicas")
+	}
+	if req.Replica == "" {
+		return nil, status.Error(codes.InvalidArgument, "replica is required")
+	}
+	hw, err := tracker.ReportReplica(req.Replica, req.NextOffset)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.ReportReplicaResponse{HighWatermark: hw}, nil
+}
+
+// GetReplicaLag returns the lags of the followers that reported to the
+// leader, along with its lowest offset, see log.Log.ReplicaLags
+func (s *grpcServer) GetReplicaLag(ctx context.Context, req *api.GetReplicaLagRequest) (*api.GetReplicaLagResponse, error) {
+	tracker, ok := s.CommitLog.(LagTracker)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log doesn't track its replicas")
+	}
+	res := &api.GetReplicaLagResponse{}
+	for _, lag := range tracker.ReplicaLags() {
+		res.Replicas = append(res.Replicas, &api.ReplicaLag{
+			Replica:    lag.ID,
+			NextOffset: lag.Next,
+			LagRecords: lag.Records,
+			LagTime:    durationpb.New(lag.Time),
+			Reported:   timestamppb.New(lag.Reported),
+		})
+	}
+	if ranger, ok := s.CommitLog.(OffsetRanger); ok {
+		lowest, err := ranger.LowestOffset()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		res.LowestOffset = lowest
+	}
+	return res, nil
+}
+
+// GetServers returns the servers of the cluster, see loadbalance
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+	getter, ok := s.CommitLog.(ServerGetter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log isn't replicated")
+	}
+	servers, err := getter.GetServers()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.GetServersResponse{Servers: servers}, nil
+}
+
+// toStatus maps log errors to grpc status codes
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, log.ErrOffsetOutOfRange), errors.Is(err, log.ErrOffsetCompacted):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, log.ErrUnknownTopic), errors.Is(err, log.ErrUnknownPartition):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, log.ErrTopicExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, log.ErrInvalidTopic), errors.Is(err, log.ErrUnknownCompression):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, log.ErrAuditTopic), errors.Is(err, log.ErrUnknownReplica):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, log.ErrSegmentClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, log.ErrCorruptRecord):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, log.ErrNotLeader):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, log.ErrRecordTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, log.ErrNoSpace):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, log.ErrDuplicateSequence),
+		errors.Is(err, log.ErrOutOfOrderSequence),
+		errors.Is(err, log.ErrInvalidSequence),
+		errors.Is(err, log.ErrStaleEpoch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
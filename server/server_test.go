@@ -0,0 +1,695 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/auth"
+	"github.com/orkhan-huseyn/vsdlog/config"
+	"github.com/orkhan-huseyn/vsdlog/internal/testcerts"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		rootClient api.LogClient,
+		nobodyClient api.LogClient,
+		config *Config,
+	){
+		"produce/consume a message to/from the log succeeds": testProduceConsume,
+		"consume past log boundary fails":                    testConsumePastBoundary,
+		"produce batch appends the records in order":         testProduceBatch,
+		"produce without a record fails":                     testProduceWithoutRecord,
+		"consume stream follows the head of the log":         testConsumeStream,
+		"get offsets tells the range of the log":             testGetOffsets,
+		"offset for epoch tells where the epoch ends":        testOffsetForEpoch,
+		"retried produce of an idempotent producer":          testIdempotentProduce,
+		"unauthorized fails":                                 testUnauthorized,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			rootClient, nobodyClient, config, teardown := setupTest(t, nil)
+			defer teardown()
+			fn(t, rootClient, nobodyClient, config)
+		})
+	}
+}
+
+func setupTest(t *testing.T, fn func(*Config)) (
+	rootClient api.LogClient,
+	nobodyClient api.LogClient,
+	cfg *Config,
+	teardown func(),
+) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	rootTLSConfig, nobodyTLSConfig, serverTLSConfig := setupTLS(t, l.Addr().String())
+	newClient := func(tlsConfig *tls.Config) (*grpc.ClientConn, api.LogClient) {
+		cc, err := grpc.NewClient(
+			l.Addr().String(),
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		)
+		require.NoError(t, err)
+		return cc, api.NewLogClient(cc)
+	}
+	rootConn, rootClient := newClient(rootTLSConfig)
+	nobodyConn, nobodyClient := newClient(nobodyTLSConfig)
+
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	err = os.WriteFile(policy, []byte("root, *, produce\nroot, *, consume\nroot, *, replicate\n"), 0644)
+	require.NoError(t, err)
+	authorizer, err := auth.New(policy)
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	cfg = &Config{
+		CommitLog:  clog,
+		Authorizer: authorizer,
+	}
+	if fn != nil {
+		fn(cfg)
+	}
+	server, err := NewGRPCServer(cfg, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+
+	go func() {
+		server.Serve(l)
+	}()
+
+	return rootClient, nobodyClient, cfg, func() {
+		server.Stop()
+		rootConn.Close()
+		nobodyConn.Close()
+		l.Close()
+		clog.Remove()
+	}
+}
+
+// setupTLS returns the tls configs of the root and nobody clients
+// and of a server that verifies them
+func setupTLS(t *testing.T, serverAddr string) (root, nobody, server *tls.Config) {
+	t.Helper()
+
+	certs, err := testcerts.Generate(t.TempDir())
+	require.NoError(t, err)
+
+	root, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile: certs.RootClientCertFile,
+		KeyFile:  certs.RootClientKeyFile,
+		CAFile:   certs.CAFile,
+	})
+	require.NoError(t, err)
+
+	nobody, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile: certs.NobodyClientCertFile,
+		KeyFile:  certs.NobodyClientKeyFile,
+		CAFile:   certs.CAFile,
+	})
+	require.NoError(t, err)
+
+	server, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certs.ServerCertFile,
+		KeyFile:       certs.ServerKeyFile,
+		CAFile:        certs.CAFile,
+		ServerAddress: serverAddr,
+		Server:        true,
+	})
+	require.NoError(t, err)
+	return root, nobody, server
+}
+
+func TestServerRequiresClientCert(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, _, serverTLSConfig := setupTLS(t, l.Addr().String())
+	server, err := NewGRPCServer(&Config{}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go server.Serve(l)
+	defer server.Stop()
+
+	cc, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	_, err = api.NewLogClient(cc).Consume(context.Background(), &api.ConsumeRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func testProduceConsume(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	want := &api.Record{
+		Key:   []byte("key"),
+		Value: []byte("hello world"),
+	}
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	want.Offset = produce.Offset
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, want.Offset, consume.Record.Offset)
+	require.WithinDuration(t, time.Now(), consume.Record.Timestamp.AsTime(), time.Minute)
+}
+
+func testProduceBatch(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	values := []string{"a", "b", "c"}
+	var records []*api.Record
+	for _, value := range values {
+		records = append(records, &api.Record{Value: []byte(value)})
+	}
+	produce, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2}, produce.Offsets)
+	for i, value := range values {
+		consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: uint64(i)})
+		require.NoError(t, err)
+		require.Equal(t, value, string(consume.Record.Value))
+	}
+
+	_, err = client.ProduceBatch(ctx, &api.ProduceBatchRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testConsumePastBoundary(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset + 1})
+	require.Nil(t, consume)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testProduceWithoutRecord(t *testing.T, client, _ api.LogClient, config *Config) {
+	_, err := client.Produce(context.Background(), &api.ProduceRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testConsumeStream(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message")},
+	}
+	_, err := client.Produce(ctx, &api.ProduceRequest{Record: records[0]})
+	require.NoError(t, err)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, records[0].Value, res.Record.Value)
+
+	// the stream is at the head of the log, so it waits for the next record
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: records[1]})
+	require.NoError(t, err)
+
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, records[1].Value, res.Record.Value)
+	require.Equal(t, uint64(1), res.Record.Offset)
+}
+
+func testGetOffsets(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	offsets, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowestOffset)
+	require.Equal(t, uint64(2), offsets.HighestOffset)
+}
+
+func testOffsetForEpoch(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	clog := config.CommitLog.(*log.Log)
+
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		require.NoError(t, clog.SetEpoch(epoch))
+		for i := 0; i < 2; i++ {
+			_, err := client.Produce(ctx, &api.ProduceRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), consume.Record.Epoch)
+
+	res, err := client.OffsetForEpoch(ctx, &api.OffsetForEpochRequest{Epoch: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Epoch)
+	require.Equal(t, uint64(2), res.EndOffset)
+
+	// a follower past the leader's epoch is ahead of a stale leader
+	_, err = client.OffsetForEpoch(ctx, &api.OffsetForEpochRequest{Epoch: 3})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func testIdempotentProduce(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	record := &api.Record{
+		Value: []byte("hello world"),
+		Headers: map[string]string{
+			log.ProducerIDHeader:  "producer",
+			log.ProducerSeqHeader: "0",
+		},
+	}
+	first, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	retry, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	require.Equal(t, first.Offset, retry.Offset)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: first.Offset})
+	require.NoError(t, err)
+	require.Equal(t, record.Headers, consume.Record.Headers)
+
+	record.Headers[log.ProducerSeqHeader] = "2"
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestServerMaxRecordBytes(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxRecordBytes = 64
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: make([]byte, 32)},
+	})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: make([]byte, 128)},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	// messages far over the cap don't get to the handler at all
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: make([]byte, 4096)},
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServerReadCommitted(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		dir, err := os.MkdirTemp("", "server-committed-test")
+		require.NoError(t, err)
+		lc := log.Config{}
+		lc.Replication.Replicas = 3
+		lc.Replication.Followers = []string{"b"}
+		clog, err := log.NewLog(dir, lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Remove() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+		require.NoError(t, err)
+	}
+	committed := &api.ConsumeRequest{Offset: 1, Isolation: api.Isolation_READ_COMMITTED}
+	_, err := client.Consume(ctx, committed)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+
+	// one follower has the first record, the leader makes two of three
+	res, err := client.ReportReplica(ctx, &api.ReportReplicaRequest{Replica: "b", NextOffset: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.HighWatermark)
+	_, err = client.Consume(ctx, committed)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0, Isolation: api.Isolation_READ_COMMITTED})
+	require.NoError(t, err)
+
+	res, err = client.ReportReplica(ctx, &api.ReportReplicaRequest{Replica: "b", NextOffset: 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.HighWatermark)
+	consume, err := client.Consume(ctx, committed)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), consume.Record.Offset)
+
+	// only the followers the leader knows count
+	_, err = client.ReportReplica(ctx, &api.ReportReplicaRequest{Replica: "x", NextOffset: 2})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	lag, err := client.GetReplicaLag(ctx, &api.GetReplicaLagRequest{})
+	require.NoError(t, err)
+	require.Len(t, lag.Replicas, 1)
+	require.Equal(t, "b", lag.Replicas[0].Replica)
+	require.Equal(t, uint64(2), lag.Replicas[0].NextOffset)
+	require.Zero(t, lag.Replicas[0].LagRecords)
+	require.Zero(t, lag.LowestOffset)
+}
+
+func TestServerAcks(t *testing.T) {
+	var clog *log.Log
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		dir, err := os.MkdirTemp("", "server-acks-test")
+		require.NoError(t, err)
+		lc := log.Config{}
+		lc.Replication.Replicas = 3
+		lc.Replication.Followers = []string{"b"}
+		clog, err = log.NewLog(dir, lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Remove() })
+		c.CommitLog = clog
+		c.Acks = api.Acks_ACKS_ALL
+	})
+	defer teardown()
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("hello")}
+
+	// the leader has it right away
+	res, err := client.Produce(ctx, &api.ProduceRequest{Record: record, Acks: api.Acks_ACKS_LEADER})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Offset)
+
+	// nothing gets committed without the followers
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = client.Produce(short, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	done := make(chan *api.ProduceResponse)
+	go func() {
+		res, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.NoError(t, err)
+		done <- res
+	}()
+	require.Eventually(t, func() bool {
+		next, _ := clog.HighestOffset()
+		return next == 2
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.ReportReplica(ctx, &api.ReportReplicaRequest{Replica: "b", NextOffset: 3})
+	require.NoError(t, err)
+	select {
+	case res := <-done:
+		require.Equal(t, uint64(2), res.Offset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the produce wasn't acknowledged")
+	}
+
+	// no acks answers before the record's appended
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record, Acks: api.Acks_ACKS_NONE})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := clog.ReadRecord(3)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// followerLog is a commit log that isn't the leader of its cluster
+type followerLog struct {
+	CommitLog
+	leader string
+}
+
+func (f *followerLog) AppendRecord(log.Record) (uint64, error) {
+	return 0, log.ErrNotLeader
+}
+
+func (f *followerLog) LeaderAddr() string {
+	return f.leader
+}
+
+func TestServerConsumeStreamSkipsCompacted(t *testing.T) {
+	var clog *log.Log
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		lc := log.Config{}
+		lc.Segment.MaxStoreBytes = 64
+		var err error
+		clog, err = log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		c.CommitLog = clog
+	})
+	defer teardown()
+	defer clog.Close()
+
+	// the first record has no key, so compaction leaves gaps after it
+	for _, key := range []string{"", "a", "a", "a", "a", "a", "b"} {
+		record := log.Record{Value: []byte("hello")}
+		if key != "" {
+			record.Key = []byte(key)
+		}
+		_, err := clog.AppendRecord(record)
+		require.NoError(t, err)
+	}
+	require.NoError(t, clog.Compact())
+	var live []uint64
+	for off := uint64(0); off < 7; off++ {
+		_, err := clog.ReadRecord(off)
+		if err == nil {
+			live = append(live, off)
+			continue
+		}
+		require.ErrorIs(t, err, log.ErrOffsetCompacted)
+	}
+	require.Less(t, len(live), 7)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	var streamed []uint64
+	for len(streamed) < len(live) {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		streamed = append(streamed, res.Record.Offset)
+	}
+	require.Equal(t, live, streamed)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// slowLog is a commit log whose reads take until the request gives up
+type slowLog struct {
+	CommitLog
+	gaveUp chan struct{}
+}
+
+func (s *slowLog) AppendContext(_ context.Context, record log.Record) (uint64, error) {
+	return s.AppendRecord(record)
+}
+
+func (s *slowLog) ReadContext(ctx context.Context, _ uint64) (log.Record, error) {
+	<-ctx.Done()
+	close(s.gaveUp)
+	return log.Record{}, ctx.Err()
+}
+
+func TestServerContext(t *testing.T) {
+	slow := &slowLog{gaveUp: make(chan struct{})}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		slow.CommitLog = c.CommitLog
+		c.CommitLog = slow
+	})
+	defer teardown()
+
+	_, err := client.Produce(context.Background(), &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	// the read on the server gave up along with the client
+	select {
+	case <-slow.gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read is still going")
+	}
+}
+
+func TestServerForwardsProduceToLeader(t *testing.T) {
+	dir, err := os.MkdirTemp("", "server-leader-test")
+	require.NoError(t, err)
+	leaderLog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer leaderLog.Remove()
+
+	leader, err := NewGRPCServer(&Config{CommitLog: leaderLog})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go leader.Serve(ln)
+	defer leader.Stop()
+
+	follower := &followerLog{}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		follower.CommitLog = c.CommitLog
+		c.CommitLog = follower
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api.Record{Value: []byte("forwarded")}
+
+	// no leader elected yet
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	follower.leader = ln.Addr().String()
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+
+	read, err := leaderLog.Read(produce.Offset)
+	require.NoError(t, err)
+	require.Equal(t, record.Value, read)
+}
+
+func testUnauthorized(t *testing.T, _, client api.LogClient, config *Config) {
+	ctx := context.Background()
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.Nil(t, produce)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Nil(t, consume)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// resolving the cluster is open to anyone, this log just isn't replicated
+	_, err = client.GetServers(ctx, &api.GetServersRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServerActions(t *testing.T) {
+	// every rpc served is listed, the ones that aren't are denied
+	for _, desc := range []grpc.ServiceDesc{api.Log_ServiceDesc, api.Admin_ServiceDesc, healthpb.Health_ServiceDesc} {
+		for _, m := range desc.Methods {
+			require.Contains(t, actions, "/"+desc.ServiceName+"/"+m.MethodName)
+		}
+		for _, st := range desc.Streams {
+			require.Contains(t, actions, "/"+desc.ServiceName+"/"+st.StreamName)
+		}
+	}
+	s := &grpcServer{Config: &Config{Authorizer: &auth.Authorizer{}}}
+	err := s.authorize(context.Background(), "/log.v1.Log/Unlisted")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoError(t, s.authorize(context.Background(), healthpb.Health_Check_FullMethodName))
+}
+
+func TestServeFetch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "server-fetch-test")
+	require.NoError(t, err)
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 64
+	clog, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		value := fmt.Sprintf("record %d", i)
+		_, err = clog.Append([]byte(value))
+		require.NoError(t, err)
+		want = append(want, value)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go ServeFetch(ln, clog)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(binary.BigEndian.AppendUint64(nil, 3))
+	require.NoError(t, err)
+
+	// the records are spread over several segments
+	var got []string
+	err = log.ScanStores(conn, c, func(record log.Record) error {
+		got = append(got, string(record.Value))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, want[3:], got)
+}
+
+func TestServerBearerToken(t *testing.T) {
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.TokenValidator = auth.StaticTokens{"s3cret": "root"}
+	})
+	defer teardown()
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	record := &api.Record{Value: []byte("hello")}
+
+	// the token's subject is authorized instead of the certificate's
+	_, err := nobody.Produce(context.Background(), &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	res, err := nobody.Produce(withToken("s3cret"), &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	_, err = nobody.Produce(withToken("guess"), &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = root.Produce(withToken("guess"), &api.ProduceRequest{Record: record})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := nobody.ConsumeStream(withToken("s3cret"), &api.ConsumeRequest{Offset: res.Offset})
+	require.NoError(t, err)
+	got, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, record.Value, got.Record.Value)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how many records of a produce stream can be appended ahead of their
+// answers, the stream stops being read past that until they're sent
+const produceStreamWindow = 256
+
+// produceAck is the answer to a record of a produce stream
+type produceAck struct {
+	off  uint64
+	acks api.Acks
+	err  error
+}
+
+// ProduceStream appends the records as they come in, like Produce would,
+// and sends their offsets back from another goroutine, so the client
+// doesn't wait a round trip for every record. the answers come in the
+// order of the records, the stream ends with the first record that fails,
+// after the answers of the ones before it. a follower doesn't forward the
+// stream, it fails as unavailable so the client moves on to the leader
+func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
+	ctx := stream.Context()
+	acks := make(chan produceAck, produceStreamWindow)
+	go s.receiveProduces(ctx, stream, acks)
+
+	for ack := range acks {
+		if ack.err != nil {
+			return ack.err
+		}
+		if ack.acks == api.Acks_ACKS_ALL {
+			if err := s.awaitCommit(ctx, ack.off); err != nil {
+				return err
+			}
+		}
+		if err := stream.Send(&api.ProduceResponse{Offset: ack.off}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveProduces appends the records of the stream until the client's
+// done sending or one of them fails
+func (s *grpcServer) receiveProduces(ctx context.Context, stream api.Log_ProduceStreamServer, acks chan<- produceAck) {
+	defer close(acks)
+
+	for {
+		ack := s.receiveProduce(ctx, stream)
+		if errors.Is(ack.err, io.EOF) {
+			return
+		}
+		select {
+		case acks <- ack:
+		case <-ctx.Done():
+			return
+		}
+		if ack.err != nil {
+			return
+		}
+	}
+}
+
+func (s *grpcServer) receiveProduce(ctx context.Context, stream api.Log_ProduceStreamServer) produceAck {
+	req, err := stream.Recv()
+	if err != nil {
+		return produceAck{err: err}
+	}
+	if err := s.checkRecord(req.Record); err != nil {
+		return produceAck{err: err}
+	}
+	if req.Topic != "" {
+		return produceAck{err: status.Error(codes.InvalidArgument, "topics are produced to with Produce and ProduceBatch")}
+	}
+	if err := s.limit(ctx, req.Record); err != nil {
+		return produceAck{err: err}
+	}
+	acks := s.acks(req.Acks)
+	if acks == api.Acks_ACKS_NONE {
+		_, err := s.produceLater(req)
+		return produceAck{acks: acks, err: err}
+	}
+	off, err := s.append(ctx, req.Record)
+	if err != nil {
+		return produceAck{err: toStatus(err)}
+	}
+	return produceAck{off: off, acks: acks}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerProduceStream(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the records are all sent before any answer is read
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		err := stream.Send(&api.ProduceRequest{Record: &api.Record{Value: []byte(fmt.Sprint(i))}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+	for i := 0; i < 100; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 42})
+	require.NoError(t, err)
+	require.Equal(t, "42", string(consume.Record.Value))
+
+	// the records before the one that fails are answered
+	stream, err = client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api.ProduceRequest{Record: &api.Record{Value: []byte("ok")}}))
+	require.NoError(t, stream.Send(&api.ProduceRequest{}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), res.Offset)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = nobody.ProduceStream(ctx)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
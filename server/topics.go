@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// namespace is the namespace of the request's tenant in Config.Topics,
+// capped by the MaxBytes of the tenant's quota
+func (s *grpcServer) namespace(ctx context.Context) (*log.Namespace, error) {
+	if s.Topics == nil {
+		return nil, status.Error(codes.Unimplemented, "topics aren't served")
+	}
+	tenant := s.tenant(ctx)
+	quota, _ := s.quota(tenant)
+	n, err := s.Topics.Namespace(tenant, quota.MaxBytes)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return n, nil
+}
+
+// produceTopic is Produce to a topic of the tenant
+func (s *grpcServer) produceTopic(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	partition, off, err := n.Append(req.Topic, logRecord(req.Record))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.ProduceResponse{Offset: off, Partition: uint32(partition)}, nil
+}
+
+// produceTopicBatch is ProduceBatch to a topic of the tenant, the records
+// before the one that fails stay in their partitions
+func (s *grpcServer) produceTopicBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &api.ProduceBatchResponse{}
+	for _, record := range req.Records {
+		partition, off, err := n.Append(req.Topic, logRecord(record))
+		if err != nil && len(res.Offsets) == 0 {
+			return nil, toStatus(err)
+		}
+		if err != nil {
+			return res, nil
+		}
+		res.Offsets = append(res.Offsets, off)
+		res.Partitions = append(res.Partitions, uint32(partition))
+	}
+	return res, nil
+}
+
+// consumeTopic is Consume from a partition of a topic of the tenant
+func (s *grpcServer) consumeTopic(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	topic, err := n.Topic(req.Topic)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	partition, err := topic.Partition(int(req.Partition))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	record, err := partition.ReadContext(ctx, req.Offset)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &api.ConsumeResponse{Record: apiRecord(req.Offset, record)}, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/orkhan-huseyn/vsdlog/api/v1"
+	"github.com/orkhan-huseyn/vsdlog/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerTopics(t *testing.T) {
+	topics, err := log.NewTopics(t.TempDir(), log.TopicConfig{})
+	require.NoError(t, err)
+	defer topics.Close()
+	_, err = topics.CreateTopic("root.orders", log.TopicConfig{Partitions: 2})
+	require.NoError(t, err)
+	_, err = topics.CreateTopic("nobody.orders", log.TopicConfig{})
+	require.NoError(t, err)
+
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = nil
+		c.Topics = topics
+		c.Quotas = map[string]Quota{"root": {MaxBytes: 1}}
+	})
+	defer teardown()
+	ctx := context.Background()
+	record := &api.Record{Key: []byte("user"), Value: []byte("hello")}
+
+	// the records go to the tenant's own topic, not to the log
+	res, err := root.Produce(ctx, &api.ProduceRequest{Record: record, Topic: "orders"})
+	require.NoError(t, err)
+	got, err := root.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Partition: res.Partition, Offset: res.Offset})
+	require.NoError(t, err)
+	require.Equal(t, record.Value, got.Record.Value)
+	_, err = root.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// the first record gets the tenant over its byte quota
+	_, err = root.Produce(ctx, &api.ProduceRequest{Record: record, Topic: "orders"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = root.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{record}, Topic: "orders"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the other tenants aren't capped, and see their own topics only
+	batch, err := nobody.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{record, record}, Topic: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, batch.Offsets)
+	require.Equal(t, []uint32{0, 0}, batch.Partitions)
+	_, err = nobody.Produce(ctx, &api.ProduceRequest{Record: record, Topic: "payments"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = nobody.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err := nobody.ConsumeStream(ctx, &api.ConsumeRequest{Topic: "orders"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}